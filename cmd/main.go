@@ -1,42 +1,214 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/gorilla/mux"
 	"github.com/jackc/pgx"
 	"log"
 	"net/http"
+	"os"
+	"sync"
+	"time"
 
+	"github.com/dantedoyl/Tech_DB_Forum/internal/audit"
+	auditHandler "github.com/dantedoyl/Tech_DB_Forum/internal/audit/delivery/http"
+	auditRepo "github.com/dantedoyl/Tech_DB_Forum/internal/audit/repository/postgres"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/auth"
+	authHandler "github.com/dantedoyl/Tech_DB_Forum/internal/auth/delivery/http"
+	authRepo "github.com/dantedoyl/Tech_DB_Forum/internal/auth/repository/postgres"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/bans"
+	bansHandler "github.com/dantedoyl/Tech_DB_Forum/internal/bans/delivery/http"
+	bansRepo "github.com/dantedoyl/Tech_DB_Forum/internal/bans/repository/postgres"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/config"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/events"
+	eventsHandler "github.com/dantedoyl/Tech_DB_Forum/internal/events/delivery/http"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/forum"
 	handler "github.com/dantedoyl/Tech_DB_Forum/internal/forum/delivery/http"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/forum/repository/memory"
 	repo "github.com/dantedoyl/Tech_DB_Forum/internal/forum/repository/postgres"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/middleware"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/notifications"
+	notificationsHandler "github.com/dantedoyl/Tech_DB_Forum/internal/notifications/delivery/http"
+	notificationsRepo "github.com/dantedoyl/Tech_DB_Forum/internal/notifications/repository/postgres"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/reports"
+	reportsHandler "github.com/dantedoyl/Tech_DB_Forum/internal/reports/delivery/http"
+	reportsRepo "github.com/dantedoyl/Tech_DB_Forum/internal/reports/repository/postgres"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/roles"
+	rolesHandler "github.com/dantedoyl/Tech_DB_Forum/internal/roles/delivery/http"
+	rolesRepo "github.com/dantedoyl/Tech_DB_Forum/internal/roles/repository/postgres"
+	searchHandler "github.com/dantedoyl/Tech_DB_Forum/internal/search/delivery/http"
+	searchRepo "github.com/dantedoyl/Tech_DB_Forum/internal/search/repository/postgres"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/server"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/stream"
+	streamHandler "github.com/dantedoyl/Tech_DB_Forum/internal/stream/delivery/http"
+	streamRepo "github.com/dantedoyl/Tech_DB_Forum/internal/stream/repository/postgres"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/webhooks"
+	webhooksHandler "github.com/dantedoyl/Tech_DB_Forum/internal/webhooks/delivery/http"
+	webhooksRepo "github.com/dantedoyl/Tech_DB_Forum/internal/webhooks/repository/postgres"
 )
 
 func main() {
+	cfg, args, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var dbConnPool *pgx.ConnPool
+	if !cfg.Features.InMemory {
+		poolConfig, err := cfg.PoolConfig()
+		if err != nil {
+			log.Fatal(err)
+		}
+		dbConnPool, err = pgx.NewConnPool(poolConfig)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if len(args) > 0 {
+		if dbConnPool == nil {
+			log.Fatalf("command %q needs a database", args[0])
+		}
+		switch args[0] {
+		case "migrate":
+			err = migrate(dbConnPool, args[1:])
+		case "role":
+			err = setRole(dbConnPool, args[1:])
+		default:
+			err = fmt.Errorf("unknown command %q", args[0])
+		}
+		dbConnPool.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	router := mux.NewRouter()
 	api := router.PathPrefix("/api").Subrouter()
+	if cfg.Features.AccessLog {
+		api.Use(middleware.AccessLog)
+	}
+	// Streams run until their client leaves or http.write_timeout nears, so
+	// they get no request timeout unless endpoint_timeouts sets one.
+	timeouts := map[string]time.Duration{"thread.stream": 0, "feed": 0}
+	for name, timeout := range cfg.HTTP.EndpointTimeouts {
+		timeouts[name] = timeout
+	}
+	api.Use(middleware.Timeout(cfg.HTTP.RequestTimeout, timeouts))
 
-	dbConnPool, err := pgx.NewConnPool(pgx.ConnPoolConfig{
-		ConnConfig:     pgx.ConnConfig{
-			Port:                 5432,
-			Database:             "techdb",
-			User:                 "dantedoyl",
-			Password:             "mypassword",
-			PreferSimpleProtocol: true,
-		},
-		MaxConnections: 100,
-		AfterConnect:   nil,
-		AcquireTimeout: 0,
-	})
-	if err != nil {
-		log.Fatal(err)
+	var forumRepo forum.ForumRepository
+	var hub *stream.Hub
+	// workers run in the background while the server is up.
+	var workers []func(ctx context.Context)
+	if dbConnPool != nil {
+		forumRepo = repo.NewForumRepository(dbConnPool)
+	} else {
+		log.Print("serving from memory, data will not be persisted")
+		forumRepo = memory.NewForumRepository()
+	}
+	forumOptions := handler.Options{
+		RequireAuth: cfg.Auth.Required,
+		BcryptCost:  cfg.Auth.BcryptCost,
+		Reactions:   cfg.Reactions.Keys,
+	}
+	bus := events.NewBus()
+	var authRepository auth.AuthRepository
+	var auditRepository audit.AuditRepository
+	var webhookRepository webhooks.WebhookRepository
+	var webhookGuard *webhooks.Guard
+	// outbox queues the webhook deliveries of the forum writes.
+	var outbox events.Outbox
+	if dbConnPool != nil {
+		authRepository = authRepo.NewAuthRepository(dbConnPool)
+		forumOptions.AuthRepo = authRepository
+		var roleRepository roles.RoleRepository = rolesRepo.NewRoleRepository(dbConnPool)
+		var banRepository bans.BanRepository = bansRepo.NewBanRepository(dbConnPool)
+		var reportRepository reports.ReportRepository = reportsRepo.NewReportRepository(dbConnPool)
+		webhookRepository = webhooksRepo.NewWebhookRepository(dbConnPool)
+		webhookGuard, err = webhooks.NewGuard(cfg.Webhooks.AllowCIDRs, cfg.Webhooks.DenyCIDRs)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if cfg.Features.AuditLog {
+			auditRepository = auditRepo.NewAuditRepository(dbConnPool)
+			forumRepo = audit.NewForumRepository(forumRepo, auditRepository)
+			roleRepository = audit.NewRoleRepository(roleRepository, auditRepository)
+			banRepository = audit.NewBanRepository(banRepository, auditRepository)
+			reportRepository = audit.NewReportRepository(reportRepository, auditRepository)
+			webhookRepository = audit.NewWebhookRepository(webhookRepository, auditRepository)
+		}
+		dispatcher := webhooks.NewDispatcher(webhookRepository, webhookGuard)
+		outbox = dispatcher
+		bus.Subscribe(dispatcher.Publish)
+		workers = append(workers, dispatcher.Run)
+		forumOptions.Authorizer = roles.NewAuthorizer(roleRepository)
+		rolesHandler.NewRolesHandler(api, roleRepository, forumOptions.Authorizer)
+		bansHandler.NewBansHandler(api, banRepository, forumOptions.Authorizer)
+		reportsHandler.NewReportsHandler(api, reportRepository, forumRepo, authRepository,
+			forumOptions.Authorizer, cfg.Auth.Required)
+		if auditRepository != nil {
+			auditHandler.NewAuditHandler(api, auditRepository, forumOptions.Authorizer)
+		}
+	}
+	forumRepo = events.NewForumRepository(forumRepo, bus, outbox)
+	feed := eventsHandler.NewFeedHandler(api, bus, forumRepo)
+	handler.NewForumHandler(api, forumRepo, forumOptions)
+	if dbConnPool != nil {
+		api.Use(middleware.Authenticate(authRepository))
+		if _, err := authHandler.NewAuthHandler(api, authRepository, cfg.Auth.SessionTTL, cfg.Auth.BcryptCost); err != nil {
+			log.Fatal(err)
+		}
+		searchHandler.NewSearchHandler(api, searchRepo.NewSearchRepository(dbConnPool))
+		hub = stream.NewHub(streamRepo.NewStreamRepository(dbConnPool))
+		streamHandler.NewStreamHandler(api, hub, forumRepo, cfg.HTTP.WriteTimeout)
+		workers = append(workers, hub.Run)
+		var notificationRepository notifications.NotificationRepository = notificationsRepo.NewNotificationRepository(dbConnPool)
+		if auditRepository != nil {
+			notificationRepository = audit.NewNotificationRepository(notificationRepository, auditRepository)
+		}
+		webhooksHandler.NewWebhooksHandler(api, webhookRepository, forumOptions.Authorizer, webhookGuard)
+		notificationsHandler.NewNotificationsHandler(api, notificationRepository, authRepository,
+			forumOptions.Authorizer, cfg.Auth.Required)
+	}
+	for name := range cfg.HTTP.EndpointTimeouts {
+		if api.Get(name) == nil {
+			log.Fatalf("config: http.endpoint_timeouts: unknown route %q", name)
+		}
 	}
 
-	forumRepo := repo.NewForumRepository(dbConnPool)
-	handler.NewForumHandler(api, forumRepo)
+	srv := server.New(&http.Server{
+		Addr:         cfg.HTTP.Addr,
+		Handler:      api,
+		ReadTimeout:  cfg.HTTP.ReadTimeout,
+		WriteTimeout: cfg.HTTP.WriteTimeout,
+		IdleTimeout:  cfg.HTTP.IdleTimeout,
+	}, cfg.HTTP.ShutdownTimeout, cfg.HTTP.DrainDelay)
+	api.HandleFunc("/service/ready", srv.Ready).Methods(http.MethodGet)
 
-	err = http.ListenAndServe(":5000", api)
+	srv.OnShutdown(feed.Close)
+	if hub != nil {
+		srv.OnShutdown(hub.Close)
+	}
+	ctx, stopWorkers := context.WithCancel(context.Background())
+	var running sync.WaitGroup
+	for _, run := range workers {
+		running.Add(1)
+		go func(run func(ctx context.Context)) {
+			defer running.Done()
+			run(ctx)
+		}(run)
+	}
+
+	err = srv.Run()
+	// The workers use the pool until they return.
+	stopWorkers()
+	running.Wait()
+	if dbConnPool != nil {
+		dbConnPool.Close()
+	}
 	if err != nil {
-		log.Fatal("can't start server")
+		log.Fatal(err)
 	}
-	fmt.Println("Starting server on port :5000")
 }
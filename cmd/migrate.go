@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx"
+
+	"github.com/dantedoyl/Tech_DB_Forum/internal/migrations"
+)
+
+const migrateUsage = "usage: main [flags] migrate up|down|status"
+
+func migrate(dbConnPool *pgx.ConnPool, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf(migrateUsage)
+	}
+
+	ctx := context.Background()
+	migrator := migrations.NewMigrator(dbConnPool)
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		for _, m := range applied {
+			fmt.Printf("applied %04d_%s\n", m.Version, m.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Println("schema is up to date")
+		}
+		return err
+	case "down":
+		reverted, err := migrator.Down(ctx)
+		if reverted != nil {
+			fmt.Printf("reverted %04d_%s\n", reverted.Version, reverted.Name)
+		} else if err == nil {
+			fmt.Println("no migrations applied")
+		}
+		return err
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			if s.AppliedAt != nil {
+				fmt.Printf("%04d_%s\tapplied %s\n", s.Version, s.Name, s.AppliedAt.Format("2006-01-02 15:04:05 MST"))
+			} else {
+				fmt.Printf("%04d_%s\tpending\n", s.Version, s.Name)
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf(migrateUsage)
+	}
+}
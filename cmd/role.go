@@ -0,0 +1,27 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx"
+
+	"github.com/dantedoyl/Tech_DB_Forum/internal/models"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/roles/repository/postgres"
+)
+
+const roleUsage = "usage: main [flags] role <nickname> admin|member|banned"
+
+// setRole changes the site role of a user from the command line, the way to
+// appoint the first admin.
+func setRole(dbConnPool *pgx.ConnPool, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf(roleUsage)
+	}
+
+	userRole, err := postgres.NewRoleRepository(dbConnPool).SetRole(context.Background(), args[0], models.Role(args[1]))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s is now %s\n", userRole.Nickname, userRole.Role)
+	return nil
+}
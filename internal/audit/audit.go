@@ -0,0 +1,65 @@
+// Package audit records who changed what. Its repositories wrap the domain
+// repositories and append an entry to the log in the transaction of every
+// successful write, so handlers need not remember to and no write goes
+// unrecorded.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+
+	"github.com/dantedoyl/Tech_DB_Forum/internal/auth"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/models"
+)
+
+type recorder struct {
+	log AuditRepository
+}
+
+// actor is the authenticated caller or, for anonymous writes, the user the
+// request named.
+func actor(ctx context.Context, claimed string) string {
+	if nickname, ok := auth.Nickname(ctx); ok {
+		return nickname
+	}
+	return claimed
+}
+
+func snapshot(v interface{}) json.RawMessage {
+	if v == nil || reflect.ValueOf(v).Kind() == reflect.Ptr && reflect.ValueOf(v).IsNil() {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+func entry(ctx context.Context, claimed, action string, entity models.Entity, target, forum string,
+	before, after interface{}) *models.AuditEntry {
+	return &models.AuditEntry{
+		Actor:  actor(ctx, claimed),
+		Action: action,
+		Entity: entity,
+		Target: target,
+		Forum:  forum,
+		Before: snapshot(before),
+		After:  snapshot(after),
+	}
+}
+
+// write calls fn in a transaction with the log, so that the entries fn
+// records commit with its write or not at all.
+func (r recorder) write(ctx context.Context, fn func(ctx context.Context) error) error {
+	return r.log.Atomic(ctx, fn)
+}
+
+// record appends entries within write. Failing to record fails the write.
+func (r recorder) record(ctx context.Context, entries ...*models.AuditEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	return r.log.Record(ctx, entries...)
+}
@@ -0,0 +1,42 @@
+package audit
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/dantedoyl/Tech_DB_Forum/internal/bans"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/models"
+)
+
+// BanRepository records the bans a bans.BanRepository issues and lifts.
+type BanRepository struct {
+	bans.BanRepository
+	recorder
+}
+
+func NewBanRepository(repo bans.BanRepository, log AuditRepository) *BanRepository {
+	return &BanRepository{BanRepository: repo, recorder: recorder{log: log}}
+}
+
+func (br *BanRepository) CreateBan(ctx context.Context, ban *models.Ban) error {
+	return br.write(ctx, func(ctx context.Context) error {
+		if err := br.BanRepository.CreateBan(ctx, ban); err != nil {
+			return err
+		}
+		return br.record(ctx, entry(ctx, ban.IssuedBy, "ban.create", models.EntityBan, strconv.Itoa(ban.ID), ban.Forum,
+			nil, ban))
+	})
+}
+
+func (br *BanRepository) LiftBan(ctx context.Context, id int, liftedBy string) (*models.Ban, error) {
+	var ban *models.Ban
+	err := br.write(ctx, func(ctx context.Context) (err error) {
+		before, _ := br.BanRepository.GetBan(ctx, id)
+		if ban, err = br.BanRepository.LiftBan(ctx, id, liftedBy); err != nil {
+			return err
+		}
+		return br.record(ctx, entry(ctx, liftedBy, "ban.lift", models.EntityBan, strconv.Itoa(id), ban.Forum,
+			before, ban))
+	})
+	return ban, err
+}
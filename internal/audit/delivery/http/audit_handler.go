@@ -0,0 +1,55 @@
+package delivery
+
+import (
+	"net/http"
+
+	"github.com/dantedoyl/Tech_DB_Forum/internal/audit"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/auth"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/models"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/response"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/roles"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/schema"
+)
+
+type AuditHandler struct {
+	AuditRepo  audit.AuditRepository
+	Authorizer *roles.Authorizer
+}
+
+func NewAuditHandler(r *mux.Router, auditRepo audit.AuditRepository, authorizer *roles.Authorizer) *AuditHandler {
+	ah := &AuditHandler{AuditRepo: auditRepo, Authorizer: authorizer}
+	r.HandleFunc("/audit", ah.Entries).Methods(http.MethodGet).Name("audit.list")
+	return ah
+}
+
+// Entries lists the audit log to admins.
+func (ah *AuditHandler) Entries(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	caller, ok := auth.Nickname(r.Context())
+	if !ok {
+		response.WriteError(w, models.Unauthorized("authentication required"))
+		return
+	}
+	if err := ah.Authorizer.Admin(r.Context(), caller); err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	query := &models.AuditQuery{}
+	decoder := schema.NewDecoder()
+	decoder.IgnoreUnknownKeys(true)
+	if err := decoder.Decode(query, r.URL.Query()); err != nil {
+		response.WriteError(w, models.Invalid("query", err.Error()))
+		return
+	}
+
+	entries, err := ah.AuditRepo.GetEntries(r.Context(), query)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	response.WriteJSON(w, http.StatusOK, entries)
+}
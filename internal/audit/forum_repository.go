@@ -0,0 +1,272 @@
+package audit
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/dantedoyl/Tech_DB_Forum/internal/forum"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/models"
+)
+
+// ForumRepository records the writes of a forum.ForumRepository. Reads pass
+// straight through.
+type ForumRepository struct {
+	forum.ForumRepository
+	recorder
+}
+
+func NewForumRepository(repo forum.ForumRepository, log AuditRepository) *ForumRepository {
+	return &ForumRepository{ForumRepository: repo, recorder: recorder{log: log}}
+}
+
+// post returns the current state of a post, or nil.
+func (fr *ForumRepository) post(ctx context.Context, id int) *models.Post {
+	info, err := fr.ForumRepository.PostInfo(ctx, id, models.Related{})
+	if err != nil {
+		return nil
+	}
+	return info.Post
+}
+
+// thread returns the current state of a thread, or nil.
+func (fr *ForumRepository) thread(ctx context.Context, slugOrID string) *models.Thread {
+	thread, err := fr.ForumRepository.GetThreadInfo(ctx, slugOrID)
+	if err != nil {
+		return nil
+	}
+	return thread
+}
+
+func (fr *ForumRepository) CreateForum(ctx context.Context, f *models.Forum) error {
+	return fr.write(ctx, func(ctx context.Context) error {
+		if err := fr.ForumRepository.CreateForum(ctx, f); err != nil {
+			return err
+		}
+		return fr.record(ctx, entry(ctx, f.User, "forum.create", models.EntityForum, f.Slug, f.Slug, nil, f))
+	})
+}
+
+func (fr *ForumRepository) CreateThread(ctx context.Context, thread *models.Thread) error {
+	return fr.write(ctx, func(ctx context.Context) error {
+		if err := fr.ForumRepository.CreateThread(ctx, thread); err != nil {
+			return err
+		}
+		return fr.record(ctx, entry(ctx, thread.Author, "thread.create", models.EntityThread, strconv.Itoa(thread.ID),
+			thread.Forum, nil, thread))
+	})
+}
+
+func (fr *ForumRepository) UpdatePostInfo(ctx context.Context, info *models.PostUpdate) (*models.Post, error) {
+	var post *models.Post
+	err := fr.write(ctx, func(ctx context.Context) (err error) {
+		before := fr.post(ctx, info.ID)
+		if post, err = fr.ForumRepository.UpdatePostInfo(ctx, info); err != nil {
+			return err
+		}
+		return fr.record(ctx, entry(ctx, info.Editor, "post.update", models.EntityPost, strconv.Itoa(post.ID),
+			post.Forum, before, post))
+	})
+	return post, err
+}
+
+func (fr *ForumRepository) DeletePost(ctx context.Context, id int) error {
+	return fr.write(ctx, func(ctx context.Context) error {
+		before := fr.post(ctx, id)
+		if err := fr.ForumRepository.DeletePost(ctx, id); err != nil || before == nil {
+			return err
+		}
+		return fr.record(ctx, entry(ctx, "", "post.delete", models.EntityPost, strconv.Itoa(id), before.Forum,
+			before, nil))
+	})
+}
+
+func (fr *ForumRepository) RevertPost(ctx context.Context, revert *models.PostRevert) (*models.Post, error) {
+	var post *models.Post
+	err := fr.write(ctx, func(ctx context.Context) (err error) {
+		before := fr.post(ctx, revert.ID)
+		if post, err = fr.ForumRepository.RevertPost(ctx, revert); err != nil {
+			return err
+		}
+		return fr.record(ctx, entry(ctx, revert.Editor, "post.revert", models.EntityPost, strconv.Itoa(post.ID),
+			post.Forum, before, post))
+	})
+	return post, err
+}
+
+func (fr *ForumRepository) ClearDB(ctx context.Context) error {
+	return fr.write(ctx, func(ctx context.Context) error {
+		before, _ := fr.ForumRepository.StatusDB(ctx)
+		if err := fr.ForumRepository.ClearDB(ctx); err != nil {
+			return err
+		}
+		return fr.record(ctx, entry(ctx, "", "service.clear", models.EntityService, "database", "", before, nil))
+	})
+}
+
+func (fr *ForumRepository) CreateUser(ctx context.Context, user *models.User) error {
+	return fr.write(ctx, func(ctx context.Context) error {
+		if err := fr.ForumRepository.CreateUser(ctx, user); err != nil {
+			return err
+		}
+		return fr.record(ctx, entry(ctx, user.Nickname, "user.create", models.EntityUser, user.Nickname, "", nil, user))
+	})
+}
+
+func (fr *ForumRepository) UpdateUserProfile(ctx context.Context, user *models.User) error {
+	return fr.write(ctx, func(ctx context.Context) error {
+		before, _ := fr.ForumRepository.GetUserProfile(ctx, user.Nickname)
+		if err := fr.ForumRepository.UpdateUserProfile(ctx, user); err != nil {
+			return err
+		}
+		return fr.record(ctx, entry(ctx, user.Nickname, "user.update", models.EntityUser, user.Nickname, "",
+			before, user))
+	})
+}
+
+// CreatePosts records one entry per post.
+func (fr *ForumRepository) CreatePosts(ctx context.Context, posts []*models.Post, slugOrID string) ([]*models.Post, error) {
+	var created []*models.Post
+	err := fr.write(ctx, func(ctx context.Context) (err error) {
+		if created, err = fr.ForumRepository.CreatePosts(ctx, posts, slugOrID); err != nil {
+			return err
+		}
+		entries := make([]*models.AuditEntry, 0, len(created))
+		for _, post := range created {
+			entries = append(entries, entry(ctx, post.Author, "post.create", models.EntityPost, strconv.Itoa(post.ID),
+				post.Forum, nil, post))
+		}
+		return fr.record(ctx, entries...)
+	})
+	return created, err
+}
+
+func (fr *ForumRepository) UpdateThreadInfo(ctx context.Context, thread *models.Thread) error {
+	slugOrID := thread.Slug
+	if slugOrID == "" {
+		slugOrID = strconv.Itoa(thread.ID)
+	}
+	return fr.write(ctx, func(ctx context.Context) error {
+		before := fr.thread(ctx, slugOrID)
+		if err := fr.ForumRepository.UpdateThreadInfo(ctx, thread); err != nil {
+			return err
+		}
+		return fr.record(ctx, entry(ctx, "", "thread.update", models.EntityThread, strconv.Itoa(thread.ID),
+			thread.Forum, before, thread))
+	})
+}
+
+func (fr *ForumRepository) SetThreadState(ctx context.Context, slugOrID string, state *models.ThreadState) (*models.Thread, error) {
+	var thread *models.Thread
+	err := fr.write(ctx, func(ctx context.Context) (err error) {
+		before := fr.thread(ctx, slugOrID)
+		if thread, err = fr.ForumRepository.SetThreadState(ctx, slugOrID, state); err != nil {
+			return err
+		}
+		return fr.record(ctx, entry(ctx, "", "thread.state", models.EntityThread, strconv.Itoa(thread.ID),
+			thread.Forum, before, thread))
+	})
+	return thread, err
+}
+
+func (fr *ForumRepository) DeleteThread(ctx context.Context, slugOrID string) error {
+	return fr.write(ctx, func(ctx context.Context) error {
+		before := fr.thread(ctx, slugOrID)
+		if err := fr.ForumRepository.DeleteThread(ctx, slugOrID); err != nil || before == nil {
+			return err
+		}
+		return fr.record(ctx, entry(ctx, "", "thread.delete", models.EntityThread, strconv.Itoa(before.ID),
+			before.Forum, before, nil))
+	})
+}
+
+// MoveThread is filed under the forum the thread moved to.
+func (fr *ForumRepository) MoveThread(ctx context.Context, slugOrID string, forum string) (*models.Thread, error) {
+	var thread *models.Thread
+	err := fr.write(ctx, func(ctx context.Context) (err error) {
+		before := fr.thread(ctx, slugOrID)
+		if thread, err = fr.ForumRepository.MoveThread(ctx, slugOrID, forum); err != nil {
+			return err
+		}
+		return fr.record(ctx, entry(ctx, "", "thread.move", models.EntityThread, strconv.Itoa(thread.ID),
+			thread.Forum, before, thread))
+	})
+	return thread, err
+}
+
+// MergeThreads is filed under the merged thread, with the thread it went into
+// as the after state.
+func (fr *ForumRepository) MergeThreads(ctx context.Context, slugOrID string, into string) (*models.Thread, error) {
+	var thread *models.Thread
+	err := fr.write(ctx, func(ctx context.Context) (err error) {
+		before := fr.thread(ctx, slugOrID)
+		if thread, err = fr.ForumRepository.MergeThreads(ctx, slugOrID, into); err != nil || before == nil {
+			return err
+		}
+		return fr.record(ctx, entry(ctx, "", "thread.merge", models.EntityThread, strconv.Itoa(before.ID),
+			before.Forum, before, thread))
+	})
+	return thread, err
+}
+
+// SplitThread is filed under the new thread, with the post it starts from as
+// the before state.
+func (fr *ForumRepository) SplitThread(ctx context.Context, split *models.ThreadSplit) (*models.Thread, error) {
+	var thread *models.Thread
+	err := fr.write(ctx, func(ctx context.Context) (err error) {
+		before := fr.post(ctx, split.Post)
+		if thread, err = fr.ForumRepository.SplitThread(ctx, split); err != nil {
+			return err
+		}
+		return fr.record(ctx, entry(ctx, "", "thread.split", models.EntityThread, strconv.Itoa(thread.ID),
+			thread.Forum, before, thread))
+	})
+	return thread, err
+}
+
+func (fr *ForumRepository) InsertOrUpdateVote(ctx context.Context, slugOrID string, vote *models.Vote) (*models.Thread, error) {
+	var thread *models.Thread
+	err := fr.write(ctx, func(ctx context.Context) (err error) {
+		if thread, err = fr.ForumRepository.InsertOrUpdateVote(ctx, slugOrID, vote); err != nil {
+			return err
+		}
+		return fr.record(ctx, entry(ctx, vote.Nickname, "thread.vote", models.EntityThread, strconv.Itoa(thread.ID),
+			thread.Forum, nil, vote))
+	})
+	return thread, err
+}
+
+func (fr *ForumRepository) InsertOrUpdatePostVote(ctx context.Context, vote *models.Vote) (*models.Post, error) {
+	var post *models.Post
+	err := fr.write(ctx, func(ctx context.Context) (err error) {
+		if post, err = fr.ForumRepository.InsertOrUpdatePostVote(ctx, vote); err != nil {
+			return err
+		}
+		return fr.record(ctx, entry(ctx, vote.Nickname, "post.vote", models.EntityPost, strconv.Itoa(post.ID),
+			post.Forum, nil, vote))
+	})
+	return post, err
+}
+
+func (fr *ForumRepository) AddReaction(ctx context.Context, reaction *models.Reaction) (*models.Post, error) {
+	var post *models.Post
+	err := fr.write(ctx, func(ctx context.Context) (err error) {
+		if post, err = fr.ForumRepository.AddReaction(ctx, reaction); err != nil {
+			return err
+		}
+		return fr.record(ctx, entry(ctx, reaction.Nickname, "post.react", models.EntityPost, strconv.Itoa(post.ID),
+			post.Forum, nil, reaction))
+	})
+	return post, err
+}
+
+func (fr *ForumRepository) RemoveReaction(ctx context.Context, reaction *models.Reaction) (*models.Post, error) {
+	var post *models.Post
+	err := fr.write(ctx, func(ctx context.Context) (err error) {
+		if post, err = fr.ForumRepository.RemoveReaction(ctx, reaction); err != nil {
+			return err
+		}
+		return fr.record(ctx, entry(ctx, reaction.Nickname, "post.unreact", models.EntityPost, strconv.Itoa(post.ID),
+			post.Forum, reaction, nil))
+	})
+	return post, err
+}
@@ -0,0 +1,62 @@
+package audit
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/dantedoyl/Tech_DB_Forum/internal/models"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/notifications"
+)
+
+// NotificationRepository records the subscriptions of a
+// notifications.NotificationRepository. Inboxes are not audited.
+type NotificationRepository struct {
+	notifications.NotificationRepository
+	recorder
+}
+
+func NewNotificationRepository(repo notifications.NotificationRepository, log AuditRepository) *NotificationRepository {
+	return &NotificationRepository{NotificationRepository: repo, recorder: recorder{log: log}}
+}
+
+func (nr *NotificationRepository) SubscribeThread(ctx context.Context, nickname string, slugOrID string) (*models.Subscription, error) {
+	var sub *models.Subscription
+	err := nr.write(ctx, func(ctx context.Context) (err error) {
+		if sub, err = nr.NotificationRepository.SubscribeThread(ctx, nickname, slugOrID); err != nil {
+			return err
+		}
+		return nr.record(ctx, entry(ctx, sub.Nickname, "thread.subscribe", models.EntityThread,
+			strconv.Itoa(sub.Thread), "", nil, sub))
+	})
+	return sub, err
+}
+
+func (nr *NotificationRepository) UnsubscribeThread(ctx context.Context, nickname string, slugOrID string) error {
+	return nr.write(ctx, func(ctx context.Context) error {
+		if err := nr.NotificationRepository.UnsubscribeThread(ctx, nickname, slugOrID); err != nil {
+			return err
+		}
+		return nr.record(ctx, entry(ctx, nickname, "thread.unsubscribe", models.EntityThread, slugOrID, "", nil, nil))
+	})
+}
+
+func (nr *NotificationRepository) SubscribeForum(ctx context.Context, nickname string, slug string) (*models.Subscription, error) {
+	var sub *models.Subscription
+	err := nr.write(ctx, func(ctx context.Context) (err error) {
+		if sub, err = nr.NotificationRepository.SubscribeForum(ctx, nickname, slug); err != nil {
+			return err
+		}
+		return nr.record(ctx, entry(ctx, sub.Nickname, "forum.subscribe", models.EntityForum, sub.Forum, sub.Forum,
+			nil, sub))
+	})
+	return sub, err
+}
+
+func (nr *NotificationRepository) UnsubscribeForum(ctx context.Context, nickname string, slug string) error {
+	return nr.write(ctx, func(ctx context.Context) error {
+		if err := nr.NotificationRepository.UnsubscribeForum(ctx, nickname, slug); err != nil {
+			return err
+		}
+		return nr.record(ctx, entry(ctx, nickname, "forum.unsubscribe", models.EntityForum, slug, slug, nil, nil))
+	})
+}
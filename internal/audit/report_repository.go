@@ -0,0 +1,56 @@
+package audit
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/dantedoyl/Tech_DB_Forum/internal/models"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/reports"
+)
+
+// ReportRepository records the reports a reports.ReportRepository files and
+// closes.
+type ReportRepository struct {
+	reports.ReportRepository
+	recorder
+}
+
+func NewReportRepository(repo reports.ReportRepository, log AuditRepository) *ReportRepository {
+	return &ReportRepository{ReportRepository: repo, recorder: recorder{log: log}}
+}
+
+func (rr *ReportRepository) ReportPost(ctx context.Context, report *models.Report) error {
+	return rr.write(ctx, func(ctx context.Context) error {
+		if err := rr.ReportRepository.ReportPost(ctx, report); err != nil {
+			return err
+		}
+		return rr.created(ctx, report)
+	})
+}
+
+func (rr *ReportRepository) ReportThread(ctx context.Context, slugOrID string, report *models.Report) error {
+	return rr.write(ctx, func(ctx context.Context) error {
+		if err := rr.ReportRepository.ReportThread(ctx, slugOrID, report); err != nil {
+			return err
+		}
+		return rr.created(ctx, report)
+	})
+}
+
+func (rr *ReportRepository) created(ctx context.Context, report *models.Report) error {
+	return rr.record(ctx, entry(ctx, report.Reporter, "report.create", models.EntityReport, strconv.Itoa(report.ID),
+		report.Forum, nil, report))
+}
+
+func (rr *ReportRepository) CloseReport(ctx context.Context, id int, status string, handledBy string) (*models.Report, error) {
+	var report *models.Report
+	err := rr.write(ctx, func(ctx context.Context) (err error) {
+		before, _ := rr.ReportRepository.GetReport(ctx, id)
+		if report, err = rr.ReportRepository.CloseReport(ctx, id, status, handledBy); err != nil {
+			return err
+		}
+		return rr.record(ctx, entry(ctx, handledBy, "report.close", models.EntityReport, strconv.Itoa(id),
+			report.Forum, before, report))
+	})
+	return report, err
+}
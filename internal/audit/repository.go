@@ -0,0 +1,16 @@
+package audit
+
+import (
+	"context"
+
+	"github.com/dantedoyl/Tech_DB_Forum/internal/models"
+)
+
+// AuditRepository is the append-only store of audit entries.
+type AuditRepository interface {
+	// Atomic calls fn in one transaction that Record and the repositories fn
+	// calls with its context join.
+	Atomic(ctx context.Context, fn func(ctx context.Context) error) error
+	Record(ctx context.Context, entries ...*models.AuditEntry) error
+	GetEntries(ctx context.Context, query *models.AuditQuery) ([]*models.AuditEntry, error)
+}
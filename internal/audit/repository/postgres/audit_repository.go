@@ -0,0 +1,147 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dantedoyl/Tech_DB_Forum/internal/models"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/pgtx"
+	"github.com/jackc/pgx"
+)
+
+const defaultLimit = 100
+
+type AuditRepository struct {
+	dbConn *pgx.ConnPool
+}
+
+func NewAuditRepository(conn *pgx.ConnPool) *AuditRepository {
+	return &AuditRepository{dbConn: conn}
+}
+
+// conn is the transaction ctx carries, or the pool.
+func (ar AuditRepository) conn(ctx context.Context) pgtx.Queryer {
+	return pgtx.Conn(ctx, ar.dbConn)
+}
+
+func (ar AuditRepository) Atomic(ctx context.Context, fn func(ctx context.Context) error) error {
+	return pgtx.Run(ctx, ar.dbConn, fn)
+}
+
+func jsonb(raw json.RawMessage) interface{} {
+	if raw == nil {
+		return nil
+	}
+	return string(raw)
+}
+
+// Record appends entries in one statement, filling in their ids and times.
+func (ar AuditRepository) Record(ctx context.Context, entries ...*models.AuditEntry) error {
+	var args []interface{}
+	values := make([]string, 0, len(entries))
+	for _, e := range entries {
+		n := len(args)
+		values = append(values, fmt.Sprintf(`(NULLIF($%d, ''), $%d, $%d, $%d, NULLIF($%d, ''), $%d::jsonb, $%d::jsonb)`,
+			n+1, n+2, n+3, n+4, n+5, n+6, n+7))
+		args = append(args, e.Actor, e.Action, string(e.Entity), e.Target, e.Forum, jsonb(e.Before), jsonb(e.After))
+	}
+
+	rows, err := ar.conn(ctx).QueryEx(ctx, `INSERT INTO audit_log(actor, action, entity, target, forum, before, after)
+		VALUES `+strings.Join(values, ", ")+` RETURNING id, created`, nil, args...)
+	if err != nil {
+		return models.Internal(err)
+	}
+	defer rows.Close()
+
+	for i := 0; rows.Next(); i++ {
+		if err := rows.Scan(&entries[i].ID, &entries[i].Created); err != nil {
+			return models.Internal(err)
+		}
+	}
+	if rows.Err() != nil {
+		return models.Internal(rows.Err())
+	}
+	return nil
+}
+
+// GetEntries pages through the log by id, oldest first unless query.Desc.
+func (ar AuditRepository) GetEntries(ctx context.Context, query *models.AuditQuery) ([]*models.AuditEntry, error) {
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	where := `TRUE`
+	if query.Actor != "" {
+		where += ` AND actor = ` + arg(query.Actor)
+	}
+	if query.Forum != "" {
+		where += ` AND forum = ` + arg(query.Forum)
+	}
+	if query.Action != "" {
+		where += ` AND action = ` + arg(query.Action)
+	}
+	if query.From != "" {
+		from, err := time.Parse(time.RFC3339Nano, query.From)
+		if err != nil {
+			return nil, models.Invalid("from", "must be an RFC 3339 timestamp")
+		}
+		where += ` AND created >= ` + arg(from)
+	}
+	if query.To != "" {
+		to, err := time.Parse(time.RFC3339Nano, query.To)
+		if err != nil {
+			return nil, models.Invalid("to", "must be an RFC 3339 timestamp")
+		}
+		where += ` AND created < ` + arg(to)
+	}
+	order := `id`
+	if query.Desc {
+		order = `id DESC`
+		if query.Since > 0 {
+			where += ` AND id < ` + arg(query.Since)
+		}
+	} else if query.Since > 0 {
+		where += ` AND id > ` + arg(query.Since)
+	}
+	limit := query.Limit
+	if limit <= 0 {
+		limit = defaultLimit
+	}
+
+	sql := fmt.Sprintf(`SELECT id, COALESCE(actor, ''), action, entity, target, COALESCE(forum, ''),
+			COALESCE(before::text, ''), COALESCE(after::text, ''), created
+		FROM audit_log WHERE %s ORDER BY %s LIMIT %s`, where, order, arg(limit))
+	rows, err := ar.conn(ctx).QueryEx(ctx, sql, nil, args...)
+	if err != nil {
+		return nil, models.Internal(err)
+	}
+	defer rows.Close()
+
+	entries := []*models.AuditEntry{}
+	for rows.Next() {
+		e := &models.AuditEntry{}
+		var entity, before, after string
+		err = rows.Scan(&e.ID, &e.Actor, &e.Action, &entity, &e.Target, &e.Forum, &before, &after, &e.Created)
+		if err != nil {
+			return nil, models.Internal(err)
+		}
+		e.Entity = models.Entity(entity)
+		if before != "" {
+			e.Before = json.RawMessage(before)
+		}
+		if after != "" {
+			e.After = json.RawMessage(after)
+		}
+		entries = append(entries, e)
+	}
+	if rows.Err() != nil {
+		return nil, models.Internal(rows.Err())
+	}
+	return entries, nil
+}
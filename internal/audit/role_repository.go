@@ -0,0 +1,50 @@
+package audit
+
+import (
+	"context"
+
+	"github.com/dantedoyl/Tech_DB_Forum/internal/models"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/roles"
+)
+
+// RoleRepository records role changes and moderator grants of a
+// roles.RoleRepository.
+type RoleRepository struct {
+	roles.RoleRepository
+	recorder
+}
+
+func NewRoleRepository(repo roles.RoleRepository, log AuditRepository) *RoleRepository {
+	return &RoleRepository{RoleRepository: repo, recorder: recorder{log: log}}
+}
+
+func (rr *RoleRepository) SetRole(ctx context.Context, nickname string, role models.Role) (*models.UserRole, error) {
+	var after *models.UserRole
+	err := rr.write(ctx, func(ctx context.Context) (err error) {
+		before, _ := rr.RoleRepository.GetUserRole(ctx, nickname)
+		if after, err = rr.RoleRepository.SetRole(ctx, nickname, role); err != nil {
+			return err
+		}
+		return rr.record(ctx, entry(ctx, "", "role.set", models.EntityUser, nickname, "", before, after))
+	})
+	return after, err
+}
+
+func (rr *RoleRepository) GrantModerator(ctx context.Context, moderator *models.Moderator) error {
+	return rr.write(ctx, func(ctx context.Context) error {
+		if err := rr.RoleRepository.GrantModerator(ctx, moderator); err != nil {
+			return err
+		}
+		return rr.record(ctx, entry(ctx, moderator.GrantedBy, "moderator.grant", models.EntityModerator,
+			moderator.Nickname, moderator.Forum, nil, moderator))
+	})
+}
+
+func (rr *RoleRepository) RevokeModerator(ctx context.Context, slug string, nickname string) error {
+	return rr.write(ctx, func(ctx context.Context) error {
+		if err := rr.RoleRepository.RevokeModerator(ctx, slug, nickname); err != nil {
+			return err
+		}
+		return rr.record(ctx, entry(ctx, "", "moderator.revoke", models.EntityModerator, nickname, slug, nil, nil))
+	})
+}
@@ -0,0 +1,50 @@
+package audit
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/dantedoyl/Tech_DB_Forum/internal/models"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/webhooks"
+)
+
+// WebhookRepository records the webhooks a webhooks.WebhookRepository
+// registers and removes. Secrets are left out of the snapshots.
+type WebhookRepository struct {
+	webhooks.WebhookRepository
+	recorder
+}
+
+func NewWebhookRepository(repo webhooks.WebhookRepository, log AuditRepository) *WebhookRepository {
+	return &WebhookRepository{WebhookRepository: repo, recorder: recorder{log: log}}
+}
+
+func redacted(webhook *models.Webhook) *models.Webhook {
+	if webhook == nil {
+		return nil
+	}
+	copied := *webhook
+	copied.Secret = ""
+	return &copied
+}
+
+func (wr *WebhookRepository) CreateWebhook(ctx context.Context, webhook *models.Webhook) error {
+	return wr.write(ctx, func(ctx context.Context) error {
+		if err := wr.WebhookRepository.CreateWebhook(ctx, webhook); err != nil {
+			return err
+		}
+		return wr.record(ctx, entry(ctx, webhook.CreatedBy, "webhook.create", models.EntityWebhook,
+			strconv.Itoa(webhook.ID), webhook.Forum, nil, redacted(webhook)))
+	})
+}
+
+func (wr *WebhookRepository) DeleteWebhook(ctx context.Context, id int) error {
+	return wr.write(ctx, func(ctx context.Context) error {
+		before, _ := wr.WebhookRepository.GetWebhook(ctx, id)
+		if err := wr.WebhookRepository.DeleteWebhook(ctx, id); err != nil || before == nil {
+			return err
+		}
+		return wr.record(ctx, entry(ctx, "", "webhook.delete", models.EntityWebhook, strconv.Itoa(id), before.Forum,
+			redacted(before), nil))
+	})
+}
@@ -0,0 +1,69 @@
+// Package auth holds password hashing, session tokens and the authenticated
+// caller carried in a request context.
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+type nicknameKey struct{}
+
+// WithNickname returns a copy of ctx carrying the authenticated nickname.
+func WithNickname(ctx context.Context, nickname string) context.Context {
+	return context.WithValue(ctx, nicknameKey{}, nickname)
+}
+
+// Nickname returns the authenticated caller of the request, if any.
+func Nickname(ctx context.Context) (string, bool) {
+	nickname, ok := ctx.Value(nicknameKey{}).(string)
+	return nickname, ok
+}
+
+func HashPassword(password string, cost int) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// CheckPassword reports whether password matches hash. An empty hash, an
+// account without password, matches nothing.
+func CheckPassword(hash, password string) bool {
+	if hash == "" {
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// NewToken returns a random opaque session token.
+func NewToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashToken is what the database stores in place of a token.
+func HashToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
+
+// BearerToken returns the token of an "Authorization: Bearer" header.
+func BearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	const prefix = "Bearer "
+	if len(header) <= len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return "", false
+	}
+	return strings.TrimSpace(header[len(prefix):]), true
+}
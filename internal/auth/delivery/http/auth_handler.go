@@ -0,0 +1,110 @@
+package delivery
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/dantedoyl/Tech_DB_Forum/internal/auth"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/models"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/response"
+	"github.com/gorilla/mux"
+)
+
+type AuthHandler struct {
+	AuthRepo   auth.AuthRepository
+	SessionTTL time.Duration
+	// dummyHash is checked against when the user is unknown or has no
+	// password, so that a login takes as long whether the account exists or not.
+	dummyHash string
+}
+
+func NewAuthHandler(r *mux.Router, authRepo auth.AuthRepository, sessionTTL time.Duration,
+	bcryptCost int) (*AuthHandler, error) {
+	dummyHash, err := auth.HashPassword("dummy password", bcryptCost)
+	if err != nil {
+		return nil, err
+	}
+	ah := &AuthHandler{AuthRepo: authRepo, SessionTTL: sessionTTL, dummyHash: dummyHash}
+	r.HandleFunc("/auth/login", ah.Login).Methods(http.MethodPost).Name("auth.login")
+	r.HandleFunc("/auth/logout", ah.Logout).Methods(http.MethodPost).Name("auth.logout")
+	r.HandleFunc("/auth/me", ah.Me).Methods(http.MethodGet).Name("auth.me")
+	return ah, nil
+}
+
+// Login exchanges a nickname and password for a session token. Unknown users
+// and wrong passwords get the same answer.
+func (ah *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	credentials := &models.Credentials{}
+	err := json.NewDecoder(r.Body).Decode(credentials)
+	if err != nil {
+		response.WriteError(w, response.InvalidBody(err))
+		return
+	}
+
+	nickname, hash, err := ah.AuthRepo.PasswordHash(r.Context(), credentials.Nickname)
+	var notFound *models.NotFoundError
+	if err != nil && !errors.As(err, &notFound) {
+		response.WriteError(w, err)
+		return
+	}
+	known := err == nil && hash != ""
+	if !known {
+		hash = ah.dummyHash
+	}
+	if !auth.CheckPassword(hash, credentials.Password) || !known {
+		response.WriteError(w, models.Unauthorized("wrong nickname or password"))
+		return
+	}
+
+	token, err := auth.NewToken()
+	if err != nil {
+		response.WriteError(w, models.Internal(err))
+		return
+	}
+	session := &models.Session{Token: token, Nickname: nickname, Expires: time.Now().Add(ah.SessionTTL)}
+	err = ah.AuthRepo.CreateSession(r.Context(), session, auth.HashToken(token))
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	response.WriteJSON(w, http.StatusOK, session)
+}
+
+func (ah *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	token, ok := auth.BearerToken(r)
+	if !ok {
+		response.WriteError(w, models.Unauthorized("authentication required"))
+		return
+	}
+	err := ah.AuthRepo.DeleteSession(r.Context(), auth.HashToken(token))
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (ah *AuthHandler) Me(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	token, ok := auth.BearerToken(r)
+	if !ok {
+		response.WriteError(w, models.Unauthorized("authentication required"))
+		return
+	}
+	session, err := ah.AuthRepo.GetSession(r.Context(), auth.HashToken(token))
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	response.WriteJSON(w, http.StatusOK, session)
+}
@@ -0,0 +1,39 @@
+package auth
+
+import (
+	"context"
+	"errors"
+
+	"github.com/dantedoyl/Tech_DB_Forum/internal/models"
+)
+
+// AuthRepository stores password hashes and login sessions. Sessions are
+// looked up by the hash of their token.
+type AuthRepository interface {
+	// PasswordHash returns the stored nickname spelling and password hash.
+	PasswordHash(ctx context.Context, nickname string) (string, string, error)
+	CreateSession(ctx context.Context, session *models.Session, tokenHash []byte) error
+	GetSession(ctx context.Context, tokenHash []byte) (*models.Session, error)
+	DeleteSession(ctx context.Context, tokenHash []byte) error
+}
+
+// CheckClaim rejects an anonymous request made in the name of nickname when
+// that account has a password: such users may only act through a session.
+// Unknown users are left for the caller to report. A nil repo checks nothing.
+func CheckClaim(ctx context.Context, repo AuthRepository, nickname string) error {
+	if repo == nil || nickname == "" {
+		return nil
+	}
+	_, hash, err := repo.PasswordHash(ctx, nickname)
+	var notFound *models.NotFoundError
+	if errors.As(err, &notFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if hash != "" {
+		return models.Unauthorized("user " + nickname + " has a password, log in to act as them")
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package postgres
+
+import (
+	"context"
+
+	"github.com/dantedoyl/Tech_DB_Forum/internal/models"
+	"github.com/jackc/pgx"
+)
+
+type AuthRepository struct {
+	dbConn *pgx.ConnPool
+}
+
+func NewAuthRepository(conn *pgx.ConnPool) *AuthRepository {
+	return &AuthRepository{dbConn: conn}
+}
+
+func (ar AuthRepository) PasswordHash(ctx context.Context, nickname string) (string, string, error) {
+	var hash string
+	err := ar.dbConn.QueryRowEx(ctx, `SELECT nickname, COALESCE(password_hash, '') FROM users WHERE nickname=$1`, nil,
+		nickname).Scan(&nickname, &hash)
+	if err == pgx.ErrNoRows {
+		return "", "", models.NotFound(models.EntityUser, nickname)
+	}
+	if err != nil {
+		return "", "", models.Internal(err)
+	}
+	return nickname, hash, nil
+}
+
+// CreateSession stores a new session and drops the expired ones.
+func (ar AuthRepository) CreateSession(ctx context.Context, session *models.Session, tokenHash []byte) error {
+	_, err := ar.dbConn.ExecEx(ctx, `DELETE FROM sessions WHERE expires_at <= now()`, nil)
+	if err != nil {
+		return models.Internal(err)
+	}
+	err = ar.dbConn.QueryRowEx(ctx, `INSERT INTO sessions(token_hash, nickname, expires_at) VALUES ($1, $2, $3)
+		RETURNING created`, nil, tokenHash, session.Nickname, session.Expires).Scan(&session.Created)
+	if err != nil {
+		return models.Internal(err)
+	}
+	return nil
+}
+
+func (ar AuthRepository) GetSession(ctx context.Context, tokenHash []byte) (*models.Session, error) {
+	session := &models.Session{}
+	err := ar.dbConn.QueryRowEx(ctx, `SELECT nickname, created, expires_at FROM sessions
+		WHERE token_hash=$1 AND expires_at > now()`, nil, tokenHash).Scan(&session.Nickname, &session.Created, &session.Expires)
+	if err == pgx.ErrNoRows {
+		return nil, models.NotFound(models.EntitySession, "")
+	}
+	if err != nil {
+		return nil, models.Internal(err)
+	}
+	return session, nil
+}
+
+func (ar AuthRepository) DeleteSession(ctx context.Context, tokenHash []byte) error {
+	_, err := ar.dbConn.ExecEx(ctx, `DELETE FROM sessions WHERE token_hash=$1`, nil, tokenHash)
+	if err != nil {
+		return models.Internal(err)
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package delivery
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dantedoyl/Tech_DB_Forum/internal/auth"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/bans"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/models"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/response"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/roles"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/schema"
+)
+
+type BansHandler struct {
+	BanRepo    bans.BanRepository
+	Authorizer *roles.Authorizer
+}
+
+func NewBansHandler(r *mux.Router, banRepo bans.BanRepository, authorizer *roles.Authorizer) *BansHandler {
+	bh := &BansHandler{BanRepo: banRepo, Authorizer: authorizer}
+	r.HandleFunc("/bans", bh.Bans).Methods(http.MethodGet).Name("bans.list")
+	r.HandleFunc("/bans", bh.CreateBan).Methods(http.MethodPost).Name("bans.create")
+	r.HandleFunc("/bans/{id:[0-9]+}", bh.Ban).Methods(http.MethodGet).Name("bans.details")
+	r.HandleFunc("/bans/{id:[0-9]+}", bh.LiftBan).Methods(http.MethodDelete).Name("bans.lift")
+	return bh
+}
+
+// authorize returns the caller if it may manage bans of forum: its moderators
+// for a forum, admins for site-wide bans.
+func (bh *BansHandler) authorize(r *http.Request, forum string) (string, error) {
+	caller, ok := auth.Nickname(r.Context())
+	if !ok {
+		return "", models.Unauthorized("authentication required")
+	}
+	if forum == "" {
+		return caller, bh.Authorizer.Admin(r.Context(), caller)
+	}
+	return caller, bh.Authorizer.Moderator(r.Context(), caller, forum)
+}
+
+// Bans lists the bans of a forum to its moderators, or all bans to admins.
+func (bh *BansHandler) Bans(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	query := &models.BanQuery{}
+	decoder := schema.NewDecoder()
+	decoder.IgnoreUnknownKeys(true)
+	if err := decoder.Decode(query, r.URL.Query()); err != nil {
+		response.WriteError(w, models.Invalid("query", err.Error()))
+		return
+	}
+
+	if _, err := bh.authorize(r, query.Forum); err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	list, err := bh.BanRepo.GetBans(r.Context(), query)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	response.WriteJSON(w, http.StatusOK, list)
+}
+
+// CreateBan bans a user. The ban ends at expires, after duration (such as
+// "72h") or never when neither is given.
+func (bh *BansHandler) CreateBan(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	body := &struct {
+		models.Ban
+		Duration string `json:"duration"`
+	}{}
+	err := json.NewDecoder(r.Body).Decode(body)
+	if err != nil {
+		response.WriteError(w, response.InvalidBody(err))
+		return
+	}
+
+	ban := &body.Ban
+	if ban.Nickname == "" {
+		response.WriteError(w, models.Invalid("nickname", "is required"))
+		return
+	}
+	if body.Duration != "" {
+		duration, err := time.ParseDuration(body.Duration)
+		if err != nil || duration <= 0 {
+			response.WriteError(w, models.Invalid("duration", "must be a positive duration such as 72h"))
+			return
+		}
+		expires := time.Now().Add(duration)
+		ban.Expires = &expires
+	} else if ban.Expires != nil && !ban.Expires.After(time.Now()) {
+		response.WriteError(w, models.Invalid("expires", "must be in the future"))
+		return
+	}
+
+	ban.IssuedBy, err = bh.authorize(r, ban.Forum)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	err = bh.BanRepo.CreateBan(r.Context(), ban)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	response.WriteJSON(w, http.StatusCreated, ban)
+}
+
+func (bh *BansHandler) Ban(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	ban, err := bh.BanRepo.GetBan(r.Context(), id)
+	if err == nil {
+		_, err = bh.authorize(r, ban.Forum)
+	}
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	response.WriteJSON(w, http.StatusOK, ban)
+}
+
+// LiftBan ends a ban early; the ban stays listed with who lifted it.
+func (bh *BansHandler) LiftBan(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	ban, err := bh.BanRepo.GetBan(r.Context(), id)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+	caller, err := bh.authorize(r, ban.Forum)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	ban, err = bh.BanRepo.LiftBan(r.Context(), id, caller)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	response.WriteJSON(w, http.StatusOK, ban)
+}
@@ -0,0 +1,16 @@
+package bans
+
+import (
+	"context"
+
+	"github.com/dantedoyl/Tech_DB_Forum/internal/models"
+)
+
+// BanRepository issues, lists and lifts bans. The forum repository enforces
+// them when threads, posts and votes are created.
+type BanRepository interface {
+	CreateBan(ctx context.Context, ban *models.Ban) error
+	GetBan(ctx context.Context, id int) (*models.Ban, error)
+	GetBans(ctx context.Context, query *models.BanQuery) ([]*models.Ban, error)
+	LiftBan(ctx context.Context, id int, liftedBy string) (*models.Ban, error)
+}
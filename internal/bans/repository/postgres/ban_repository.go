@@ -0,0 +1,166 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/dantedoyl/Tech_DB_Forum/internal/models"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/pgtx"
+	"github.com/jackc/pgx"
+	"github.com/jackc/pgx/pgtype"
+)
+
+const (
+	codeNotNullViolation    = "23502"
+	codeForeignKeyViolation = "23503"
+)
+
+const defaultLimit = 100
+
+const banColumns = `id, nickname, COALESCE(forum, ''), reason, COALESCE(issued_by, ''), created, expires_at,
+	lifted_at, COALESCE(lifted_by, '')`
+
+type BanRepository struct {
+	dbConn *pgx.ConnPool
+}
+
+func NewBanRepository(conn *pgx.ConnPool) *BanRepository {
+	return &BanRepository{dbConn: conn}
+}
+
+// conn is the transaction ctx carries, or the pool.
+func (br BanRepository) conn(ctx context.Context) pgtx.Queryer {
+	return pgtx.Conn(ctx, br.dbConn)
+}
+
+func scanBan(row interface{ Scan(...interface{}) error }) (*models.Ban, error) {
+	ban := &models.Ban{}
+	var expires, lifted pgtype.Timestamptz
+	err := row.Scan(&ban.ID, &ban.Nickname, &ban.Forum, &ban.Reason, &ban.IssuedBy, &ban.Created, &expires,
+		&lifted, &ban.LiftedBy)
+	if err != nil {
+		return nil, err
+	}
+	if expires.Status == pgtype.Present {
+		ban.Expires = &expires.Time
+	}
+	if lifted.Status == pgtype.Present {
+		ban.Lifted = &lifted.Time
+	}
+	return ban, nil
+}
+
+// CreateBan stores ban with the canonical spelling of its user and forum.
+func (br BanRepository) CreateBan(ctx context.Context, ban *models.Ban) error {
+	if ban.Forum != "" {
+		err := br.conn(ctx).QueryRowEx(ctx, `SELECT slug FROM forum WHERE slug=$1`, nil, ban.Forum).Scan(&ban.Forum)
+		if err == pgx.ErrNoRows {
+			return models.NotFound(models.EntityForum, ban.Forum)
+		}
+		if err != nil {
+			return models.Internal(err)
+		}
+	}
+
+	row := br.conn(ctx).QueryRowEx(ctx, `INSERT INTO bans(nickname, forum, reason, issued_by, expires_at)
+		VALUES ((SELECT nickname FROM users WHERE nickname=$1), NULLIF($2, ''), $3, NULLIF($4, ''), $5)
+		RETURNING `+banColumns, nil, ban.Nickname, ban.Forum, ban.Reason, ban.IssuedBy, ban.Expires)
+	created, err := scanBan(row)
+	if err != nil {
+		// An unknown nickname selects NULL.
+		if pgErrorIs(err, codeNotNullViolation) || pgErrorIs(err, codeForeignKeyViolation) {
+			return models.NotFound(models.EntityUser, ban.Nickname)
+		}
+		return models.Internal(err)
+	}
+	*ban = *created
+	return nil
+}
+
+func pgErrorIs(err error, code string) bool {
+	pgErr, ok := err.(pgx.PgError)
+	return ok && pgErr.Code == code
+}
+
+func (br BanRepository) GetBan(ctx context.Context, id int) (*models.Ban, error) {
+	row := br.conn(ctx).QueryRowEx(ctx, `SELECT `+banColumns+` FROM bans WHERE id=$1`, nil, id)
+	ban, err := scanBan(row)
+	if err == pgx.ErrNoRows {
+		return nil, models.NotFound(models.EntityBan, strconv.Itoa(id))
+	}
+	if err != nil {
+		return nil, models.Internal(err)
+	}
+	return ban, nil
+}
+
+// GetBans pages through bans by id. Active keeps only the bans in force.
+func (br BanRepository) GetBans(ctx context.Context, query *models.BanQuery) ([]*models.Ban, error) {
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	where := `TRUE`
+	if query.Nickname != "" {
+		where += ` AND nickname = ` + arg(query.Nickname)
+	}
+	if query.Forum != "" {
+		where += ` AND forum = ` + arg(query.Forum)
+	}
+	if query.Active {
+		where += ` AND lifted_at IS NULL AND (expires_at IS NULL OR expires_at > now())`
+	}
+	order := `id`
+	if query.Desc {
+		order = `id DESC`
+		if query.Since > 0 {
+			where += ` AND id < ` + arg(query.Since)
+		}
+	} else if query.Since > 0 {
+		where += ` AND id > ` + arg(query.Since)
+	}
+	limit := query.Limit
+	if limit <= 0 {
+		limit = defaultLimit
+	}
+
+	sql := fmt.Sprintf(`SELECT %s FROM bans WHERE %s ORDER BY %s LIMIT %s`, banColumns, where, order, arg(limit))
+	rows, err := br.conn(ctx).QueryEx(ctx, sql, nil, args...)
+	if err != nil {
+		return nil, models.Internal(err)
+	}
+	defer rows.Close()
+
+	bans := []*models.Ban{}
+	for rows.Next() {
+		ban, err := scanBan(rows)
+		if err != nil {
+			return nil, models.Internal(err)
+		}
+		bans = append(bans, ban)
+	}
+	if rows.Err() != nil {
+		return nil, models.Internal(rows.Err())
+	}
+	return bans, nil
+}
+
+// LiftBan ends a ban early. Lifting a ban twice is a conflict.
+func (br BanRepository) LiftBan(ctx context.Context, id int, liftedBy string) (*models.Ban, error) {
+	row := br.conn(ctx).QueryRowEx(ctx, `UPDATE bans SET lifted_at=now(), lifted_by=NULLIF($2, '')
+		WHERE id=$1 AND lifted_at IS NULL RETURNING `+banColumns, nil, id, liftedBy)
+	ban, err := scanBan(row)
+	if err == pgx.ErrNoRows {
+		if _, err := br.GetBan(ctx, id); err != nil {
+			return nil, err
+		}
+		return nil, &models.ConflictError{Entity: models.EntityBan, Reason: fmt.Sprintf("ban %d is already lifted", id)}
+	}
+	if err != nil {
+		return nil, models.Internal(err)
+	}
+	return ban, nil
+}
@@ -0,0 +1,297 @@
+// Package config loads the server configuration.
+//
+// Every setting has a built-in default and can be overridden, from the lowest
+// to the highest precedence, by:
+//
+//  1. a YAML file given with -config or FORUM_CONFIG;
+//  2. environment variables (FORUM_DB_HOST, FORUM_HTTP_ADDR, ...);
+//  3. command line flags (-db-host, -http-addr, ...).
+//
+// Run the binary with -h to list all flags together with their variables.
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dantedoyl/Tech_DB_Forum/internal/models"
+	"github.com/jackc/pgx"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v2"
+)
+
+const envPrefix = "FORUM_"
+
+type Config struct {
+	Database  DatabaseConfig  `yaml:"database"`
+	HTTP      HTTPConfig      `yaml:"http"`
+	Auth      AuthConfig      `yaml:"auth"`
+	Features  FeaturesConfig  `yaml:"features"`
+	Reactions ReactionsConfig `yaml:"reactions"`
+	Webhooks  WebhooksConfig  `yaml:"webhooks"`
+}
+
+type DatabaseConfig struct {
+	// DSN is a libpq connection string or postgres:// URL. When set it
+	// replaces Host, Port, Name, User and Password.
+	DSN                  string        `yaml:"dsn"`
+	Host                 string        `yaml:"host"`
+	Port                 int           `yaml:"port"`
+	Name                 string        `yaml:"name"`
+	User                 string        `yaml:"user"`
+	Password             string        `yaml:"password"`
+	PreferSimpleProtocol bool          `yaml:"prefer_simple_protocol"`
+	MaxConnections       int           `yaml:"max_connections"`
+	AcquireTimeout       time.Duration `yaml:"acquire_timeout"`
+}
+
+type HTTPConfig struct {
+	Addr         string        `yaml:"addr"`
+	ReadTimeout  time.Duration `yaml:"read_timeout"`
+	WriteTimeout time.Duration `yaml:"write_timeout"`
+	IdleTimeout  time.Duration `yaml:"idle_timeout"`
+	// ShutdownTimeout bounds how long in-flight requests may run after a
+	// termination signal; DrainDelay is spent reporting not-ready first.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	DrainDelay      time.Duration `yaml:"drain_delay"`
+	// RequestTimeout caps the database work done for one request. Entries of
+	// EndpointTimeouts override it for single routes, keyed by route name
+	// (forum.threads, thread.posts, ...); zero means no limit.
+	RequestTimeout   time.Duration            `yaml:"request_timeout"`
+	EndpointTimeouts map[string]time.Duration `yaml:"endpoint_timeouts"`
+}
+
+type AuthConfig struct {
+	// Required rejects anonymous writes. Without it, writes with a session
+	// token act as its user and the others trust the nickname in the body,
+	// as the original API does.
+	Required   bool          `yaml:"required"`
+	SessionTTL time.Duration `yaml:"session_ttl"`
+	BcryptCost int           `yaml:"bcrypt_cost"`
+}
+
+type FeaturesConfig struct {
+	AccessLog bool `yaml:"access_log"`
+	// AuditLog records every write in the audit_log table. Postgres only.
+	AuditLog bool `yaml:"audit_log"`
+	// InMemory serves the API from process memory instead of Postgres.
+	InMemory bool `yaml:"in_memory"`
+}
+
+type ReactionsConfig struct {
+	// Keys are the reactions users may leave on posts.
+	Keys []string `yaml:"keys"`
+}
+
+type WebhooksConfig struct {
+	// Webhooks may not reach loopback, private or link-local addresses.
+	// AllowCIDRs opens blocks of them, such as a receiver on the internal
+	// network; DenyCIDRs blocks more. An allowed block wins over a denied one.
+	AllowCIDRs []string `yaml:"allow_cidrs"`
+	DenyCIDRs  []string `yaml:"deny_cidrs"`
+}
+
+func Default() *Config {
+	return &Config{
+		Database: DatabaseConfig{
+			Host:                 "localhost",
+			Port:                 5432,
+			Name:                 "techdb",
+			User:                 "dantedoyl",
+			Password:             "mypassword",
+			PreferSimpleProtocol: true,
+			MaxConnections:       100,
+		},
+		HTTP: HTTPConfig{
+			Addr:         ":5000",
+			ReadTimeout:  15 * time.Second,
+			WriteTimeout: 15 * time.Second,
+			IdleTimeout:  60 * time.Second,
+
+			ShutdownTimeout: 15 * time.Second,
+			RequestTimeout:  10 * time.Second,
+		},
+		Auth: AuthConfig{
+			SessionTTL: 30 * 24 * time.Hour,
+			BcryptCost: bcrypt.DefaultCost,
+		},
+		Features: FeaturesConfig{
+			AuditLog: true,
+		},
+		Reactions: ReactionsConfig{
+			Keys: append([]string(nil), models.DefaultReactions...),
+		},
+	}
+}
+
+// Load builds the configuration from args (without the program name) and the
+// process environment. It returns the arguments left after flag parsing.
+func Load(args []string) (*Config, []string, error) {
+	fs := flag.NewFlagSet("forum", flag.ContinueOnError)
+	configPath := fs.String("config", os.Getenv(envPrefix+"CONFIG"), "path to a YAML config file (env "+envPrefix+"CONFIG)")
+
+	flagValues := make(map[string]*rawValue, len(settings))
+	for _, s := range settings {
+		v := &rawValue{isBool: s.isBool}
+		flagValues[s.name] = v
+		fs.Var(v, s.name, fmt.Sprintf("%s (env %s)", s.usage, s.env()))
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	cfg := Default()
+	if *configPath != "" {
+		if err := cfg.loadFile(*configPath); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for _, s := range settings {
+		if value, ok := os.LookupEnv(s.env()); ok {
+			if err := s.apply(cfg, value); err != nil {
+				return nil, nil, fmt.Errorf("config: %s: %v", s.env(), err)
+			}
+		}
+	}
+
+	for _, s := range settings {
+		if v := flagValues[s.name]; v.set {
+			if err := s.apply(cfg, v.value); err != nil {
+				return nil, nil, fmt.Errorf("config: -%s: %v", s.name, err)
+			}
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, err
+	}
+	return cfg, fs.Args(), nil
+}
+
+func (c *Config) loadFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config: %v", err)
+	}
+	if err := yaml.UnmarshalStrict(data, c); err != nil {
+		return fmt.Errorf("config: %s: %v", path, err)
+	}
+	return nil
+}
+
+// Validate reports every invalid setting at once.
+func (c *Config) Validate() error {
+	var problems []string
+	switch {
+	case c.Features.InMemory:
+		// The connection settings are not used.
+	case c.Database.DSN != "":
+		if _, err := pgx.ParseConnectionString(c.Database.DSN); err != nil {
+			problems = append(problems, fmt.Sprintf("database.dsn: %v", err))
+		}
+	default:
+		if c.Database.Host == "" {
+			problems = append(problems, "database.host must not be empty")
+		}
+		if c.Database.Port <= 0 || c.Database.Port > 65535 {
+			problems = append(problems, fmt.Sprintf("database.port %d is out of range", c.Database.Port))
+		}
+		if c.Database.Name == "" {
+			problems = append(problems, "database.name must not be empty")
+		}
+		if c.Database.User == "" {
+			problems = append(problems, "database.user must not be empty")
+		}
+	}
+	if c.Database.MaxConnections < 2 {
+		problems = append(problems, "database.max_connections must be at least 2")
+	}
+	if c.Database.AcquireTimeout < 0 {
+		problems = append(problems, "database.acquire_timeout must not be negative")
+	}
+	if c.HTTP.Addr == "" {
+		problems = append(problems, "http.addr must not be empty")
+	}
+	if c.HTTP.ReadTimeout < 0 || c.HTTP.WriteTimeout < 0 || c.HTTP.IdleTimeout < 0 || c.HTTP.DrainDelay < 0 {
+		problems = append(problems, "http timeouts must not be negative")
+	}
+	if c.HTTP.RequestTimeout < 0 {
+		problems = append(problems, "http.request_timeout must not be negative")
+	}
+	for name, timeout := range c.HTTP.EndpointTimeouts {
+		if timeout < 0 {
+			problems = append(problems, fmt.Sprintf("http.endpoint_timeouts.%s must not be negative", name))
+		}
+	}
+	if c.HTTP.ShutdownTimeout <= 0 {
+		problems = append(problems, "http.shutdown_timeout must be positive")
+	}
+	if c.Auth.Required && c.Features.InMemory {
+		problems = append(problems, "auth.required needs the database, it cannot be used with features.in_memory")
+	}
+	if c.Auth.SessionTTL <= 0 {
+		problems = append(problems, "auth.session_ttl must be positive")
+	}
+	if c.Auth.BcryptCost < bcrypt.MinCost || c.Auth.BcryptCost > bcrypt.MaxCost {
+		problems = append(problems, fmt.Sprintf("auth.bcrypt_cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost))
+	}
+	if len(c.Reactions.Keys) == 0 {
+		problems = append(problems, "reactions.keys must not be empty")
+	}
+	seen := make(map[string]bool, len(c.Reactions.Keys))
+	for _, key := range c.Reactions.Keys {
+		switch {
+		case !models.ValidReactionKey(key):
+			problems = append(problems, fmt.Sprintf("reactions.keys: %q must be 1 to 32 characters of a-z, 0-9, _, + or -", key))
+		case seen[key]:
+			problems = append(problems, fmt.Sprintf("reactions.keys: %q is listed twice", key))
+		}
+		seen[key] = true
+	}
+	for _, cidr := range c.Webhooks.AllowCIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			problems = append(problems, fmt.Sprintf("webhooks.allow_cidrs: %q is not a CIDR block", cidr))
+		}
+	}
+	for _, cidr := range c.Webhooks.DenyCIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			problems = append(problems, fmt.Sprintf("webhooks.deny_cidrs: %q is not a CIDR block", cidr))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("config: invalid configuration:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// PoolConfig converts the database settings into a pgx pool configuration.
+func (c *Config) PoolConfig() (pgx.ConnPoolConfig, error) {
+	connConfig := pgx.ConnConfig{
+		Host:     c.Database.Host,
+		Port:     uint16(c.Database.Port),
+		Database: c.Database.Name,
+		User:     c.Database.User,
+		Password: c.Database.Password,
+	}
+	if c.Database.DSN != "" {
+		var err error
+		connConfig, err = pgx.ParseConnectionString(c.Database.DSN)
+		if err != nil {
+			return pgx.ConnPoolConfig{}, err
+		}
+	}
+	connConfig.PreferSimpleProtocol = c.Database.PreferSimpleProtocol
+
+	return pgx.ConnPoolConfig{
+		ConnConfig:     connConfig,
+		MaxConnections: c.Database.MaxConnections,
+		AcquireTimeout: c.Database.AcquireTimeout,
+	}, nil
+}
@@ -0,0 +1,263 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dantedoyl/Tech_DB_Forum/internal/models"
+)
+
+// setEnv replaces the FORUM_ variables of the process with env for the
+// duration of the test.
+func setEnv(t *testing.T, env map[string]string) {
+	t.Helper()
+	names := []string{envPrefix + "CONFIG"}
+	for _, s := range settings {
+		names = append(names, s.env())
+	}
+	for _, name := range names {
+		old, ok := os.LookupEnv(name)
+		os.Unsetenv(name)
+		t.Cleanup(func() {
+			if ok {
+				os.Setenv(name, old)
+			} else {
+				os.Unsetenv(name)
+			}
+		})
+	}
+	for name, value := range env {
+		os.Setenv(name, value)
+	}
+}
+
+// writeFile writes a config file and returns its path.
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "forum.yaml")
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// loaded is the part of a Config the precedence tests look at.
+type loaded struct {
+	Host     string
+	Port     int
+	Addr     string
+	InMemory bool
+	Timeout  time.Duration
+	Keys     []string
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	const file = `
+database:
+  host: file-host
+  port: 6000
+http:
+  addr: ":6000"
+  request_timeout: 6s
+reactions:
+  keys: [file]
+`
+	defaults := loaded{"localhost", 5432, ":5000", false, 10 * time.Second, models.DefaultReactions}
+	fromFile := loaded{"file-host", 6000, ":6000", false, 6 * time.Second, []string{"file"}}
+
+	tests := []struct {
+		name string
+		// file is written to disk and passed with -config, unless the
+		// environment names it with FORUM_CONFIG.
+		file string
+		env  map[string]string
+		args []string
+		want loaded
+	}{
+		{name: "defaults", want: defaults},
+		{name: "file over defaults", file: file, want: fromFile},
+		{name: "file named by the environment", file: file,
+			env:  map[string]string{"FORUM_CONFIG": "FILE"},
+			want: fromFile},
+		{name: "env over file", file: file,
+			env: map[string]string{
+				"FORUM_DB_HOST":              "env-host",
+				"FORUM_HTTP_REQUEST_TIMEOUT": "7s",
+				"FORUM_REACTIONS_KEYS":       "env, other",
+			},
+			want: loaded{"env-host", 6000, ":6000", false, 7 * time.Second, []string{"env", "other"}}},
+		{name: "flags over env", file: file,
+			env: map[string]string{"FORUM_DB_HOST": "env-host", "FORUM_DB_PORT": "7000", "FORUM_HTTP_ADDR": ":7000"},
+			args: []string{"-db-host", "flag-host", "-http-addr=:8000", "-feature-in-memory",
+				"-reactions-keys", "flag"},
+			want: loaded{"flag-host", 7000, ":8000", true, 6 * time.Second, []string{"flag"}}},
+		{name: "flags over defaults",
+			args: []string{"-db-port", "8000"},
+			want: loaded{"localhost", 8000, ":5000", false, 10 * time.Second, defaults.Keys}},
+		{name: "false flag over true env",
+			env:  map[string]string{"FORUM_FEATURE_IN_MEMORY": "true"},
+			args: []string{"-feature-in-memory=false"},
+			want: defaults},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := make(map[string]string)
+			for name, value := range tt.env {
+				env[name] = value
+			}
+			args := tt.args
+			if tt.file != "" {
+				path := writeFile(t, tt.file)
+				if env["FORUM_CONFIG"] == "FILE" {
+					env["FORUM_CONFIG"] = path
+				} else {
+					args = append([]string{"-config", path}, args...)
+				}
+			}
+			setEnv(t, env)
+
+			cfg, rest, err := Load(append(args, "extra"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := loaded{cfg.Database.Host, cfg.Database.Port, cfg.HTTP.Addr, cfg.Features.InMemory,
+				cfg.HTTP.RequestTimeout, cfg.Reactions.Keys}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("loaded %+v, want %+v", got, tt.want)
+			}
+			if !reflect.DeepEqual(rest, []string{"extra"}) {
+				t.Errorf("arguments left = %q, want [extra]", rest)
+			}
+		})
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		env  map[string]string
+		args []string
+		want string
+	}{
+		{name: "unknown file key", file: "database:\n  hots: x\n", want: "field hots not found"},
+		{name: "bad env value", env: map[string]string{"FORUM_DB_PORT": "port"}, want: "config: FORUM_DB_PORT:"},
+		{name: "bad flag value", args: []string{"-http-read-timeout", "soon"}, want: "config: -http-read-timeout:"},
+		{name: "bad endpoint timeout", env: map[string]string{"FORUM_HTTP_ENDPOINT_TIMEOUTS": "forum.threads"},
+			want: `"forum.threads" is not a name=duration pair`},
+		{name: "invalid result", args: []string{"-db-max-connections", "1"},
+			want: "database.max_connections must be at least 2"},
+		{name: "unknown flag", args: []string{"-db-hots", "x"}, want: "flag provided but not defined"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setEnv(t, tt.env)
+			args := tt.args
+			if tt.file != "" {
+				args = append([]string{"-config", writeFile(t, tt.file)}, args...)
+			}
+
+			_, _, err := Load(args)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(c *Config)
+		want   []string
+	}{
+		{"defaults", func(c *Config) {}, nil},
+		{"in memory ignores the connection", func(c *Config) {
+			c.Features.InMemory = true
+			c.Database.Host, c.Database.Port = "", 0
+		}, nil},
+		{"dsn replaces the connection", func(c *Config) {
+			c.Database.DSN = "postgres://forum@db/forum"
+			c.Database.Host = ""
+		}, nil},
+		{"bad dsn", func(c *Config) { c.Database.DSN = "postgres://forum@db:port/forum" }, []string{
+			"database.dsn: ",
+		}},
+		{"every problem", func(c *Config) {
+			c.Database.Host, c.Database.Port, c.Database.Name, c.Database.User = "", 70000, "", ""
+			c.Database.MaxConnections = 1
+			c.Database.AcquireTimeout = -time.Second
+			c.HTTP.Addr = ""
+			c.HTTP.IdleTimeout = -time.Second
+			c.HTTP.RequestTimeout = -time.Second
+			c.HTTP.EndpointTimeouts = map[string]time.Duration{"forum.threads": -time.Second}
+			c.HTTP.ShutdownTimeout = 0
+			c.Auth.SessionTTL = 0
+			c.Auth.BcryptCost = 1
+			c.Reactions.Keys = []string{"heart", "Heart!", "heart"}
+			c.Webhooks.AllowCIDRs = []string{"10.0.0.0/8", "10.1.2.3"}
+			c.Webhooks.DenyCIDRs = []string{"internal.example.com"}
+		}, []string{
+			"database.host must not be empty",
+			"database.port 70000 is out of range",
+			"database.name must not be empty",
+			"database.user must not be empty",
+			"database.max_connections must be at least 2",
+			"database.acquire_timeout must not be negative",
+			"http.addr must not be empty",
+			"http timeouts must not be negative",
+			"http.request_timeout must not be negative",
+			"http.endpoint_timeouts.forum.threads must not be negative",
+			"http.shutdown_timeout must be positive",
+			"auth.session_ttl must be positive",
+			"auth.bcrypt_cost must be between 4 and 31",
+			`reactions.keys: "Heart!" must be 1 to 32 characters of a-z, 0-9, _, + or -`,
+			`reactions.keys: "heart" is listed twice`,
+			`webhooks.allow_cidrs: "10.1.2.3" is not a CIDR block`,
+			`webhooks.deny_cidrs: "internal.example.com" is not a CIDR block`,
+		}},
+		{"auth without a database", func(c *Config) {
+			c.Features.InMemory = true
+			c.Auth.Required = true
+			c.Reactions.Keys = nil
+		}, []string{
+			"auth.required needs the database, it cannot be used with features.in_memory",
+			"reactions.keys must not be empty",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			tt.change(cfg)
+
+			err := cfg.Validate()
+			if tt.want == nil {
+				if err != nil {
+					t.Errorf("err = %v, want none", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("err = nil, want %q", tt.want)
+			}
+			lines := strings.Split(err.Error(), "\n  ")
+			if lines[0] != "config: invalid configuration:" {
+				t.Errorf("err starts with %q", lines[0])
+			}
+			got := lines[1:]
+			if len(got) != len(tt.want) {
+				t.Fatalf("problems = %q, want %q", got, tt.want)
+			}
+			for i, want := range tt.want {
+				if !strings.HasPrefix(got[i], want) {
+					t.Errorf("problem %d = %q, want %q", i, got[i], want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// setting binds one configuration field to a flag and an environment variable.
+type setting struct {
+	name   string
+	usage  string
+	isBool bool
+	apply  func(c *Config, value string) error
+}
+
+func (s setting) env() string {
+	return envPrefix + strings.ToUpper(strings.Replace(s.name, "-", "_", -1))
+}
+
+var settings = []setting{
+	stringSetting("db-dsn", "database connection string, overrides the other db-* connection settings",
+		func(c *Config) *string { return &c.Database.DSN }),
+	stringSetting("db-host", "database host", func(c *Config) *string { return &c.Database.Host }),
+	intSetting("db-port", "database port", func(c *Config) *int { return &c.Database.Port }),
+	stringSetting("db-name", "database name", func(c *Config) *string { return &c.Database.Name }),
+	stringSetting("db-user", "database user", func(c *Config) *string { return &c.Database.User }),
+	stringSetting("db-password", "database password", func(c *Config) *string { return &c.Database.Password }),
+	boolSetting("db-prefer-simple-protocol", "use the simple query protocol",
+		func(c *Config) *bool { return &c.Database.PreferSimpleProtocol }),
+	intSetting("db-max-connections", "connection pool size",
+		func(c *Config) *int { return &c.Database.MaxConnections }),
+	durationSetting("db-acquire-timeout", "how long to wait for a free pool connection, 0 waits forever",
+		func(c *Config) *time.Duration { return &c.Database.AcquireTimeout }),
+	stringSetting("http-addr", "HTTP listen address", func(c *Config) *string { return &c.HTTP.Addr }),
+	durationSetting("http-read-timeout", "HTTP read timeout",
+		func(c *Config) *time.Duration { return &c.HTTP.ReadTimeout }),
+	durationSetting("http-write-timeout", "HTTP write timeout",
+		func(c *Config) *time.Duration { return &c.HTTP.WriteTimeout }),
+	durationSetting("http-idle-timeout", "HTTP keep-alive idle timeout",
+		func(c *Config) *time.Duration { return &c.HTTP.IdleTimeout }),
+	durationSetting("http-request-timeout", "default deadline for the work done by one request, 0 disables it",
+		func(c *Config) *time.Duration { return &c.HTTP.RequestTimeout }),
+	durationMapSetting("http-endpoint-timeouts", "per-route deadlines as route=duration pairs separated by commas",
+		func(c *Config) *map[string]time.Duration { return &c.HTTP.EndpointTimeouts }),
+	durationSetting("http-shutdown-timeout", "how long to wait for in-flight requests on shutdown",
+		func(c *Config) *time.Duration { return &c.HTTP.ShutdownTimeout }),
+	durationSetting("http-drain-delay", "how long to report not-ready before shutting down",
+		func(c *Config) *time.Duration { return &c.HTTP.DrainDelay }),
+	boolSetting("auth-required", "reject writes without a session token",
+		func(c *Config) *bool { return &c.Auth.Required }),
+	durationSetting("auth-session-ttl", "lifetime of a login session",
+		func(c *Config) *time.Duration { return &c.Auth.SessionTTL }),
+	intSetting("auth-bcrypt-cost", "bcrypt cost of stored password hashes",
+		func(c *Config) *int { return &c.Auth.BcryptCost }),
+	boolSetting("feature-access-log", "log every HTTP request",
+		func(c *Config) *bool { return &c.Features.AccessLog }),
+	boolSetting("feature-audit-log", "record every write in the audit log",
+		func(c *Config) *bool { return &c.Features.AuditLog }),
+	boolSetting("feature-in-memory", "keep all data in process memory instead of Postgres",
+		func(c *Config) *bool { return &c.Features.InMemory }),
+	stringListSetting("reactions-keys", "reaction keys users may leave on posts, separated by commas",
+		func(c *Config) *[]string { return &c.Reactions.Keys }),
+	stringListSetting("webhooks-allow-cidrs", "blocked address ranges webhooks may reach anyway, separated by commas",
+		func(c *Config) *[]string { return &c.Webhooks.AllowCIDRs }),
+	stringListSetting("webhooks-deny-cidrs", "more address ranges webhooks may not reach, separated by commas",
+		func(c *Config) *[]string { return &c.Webhooks.DenyCIDRs }),
+}
+
+func stringSetting(name, usage string, field func(c *Config) *string) setting {
+	return setting{name: name, usage: usage, apply: func(c *Config, value string) error {
+		*field(c) = value
+		return nil
+	}}
+}
+
+func intSetting(name, usage string, field func(c *Config) *int) setting {
+	return setting{name: name, usage: usage, apply: func(c *Config, value string) error {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		*field(c) = n
+		return nil
+	}}
+}
+
+func boolSetting(name, usage string, field func(c *Config) *bool) setting {
+	return setting{name: name, usage: usage, isBool: true, apply: func(c *Config, value string) error {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		*field(c) = b
+		return nil
+	}}
+}
+
+func durationSetting(name, usage string, field func(c *Config) *time.Duration) setting {
+	return setting{name: name, usage: usage, apply: func(c *Config, value string) error {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*field(c) = d
+		return nil
+	}}
+}
+
+func stringListSetting(name, usage string, field func(c *Config) *[]string) setting {
+	return setting{name: name, usage: usage, apply: func(c *Config, value string) error {
+		var list []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		*field(c) = list
+		return nil
+	}}
+}
+
+func durationMapSetting(name, usage string, field func(c *Config) *map[string]time.Duration) setting {
+	return setting{name: name, usage: usage, apply: func(c *Config, value string) error {
+		m := make(map[string]time.Duration)
+		for _, pair := range strings.Split(value, ",") {
+			if pair = strings.TrimSpace(pair); pair == "" {
+				continue
+			}
+			parts := strings.SplitN(pair, "=", 2)
+			if len(parts) != 2 {
+				return fmt.Errorf("%q is not a name=duration pair", pair)
+			}
+			d, err := time.ParseDuration(parts[1])
+			if err != nil {
+				return err
+			}
+			m[strings.TrimSpace(parts[0])] = d
+		}
+		*field(c) = m
+		return nil
+	}}
+}
+
+// rawValue records a flag value so it can be applied after the config file
+// and the environment.
+type rawValue struct {
+	value  string
+	set    bool
+	isBool bool
+}
+
+func (v *rawValue) String() string {
+	return v.value
+}
+
+func (v *rawValue) Set(value string) error {
+	v.value, v.set = value, true
+	return nil
+}
+
+func (v *rawValue) IsBoolFlag() bool {
+	return v.isBool
+}
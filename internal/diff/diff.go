@@ -0,0 +1,110 @@
+// Package diff compares two texts word by word.
+package diff
+
+import "regexp"
+
+type Kind string
+
+const (
+	Equal  Kind = "equal"
+	Insert Kind = "insert"
+	Delete Kind = "delete"
+)
+
+// Op is one run of text that is kept, inserted or deleted on the way from the
+// old text to the new one.
+type Op struct {
+	Kind Kind   `json:"op"`
+	Text string `json:"text"`
+}
+
+// maxCells bounds the LCS table. Larger inputs are diffed only around their
+// common prefix and suffix.
+const maxCells = 4 << 20
+
+var token = regexp.MustCompile(`\s+|\S+`)
+
+// Words returns the operations turning a into b. Whitespace runs are tokens of
+// their own, so joining the Equal and Insert texts gives b back.
+func Words(a, b string) []Op {
+	x, y := token.FindAllString(a, -1), token.FindAllString(b, -1)
+
+	var ops []Op
+	prefix := 0
+	for prefix < len(x) && prefix < len(y) && x[prefix] == y[prefix] {
+		ops = appendOp(ops, Equal, x[prefix])
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(x)-prefix && suffix < len(y)-prefix && x[len(x)-1-suffix] == y[len(y)-1-suffix] {
+		suffix++
+	}
+	x, y, tail := x[prefix:len(x)-suffix], y[prefix:len(y)-suffix], x[len(x)-suffix:]
+
+	if len(x)*len(y) > maxCells {
+		for _, t := range x {
+			ops = appendOp(ops, Delete, t)
+		}
+		for _, t := range y {
+			ops = appendOp(ops, Insert, t)
+		}
+	} else {
+		ops = lcs(ops, x, y)
+	}
+
+	for _, t := range tail {
+		ops = appendOp(ops, Equal, t)
+	}
+	return ops
+}
+
+func lcs(ops []Op, x, y []string) []Op {
+	// table[i][j] is the LCS length of x[i:] and y[j:].
+	table := make([][]int, len(x)+1)
+	for i := range table {
+		table[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				table[i][j] = table[i+1][j+1] + 1
+			} else if table[i+1][j] >= table[i][j+1] {
+				table[i][j] = table[i+1][j]
+			} else {
+				table[i][j] = table[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(x) && j < len(y) {
+		switch {
+		case x[i] == y[j]:
+			ops = appendOp(ops, Equal, x[i])
+			i++
+			j++
+		case table[i+1][j] >= table[i][j+1]:
+			ops = appendOp(ops, Delete, x[i])
+			i++
+		default:
+			ops = appendOp(ops, Insert, y[j])
+			j++
+		}
+	}
+	for ; i < len(x); i++ {
+		ops = appendOp(ops, Delete, x[i])
+	}
+	for ; j < len(y); j++ {
+		ops = appendOp(ops, Insert, y[j])
+	}
+	return ops
+}
+
+// appendOp merges text into the last op when it has the same kind.
+func appendOp(ops []Op, kind Kind, text string) []Op {
+	if n := len(ops); n > 0 && ops[n-1].Kind == kind {
+		ops[n-1].Text += text
+		return ops
+	}
+	return append(ops, Op{Kind: kind, Text: text})
+}
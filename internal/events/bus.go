@@ -0,0 +1,47 @@
+// Package events carries writes to live subscribers within the process. Its
+// ForumRepository publishes an event to a Bus after every successful write
+// that a reader might want to see at once.
+package events
+
+import (
+	"sync"
+
+	"github.com/dantedoyl/Tech_DB_Forum/internal/models"
+)
+
+type handler struct {
+	f func(*models.Event)
+}
+
+type Bus struct {
+	mu       sync.RWMutex
+	handlers map[*handler]struct{}
+}
+
+func NewBus() *Bus {
+	return &Bus{handlers: map[*handler]struct{}{}}
+}
+
+// Subscribe calls f with every event published until the returned function
+// is called. f runs on the publisher's goroutine and must not block.
+func (b *Bus) Subscribe(f func(*models.Event)) func() {
+	h := &handler{f: f}
+	b.mu.Lock()
+	b.handlers[h] = struct{}{}
+	b.mu.Unlock()
+	return func() {
+		b.mu.Lock()
+		delete(b.handlers, h)
+		b.mu.Unlock()
+	}
+}
+
+func (b *Bus) Publish(events ...*models.Event) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for h := range b.handlers {
+		for _, e := range events {
+			h.f(e)
+		}
+	}
+}
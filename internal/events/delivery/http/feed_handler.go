@@ -0,0 +1,257 @@
+package delivery
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dantedoyl/Tech_DB_Forum/internal/events"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/forum"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/models"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	writeWait        = 10 * time.Second
+	pongWait         = 60 * time.Second
+	pingPeriod       = pongWait * 9 / 10
+	lookupTimeout    = 5 * time.Second
+	maxMessageSize   = 4096
+	sendBuffer       = 256
+	maxSubscriptions = 100
+)
+
+type FeedHandler struct {
+	Bus       *events.Bus
+	ForumRepo forum.ForumRepository
+
+	upgrader websocket.Upgrader
+
+	mu      sync.Mutex
+	clients map[*client]struct{}
+	closed  bool
+}
+
+func NewFeedHandler(r *mux.Router, bus *events.Bus, forumRepo forum.ForumRepository) *FeedHandler {
+	fh := &FeedHandler{Bus: bus, ForumRepo: forumRepo, clients: map[*client]struct{}{}}
+	r.HandleFunc("/feed", fh.Feed).Methods(http.MethodGet).Name("feed")
+	return fh
+}
+
+// Feed upgrades the request to a WebSocket that carries the events of the
+// forums and threads the client subscribes to. A client that falls
+// sendBuffer messages behind is disconnected with 1013 (try again later).
+func (fh *FeedHandler) Feed(w http.ResponseWriter, r *http.Request) {
+	conn, err := fh.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has answered the request.
+		return
+	}
+
+	c := &client{
+		conn:    conn,
+		send:    make(chan interface{}, sendBuffer),
+		done:    make(chan struct{}),
+		forums:  map[string]bool{},
+		threads: map[int]bool{},
+	}
+	if !fh.register(c) {
+		c.stop(websocket.CloseGoingAway, "server is shutting down")
+		c.write()
+		return
+	}
+	defer fh.unregister(c)
+	unsubscribe := fh.Bus.Subscribe(c.deliver)
+	defer unsubscribe()
+
+	written := make(chan struct{})
+	go func() {
+		c.write()
+		close(written)
+	}()
+	fh.read(c)
+	c.stop(websocket.CloseNormalClosure, "")
+	<-written
+}
+
+func (fh *FeedHandler) register(c *client) bool {
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+	if fh.closed {
+		return false
+	}
+	fh.clients[c] = struct{}{}
+	return true
+}
+
+func (fh *FeedHandler) unregister(c *client) {
+	fh.mu.Lock()
+	delete(fh.clients, c)
+	fh.mu.Unlock()
+}
+
+// Close disconnects every client with 1001 (going away). Hijacked
+// connections are not waited for by the HTTP server's shutdown.
+func (fh *FeedHandler) Close() {
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+	fh.closed = true
+	for c := range fh.clients {
+		c.stop(websocket.CloseGoingAway, "server is shutting down")
+	}
+}
+
+// read handles the client's requests until the connection fails or the
+// client stops answering pings.
+func (fh *FeedHandler) read(c *client) {
+	c.conn.SetReadLimit(maxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		_, message, err := c.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Printf("feed: %v", err)
+			}
+			return
+		}
+		req := &models.FeedRequest{}
+		if err := json.Unmarshal(message, req); err != nil {
+			c.enqueue(&models.FeedReply{Type: "error", Error: "message must be a JSON object"})
+			continue
+		}
+		c.enqueue(fh.handle(c, req))
+	}
+}
+
+func (fh *FeedHandler) handle(c *client, req *models.FeedRequest) *models.FeedReply {
+	if req.Action != "subscribe" && req.Action != "unsubscribe" {
+		return &models.FeedReply{Type: "error", Error: "action must be subscribe or unsubscribe"}
+	}
+	if (req.Forum == "") == (req.Thread == "") {
+		return &models.FeedReply{Type: "error", Error: "exactly one of forum and thread is required"}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+	defer cancel()
+	reply := &models.FeedReply{Type: req.Action + "d"}
+	if req.Forum != "" {
+		forum, err := fh.ForumRepo.GetForumInfo(ctx, req.Forum)
+		if err != nil {
+			return &models.FeedReply{Type: "error", Forum: req.Forum, Error: err.Error()}
+		}
+		reply.Forum = forum.Slug
+	} else {
+		thread, err := fh.ForumRepo.GetThreadInfo(ctx, req.Thread)
+		if err != nil {
+			return &models.FeedReply{Type: "error", Error: err.Error()}
+		}
+		reply.Thread = thread.ID
+	}
+
+	if !c.subscribe(req.Action == "subscribe", reply.Forum, reply.Thread) {
+		return &models.FeedReply{Type: "error", Error: "at most " + strconv.Itoa(maxSubscriptions) + " subscriptions"}
+	}
+	return reply
+}
+
+type client struct {
+	conn *websocket.Conn
+	send chan interface{}
+
+	mu      sync.Mutex
+	forums  map[string]bool
+	threads map[int]bool
+
+	done      chan struct{}
+	closeCode int
+	closeText string
+}
+
+func (c *client) subscribe(on bool, forum string, thread int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if on && len(c.forums)+len(c.threads) >= maxSubscriptions {
+		return false
+	}
+	switch {
+	case forum != "" && on:
+		c.forums[strings.ToLower(forum)] = true
+	case forum != "":
+		delete(c.forums, strings.ToLower(forum))
+	case on:
+		c.threads[thread] = true
+	default:
+		delete(c.threads, thread)
+	}
+	return true
+}
+
+// deliver is called by the bus for every event.
+func (c *client) deliver(e *models.Event) {
+	c.mu.Lock()
+	wanted := c.threads[e.Thread] || c.forums[strings.ToLower(e.Forum)]
+	c.mu.Unlock()
+	if wanted {
+		c.enqueue(e)
+	}
+}
+
+func (c *client) enqueue(message interface{}) {
+	select {
+	case c.send <- message:
+	default:
+		c.stop(websocket.CloseTryAgainLater, "client is too slow")
+	}
+}
+
+// stop asks the writer to close the connection; the first reason wins.
+func (c *client) stop(code int, text string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	select {
+	case <-c.done:
+	default:
+		c.closeCode, c.closeText = code, text
+		close(c.done)
+	}
+}
+
+// write sends queued messages and pings until the client is stopped, then
+// closes the connection, which also ends read.
+func (c *client) write() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case message := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteJSON(message); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+				return
+			}
+		case <-c.done:
+			c.mu.Lock()
+			message := websocket.FormatCloseMessage(c.closeCode, c.closeText)
+			c.mu.Unlock()
+			c.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(writeWait))
+			return
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package events
+
+import (
+	"context"
+
+	"github.com/dantedoyl/Tech_DB_Forum/internal/forum"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/models"
+)
+
+// Outbox stores the events of a write in the write's transaction, so that
+// every committed write keeps its events.
+type Outbox interface {
+	// Atomic calls fn in one transaction that Enqueue and the repositories
+	// fn calls with its context join.
+	Atomic(ctx context.Context, fn func(ctx context.Context) error) error
+	Enqueue(ctx context.Context, events ...*models.Event) error
+}
+
+// ForumRepository publishes the writes of a forum.ForumRepository to a Bus
+// once they commit, after storing them in the Outbox if there is one.
+type ForumRepository struct {
+	forum.ForumRepository
+	bus    *Bus
+	outbox Outbox
+}
+
+// NewForumRepository returns a ForumRepository publishing to bus. outbox may
+// be nil.
+func NewForumRepository(repo forum.ForumRepository, bus *Bus, outbox Outbox) *ForumRepository {
+	return &ForumRepository{ForumRepository: repo, bus: bus, outbox: outbox}
+}
+
+// emit calls write and, if it succeeds, enqueues the events it returns in
+// the same transaction and publishes them after the commit.
+func (fr *ForumRepository) emit(ctx context.Context, write func(ctx context.Context) ([]*models.Event, error)) error {
+	var events []*models.Event
+	var err error
+	if fr.outbox == nil {
+		events, err = write(ctx)
+	} else {
+		err = fr.outbox.Atomic(ctx, func(ctx context.Context) (err error) {
+			if events, err = write(ctx); err != nil {
+				return err
+			}
+			return fr.outbox.Enqueue(ctx, events...)
+		})
+	}
+	if err == nil {
+		fr.bus.Publish(events...)
+	}
+	return err
+}
+
+func threadEvent(kind string, thread *models.Thread) *models.Event {
+	return &models.Event{Type: kind, Forum: thread.Forum, Thread: thread.ID, Data: thread}
+}
+
+func (fr *ForumRepository) CreateThread(ctx context.Context, thread *models.Thread) error {
+	return fr.emit(ctx, func(ctx context.Context) ([]*models.Event, error) {
+		if err := fr.ForumRepository.CreateThread(ctx, thread); err != nil {
+			return nil, err
+		}
+		return []*models.Event{threadEvent(models.EventThreadCreated, thread)}, nil
+	})
+}
+
+func (fr *ForumRepository) CreatePosts(ctx context.Context, posts []*models.Post, slugOrID string) ([]*models.Post, error) {
+	var created []*models.Post
+	err := fr.emit(ctx, func(ctx context.Context) (_ []*models.Event, err error) {
+		if created, err = fr.ForumRepository.CreatePosts(ctx, posts, slugOrID); err != nil {
+			return nil, err
+		}
+		events := make([]*models.Event, 0, len(created))
+		for _, post := range created {
+			events = append(events, &models.Event{
+				Type:   models.EventPostCreated,
+				Forum:  post.Forum,
+				Thread: post.Thread,
+				Data:   post,
+			})
+		}
+		return events, nil
+	})
+	return created, err
+}
+
+func (fr *ForumRepository) UpdateThreadInfo(ctx context.Context, thread *models.Thread) error {
+	return fr.emit(ctx, func(ctx context.Context) ([]*models.Event, error) {
+		if err := fr.ForumRepository.UpdateThreadInfo(ctx, thread); err != nil {
+			return nil, err
+		}
+		return []*models.Event{threadEvent(models.EventThreadUpdated, thread)}, nil
+	})
+}
+
+func (fr *ForumRepository) SetThreadState(ctx context.Context, slugOrID string, state *models.ThreadState) (*models.Thread, error) {
+	var thread *models.Thread
+	err := fr.emit(ctx, func(ctx context.Context) (_ []*models.Event, err error) {
+		if thread, err = fr.ForumRepository.SetThreadState(ctx, slugOrID, state); err != nil {
+			return nil, err
+		}
+		return []*models.Event{threadEvent(models.EventThreadUpdated, thread)}, nil
+	})
+	return thread, err
+}
+
+func (fr *ForumRepository) InsertOrUpdateVote(ctx context.Context, slugOrID string, vote *models.Vote) (*models.Thread, error) {
+	var thread *models.Thread
+	err := fr.emit(ctx, func(ctx context.Context) (_ []*models.Event, err error) {
+		if thread, err = fr.ForumRepository.InsertOrUpdateVote(ctx, slugOrID, vote); err != nil {
+			return nil, err
+		}
+		return []*models.Event{threadEvent(models.EventVoteChanged, thread)}, nil
+	})
+	return thread, err
+}
@@ -2,9 +2,13 @@ package delivery
 
 import (
 	"encoding/json"
-	"fmt"
+	"errors"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/auth"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/diff"
 	"github.com/dantedoyl/Tech_DB_Forum/internal/forum"
 	"github.com/dantedoyl/Tech_DB_Forum/internal/models"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/response"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/roles"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/schema"
 	"net/http"
@@ -14,65 +18,211 @@ import (
 
 type ForumHandler struct {
 	ForumRepo forum.ForumRepository
+	opts      Options
 }
 
-func NewForumHandler(r *mux.Router, forumRepo forum.ForumRepository)  *ForumHandler{
-	fh := &ForumHandler{ForumRepo: forumRepo}
-	r.HandleFunc("/forum/create", fh.CreateForum).Methods(http.MethodPost)
-	r.HandleFunc("/forum/{slug}/details", fh.ForumInfo).Methods(http.MethodGet)
-	r.HandleFunc("/forum/{slug}/create", fh.CreateThread).Methods(http.MethodPost)
-	r.HandleFunc("/forum/{slug}/users", fh.ForumUsers).Methods(http.MethodGet)
-	r.HandleFunc("/forum/{slug}/threads", fh.ForumThreads).Methods(http.MethodGet)
-	r.HandleFunc("/post/{id}/details", fh.PostInfoUpdate).Methods(http.MethodPost)
-	r.HandleFunc("/post/{id}/details", fh.PostInfo).Methods(http.MethodGet)
-	r.HandleFunc("/service/status", fh.StatusDB).Methods(http.MethodGet)
-	r.HandleFunc("/service/clear", fh.ClearDB).Methods(http.MethodPost)
-	r.HandleFunc("/thread/{slug_or_id}/create", fh.CreatePost).Methods(http.MethodPost)
-	r.HandleFunc("/thread/{slug_or_id}/details", fh.ThreadInfo).Methods(http.MethodGet)
-	r.HandleFunc("/thread/{slug_or_id}/details", fh.UpdateThread).Methods(http.MethodPost)
-	r.HandleFunc("/thread/{slug_or_id}/posts", fh.ThreadPosts).Methods(http.MethodGet)
-	r.HandleFunc("/thread/{slug_or_id}/vote", fh.Vote).Methods(http.MethodPost)
-	r.HandleFunc("/user/{nickname}/create", fh.CreateUser).Methods(http.MethodPost)
-	r.HandleFunc("/user/{nickname}/profile", fh.UserProfile).Methods(http.MethodGet)
-	r.HandleFunc("/user/{nickname}/profile", fh.UserProfileUpdate).Methods(http.MethodPost)
+type Options struct {
+	// RequireAuth rejects writes without an authenticated caller.
+	RequireAuth bool
+	BcryptCost  int
+	// AuthRepo, when set, stops anonymous writes in the name of users that
+	// have a password.
+	AuthRepo auth.AuthRepository
+	// Authorizer, when set, enforces roles: banned users cannot write, posts
+	// and threads are changed only by their author, a moderator of their forum
+	// or an admin, and deleting needs an authenticated caller.
+	Authorizer *roles.Authorizer
+	// Reactions are the reaction keys users may leave on posts,
+	// models.DefaultReactions when empty.
+	Reactions []string
+}
+
+func NewForumHandler(r *mux.Router, forumRepo forum.ForumRepository, opts Options) *ForumHandler {
+	fh := &ForumHandler{ForumRepo: forumRepo, opts: opts}
+	r.HandleFunc("/forum/create", fh.CreateForum).Methods(http.MethodPost).Name("forum.create")
+	r.HandleFunc("/forum/{slug}/details", fh.ForumInfo).Methods(http.MethodGet).Name("forum.details")
+	r.HandleFunc("/forum/{slug}/create", fh.CreateThread).Methods(http.MethodPost).Name("forum.create_thread")
+	r.HandleFunc("/forum/{slug}/users", fh.ForumUsers).Methods(http.MethodGet).Name("forum.users")
+	r.HandleFunc("/forum/{slug}/threads", fh.ForumThreads).Methods(http.MethodGet).Name("forum.threads")
+	r.HandleFunc("/post/{id}/details", fh.PostInfoUpdate).Methods(http.MethodPost).Name("post.update")
+	r.HandleFunc("/post/{id}/details", fh.PostInfo).Methods(http.MethodGet).Name("post.details")
+	r.HandleFunc("/post/{id}", fh.DeletePost).Methods(http.MethodDelete).Name("post.delete")
+	r.HandleFunc("/post/{id}/history", fh.PostHistory).Methods(http.MethodGet).Name("post.history")
+	r.HandleFunc("/post/{id}/diff", fh.PostDiff).Methods(http.MethodGet).Name("post.diff")
+	r.HandleFunc("/post/{id}/revert", fh.RevertPost).Methods(http.MethodPost).Name("post.revert")
+	r.HandleFunc("/post/{id}/split", fh.SplitThread).Methods(http.MethodPost).Name("post.split")
+	r.HandleFunc("/post/{id}/vote", fh.PostVote).Methods(http.MethodPost).Name("post.vote")
+	r.HandleFunc("/post/{id}/reactions", fh.PostReactions).Methods(http.MethodGet).Name("post.reactions")
+	r.HandleFunc("/post/{id}/reactions/{reaction}", fh.AddReaction).Methods(http.MethodPut).Name("post.react")
+	r.HandleFunc("/post/{id}/reactions/{reaction}", fh.RemoveReaction).Methods(http.MethodDelete).Name("post.unreact")
+	r.HandleFunc("/service/status", fh.StatusDB).Methods(http.MethodGet).Name("service.status")
+	r.HandleFunc("/service/clear", fh.ClearDB).Methods(http.MethodPost).Name("service.clear")
+	r.HandleFunc("/thread/{slug_or_id}/create", fh.CreatePost).Methods(http.MethodPost).Name("thread.create_posts")
+	r.HandleFunc("/thread/{slug_or_id}/details", fh.ThreadInfo).Methods(http.MethodGet).Name("thread.details")
+	r.HandleFunc("/thread/{slug_or_id}/details", fh.UpdateThread).Methods(http.MethodPost).Name("thread.update")
+	r.HandleFunc("/thread/{slug_or_id}/state", fh.SetThreadState).Methods(http.MethodPost).Name("thread.state")
+	r.HandleFunc("/thread/{slug_or_id}/move", fh.MoveThread).Methods(http.MethodPost).Name("thread.move")
+	r.HandleFunc("/thread/{slug_or_id}/merge", fh.MergeThreads).Methods(http.MethodPost).Name("thread.merge")
+	r.HandleFunc("/thread/{slug_or_id}", fh.DeleteThread).Methods(http.MethodDelete).Name("thread.delete")
+	r.HandleFunc("/thread/{slug_or_id}/posts", fh.ThreadPosts).Methods(http.MethodGet).Name("thread.posts")
+	r.HandleFunc("/thread/{slug_or_id}/vote", fh.Vote).Methods(http.MethodPost).Name("thread.vote")
+	r.HandleFunc("/user/{nickname}/create", fh.CreateUser).Methods(http.MethodPost).Name("user.create")
+	r.HandleFunc("/user/{nickname}/profile", fh.UserProfile).Methods(http.MethodGet).Name("user.profile")
+	r.HandleFunc("/user/{nickname}/profile", fh.UserProfileUpdate).Methods(http.MethodPost).Name("user.update")
+	r.HandleFunc("/user/{nickname}/mentions", fh.UserMentions).Methods(http.MethodGet).Name("user.mentions")
 	return fh
 }
 
+// threadView hides the slug the repository generates for threads created
+// without one.
+func threadView(thread *models.Thread) interface{} {
+	if thread.Slug == "" || thread.Slug == thread.Title+thread.Author {
+		return models.DeleteSlug(thread)
+	}
+	return thread
+}
+
+// actor returns the nickname a write is made as: the authenticated caller if
+// there is one, otherwise the nickname claimed in the request body, unless
+// that user has a password. Banned users are rejected either way.
+func (fh *ForumHandler) actor(r *http.Request, claimed string) (string, error) {
+	nickname, ok := auth.Nickname(r.Context())
+	if !ok {
+		if fh.opts.RequireAuth {
+			return "", models.Unauthorized("authentication required")
+		}
+		if err := auth.CheckClaim(r.Context(), fh.opts.AuthRepo, claimed); err != nil {
+			return "", err
+		}
+		nickname = claimed
+	}
+	if fh.opts.Authorizer != nil && nickname != "" {
+		return nickname, fh.opts.Authorizer.Active(r.Context(), nickname)
+	}
+	return nickname, nil
+}
+
+// self checks that the caller may act on the account of nickname.
+func (fh *ForumHandler) self(r *http.Request, nickname string) error {
+	caller, err := fh.actor(r, nickname)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(caller, nickname) {
+		return models.Forbidden("only the account owner may do this")
+	}
+	return nil
+}
+
+// guard returns the caller, as resolved by actor from the token or the
+// claimed nickname, once it is allowed to change content that owner reports
+// the forum and author of. When roles are enforced the caller must be named
+// and be the author, a moderator of the forum or an admin, and strict
+// endpoints need a token.
+func (fh *ForumHandler) guard(r *http.Request, strict bool, claimed string,
+	owner func() (string, string, error)) (string, error) {
+	if _, ok := auth.Nickname(r.Context()); !ok && strict && fh.opts.Authorizer != nil {
+		return "", models.Unauthorized("authentication required")
+	}
+	caller, err := fh.actor(r, claimed)
+	if err != nil || fh.opts.Authorizer == nil {
+		return caller, err
+	}
+	if caller == "" {
+		return "", models.Unauthorized("authentication required")
+	}
+
+	forum, author, err := owner()
+	if err != nil {
+		return "", err
+	}
+	if strings.EqualFold(caller, author) {
+		return caller, nil
+	}
+	return caller, fh.opts.Authorizer.Moderator(r.Context(), caller, forum)
+}
+
+// moderate checks that the caller moderates every forum that owners report.
+func (fh *ForumHandler) moderate(r *http.Request, owners ...func() (string, string, error)) error {
+	caller, ok := auth.Nickname(r.Context())
+	if !ok {
+		if fh.opts.RequireAuth || fh.opts.Authorizer != nil {
+			return models.Unauthorized("authentication required")
+		}
+		return nil
+	}
+	if fh.opts.Authorizer == nil {
+		return nil
+	}
+
+	for _, owner := range owners {
+		forum, _, err := owner()
+		if err == nil {
+			err = fh.opts.Authorizer.Moderator(r.Context(), caller, forum)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func forumOwner(slug string) func() (string, string, error) {
+	return func() (string, string, error) {
+		return slug, "", nil
+	}
+}
+
+func (fh *ForumHandler) postOwner(r *http.Request, id int) func() (string, string, error) {
+	return func() (string, string, error) {
+		info, err := fh.ForumRepo.PostInfo(r.Context(), id, models.Related{})
+		if err != nil {
+			return "", "", err
+		}
+		return info.Post.Forum, info.Post.Author, nil
+	}
+}
+
+func (fh *ForumHandler) threadOwner(r *http.Request, slugOrID string) func() (string, string, error) {
+	return func() (string, string, error) {
+		thread, err := fh.ForumRepo.GetThreadInfo(r.Context(), slugOrID)
+		if err != nil {
+			return "", "", err
+		}
+		return thread.Forum, thread.Author, nil
+	}
+}
+
+func decodeParams(r *http.Request) *models.Params {
+	params := &models.Params{}
+	decoder := schema.NewDecoder()
+	decoder.IgnoreUnknownKeys(true)
+	_ = decoder.Decode(params, r.URL.Query())
+	return params
+}
+
 func (fh *ForumHandler) CreateForum(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	forum := &models.Forum{}
 	err := json.NewDecoder(r.Body).Decode(forum)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		response.WriteError(w, response.InvalidBody(err))
 		return
 	}
 
-	er := fh.ForumRepo.CreateForum(forum)
-	if er != nil{
-		if er.Code == http.StatusConflict{
-			w.WriteHeader(http.StatusConflict)
-			body, err := json.Marshal(forum)
-			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
-				w.Write(models.ErrorToJSON(er.Message))
-				return
-			}
-			w.Write(body)
-			return
-		}
-		w.WriteHeader(er.Code)
-		w.Write(models.ErrorToJSON(er.Message))
+	forum.User, err = fh.actor(r, forum.User)
+	if err != nil {
+		response.WriteError(w, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusCreated)
-	body, err := json.Marshal(forum)
+	err = fh.ForumRepo.CreateForum(r.Context(), forum)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write(models.ErrorToJSON(err.Error()))
+		response.WriteError(w, err)
 		return
 	}
-	w.Write(body)
+
+	response.WriteJSON(w, http.StatusCreated, forum)
 }
 
 func (fh *ForumHandler) ForumInfo(w http.ResponseWriter, r *http.Request) {
@@ -80,20 +230,12 @@ func (fh *ForumHandler) ForumInfo(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	slug := vars["slug"]
 
-	forum, er := fh.ForumRepo.GetForumInfo(slug)
-	if er != nil {
-		w.WriteHeader(er.Code)
-		w.Write(models.ErrorToJSON(er.Message))
-		return
-	}
-	w.WriteHeader(http.StatusOK)
-	body, err := json.Marshal(forum)
+	forum, err := fh.ForumRepo.GetForumInfo(r.Context(), slug)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write(models.ErrorToJSON(err.Error()))
+		response.WriteError(w, err)
 		return
 	}
-	w.Write(body)
+	response.WriteJSON(w, http.StatusOK, forum)
 }
 
 func (fh *ForumHandler) CreateThread(w http.ResponseWriter, r *http.Request) {
@@ -104,122 +246,68 @@ func (fh *ForumHandler) CreateThread(w http.ResponseWriter, r *http.Request) {
 	thread := &models.Thread{}
 	err := json.NewDecoder(r.Body).Decode(thread)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		response.WriteError(w, response.InvalidBody(err))
+		return
+	}
+
+	thread.Author, err = fh.actor(r, thread.Author)
+	if err != nil {
+		response.WriteError(w, err)
 		return
 	}
 
+	// New threads start open; flags are set through SetThreadState.
 	thread.Forum = slug
-	er := fh.ForumRepo.CreateThread(thread)
-	if er != nil{
-		if er.Code == http.StatusConflict{
-			w.WriteHeader(http.StatusConflict)
-			body, err := json.Marshal(thread)
-			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
-				w.Write(models.ErrorToJSON(er.Message))
-				return
+	thread.Locked, thread.Pinned, thread.Archived = false, false, false
+	err = fh.ForumRepo.CreateThread(r.Context(), thread)
+	if err != nil {
+		var conflict *models.ConflictError
+		if errors.As(err, &conflict) {
+			if existing, ok := conflict.Existing.(*models.Thread); ok {
+				conflict.Existing = threadView(existing)
 			}
-			w.Write(body)
-			return
 		}
-		w.WriteHeader(er.Code)
-		w.Write(models.ErrorToJSON(er.Message))
+		response.WriteError(w, err)
 		return
 	}
-	w.WriteHeader(http.StatusCreated)
-	var body []byte
-	if(thread.Slug == ""){
-		body, err = json.Marshal(models.DeleteSlug(thread))
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write(models.ErrorToJSON(err.Error()))
-			return
-		}
-	} else {
-		body, err = json.Marshal(thread)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write(models.ErrorToJSON(err.Error()))
-			return
-		}
-	}
-	w.Write(body)
+	response.WriteJSON(w, http.StatusCreated, threadView(thread))
 }
 
-func (fh *ForumHandler)ForumUsers(w http.ResponseWriter, r *http.Request) {
+func (fh *ForumHandler) ForumUsers(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	params := &models.Params{}
-	decoder := schema.NewDecoder()
-	decoder.IgnoreUnknownKeys(true)
-	_ = decoder.Decode(params, r.URL.Query())
-	fmt.Println(params)
+	params := decodeParams(r)
 	vars := mux.Vars(r)
 	slug := vars["slug"]
 
-	users, er := fh.ForumRepo.GetForumUsers(slug, params)
-	if er != nil {
-		w.WriteHeader(er.Code)
-		w.Write(models.ErrorToJSON(er.Message))
+	users, err := fh.ForumRepo.GetForumUsers(r.Context(), slug, params)
+	if err != nil {
+		response.WriteError(w, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	if len(users) == 0 {
-		w.Write([]byte("[]"))
-		return
-	}
-	body, err := json.Marshal(users)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write(models.ErrorToJSON(err.Error()))
-		return
+	if users == nil {
+		users = []*models.User{}
 	}
-	w.Write(body)
+	response.WriteJSON(w, http.StatusOK, users)
 }
 
-func (fh *ForumHandler)ForumThreads(w http.ResponseWriter, r *http.Request) {
+func (fh *ForumHandler) ForumThreads(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-
-	params :=  &models.Params{}
-	decoder := schema.NewDecoder()
-	decoder.IgnoreUnknownKeys(true)
-	_ = decoder.Decode(params, r.URL.Query())
-
+	params := decodeParams(r)
 	vars := mux.Vars(r)
 	slug := vars["slug"]
 
-
-	threads, er := fh.ForumRepo.GetForumThreads(slug, params)
-	if er != nil {
-		w.WriteHeader(er.Code)
-		w.Write(models.ErrorToJSON(er.Message))
-		return
-	}
-
-	if len(threads) == 0 {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("[]"))
+	threads, err := fh.ForumRepo.GetForumThreads(r.Context(), slug, params)
+	if err != nil {
+		response.WriteError(w, err)
 		return
 	}
 
-	var thr []interface{}
-	for _, thread := range threads{
-		if (thread.Slug == thread.Title + thread.Author){
-			thr = append(thr, models.DeleteSlug(thread))
-		} else {
-			thr = append(thr, thread)
-		}
-	}
-
-	w.WriteHeader(http.StatusOK)
-
-	body, err := json.Marshal(thr)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write(models.ErrorToJSON(err.Error()))
-		return
+	thr := []interface{}{}
+	for _, thread := range threads {
+		thr = append(thr, threadView(thread))
 	}
-		w.Write(body)
+	response.WriteJSON(w, http.StatusOK, thr)
 }
 
 func (fh *ForumHandler) PostInfoUpdate(w http.ResponseWriter, r *http.Request) {
@@ -232,27 +320,23 @@ func (fh *ForumHandler) PostInfoUpdate(w http.ResponseWriter, r *http.Request) {
 	postUpdate.ID = id
 	err := json.NewDecoder(r.Body).Decode(postUpdate)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		response.WriteError(w, response.InvalidBody(err))
 		return
 	}
 
-	post, er := fh.ForumRepo.UpdatePostInfo(postUpdate)
-	if er != nil {
-		w.WriteHeader(er.Code)
-		w.Write(models.ErrorToJSON(er.Message))
+	postUpdate.Editor, err = fh.guard(r, false, postUpdate.Editor, fh.postOwner(r, id))
+	if err != nil {
+		response.WriteError(w, err)
 		return
 	}
 
-	body, err := json.Marshal(post)
+	post, err := fh.ForumRepo.UpdatePostInfo(r.Context(), postUpdate)
 	if err != nil {
-
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write(models.ErrorToJSON(err.Error()))
+		response.WriteError(w, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	w.Write(body)
+	response.WriteJSON(w, http.StatusOK, post)
 }
 
 func (fh *ForumHandler) PostInfo(w http.ResponseWriter, r *http.Request) {
@@ -276,46 +360,154 @@ func (fh *ForumHandler) PostInfo(w http.ResponseWriter, r *http.Request) {
 		related.IsForum = true
 	}
 
-	post, er := fh.ForumRepo.PostInfo(id, related)
-	if er != nil {
-		w.WriteHeader(er.Code)
-		w.Write(models.ErrorToJSON(er.Message))
+	post, err := fh.ForumRepo.PostInfo(r.Context(), id, related)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	response.WriteJSON(w, http.StatusOK, post)
+}
+
+func (fh *ForumHandler) DeletePost(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	vars := mux.Vars(r)
+	id, _ := strconv.Atoi(vars["id"])
+
+	if _, err := fh.guard(r, true, "", fh.postOwner(r, id)); err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	err := fh.ForumRepo.DeletePost(r.Context(), id)
+	if err != nil {
+		response.WriteError(w, err)
 		return
 	}
 
-	body, err := json.Marshal(post)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (fh *ForumHandler) PostHistory(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	vars := mux.Vars(r)
+	id, _ := strconv.Atoi(vars["id"])
+	params := decodeParams(r)
+
+	revisions, err := fh.ForumRepo.GetPostHistory(r.Context(), id, params)
 	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	if revisions == nil {
+		revisions = []*models.PostRevision{}
+	}
+	response.WriteJSON(w, http.StatusOK, revisions)
+}
+
+// PostDiff compares two revisions given as ?from=&to=; a missing or zero
+// revision stands for the current text.
+func (fh *ForumHandler) PostDiff(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	vars := mux.Vars(r)
+	id, _ := strconv.Atoi(vars["id"])
 
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write(models.ErrorToJSON(err.Error()))
+	result := &models.PostDiff{PostID: id}
+	for _, field := range []string{"from", "to"} {
+		revision := &result.From
+		if field == "to" {
+			revision = &result.To
+		}
+		value := r.URL.Query().Get(field)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			response.WriteError(w, models.Invalid(field, "must be a revision number"))
+			return
+		}
+		*revision = n
+	}
+
+	from, err := fh.ForumRepo.GetPostRevision(r.Context(), id, result.From)
+	if err != nil {
+		response.WriteError(w, err)
 		return
 	}
-	w.WriteHeader(http.StatusOK)
-	w.Write(body)
+	to, err := fh.ForumRepo.GetPostRevision(r.Context(), id, result.To)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	result.Ops = diff.Words(from.Message, to.Message)
+	response.WriteJSON(w, http.StatusOK, result)
+}
+
+func (fh *ForumHandler) RevertPost(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	vars := mux.Vars(r)
+	id, _ := strconv.Atoi(vars["id"])
+
+	revert := &models.PostRevert{}
+	err := json.NewDecoder(r.Body).Decode(revert)
+	if err != nil {
+		response.WriteError(w, response.InvalidBody(err))
+		return
+	}
+	revert.ID = id
+	revert.Editor, err = fh.guard(r, false, revert.Editor, fh.postOwner(r, id))
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	post, err := fh.ForumRepo.RevertPost(r.Context(), revert)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	response.WriteJSON(w, http.StatusOK, post)
 }
 
 func (fh *ForumHandler) StatusDB(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	status := fh.ForumRepo.StatusDB()
-	body, err := json.Marshal(status)
+	status, err := fh.ForumRepo.StatusDB(r.Context())
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write(models.ErrorToJSON(err.Error()))
+		response.WriteError(w, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	w.Write(body)
+	response.WriteJSON(w, http.StatusOK, status)
 }
 
-func (fh *ForumHandler) ClearDB(w http.ResponseWriter, r *http.Request)  {
+// ClearDB empties the database. With roles enforced only an admin may do it.
+func (fh *ForumHandler) ClearDB(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	er := fh.ForumRepo.ClearDB()
-	if er != nil {
-		w.WriteHeader(er.Code)
-		w.Write(models.ErrorToJSON(er.Message))
+	if fh.opts.Authorizer != nil {
+		caller, ok := auth.Nickname(r.Context())
+		if !ok {
+			response.WriteError(w, models.Unauthorized("authentication required"))
+			return
+		}
+		if err := fh.opts.Authorizer.Admin(r.Context(), caller); err != nil {
+			response.WriteError(w, err)
+			return
+		}
+	}
+
+	err := fh.ForumRepo.ClearDB(r.Context())
+	if err != nil {
+		response.WriteError(w, err)
 		return
 	}
 
@@ -328,41 +520,37 @@ func (fh *ForumHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	nickname, _ := vars["nickname"]
 
-	user := &models.User{}
-	err := json.NewDecoder(r.Body).Decode(user)
+	body := &struct {
+		models.User
+		Password string `json:"password"`
+	}{}
+	err := json.NewDecoder(r.Body).Decode(body)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		response.WriteError(w, response.InvalidBody(err))
 		return
 	}
 
+	user := &body.User
 	user.Nickname = nickname
-
-	users, er := fh.ForumRepo.CreateUser(user)
-	if er != nil{
-		if er.Code == http.StatusConflict {
-			w.WriteHeader(er.Code)
-			body, err := json.Marshal(users)
-			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
-				w.Write(body)
-				return
-			}
-			w.Write(body)
+	switch {
+	case body.Password != "":
+		user.PasswordHash, err = auth.HashPassword(body.Password, fh.opts.BcryptCost)
+		if err != nil {
+			response.WriteError(w, models.Invalid("password", err.Error()))
 			return
 		}
-			w.WriteHeader(er.Code)
-			w.Write(models.ErrorToJSON(er.Message))
-			return
+	case fh.opts.RequireAuth:
+		response.WriteError(w, models.Invalid("password", "is required"))
+		return
 	}
 
-	w.WriteHeader(http.StatusCreated)
-	body, err := json.Marshal(users[0])
+	err = fh.ForumRepo.CreateUser(r.Context(), user)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write(models.ErrorToJSON(er.Message))
+		response.WriteError(w, err)
 		return
 	}
-	w.Write(body)
+
+	response.WriteJSON(w, http.StatusCreated, user)
 }
 
 func (fh *ForumHandler) UserProfile(w http.ResponseWriter, r *http.Request) {
@@ -371,22 +559,13 @@ func (fh *ForumHandler) UserProfile(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	nickname, _ := vars["nickname"]
 
-	user, er := fh.ForumRepo.GetUserProfile(nickname)
-	if er != nil {
-		w.WriteHeader(er.Code)
-		w.Write(models.ErrorToJSON(er.Message))
-		return
-	}
-
-	body, err := json.Marshal(user)
+	user, err := fh.ForumRepo.GetUserProfile(r.Context(), nickname)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write(models.ErrorToJSON(err.Error()))
+		response.WriteError(w, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	w.Write(body)
+	response.WriteJSON(w, http.StatusOK, user)
 }
 
 func (fh *ForumHandler) UserProfileUpdate(w http.ResponseWriter, r *http.Request) {
@@ -398,28 +577,23 @@ func (fh *ForumHandler) UserProfileUpdate(w http.ResponseWriter, r *http.Request
 	user := &models.User{}
 	err := json.NewDecoder(r.Body).Decode(user)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write(models.ErrorToJSON(err.Error()))
+		response.WriteError(w, response.InvalidBody(err))
 		return
 	}
 
 	user.Nickname = nickname
-
-	er := fh.ForumRepo.UpdateUserProfile(user)
-	if er != nil {
-		w.WriteHeader(er.Code)
-		w.Write(models.ErrorToJSON(er.Message))
+	if err = fh.self(r, nickname); err != nil {
+		response.WriteError(w, err)
 		return
 	}
 
-	body, err := json.Marshal(user)
+	err = fh.ForumRepo.UpdateUserProfile(r.Context(), user)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write(models.ErrorToJSON(err.Error()))
+		response.WriteError(w, err)
 		return
 	}
-	w.WriteHeader(http.StatusOK)
-	w.Write(body)
+
+	response.WriteJSON(w, http.StatusOK, user)
 }
 
 func (fh *ForumHandler) CreatePost(w http.ResponseWriter, r *http.Request) {
@@ -431,33 +605,34 @@ func (fh *ForumHandler) CreatePost(w http.ResponseWriter, r *http.Request) {
 	var posts []*models.Post
 	err := json.NewDecoder(r.Body).Decode(&posts)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
-
-	_, er := fh.ForumRepo.CreatePosts(posts, slugOrID)
-	if er != nil {
-		w.WriteHeader(er.Code)
-		w.Write(models.ErrorToJSON(er.Message))
+		response.WriteError(w, response.InvalidBody(err))
 		return
 	}
 
-	if len(posts) == 0 {
-		w.WriteHeader(http.StatusCreated)
-		w.Write([]byte("[]"))
-		return
+	authors := make(map[string]string)
+	for _, post := range posts {
+		author, ok := authors[post.Author]
+		if !ok {
+			author, err = fh.actor(r, post.Author)
+			if err != nil {
+				response.WriteError(w, err)
+				return
+			}
+			authors[post.Author] = author
+		}
+		post.Author = author
 	}
 
-	body, err := json.Marshal(posts)
+	posts, err = fh.ForumRepo.CreatePosts(r.Context(), posts, slugOrID)
 	if err != nil {
-
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write(models.ErrorToJSON(err.Error()))
+		response.WriteError(w, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusCreated)
-	w.Write(body)
+	if posts == nil {
+		posts = []*models.Post{}
+	}
+	response.WriteJSON(w, http.StatusCreated, posts)
 }
 
 func (fh *ForumHandler) ThreadInfo(w http.ResponseWriter, r *http.Request) {
@@ -465,33 +640,13 @@ func (fh *ForumHandler) ThreadInfo(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	slugOrID, _ := vars["slug_or_id"]
 
-	thread, er := fh.ForumRepo.GetThreadInfo(slugOrID)
-	if er != nil {
-		w.WriteHeader(er.Code)
-		w.Write(models.ErrorToJSON(er.Message))
+	thread, err := fh.ForumRepo.GetThreadInfo(r.Context(), slugOrID)
+	if err != nil {
+		response.WriteError(w, err)
 		return
 	}
 
-	var body []byte
-	var err error
-	if (thread.Slug == thread.Title+thread.Author) {
-		body, err = json.Marshal(models.DeleteSlug(thread))
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write(models.ErrorToJSON(err.Error()))
-			return
-		}
-	} else {
-		body, err = json.Marshal(thread)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write(models.ErrorToJSON(err.Error()))
-			return
-		}
-	}
-
-	w.WriteHeader(http.StatusOK)
-	w.Write(body)
+	response.WriteJSON(w, http.StatusOK, threadView(thread))
 }
 
 func (fh *ForumHandler) UpdateThread(w http.ResponseWriter, r *http.Request) {
@@ -503,9 +658,13 @@ func (fh *ForumHandler) UpdateThread(w http.ResponseWriter, r *http.Request) {
 	thread := &models.Thread{}
 	err := json.NewDecoder(r.Body).Decode(&thread)
 	if err != nil {
+		response.WriteError(w, response.InvalidBody(err))
+		return
+	}
 
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write(models.ErrorToJSON(err.Error()))
+	_, err = fh.guard(r, false, thread.Author, fh.threadOwner(r, slugOrID))
+	if err != nil {
+		response.WriteError(w, err)
 		return
 	}
 
@@ -516,32 +675,167 @@ func (fh *ForumHandler) UpdateThread(w http.ResponseWriter, r *http.Request) {
 		thread.ID = id
 	}
 
-	er := fh.ForumRepo.UpdateThreadInfo(thread)
-	if er != nil {
-		w.WriteHeader(er.Code)
-		w.Write(models.ErrorToJSON(er.Message))
+	err = fh.ForumRepo.UpdateThreadInfo(r.Context(), thread)
+	if err != nil {
+		response.WriteError(w, err)
 		return
 	}
 
-	var body []byte
-	if (thread.Slug == thread.Title+thread.Author) {
-		body, err = json.Marshal(models.DeleteSlug(thread))
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write(models.ErrorToJSON(err.Error()))
-			return
-		}
-	} else {
-		body, err = json.Marshal(thread)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write(models.ErrorToJSON(err.Error()))
-			return
-		}
+	response.WriteJSON(w, http.StatusOK, threadView(thread))
+}
+
+// SetThreadState locks, pins or archives a thread. Only moderators may.
+func (fh *ForumHandler) SetThreadState(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	vars := mux.Vars(r)
+	slugOrID, _ := vars["slug_or_id"]
+
+	state := &models.ThreadState{}
+	err := json.NewDecoder(r.Body).Decode(state)
+	if err != nil {
+		response.WriteError(w, response.InvalidBody(err))
+		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	w.Write(body)
+	err = fh.moderate(r, fh.threadOwner(r, slugOrID))
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	thread, err := fh.ForumRepo.SetThreadState(r.Context(), slugOrID, state)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	response.WriteJSON(w, http.StatusOK, threadView(thread))
+}
+
+// MoveThread moves a thread to another forum. The caller must moderate both.
+func (fh *ForumHandler) MoveThread(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	vars := mux.Vars(r)
+	slugOrID, _ := vars["slug_or_id"]
+
+	body := &struct {
+		Forum string `json:"forum"`
+	}{}
+	err := json.NewDecoder(r.Body).Decode(body)
+	if err != nil {
+		response.WriteError(w, response.InvalidBody(err))
+		return
+	}
+	if body.Forum == "" {
+		response.WriteError(w, models.Invalid("forum", "is required"))
+		return
+	}
+
+	err = fh.moderate(r, fh.threadOwner(r, slugOrID), forumOwner(body.Forum))
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	thread, err := fh.ForumRepo.MoveThread(r.Context(), slugOrID, body.Forum)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	response.WriteJSON(w, http.StatusOK, threadView(thread))
+}
+
+// MergeThreads moves the posts of a thread into the thread named by "into"
+// and deletes it.
+func (fh *ForumHandler) MergeThreads(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	vars := mux.Vars(r)
+	slugOrID, _ := vars["slug_or_id"]
+
+	body := &struct {
+		Into string `json:"into"`
+	}{}
+	err := json.NewDecoder(r.Body).Decode(body)
+	if err != nil {
+		response.WriteError(w, response.InvalidBody(err))
+		return
+	}
+	if body.Into == "" {
+		response.WriteError(w, models.Invalid("into", "is required"))
+		return
+	}
+
+	err = fh.moderate(r, fh.threadOwner(r, slugOrID), fh.threadOwner(r, body.Into))
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	thread, err := fh.ForumRepo.MergeThreads(r.Context(), slugOrID, body.Into)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	response.WriteJSON(w, http.StatusOK, threadView(thread))
+}
+
+// SplitThread moves a post and its replies into a new thread.
+func (fh *ForumHandler) SplitThread(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	vars := mux.Vars(r)
+	id, _ := strconv.Atoi(vars["id"])
+
+	split := &models.ThreadSplit{}
+	err := json.NewDecoder(r.Body).Decode(split)
+	if err != nil {
+		response.WriteError(w, response.InvalidBody(err))
+		return
+	}
+	split.Post = id
+
+	owners := []func() (string, string, error){fh.postOwner(r, id)}
+	if split.Forum != "" {
+		owners = append(owners, forumOwner(split.Forum))
+	}
+	err = fh.moderate(r, owners...)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	thread, err := fh.ForumRepo.SplitThread(r.Context(), split)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	response.WriteJSON(w, http.StatusCreated, threadView(thread))
+}
+
+func (fh *ForumHandler) DeleteThread(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	vars := mux.Vars(r)
+	slugOrID, _ := vars["slug_or_id"]
+
+	if _, err := fh.guard(r, true, "", fh.threadOwner(r, slugOrID)); err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	err := fh.ForumRepo.DeleteThread(r.Context(), slugOrID)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
 }
 
 func (fh *ForumHandler) Vote(w http.ResponseWriter, r *http.Request) {
@@ -553,37 +847,54 @@ func (fh *ForumHandler) Vote(w http.ResponseWriter, r *http.Request) {
 	vote := &models.Vote{}
 	err := json.NewDecoder(r.Body).Decode(&vote)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write(models.ErrorToJSON(err.Error()))
+		response.WriteError(w, response.InvalidBody(err))
 		return
 	}
 
-	thread, er := fh.ForumRepo.InsertOrUpdateVote(slugOrID, vote)
-	if er != nil {
-		w.WriteHeader(er.Code)
-		w.Write(models.ErrorToJSON(er.Message))
+	vote.Nickname, err = fh.actor(r, vote.Nickname)
+	if err != nil {
+		response.WriteError(w, err)
 		return
 	}
 
-	var body []byte
-	if (thread.Slug == thread.Title+thread.Author) {
-		body, err = json.Marshal(models.DeleteSlug(thread))
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write(models.ErrorToJSON(err.Error()))
-			return
-		}
-	} else {
-		body, err = json.Marshal(thread)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write(models.ErrorToJSON(err.Error()))
-			return
-		}
+	thread, err := fh.ForumRepo.InsertOrUpdateVote(r.Context(), slugOrID, vote)
+	if err != nil {
+		response.WriteError(w, err)
+		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	w.Write(body)
+	response.WriteJSON(w, http.StatusOK, threadView(thread))
+}
+
+// PostVote sets the caller's voice on a post and answers with the post and
+// its score.
+func (fh *ForumHandler) PostVote(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	vars := mux.Vars(r)
+	id, _ := strconv.Atoi(vars["id"])
+
+	vote := &models.Vote{}
+	err := json.NewDecoder(r.Body).Decode(&vote)
+	if err != nil {
+		response.WriteError(w, response.InvalidBody(err))
+		return
+	}
+	vote.Post = id
+
+	vote.Nickname, err = fh.actor(r, vote.Nickname)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	post, err := fh.ForumRepo.InsertOrUpdatePostVote(r.Context(), vote)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	response.WriteJSON(w, http.StatusOK, post)
 }
 
 func (fh *ForumHandler) ThreadPosts(w http.ResponseWriter, r *http.Request) {
@@ -591,32 +902,127 @@ func (fh *ForumHandler) ThreadPosts(w http.ResponseWriter, r *http.Request) {
 
 	vars := mux.Vars(r)
 	slugOrID, _ := vars["slug_or_id"]
+	params := decodeParams(r)
 
-	params :=  &models.Params{}
-	decoder := schema.NewDecoder()
-	decoder.IgnoreUnknownKeys(true)
-	_ = decoder.Decode(params, r.URL.Query())
+	posts, err := fh.ForumRepo.GetThreadPosts(r.Context(), slugOrID, params)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	if posts == nil {
+		posts = []*models.Post{}
+	}
+	response.WriteJSON(w, http.StatusOK, posts)
+}
 
-	posts, er := fh.ForumRepo.GetThreadPosts(slugOrID, params)
-	if er != nil {
-		w.WriteHeader(er.Code)
-		w.Write(models.ErrorToJSON(er.Message))
+// UserMentions lists the posts that mention a user, by post id.
+func (fh *ForumHandler) UserMentions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	nickname := mux.Vars(r)["nickname"]
+
+	posts, err := fh.ForumRepo.GetUserMentions(r.Context(), nickname, decodeParams(r))
+	if err != nil {
+		response.WriteError(w, err)
 		return
 	}
+	if posts == nil {
+		posts = []*models.Post{}
+	}
+
+	response.WriteJSON(w, http.StatusOK, posts)
+}
+
+// reactionKey returns the reaction key of the request if it is one on offer.
+func (fh *ForumHandler) reactionKey(key string) (string, error) {
+	keys := fh.opts.Reactions
+	if len(keys) == 0 {
+		keys = models.DefaultReactions
+	}
+	for _, k := range keys {
+		if k == key {
+			return key, nil
+		}
+	}
+	return "", models.Invalid("reaction", "must be one of "+strings.Join(keys, ", "))
+}
+
+// reaction reads the post, key and user of a reaction request. The user is the
+// authenticated caller or the nickname parameter.
+func (fh *ForumHandler) reaction(r *http.Request) (*models.Reaction, error) {
+	vars := mux.Vars(r)
+	id, _ := strconv.Atoi(vars["id"])
+	key, err := fh.reactionKey(vars["reaction"])
+	if err != nil {
+		return nil, err
+	}
+	nickname, err := fh.actor(r, r.URL.Query().Get("nickname"))
+	if err != nil {
+		return nil, err
+	}
+	if nickname == "" {
+		return nil, models.Invalid("nickname", "must not be empty")
+	}
+	return &models.Reaction{Post: id, Key: key, Nickname: nickname}, nil
+}
+
+// AddReaction leaves a reaction on a post and answers with the post and its
+// reaction counts.
+func (fh *ForumHandler) AddReaction(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
 
-	if len(posts) == 0 {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("[]"))
+	reaction, err := fh.reaction(r)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+	post, err := fh.ForumRepo.AddReaction(r.Context(), reaction)
+	if err != nil {
+		response.WriteError(w, err)
 		return
 	}
 
-	body, err := json.Marshal(posts)
+	response.WriteJSON(w, http.StatusOK, post)
+}
+
+func (fh *ForumHandler) RemoveReaction(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	reaction, err := fh.reaction(r)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+	post, err := fh.ForumRepo.RemoveReaction(r.Context(), reaction)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write(models.ErrorToJSON(err.Error()))
+		response.WriteError(w, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	w.Write(body)
-}
\ No newline at end of file
+	response.WriteJSON(w, http.StatusOK, post)
+}
+
+// PostReactions lists who reacted to a post, optionally with one key only.
+func (fh *ForumHandler) PostReactions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	key := r.URL.Query().Get("reaction")
+	if key != "" {
+		var err error
+		if key, err = fh.reactionKey(key); err != nil {
+			response.WriteError(w, err)
+			return
+		}
+	}
+	reactions, err := fh.ForumRepo.GetReactions(r.Context(), id, key, decodeParams(r))
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+	if reactions == nil {
+		reactions = []*models.Reaction{}
+	}
+
+	response.WriteJSON(w, http.StatusOK, reactions)
+}
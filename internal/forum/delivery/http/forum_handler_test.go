@@ -0,0 +1,430 @@
+package delivery
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/dantedoyl/Tech_DB_Forum/internal/forum/repository/memory"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/models"
+	"github.com/gorilla/mux"
+)
+
+// testAPI serves a ForumHandler over the memory repository, as cmd does with
+// -feature-in-memory.
+type testAPI struct {
+	t   *testing.T
+	srv *httptest.Server
+}
+
+func newTestAPI(t *testing.T) *testAPI {
+	router := mux.NewRouter()
+	NewForumHandler(router.PathPrefix("/api").Subrouter(), memory.NewForumRepository(), Options{})
+	srv := httptest.NewServer(router)
+	t.Cleanup(srv.Close)
+	return &testAPI{t: t, srv: srv}
+}
+
+// do sends body as JSON, checks the status and decodes the response into
+// out unless it is nil. It returns the raw response body.
+func (a *testAPI) do(method, path string, body interface{}, status int, out interface{}) []byte {
+	a.t.Helper()
+	var reader *bytes.Reader
+	if body == nil {
+		reader = bytes.NewReader(nil)
+	} else {
+		raw, err := json.Marshal(body)
+		if err != nil {
+			a.t.Fatal(err)
+		}
+		reader = bytes.NewReader(raw)
+	}
+	req, err := http.NewRequest(method, a.srv.URL+"/api"+path, reader)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	resp, err := a.srv.Client().Do(req)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	if resp.StatusCode != status {
+		a.t.Fatalf("%s %s: status %d, want %d: %s", method, path, resp.StatusCode, status, raw)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		a.t.Errorf("%s %s: Content-Type %q", method, path, ct)
+	}
+	if out != nil {
+		if err := json.Unmarshal(raw, out); err != nil {
+			a.t.Fatalf("%s %s: %s: %v", method, path, raw, err)
+		}
+	}
+	return raw
+}
+
+// errorCode checks an error response body.
+func (a *testAPI) errorCode(raw []byte, code string, entity models.Entity) {
+	a.t.Helper()
+	var body struct {
+		Code    string        `json:"code"`
+		Message string        `json:"message"`
+		Entity  models.Entity `json:"entity"`
+	}
+	if err := json.Unmarshal(raw, &body); err != nil {
+		a.t.Fatal(err)
+	}
+	if body.Code != code || body.Entity != entity || body.Message == "" {
+		a.t.Errorf("error %s, want code %q, entity %q", raw, code, entity)
+	}
+}
+
+func (a *testAPI) createUser(nickname string) {
+	a.t.Helper()
+	a.do(http.MethodPost, "/user/"+nickname+"/create",
+		&models.User{FullName: "Test " + nickname, Email: nickname + "@example.com"}, http.StatusCreated, nil)
+}
+
+var createdAt = time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// seed creates the user jo, the forum news and the thread hello in it.
+func (a *testAPI) seed() *models.Thread {
+	a.t.Helper()
+	a.createUser("jo")
+	a.do(http.MethodPost, "/forum/create", &models.Forum{Title: "News", User: "jo", Slug: "news"},
+		http.StatusCreated, nil)
+	thread := &models.Thread{}
+	a.do(http.MethodPost, "/forum/news/create",
+		&models.Thread{Title: "Hello", Author: "jo", Message: "first", Slug: "hello", Created: createdAt},
+		http.StatusCreated, thread)
+	return thread
+}
+
+func TestForum(t *testing.T) {
+	a := newTestAPI(t)
+	a.createUser("jo")
+
+	created := &models.Forum{}
+	a.do(http.MethodPost, "/forum/create", &models.Forum{Title: "News", User: "JO", Slug: "news"},
+		http.StatusCreated, created)
+	if created.Slug != "news" || created.User != "jo" || created.Title != "News" {
+		t.Errorf("created %+v", created)
+	}
+
+	existing := &models.Forum{}
+	a.do(http.MethodPost, "/forum/create", &models.Forum{Title: "Other", User: "jo", Slug: "NEWS"},
+		http.StatusConflict, existing)
+	if existing.Slug != "news" || existing.Title != "News" {
+		t.Errorf("conflict returned %+v, want the stored forum", existing)
+	}
+
+	raw := a.do(http.MethodPost, "/forum/create", &models.Forum{Title: "Lost", User: "nobody", Slug: "lost"},
+		http.StatusNotFound, nil)
+	a.errorCode(raw, "not_found", models.EntityUser)
+
+	got := &models.Forum{}
+	a.do(http.MethodGet, "/forum/NEWS/details", nil, http.StatusOK, got)
+	if got.Slug != "news" || got.User != "jo" || got.Threads != 0 || got.Posts != 0 {
+		t.Errorf("details %+v", got)
+	}
+
+	raw = a.do(http.MethodGet, "/forum/lost/details", nil, http.StatusNotFound, nil)
+	a.errorCode(raw, "not_found", models.EntityForum)
+
+	raw = a.do(http.MethodPost, "/forum/create", nil, http.StatusBadRequest, nil)
+	a.errorCode(raw, "invalid", "")
+}
+
+func TestThread(t *testing.T) {
+	a := newTestAPI(t)
+	thread := a.seed()
+	if thread.ID == 0 || thread.Forum != "news" || thread.Slug != "hello" || thread.Author != "jo" {
+		t.Errorf("created %+v", thread)
+	}
+
+	existing := &models.Thread{}
+	a.do(http.MethodPost, "/forum/news/create",
+		&models.Thread{Title: "Again", Author: "jo", Message: "m", Slug: "HELLO"}, http.StatusConflict, existing)
+	if existing.ID != thread.ID {
+		t.Errorf("conflict returned thread %d, want %d", existing.ID, thread.ID)
+	}
+
+	raw := a.do(http.MethodPost, "/forum/lost/create",
+		&models.Thread{Title: "T", Author: "jo", Message: "m"}, http.StatusNotFound, nil)
+	a.errorCode(raw, "not_found", models.EntityForum)
+
+	for _, key := range []string{"hello", strconv.Itoa(thread.ID)} {
+		got := &models.Thread{}
+		a.do(http.MethodGet, "/thread/"+key+"/details", nil, http.StatusOK, got)
+		if got.ID != thread.ID || got.Title != "Hello" {
+			t.Errorf("details of %s: %+v", key, got)
+		}
+	}
+	raw = a.do(http.MethodGet, "/thread/404/details", nil, http.StatusNotFound, nil)
+	a.errorCode(raw, "not_found", models.EntityThread)
+
+	second := &models.Thread{}
+	a.do(http.MethodPost, "/forum/news/create",
+		&models.Thread{Title: "Second", Author: "jo", Message: "m", Created: createdAt.Add(time.Hour)},
+		http.StatusCreated, second)
+	raw = a.do(http.MethodGet, "/thread/"+strconv.Itoa(second.ID)+"/details", nil, http.StatusOK, nil)
+	if bytes.Contains(raw, []byte(`"slug"`)) {
+		t.Errorf("thread created without a slug shows one: %s", raw)
+	}
+
+	var threads []*models.Thread
+	a.do(http.MethodGet, "/forum/news/threads?desc=true&limit=10", nil, http.StatusOK, &threads)
+	if len(threads) != 2 || threads[0].ID != second.ID || threads[1].ID != thread.ID {
+		t.Errorf("threads desc = %v", ids(threads))
+	}
+	a.do(http.MethodGet, "/forum/news/threads?limit=1", nil, http.StatusOK, &threads)
+	if len(threads) != 1 || threads[0].ID != thread.ID {
+		t.Errorf("threads limit 1 = %v", ids(threads))
+	}
+
+	a.do(http.MethodPost, "/forum/create", &models.Forum{Title: "Empty", User: "jo", Slug: "empty"},
+		http.StatusCreated, nil)
+	raw = a.do(http.MethodGet, "/forum/empty/threads", nil, http.StatusOK, nil)
+	if string(raw) != "[]" {
+		t.Errorf("threads of an empty forum = %s, want []", raw)
+	}
+	raw = a.do(http.MethodGet, "/forum/lost/threads", nil, http.StatusNotFound, nil)
+	a.errorCode(raw, "not_found", models.EntityForum)
+}
+
+func TestPosts(t *testing.T) {
+	a := newTestAPI(t)
+	thread := a.seed()
+	a.createUser("al")
+
+	var posts []*models.Post
+	a.do(http.MethodPost, "/thread/hello/create", []*models.Post{
+		{Author: "jo", Message: "one"},
+		{Author: "al", Message: "two, for @jo"},
+	}, http.StatusCreated, &posts)
+	if len(posts) != 2 || posts[0].Thread != thread.ID || posts[0].Forum != "news" || posts[1].Author != "al" {
+		t.Fatalf("created %+v", posts)
+	}
+
+	var replies []*models.Post
+	a.do(http.MethodPost, "/thread/"+strconv.Itoa(thread.ID)+"/create",
+		[]*models.Post{{Author: "jo", Message: "three", Parent: int64(posts[0].ID)}}, http.StatusCreated, &replies)
+	if len(replies) != 1 || replies[0].Parent != int64(posts[0].ID) {
+		t.Fatalf("reply %+v", replies)
+	}
+
+	raw := a.do(http.MethodPost, "/thread/hello/create",
+		[]*models.Post{{Author: "jo", Message: "orphan", Parent: 404}}, http.StatusConflict, nil)
+	a.errorCode(raw, "conflict", models.EntityPost)
+	raw = a.do(http.MethodPost, "/thread/hello/create",
+		[]*models.Post{{Author: "nobody", Message: "m"}}, http.StatusNotFound, nil)
+	a.errorCode(raw, "not_found", models.EntityUser)
+	raw = a.do(http.MethodPost, "/thread/404/create", []*models.Post{{Author: "jo", Message: "m"}},
+		http.StatusNotFound, nil)
+	a.errorCode(raw, "not_found", models.EntityThread)
+
+	raw = a.do(http.MethodPost, "/thread/hello/create", []*models.Post{}, http.StatusCreated, nil)
+	if string(raw) != "[]" {
+		t.Errorf("empty batch = %s, want []", raw)
+	}
+
+	info := &struct {
+		Post *models.Post `json:"post"`
+	}{}
+	a.do(http.MethodGet, "/post/"+strconv.Itoa(posts[1].ID)+"/details", nil, http.StatusOK, info)
+	if info.Post == nil || info.Post.Message != "two, for @jo" {
+		t.Errorf("details %+v", info.Post)
+	}
+	raw = a.do(http.MethodGet, "/post/404/details", nil, http.StatusNotFound, nil)
+	a.errorCode(raw, "not_found", models.EntityPost)
+
+	var listed []*models.Post
+	a.do(http.MethodGet, "/thread/hello/posts?sort=flat&limit=10", nil, http.StatusOK, &listed)
+	if len(listed) != 3 || listed[0].ID != posts[0].ID || listed[2].ID != replies[0].ID {
+		t.Errorf("flat posts %+v", listed)
+	}
+	a.do(http.MethodGet, "/thread/hello/posts?sort=tree&limit=10", nil, http.StatusOK, &listed)
+	if len(listed) != 3 || listed[1].ID != replies[0].ID {
+		t.Errorf("tree posts %+v", listed)
+	}
+
+	forum := &models.Forum{}
+	a.do(http.MethodGet, "/forum/news/details", nil, http.StatusOK, forum)
+	if forum.Threads != 1 || forum.Posts != 3 {
+		t.Errorf("forum counts %d threads, %d posts; want 1, 3", forum.Threads, forum.Posts)
+	}
+
+	a.do(http.MethodPost, "/forum/news/create",
+		&models.Thread{Title: "Quiet", Author: "jo", Message: "m", Slug: "quiet"}, http.StatusCreated, nil)
+	raw = a.do(http.MethodGet, "/thread/quiet/posts?limit=10", nil, http.StatusOK, nil)
+	if string(raw) != "[]" {
+		t.Errorf("posts of an empty thread = %s, want []", raw)
+	}
+}
+
+// TestPinnedThreads pages the default sort of a forum with a pinned thread.
+func TestPinnedThreads(t *testing.T) {
+	a := newTestAPI(t)
+	a.seed()
+	threads := make([]*models.Thread, 6)
+	for i := 1; i <= 5; i++ {
+		threads[i] = &models.Thread{}
+		a.do(http.MethodPost, "/forum/news/create", &models.Thread{Title: "T", Author: "jo", Message: "m",
+			Slug: "t" + strconv.Itoa(i), Created: createdAt.Add(time.Duration(i) * time.Hour)},
+			http.StatusCreated, threads[i])
+	}
+	a.do(http.MethodDelete, "/thread/hello", nil, http.StatusNoContent, nil)
+	a.do(http.MethodPost, "/thread/t4/state", map[string]bool{"pinned": true}, http.StatusOK, nil)
+	since := func(i int) string {
+		return "&since=" + threads[i].Created.Format(time.RFC3339Nano)
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"limit=2", []string{"t4", "t1", "t2"}},
+		{"limit=2" + since(3), []string{"t3", "t5"}},
+		{"limit=10" + since(1), []string{"t1", "t2", "t3", "t5"}},
+		{"limit=1", []string{"t4", "t1"}},
+		{"limit=1" + since(2), []string{"t2"}},
+		{"limit=1" + since(3), []string{"t3"}},
+		{"limit=1" + since(4), []string{"t5"}},
+		{"limit=1" + since(5), []string{"t5"}},
+		{"limit=2&desc=true", []string{"t4", "t5", "t3"}},
+		{"limit=2&desc=true" + since(2), []string{"t2", "t1"}},
+	}
+	for _, tt := range tests {
+		var page []*models.Thread
+		a.do(http.MethodGet, "/forum/news/threads?"+tt.query, nil, http.StatusOK, &page)
+		if got := slugs(page); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestUserMentions(t *testing.T) {
+	a := newTestAPI(t)
+	a.seed()
+	a.createUser("al")
+
+	var posts []*models.Post
+	a.do(http.MethodPost, "/thread/hello/create", []*models.Post{
+		{Author: "al", Message: "thanks @JO, and @nobody"},
+		{Author: "al", Message: "no mention, jo@example.com"},
+	}, http.StatusCreated, &posts)
+
+	var mentions []*models.Post
+	a.do(http.MethodGet, "/user/jo/mentions?limit=10", nil, http.StatusOK, &mentions)
+	if len(mentions) != 1 || mentions[0].ID != posts[0].ID {
+		t.Errorf("mentions of jo = %+v", mentions)
+	}
+	if raw := a.do(http.MethodGet, "/user/al/mentions", nil, http.StatusOK, nil); string(raw) != "[]" {
+		t.Errorf("mentions of al = %s, want []", raw)
+	}
+	raw := a.do(http.MethodGet, "/user/nobody/mentions", nil, http.StatusNotFound, nil)
+	a.errorCode(raw, "not_found", models.EntityUser)
+}
+
+func TestPostReactions(t *testing.T) {
+	a := newTestAPI(t)
+	a.seed()
+	a.createUser("al")
+
+	var posts []*models.Post
+	a.do(http.MethodPost, "/thread/hello/create", []*models.Post{
+		{Author: "jo", Message: "one"},
+		{Author: "jo", Message: "two"},
+	}, http.StatusCreated, &posts)
+	first := "/post/" + strconv.Itoa(posts[0].ID) + "/reactions"
+
+	a.do(http.MethodPut, first+"/like?nickname=al", nil, http.StatusOK, nil)
+	a.do(http.MethodPut, first+"/like?nickname=jo", nil, http.StatusOK, nil)
+	a.do(http.MethodPut, first+"/love?nickname=jo", nil, http.StatusOK, nil)
+	raw := a.do(http.MethodPut, first+"/meh?nickname=jo", nil, http.StatusBadRequest, nil)
+	a.errorCode(raw, "invalid", "")
+
+	var reactions []*models.Reaction
+	a.do(http.MethodGet, first+"?limit=10", nil, http.StatusOK, &reactions)
+	if len(reactions) != 3 {
+		t.Errorf("reactions = %+v", reactions)
+	}
+	a.do(http.MethodGet, first+"?reaction=like&limit=10", nil, http.StatusOK, &reactions)
+	if len(reactions) != 2 || reactions[0].Key != "like" || reactions[1].Key != "like" {
+		t.Errorf("like reactions = %+v", reactions)
+	}
+
+	second := "/post/" + strconv.Itoa(posts[1].ID) + "/reactions"
+	if raw := a.do(http.MethodGet, second, nil, http.StatusOK, nil); string(raw) != "[]" {
+		t.Errorf("reactions of an untouched post = %s, want []", raw)
+	}
+}
+
+// TestRankedThreads pages a ranking sort with the cursors it returns while
+// the last thread of a page moves.
+func TestRankedThreads(t *testing.T) {
+	a := newTestAPI(t)
+	a.seed()
+	a.createUser("al")
+	a.createUser("bo")
+	for i := 0; i < 3; i++ {
+		a.do(http.MethodPost, "/forum/news/create", &models.Thread{Title: "T", Author: "jo", Message: "m",
+			Slug: "t" + strconv.Itoa(i)}, http.StatusCreated, nil)
+	}
+	vote := func(thread, nickname string, voice int) {
+		a.do(http.MethodPost, "/thread/"+thread+"/vote", &models.Vote{Nickname: nickname, Voice: voice},
+			http.StatusOK, nil)
+	}
+	vote("t0", "jo", 1)
+	vote("t0", "al", 1)
+	vote("t1", "jo", 1)
+	a.do(http.MethodPost, "/thread/hello/state", map[string]bool{"pinned": true}, http.StatusOK, nil)
+
+	var page []*models.Thread
+	a.do(http.MethodGet, "/forum/news/threads?sort=top&limit=2", nil, http.StatusOK, &page)
+	if len(page) != 2 || page[0].Slug != "hello" || page[1].Slug != "t0" {
+		t.Fatalf("first page %v", slugs(page))
+	}
+	if page[0].Cursor != "pinned_0_"+strconv.Itoa(page[0].ID) {
+		t.Errorf("cursor of the pinned thread = %q", page[0].Cursor)
+	}
+
+	// t0 falls below every other thread before the next page, which still
+	// continues from where t0 was listed.
+	vote("t0", "jo", -1)
+	vote("t0", "al", -1)
+	a.do(http.MethodGet, "/forum/news/threads?sort=top&limit=2&since="+page[1].Cursor, nil, http.StatusOK, &page)
+	if len(page) != 2 || page[0].Slug != "t1" || page[1].Slug != "t2" {
+		t.Errorf("second page %v", slugs(page))
+	}
+
+	raw := a.do(http.MethodGet, "/forum/news/threads?sort=top&since=12", nil, http.StatusBadRequest, nil)
+	a.errorCode(raw, "invalid", "")
+	raw = a.do(http.MethodGet, "/forum/news/threads?sort=sideways", nil, http.StatusBadRequest, nil)
+	a.errorCode(raw, "invalid", "")
+}
+
+func ids(threads []*models.Thread) []int {
+	var ids []int
+	for _, thread := range threads {
+		ids = append(ids, thread.ID)
+	}
+	return ids
+}
+
+func slugs(threads []*models.Thread) []string {
+	var slugs []string
+	for _, thread := range threads {
+		slugs = append(slugs, thread.Slug)
+	}
+	return slugs
+}
@@ -1,23 +1,46 @@
 package forum
 
-import "github.com/dantedoyl/Tech_DB_Forum/internal/models"
+import (
+	"context"
 
+	"github.com/dantedoyl/Tech_DB_Forum/internal/models"
+)
+
+// ForumRepository stores forums, threads, posts, users and votes.
+//
+// Methods report failures with the error types of the models package:
+// *models.NotFoundError, *models.ConflictError, *models.ValidationError and
+// *models.InternalError.
 type ForumRepository interface {
-	CreateForum(forum *models.Forum) *models.Error
-	GetForumInfo(slug string) (*models.Forum, *models.Error)
-	CreateThread(thread *models.Thread) *models.Error
-	GetForumUsers(slug string, params *models.Params) ([]*models.User, *models.Error)
-	GetForumThreads(slug string, params *models.Params) ([]*models.Thread, *models.Error)
-	UpdatePostInfo(info *models.PostUpdate) (*models.Post, *models.Error)
-	PostInfo(id int, related models.Related) (*models.PostInfo, *models.Error)
-	StatusDB() *models.Status
-	ClearDB() *models.Error
-	CreateUser(user *models.User)([]*models.User, *models.Error)
-	GetUserProfile(nickname string) (*models.User, *models.Error)
-	UpdateUserProfile(user *models.User) *models.Error
-	CreatePosts(posts []*models.Post, slugOrID string) ([]*models.Post, *models.Error)
-	GetThreadInfo(slugOrID string) (*models.Thread, *models.Error)
-	UpdateThreadInfo(thread *models.Thread) *models.Error
-	InsertOrUpdateVote(slugOrID string, vote *models.Vote) (*models.Thread, *models.Error)
-	GetThreadPosts(slugOrID string, params *models.Params) ([]*models.Post, *models.Error)
-}
\ No newline at end of file
+	CreateForum(ctx context.Context, forum *models.Forum) error
+	GetForumInfo(ctx context.Context, slug string) (*models.Forum, error)
+	CreateThread(ctx context.Context, thread *models.Thread) error
+	GetForumUsers(ctx context.Context, slug string, params *models.Params) ([]*models.User, error)
+	GetForumThreads(ctx context.Context, slug string, params *models.Params) ([]*models.Thread, error)
+	UpdatePostInfo(ctx context.Context, info *models.PostUpdate) (*models.Post, error)
+	DeletePost(ctx context.Context, id int) error
+	GetPostHistory(ctx context.Context, id int, params *models.Params) ([]*models.PostRevision, error)
+	GetPostRevision(ctx context.Context, id int, revision int) (*models.PostRevision, error)
+	RevertPost(ctx context.Context, revert *models.PostRevert) (*models.Post, error)
+	PostInfo(ctx context.Context, id int, related models.Related) (*models.PostInfo, error)
+	AddReaction(ctx context.Context, reaction *models.Reaction) (*models.Post, error)
+	RemoveReaction(ctx context.Context, reaction *models.Reaction) (*models.Post, error)
+	GetReactions(ctx context.Context, id int, key string, params *models.Params) ([]*models.Reaction, error)
+	StatusDB(ctx context.Context) (*models.Status, error)
+	ClearDB(ctx context.Context) error
+	CreateUser(ctx context.Context, user *models.User) error
+	GetUserProfile(ctx context.Context, nickname string) (*models.User, error)
+	UpdateUserProfile(ctx context.Context, user *models.User) error
+	GetUserMentions(ctx context.Context, nickname string, params *models.Params) ([]*models.Post, error)
+	CreatePosts(ctx context.Context, posts []*models.Post, slugOrID string) ([]*models.Post, error)
+	GetThreadInfo(ctx context.Context, slugOrID string) (*models.Thread, error)
+	UpdateThreadInfo(ctx context.Context, thread *models.Thread) error
+	SetThreadState(ctx context.Context, slugOrID string, state *models.ThreadState) (*models.Thread, error)
+	DeleteThread(ctx context.Context, slugOrID string) error
+	MoveThread(ctx context.Context, slugOrID string, forum string) (*models.Thread, error)
+	MergeThreads(ctx context.Context, slugOrID string, into string) (*models.Thread, error)
+	SplitThread(ctx context.Context, split *models.ThreadSplit) (*models.Thread, error)
+	InsertOrUpdateVote(ctx context.Context, slugOrID string, vote *models.Vote) (*models.Thread, error)
+	InsertOrUpdatePostVote(ctx context.Context, vote *models.Vote) (*models.Post, error)
+	GetThreadPosts(ctx context.Context, slugOrID string, params *models.Params) ([]*models.Post, error)
+}
@@ -0,0 +1,131 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/dantedoyl/Tech_DB_Forum/internal/models"
+)
+
+// defaultBanLimit is the page size of GetBans without a limit, as in postgres.
+const defaultBanLimit = 100
+
+func copyBan(ban *models.Ban) *models.Ban {
+	b := *ban
+	return &b
+}
+
+// inForce reports whether ban keeps its user out at now.
+func inForce(ban *models.Ban, now time.Time) bool {
+	return ban.Lifted == nil && (ban.Expires == nil || ban.Expires.After(now))
+}
+
+// CreateBan stores ban with the canonical spelling of its user and forum.
+func (fr *ForumRepository) CreateBan(ctx context.Context, ban *models.Ban) error {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+
+	if ban.Forum != "" {
+		forum, ok := fr.forums[key(ban.Forum)]
+		if !ok {
+			return models.NotFound(models.EntityForum, ban.Forum)
+		}
+		ban.Forum = forum.Slug
+	}
+	user, ok := fr.users[key(ban.Nickname)]
+	if !ok {
+		return models.NotFound(models.EntityUser, ban.Nickname)
+	}
+
+	b := copyBan(ban)
+	b.ID = len(fr.bans) + 1
+	b.Nickname = user.Nickname
+	b.Created = time.Now()
+	b.Lifted = nil
+	b.LiftedBy = ""
+	fr.bans = append(fr.bans, b)
+	*ban = *b
+	return nil
+}
+
+func (fr *ForumRepository) GetBan(ctx context.Context, id int) (*models.Ban, error) {
+	fr.mu.RLock()
+	defer fr.mu.RUnlock()
+
+	if id < 1 || id > len(fr.bans) {
+		return nil, models.NotFound(models.EntityBan, strconv.Itoa(id))
+	}
+	return copyBan(fr.bans[id-1]), nil
+}
+
+// GetBans pages through bans by id. Active keeps only the bans in force.
+func (fr *ForumRepository) GetBans(ctx context.Context, query *models.BanQuery) ([]*models.Ban, error) {
+	fr.mu.RLock()
+	defer fr.mu.RUnlock()
+
+	limit := query.Limit
+	if limit <= 0 {
+		limit = defaultBanLimit
+	}
+	now := time.Now()
+	bans := []*models.Ban{}
+	for i := range fr.bans {
+		ban := fr.bans[i]
+		if query.Desc {
+			ban = fr.bans[len(fr.bans)-1-i]
+		}
+		if len(bans) == limit {
+			break
+		}
+		if query.Nickname != "" && key(ban.Nickname) != key(query.Nickname) ||
+			query.Forum != "" && key(ban.Forum) != key(query.Forum) ||
+			query.Active && !inForce(ban, now) {
+			continue
+		}
+		if query.Since > 0 && (query.Desc && ban.ID >= query.Since || !query.Desc && ban.ID <= query.Since) {
+			continue
+		}
+		bans = append(bans, copyBan(ban))
+	}
+	return bans, nil
+}
+
+// LiftBan ends a ban early. Lifting a ban twice is a conflict.
+func (fr *ForumRepository) LiftBan(ctx context.Context, id int, liftedBy string) (*models.Ban, error) {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+
+	if id < 1 || id > len(fr.bans) {
+		return nil, models.NotFound(models.EntityBan, strconv.Itoa(id))
+	}
+	ban := fr.bans[id-1]
+	if ban.Lifted != nil {
+		return nil, &models.ConflictError{Entity: models.EntityBan, Reason: fmt.Sprintf("ban %d is already lifted", id)}
+	}
+	now := time.Now()
+	ban.Lifted = &now
+	ban.LiftedBy = liftedBy
+	return copyBan(ban), nil
+}
+
+// checkBan rejects a write of nickname in forum while a ban is in force. Of
+// several bans, the one lasting longest is reported. The caller must hold
+// fr.mu.
+func (fr *ForumRepository) checkBan(forum, nickname string) error {
+	now := time.Now()
+	var found *models.Ban
+	for _, ban := range fr.bans {
+		if key(ban.Nickname) != key(nickname) || ban.Forum != "" && key(ban.Forum) != key(forum) || !inForce(ban, now) {
+			continue
+		}
+		if found == nil || found.Expires != nil && (ban.Expires == nil || ban.Expires.After(*found.Expires)) {
+			found = ban
+		}
+	}
+	if found != nil {
+		return found.Forbidden()
+	}
+	return nil
+}
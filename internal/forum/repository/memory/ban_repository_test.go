@@ -0,0 +1,187 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dantedoyl/Tech_DB_Forum/internal/models"
+)
+
+// banFixture is a repository with the users jo and bob, the forums news and
+// other, and a thread and a post of jo in news.
+type banFixture struct {
+	repo   *ForumRepository
+	thread *models.Thread
+	post   *models.Post
+}
+
+func newBanFixture(t *testing.T) *banFixture {
+	t.Helper()
+	ctx := context.Background()
+	repo := NewForumRepository()
+	for _, nickname := range []string{"jo", "bob"} {
+		if err := repo.CreateUser(ctx, &models.User{Nickname: nickname, Email: nickname + "@example.com"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, slug := range []string{"news", "other"} {
+		if err := repo.CreateForum(ctx, &models.Forum{Slug: slug, Title: slug, User: "jo"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	thread := &models.Thread{Title: "hello", Author: "jo", Forum: "news", Slug: "hello", Message: "hi"}
+	if err := repo.CreateThread(ctx, thread); err != nil {
+		t.Fatal(err)
+	}
+	posts, err := repo.CreatePosts(ctx, []*models.Post{{Author: "jo", Message: "first"}}, "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &banFixture{repo: repo, thread: thread, post: posts[0]}
+}
+
+// writes runs each write bob can make in forum and returns their errors by
+// name.
+func (f *banFixture) writes(forum string) map[string]error {
+	ctx := context.Background()
+	errs := make(map[string]error)
+	thread := &models.Thread{Title: "bob in " + forum, Author: "bob", Forum: forum, Message: "hi"}
+	errs["thread"] = f.repo.CreateThread(ctx, thread)
+	if forum != "news" {
+		return errs
+	}
+	_, errs["post"] = f.repo.CreatePosts(ctx, []*models.Post{{Author: "bob", Message: "reply"}}, "hello")
+	_, errs["vote"] = f.repo.InsertOrUpdateVote(ctx, "hello", &models.Vote{Nickname: "bob", Voice: 1})
+	_, errs["post vote"] = f.repo.InsertOrUpdatePostVote(ctx, &models.Vote{Nickname: "bob", Voice: 1, Post: f.post.ID})
+	_, errs["reaction"] = f.repo.AddReaction(ctx, &models.Reaction{Post: f.post.ID, Key: "👍", Nickname: "bob"})
+	return errs
+}
+
+func TestBansKeepUsersOut(t *testing.T) {
+	hour := time.Hour
+	tests := []struct {
+		name string
+		ban  *models.Ban
+		// lift lifts the ban right after it is created.
+		lift bool
+		// banned lists the forums bob may not write in.
+		banned map[string]bool
+	}{
+		{"site ban", &models.Ban{Nickname: "BOB", Reason: "spam"}, false,
+			map[string]bool{"news": true, "other": true}},
+		{"forum mute", &models.Ban{Nickname: "bob", Forum: "NEWS"}, false,
+			map[string]bool{"news": true}},
+		{"temporary", &models.Ban{Nickname: "bob", Expires: timeIn(hour)}, false,
+			map[string]bool{"news": true, "other": true}},
+		{"expired", &models.Ban{Nickname: "bob", Expires: timeIn(-hour)}, false, nil},
+		{"lifted", &models.Ban{Nickname: "bob"}, true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newBanFixture(t)
+			ctx := context.Background()
+			if err := f.repo.CreateBan(ctx, tt.ban); err != nil {
+				t.Fatal(err)
+			}
+			if tt.lift {
+				if _, err := f.repo.LiftBan(ctx, tt.ban.ID, "jo"); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			for _, forum := range []string{"news", "other"} {
+				for write, err := range f.writes(forum) {
+					var forbidden *models.ForbiddenError
+					switch {
+					case tt.banned[forum] && !errors.As(err, &forbidden):
+						t.Errorf("%s in %s: err = %v, want the ban", write, forum, err)
+					case !tt.banned[forum] && err != nil:
+						t.Errorf("%s in %s: err = %v, want none", write, forum, err)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestBanReportsItsPost(t *testing.T) {
+	f := newBanFixture(t)
+	ctx := context.Background()
+	if err := f.repo.CreateBan(ctx, &models.Ban{Nickname: "bob", Forum: "news", Reason: "spam"}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := f.repo.CreatePosts(ctx, []*models.Post{
+		{Author: "jo", Message: "fine"},
+		{Author: "bob", Message: "spam"},
+	}, "hello")
+	var item *models.ItemError
+	if !errors.As(err, &item) || item.Index != 1 {
+		t.Fatalf("err = %v, want an error on item 1", err)
+	}
+	if want := "user bob is banned from forum news: spam"; item.Err.Error() != want {
+		t.Errorf("err = %q, want %q", item.Err, want)
+	}
+	// The batch is all or nothing.
+	if posts, _ := f.repo.GetThreadPosts(ctx, "hello", &models.Params{Limit: 10}); len(posts) != 1 {
+		t.Errorf("thread has %d posts, want 1", len(posts))
+	}
+}
+
+func TestLongestBanIsReported(t *testing.T) {
+	f := newBanFixture(t)
+	ctx := context.Background()
+	for _, ban := range []*models.Ban{
+		{Nickname: "bob", Expires: timeIn(time.Hour), Reason: "short"},
+		{Nickname: "bob", Forum: "news", Reason: "forever"},
+		{Nickname: "bob", Expires: timeIn(2 * time.Hour), Reason: "long"},
+	} {
+		if err := f.repo.CreateBan(ctx, ban); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := f.writes("news")["thread"]
+	if want := "user bob is banned from forum news: forever"; err == nil || err.Error() != want {
+		t.Errorf("err = %v, want %q", err, want)
+	}
+}
+
+func TestLiftBan(t *testing.T) {
+	f := newBanFixture(t)
+	ctx := context.Background()
+	ban := &models.Ban{Nickname: "Bob", Forum: "News"}
+	if err := f.repo.CreateBan(ctx, ban); err != nil {
+		t.Fatal(err)
+	}
+	if ban.ID != 1 || ban.Nickname != "bob" || ban.Forum != "news" {
+		t.Errorf("created %+v, want id 1 for bob in news", ban)
+	}
+
+	lifted, err := f.repo.LiftBan(ctx, ban.ID, "jo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lifted.Lifted == nil || lifted.LiftedBy != "jo" {
+		t.Errorf("lifted = %v by %q, want a time by jo", lifted.Lifted, lifted.LiftedBy)
+	}
+	var conflict *models.ConflictError
+	if _, err := f.repo.LiftBan(ctx, ban.ID, "jo"); !errors.As(err, &conflict) {
+		t.Errorf("second lift: err = %v, want a conflict", err)
+	}
+	var notFound *models.NotFoundError
+	if _, err := f.repo.LiftBan(ctx, 2, "jo"); !errors.As(err, &notFound) {
+		t.Errorf("unknown ban: err = %v, want not found", err)
+	}
+	active, err := f.repo.GetBans(ctx, &models.BanQuery{Nickname: "bob", Active: true})
+	if err != nil || len(active) != 0 {
+		t.Errorf("active bans = %v, %v; want none", active, err)
+	}
+}
+
+func timeIn(d time.Duration) *time.Time {
+	t := time.Now().Add(d)
+	return &t
+}
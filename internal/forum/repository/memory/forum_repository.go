@@ -0,0 +1,1356 @@
+// Package memory is an in-process implementation of forum.ForumRepository.
+//
+// It mirrors the behaviour of the postgres repository, including the citext
+// (case-insensitive) handling of nicknames, emails and slugs, and keeps all
+// data in maps guarded by a single RWMutex. It is meant for tests and for the
+// demo mode enabled with features.in_memory.
+package memory
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dantedoyl/Tech_DB_Forum/internal/models"
+)
+
+type post struct {
+	models.Post
+	route     []int64
+	deleted   bool
+	revisions []*models.PostRevision
+	// mentions holds the folded nicknames of the users the message mentions.
+	mentions map[string]bool
+	// reactions are kept in the order they were left.
+	reactions []*models.Reaction
+}
+
+// view returns a copy of the post as the API shows it.
+func (p *post) view() *models.Post {
+	result := p.Post
+	if p.deleted {
+		result.Tombstone()
+	}
+	return &result
+}
+
+// reactionView is view with the reaction counts filled in.
+func (p *post) reactionView() *models.Post {
+	result := p.view()
+	for _, reaction := range p.reactions {
+		if result.Reactions == nil {
+			result.Reactions = make(map[string]int)
+		}
+		result.Reactions[reaction.Key]++
+	}
+	return result
+}
+
+type ForumRepository struct {
+	mu sync.RWMutex
+
+	users      map[string]*models.User
+	emails     map[string]string
+	forums     map[string]*models.Forum
+	forumUsers map[string]map[string]bool
+	threads    []*models.Thread
+	deleted    map[int]bool
+	slugs      map[string]int
+	posts      []*post
+	votes      map[int]map[string]int
+	postVotes  map[int]map[string]int
+	// reactionID is the id of the last reaction left.
+	reactionID int64
+	// bans are kept in id order, bans[i] having id i+1.
+	bans []*models.Ban
+}
+
+func NewForumRepository() *ForumRepository {
+	fr := &ForumRepository{}
+	fr.reset()
+	return fr
+}
+
+func (fr *ForumRepository) reset() {
+	fr.users = make(map[string]*models.User)
+	fr.emails = make(map[string]string)
+	fr.forums = make(map[string]*models.Forum)
+	fr.forumUsers = make(map[string]map[string]bool)
+	fr.threads = nil
+	fr.deleted = make(map[int]bool)
+	fr.slugs = make(map[string]int)
+	fr.posts = nil
+	fr.votes = make(map[int]map[string]int)
+	fr.postVotes = make(map[int]map[string]int)
+	fr.reactionID = 0
+	fr.bans = nil
+}
+
+// key folds s the way the citext columns compare it.
+func key(s string) string {
+	return strings.ToLower(s)
+}
+
+func copyUser(user *models.User) *models.User {
+	u := *user
+	return &u
+}
+
+func copyThread(thread *models.Thread) *models.Thread {
+	t := *thread
+	return &t
+}
+
+func (fr *ForumRepository) addForumUser(forumSlug, nickname string) {
+	users, ok := fr.forumUsers[key(forumSlug)]
+	if !ok {
+		users = make(map[string]bool)
+		fr.forumUsers[key(forumSlug)] = users
+	}
+	users[key(nickname)] = true
+}
+
+// dropForumUser removes nickname from the users of a forum once they have no
+// live thread or post left in it.
+func (fr *ForumRepository) dropForumUser(forumSlug, nickname string) {
+	for _, t := range fr.threads {
+		if !fr.deleted[t.ID] && key(t.Forum) == key(forumSlug) && key(t.Author) == key(nickname) {
+			return
+		}
+	}
+	for _, p := range fr.posts {
+		if !p.deleted && key(p.Forum) == key(forumSlug) && key(p.Author) == key(nickname) {
+			return
+		}
+	}
+	delete(fr.forumUsers[key(forumSlug)], key(nickname))
+}
+
+// thread looks a live thread up by id or slug. The caller must hold fr.mu.
+func (fr *ForumRepository) thread(slugOrID string) (*models.Thread, bool) {
+	id, err := strconv.Atoi(slugOrID)
+	if err != nil {
+		var ok bool
+		id, ok = fr.slugs[key(slugOrID)]
+		if !ok {
+			return nil, false
+		}
+	}
+	if id <= 0 || id > len(fr.threads) || fr.deleted[id] {
+		return nil, false
+	}
+	return fr.threads[id-1], true
+}
+
+func (fr *ForumRepository) post(id int64) (*post, bool) {
+	if id <= 0 || id > int64(len(fr.posts)) {
+		return nil, false
+	}
+	return fr.posts[id-1], true
+}
+
+func (fr *ForumRepository) CreateForum(ctx context.Context, forum *models.Forum) error {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+
+	user, ok := fr.users[key(forum.User)]
+	if !ok {
+		return models.NotFound(models.EntityUser, forum.User)
+	}
+	forum.User = user.Nickname
+
+	if existing, ok := fr.forums[key(forum.Slug)]; ok {
+		f := *existing
+		return models.Conflict(models.EntityForum, &f)
+	}
+	f := *forum
+	f.Posts, f.Threads = 0, 0
+	fr.forums[key(forum.Slug)] = &f
+	return nil
+}
+
+func (fr *ForumRepository) GetForumInfo(ctx context.Context, slug string) (*models.Forum, error) {
+	fr.mu.RLock()
+	defer fr.mu.RUnlock()
+
+	forum, ok := fr.forums[key(slug)]
+	if !ok {
+		return nil, models.NotFound(models.EntityForum, slug)
+	}
+	f := *forum
+	return &f, nil
+}
+
+func (fr *ForumRepository) CreateThread(ctx context.Context, thread *models.Thread) error {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+
+	forum, ok := fr.forums[key(thread.Forum)]
+	if !ok {
+		return models.NotFound(models.EntityForum, thread.Forum)
+	}
+	if _, ok := fr.users[key(thread.Author)]; !ok {
+		return models.NotFound(models.EntityUser, thread.Author)
+	}
+	if err := fr.checkBan(forum.Slug, thread.Author); err != nil {
+		return err
+	}
+
+	slug := thread.Slug
+	if slug == "" {
+		slug = thread.Title + thread.Author
+	}
+	if id, ok := fr.slugs[key(slug)]; ok {
+		return models.Conflict(models.EntityThread, copyThread(fr.threads[id-1]))
+	}
+
+	t := copyThread(thread)
+	t.ID = len(fr.threads) + 1
+	t.Forum = forum.Slug
+	t.Slug = slug
+	fr.threads = append(fr.threads, t)
+	fr.slugs[key(slug)] = t.ID
+	forum.Threads++
+	fr.addForumUser(forum.Slug, thread.Author)
+
+	thread.ID = t.ID
+	thread.Forum = forum.Slug
+	return nil
+}
+
+func (fr *ForumRepository) GetForumUsers(ctx context.Context, slug string, params *models.Params) ([]*models.User, error) {
+	fr.mu.RLock()
+	defer fr.mu.RUnlock()
+
+	if _, ok := fr.forums[key(slug)]; !ok {
+		return nil, models.NotFound(models.EntityForum, slug)
+	}
+
+	var nicknames []string
+	for nickname := range fr.forumUsers[key(slug)] {
+		if params.Since != "" {
+			if params.Desc && nickname >= key(params.Since) || !params.Desc && nickname <= key(params.Since) {
+				continue
+			}
+		}
+		nicknames = append(nicknames, nickname)
+	}
+	sort.Strings(nicknames)
+	if params.Desc {
+		for i, j := 0, len(nicknames)-1; i < j; i, j = i+1, j-1 {
+			nicknames[i], nicknames[j] = nicknames[j], nicknames[i]
+		}
+	}
+	if params.Limit > 0 && len(nicknames) > params.Limit {
+		nicknames = nicknames[:params.Limit]
+	}
+
+	var users []*models.User
+	for _, nickname := range nicknames {
+		user := copyUser(fr.users[nickname])
+		// The forum_users copy in postgres carries no reputation.
+		user.Reputation = 0
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+func (fr *ForumRepository) GetForumThreads(ctx context.Context, slug string, params *models.Params) ([]*models.Thread, error) {
+	fr.mu.RLock()
+	defer fr.mu.RUnlock()
+
+	if _, ok := fr.forums[key(slug)]; !ok {
+		return nil, models.NotFound(models.EntityForum, slug)
+	}
+	switch params.Sort {
+	case "", "created":
+	case models.ThreadSortHot, models.ThreadSortTop, models.ThreadSortActive:
+		return fr.rankForumThreads(slug, params)
+	default:
+		return nil, models.Invalid("sort", "must be created, hot, top or active")
+	}
+
+	var since time.Time
+	if params.Since != "" {
+		var err error
+		since, err = time.Parse(time.RFC3339Nano, params.Since)
+		if err != nil {
+			return nil, models.Invalid("since", "must be an RFC 3339 timestamp")
+		}
+	}
+
+	// Pinned threads lead the first page and don't count towards the limit,
+	// as in the postgres repository.
+	activity := fr.threadActivity()
+	var pinned, threads []*models.Thread
+	for _, thread := range fr.threads {
+		if key(thread.Forum) != key(slug) || fr.deleted[thread.ID] {
+			continue
+		}
+		if thread.Pinned {
+			if params.Since == "" {
+				pinned = append(pinned, activity.thread(thread))
+			}
+			continue
+		}
+		if params.Since != "" {
+			if params.Desc && thread.Created.After(since) || !params.Desc && thread.Created.Before(since) {
+				continue
+			}
+		}
+		threads = append(threads, activity.thread(thread))
+	}
+	byCreated := func(threads []*models.Thread) {
+		sort.SliceStable(threads, func(i, j int) bool {
+			if params.Desc {
+				return threads[i].Created.After(threads[j].Created)
+			}
+			return threads[i].Created.Before(threads[j].Created)
+		})
+	}
+	byCreated(pinned)
+	byCreated(threads)
+	if len(threads) > params.Limit {
+		threads = threads[:params.Limit]
+	}
+	return append(pinned, threads...), nil
+}
+
+// rankForumThreads mirrors the ranking sorts of the postgres repository:
+// pinned threads first, then highest ranked, the newer thread first on ties,
+// and since is the cursor of the last thread seen. The caller must hold fr.mu.
+func (fr *ForumRepository) rankForumThreads(slug string, params *models.Params) ([]*models.Thread, error) {
+	var start time.Time
+	if params.Sort == models.ThreadSortTop {
+		var err error
+		start, err = models.WindowStart(params.Window, time.Now())
+		if err != nil {
+			return nil, err
+		}
+	}
+	var since *models.ThreadCursor
+	if params.Since != "" {
+		var err error
+		if since, err = models.ParseThreadCursor(params.Sort, params.Since); err != nil {
+			return nil, err
+		}
+	}
+
+	activity := fr.threadActivity()
+	place := func(thread *models.Thread) *models.ThreadCursor {
+		cursor := &models.ThreadCursor{Pinned: thread.Pinned, ID: thread.ID}
+		switch params.Sort {
+		case models.ThreadSortHot:
+			cursor.Rank = hotRank(thread)
+		case models.ThreadSortTop:
+			cursor.Rank = thread.Votes
+		case models.ThreadSortActive:
+			cursor.Rank = activity.lastActive(thread)
+		}
+		return cursor
+	}
+
+	var threads []*models.Thread
+	places := map[int]*models.ThreadCursor{}
+	for _, thread := range fr.threads {
+		if key(thread.Forum) != key(slug) || fr.deleted[thread.ID] || thread.Created.Before(start) {
+			continue
+		}
+		places[thread.ID] = place(thread)
+		if since != nil && !placedBefore(since, places[thread.ID]) {
+			continue
+		}
+		threads = append(threads, thread)
+	}
+	sort.Slice(threads, func(i, j int) bool {
+		return placedBefore(places[threads[i].ID], places[threads[j].ID])
+	})
+	if len(threads) > params.Limit {
+		threads = threads[:params.Limit]
+	}
+
+	result := []*models.Thread{}
+	for _, thread := range threads {
+		listed := activity.thread(thread)
+		listed.Cursor = places[thread.ID].String()
+		result = append(result, listed)
+	}
+	return result, nil
+}
+
+// placedBefore reports whether a thread at cursor a is listed before one at
+// b: pinned first, then by rank and by id, highest first.
+func placedBefore(a, b *models.ThreadCursor) bool {
+	if a.Pinned != b.Pinned {
+		return a.Pinned
+	}
+	switch ra := a.Rank.(type) {
+	case float64:
+		if rb := b.Rank.(float64); ra != rb {
+			return ra > rb
+		}
+	case int:
+		if rb := b.Rank.(int); ra != rb {
+			return ra > rb
+		}
+	case time.Time:
+		if rb := b.Rank.(time.Time); !ra.Equal(rb) {
+			return ra.After(rb)
+		}
+	}
+	return a.ID > b.ID
+}
+
+// hotRank mirrors thread_hot of migration 0017_thread_ranking.
+func hotRank(thread *models.Thread) float64 {
+	votes := float64(thread.Votes)
+	sign := 0.0
+	switch {
+	case votes > 0:
+		sign = 1
+	case votes < 0:
+		sign = -1
+	}
+	return sign*math.Log10(math.Max(math.Abs(votes), 1)) + float64(thread.Created.UnixNano())/1e9/45000
+}
+
+// postActivity is the number of live posts of a thread and the time of the
+// newest.
+type postActivity struct {
+	posts int
+	last  time.Time
+}
+
+// threadActivity is the activity of threads by id; threads without live
+// posts are missing.
+type threadActivity map[int]*postActivity
+
+// threadActivity counts the live posts of every thread. The caller must hold
+// fr.mu.
+func (fr *ForumRepository) threadActivity() threadActivity {
+	result := make(threadActivity)
+	for _, p := range fr.posts {
+		if p.deleted {
+			continue
+		}
+		a, ok := result[p.Thread]
+		if !ok {
+			a = &postActivity{}
+			result[p.Thread] = a
+		}
+		a.posts++
+		if p.Created.After(a.last) {
+			a.last = p.Created
+		}
+	}
+	return result
+}
+
+// lastActive is the time of the newest post of thread, or its creation time
+// when it has none.
+func (ta threadActivity) lastActive(thread *models.Thread) time.Time {
+	if a, ok := ta[thread.ID]; ok {
+		return a.last
+	}
+	return thread.Created
+}
+
+// thread returns a copy of thread with its post count and last post time.
+func (ta threadActivity) thread(thread *models.Thread) *models.Thread {
+	t := copyThread(thread)
+	if a, ok := ta[thread.ID]; ok {
+		last := a.last
+		t.Posts, t.LastPostAt = a.posts, &last
+	}
+	return t
+}
+
+func (fr *ForumRepository) UpdatePostInfo(ctx context.Context, info *models.PostUpdate) (*models.Post, error) {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+
+	p, ok := fr.post(int64(info.ID))
+	if !ok || p.deleted {
+		return nil, models.NotFound(models.EntityPost, strconv.Itoa(info.ID))
+	}
+	if err := fr.editPost(p, info.Message, info.Editor); err != nil {
+		return nil, err
+	}
+	return p.view(), nil
+}
+
+// editPost replaces the message of p, keeping the old text as a revision.
+// The caller must hold fr.mu for writing.
+func (fr *ForumRepository) editPost(p *post, message, editor string) error {
+	if message == "" || message == p.Message {
+		return nil
+	}
+	if editor != "" {
+		user, ok := fr.users[key(editor)]
+		if !ok {
+			return models.NotFound(models.EntityUser, editor)
+		}
+		editor = user.Nickname
+	}
+	p.revisions = append(p.revisions, &models.PostRevision{
+		PostID:   p.ID,
+		Revision: len(p.revisions) + 1,
+		Message:  p.Message,
+		Editor:   editor,
+		Edited:   time.Now(),
+	})
+	p.Message = message
+	p.IsEdited = true
+	p.mentions = fr.mentions(message)
+	return nil
+}
+
+// mentions resolves the mentions of message to users. The caller must hold
+// fr.mu.
+func (fr *ForumRepository) mentions(message string) map[string]bool {
+	var users map[string]bool
+	for _, nickname := range models.ParseMentions(message) {
+		if _, ok := fr.users[key(nickname)]; ok {
+			if users == nil {
+				users = make(map[string]bool)
+			}
+			users[key(nickname)] = true
+		}
+	}
+	return users
+}
+
+func (fr *ForumRepository) GetUserMentions(ctx context.Context, nickname string, params *models.Params) ([]*models.Post, error) {
+	fr.mu.RLock()
+	defer fr.mu.RUnlock()
+
+	if _, ok := fr.users[key(nickname)]; !ok {
+		return nil, models.NotFound(models.EntityUser, nickname)
+	}
+	var since int64
+	if params.Since != "" {
+		var err error
+		since, err = strconv.ParseInt(params.Since, 10, 64)
+		if err != nil {
+			return nil, models.Invalid("since", "must be a post id")
+		}
+	}
+	limit := params.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+
+	var posts []*post
+	for _, p := range fr.posts {
+		if p.mentions[key(nickname)] && !p.deleted && !fr.deleted[p.Thread] {
+			posts = append(posts, p)
+		}
+	}
+	if params.Desc {
+		reversePosts(posts)
+	}
+	result := []*models.Post{}
+	for _, p := range posts {
+		if params.Since != "" && (params.Desc && int64(p.ID) >= since || !params.Desc && int64(p.ID) <= since) {
+			continue
+		}
+		if len(result) == limit {
+			break
+		}
+		result = append(result, p.view())
+	}
+	return result, nil
+}
+
+func (fr *ForumRepository) GetPostHistory(ctx context.Context, id int, params *models.Params) ([]*models.PostRevision, error) {
+	fr.mu.RLock()
+	defer fr.mu.RUnlock()
+
+	p, ok := fr.post(int64(id))
+	if !ok || p.deleted {
+		return nil, models.NotFound(models.EntityPost, strconv.Itoa(id))
+	}
+	var since int
+	if params.Since != "" {
+		var err error
+		since, err = strconv.Atoi(params.Since)
+		if err != nil {
+			return nil, models.Invalid("since", "must be a revision number")
+		}
+	}
+
+	var revisions []*models.PostRevision
+	for i := range p.revisions {
+		revision := p.revisions[i]
+		if params.Desc {
+			revision = p.revisions[len(p.revisions)-1-i]
+		}
+		if params.Since != "" {
+			if params.Desc && revision.Revision >= since || !params.Desc && revision.Revision <= since {
+				continue
+			}
+		}
+		r := *revision
+		revisions = append(revisions, &r)
+		if params.Limit > 0 && len(revisions) == params.Limit {
+			break
+		}
+	}
+	return revisions, nil
+}
+
+func (fr *ForumRepository) GetPostRevision(ctx context.Context, id int, revision int) (*models.PostRevision, error) {
+	fr.mu.RLock()
+	defer fr.mu.RUnlock()
+
+	p, ok := fr.post(int64(id))
+	if !ok || p.deleted {
+		return nil, models.NotFound(models.EntityPost, strconv.Itoa(id))
+	}
+	if revision == 0 {
+		return &models.PostRevision{PostID: id, Message: p.Message}, nil
+	}
+	if revision < 0 || revision > len(p.revisions) {
+		return nil, models.NotFound(models.EntityRevision, fmt.Sprintf("%d of post %d", revision, id))
+	}
+	r := *p.revisions[revision-1]
+	return &r, nil
+}
+
+func (fr *ForumRepository) RevertPost(ctx context.Context, revert *models.PostRevert) (*models.Post, error) {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+
+	p, ok := fr.post(int64(revert.ID))
+	if !ok || p.deleted {
+		return nil, models.NotFound(models.EntityPost, strconv.Itoa(revert.ID))
+	}
+	if revert.Revision <= 0 || revert.Revision > len(p.revisions) {
+		return nil, models.NotFound(models.EntityRevision, fmt.Sprintf("%d of post %d", revert.Revision, revert.ID))
+	}
+	if err := fr.editPost(p, p.revisions[revert.Revision-1].Message, revert.Editor); err != nil {
+		return nil, err
+	}
+	return p.view(), nil
+}
+
+func (fr *ForumRepository) DeletePost(ctx context.Context, id int) error {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+
+	p, ok := fr.post(int64(id))
+	if !ok || p.deleted {
+		return models.NotFound(models.EntityPost, strconv.Itoa(id))
+	}
+	p.deleted = true
+	fr.forums[key(p.Forum)].Posts--
+	fr.dropForumUser(p.Forum, p.Author)
+	return nil
+}
+
+func (fr *ForumRepository) PostInfo(ctx context.Context, id int, related models.Related) (*models.PostInfo, error) {
+	fr.mu.RLock()
+	defer fr.mu.RUnlock()
+
+	p, ok := fr.post(int64(id))
+	if !ok || fr.deleted[p.Thread] {
+		return nil, models.NotFound(models.EntityPost, strconv.Itoa(id))
+	}
+	postAll := &models.PostInfo{Post: p.reactionView()}
+	if related.IsForum {
+		forum := *fr.forums[key(p.Forum)]
+		postAll.Forum = &forum
+	}
+	if related.IsUser {
+		postAll.Author = copyUser(fr.users[key(p.Author)])
+	}
+	if related.IsThread {
+		thread := copyThread(fr.threads[p.Thread-1])
+		if thread.Slug == thread.Title+thread.Author {
+			postAll.Thread = models.DeleteSlug(thread)
+		} else {
+			postAll.Thread = thread
+		}
+	}
+	return postAll, nil
+}
+
+func (fr *ForumRepository) StatusDB(ctx context.Context) (*models.Status, error) {
+	fr.mu.RLock()
+	defer fr.mu.RUnlock()
+
+	status := &models.Status{
+		User:   len(fr.users),
+		Forum:  len(fr.forums),
+		Thread: len(fr.threads) - len(fr.deleted),
+	}
+	for _, p := range fr.posts {
+		if !p.deleted {
+			status.Post++
+		}
+	}
+	return status, nil
+}
+
+func (fr *ForumRepository) ClearDB(ctx context.Context) error {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+
+	fr.reset()
+	return nil
+}
+
+func (fr *ForumRepository) CreateUser(ctx context.Context, user *models.User) error {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+
+	var users []*models.User
+	if existing, ok := fr.users[key(user.Nickname)]; ok {
+		users = append(users, copyUser(existing))
+	}
+	if nickname, ok := fr.emails[key(user.Email)]; ok && nickname != key(user.Nickname) {
+		users = append(users, copyUser(fr.users[nickname]))
+	}
+	if len(users) > 0 {
+		return models.Conflict(models.EntityUser, users)
+	}
+
+	fr.users[key(user.Nickname)] = copyUser(user)
+	fr.emails[key(user.Email)] = key(user.Nickname)
+	return nil
+}
+
+func (fr *ForumRepository) GetUserProfile(ctx context.Context, nickname string) (*models.User, error) {
+	fr.mu.RLock()
+	defer fr.mu.RUnlock()
+
+	user, ok := fr.users[key(nickname)]
+	if !ok {
+		return nil, models.NotFound(models.EntityUser, nickname)
+	}
+	return copyUser(user), nil
+}
+
+func (fr *ForumRepository) UpdateUserProfile(ctx context.Context, user *models.User) error {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+
+	existing, ok := fr.users[key(user.Nickname)]
+	if !ok {
+		return models.NotFound(models.EntityUser, user.Nickname)
+	}
+	if user.Email != "" {
+		if nickname, ok := fr.emails[key(user.Email)]; ok && nickname != key(existing.Nickname) {
+			return &models.ConflictError{Entity: models.EntityUser,
+				Reason: fmt.Sprintf("Email %s is already used by another user", user.Email)}
+		}
+		delete(fr.emails, key(existing.Email))
+		existing.Email = user.Email
+		fr.emails[key(user.Email)] = key(existing.Nickname)
+	}
+	if user.About != "" {
+		existing.About = user.About
+	}
+	if user.FullName != "" {
+		existing.FullName = user.FullName
+	}
+	*user = *existing
+	return nil
+}
+
+func (fr *ForumRepository) CreatePosts(ctx context.Context, posts []*models.Post, slugOrID string) ([]*models.Post, error) {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+
+	thread, ok := fr.thread(slugOrID)
+	if !ok {
+		return nil, models.NotFound(models.EntityThread, slugOrID)
+	}
+	if err := thread.CheckOpen(); err != nil {
+		return nil, err
+	}
+
+	// Validate the whole batch first: like the transaction in postgres,
+	// either every post is created or none is.
+	routes := make([][]int64, len(posts))
+	nextID := int64(len(fr.posts)) + 1
+	for i, p := range posts {
+		if _, ok := fr.users[key(p.Author)]; !ok {
+			return nil, &models.ItemError{Index: i, Err: models.NotFound(models.EntityUser, p.Author)}
+		}
+		if err := fr.checkBan(thread.Forum, p.Author); err != nil {
+			return nil, &models.ItemError{Index: i, Err: err}
+		}
+		id := nextID + int64(i)
+		if p.Parent == 0 {
+			routes[i] = []int64{id}
+			continue
+		}
+		var parentRoute []int64
+		if parent, ok := fr.post(p.Parent); ok && parent.Thread == thread.ID {
+			parentRoute = parent.route
+		} else if p.Parent >= nextID && p.Parent < id {
+			// Earlier rows of the same batch are already visible to later ones.
+			parentRoute = routes[p.Parent-nextID]
+		} else {
+			return nil, &models.ItemError{Index: i, Err: &models.ConflictError{Entity: models.EntityPost,
+				Reason: fmt.Sprintf("Parent post %d is not in this thread", p.Parent)}}
+		}
+		routes[i] = append(append([]int64{}, parentRoute...), id)
+	}
+
+	created := time.Now()
+	forum := fr.forums[key(thread.Forum)]
+	for i, p := range posts {
+		p.ID = int(nextID) + i
+		p.Created = created
+		p.Forum = thread.Forum
+		p.Thread = thread.ID
+		p.IsEdited = false
+		fr.posts = append(fr.posts, &post{Post: *p, route: routes[i], mentions: fr.mentions(p.Message)})
+		forum.Posts++
+		fr.addForumUser(thread.Forum, p.Author)
+	}
+	return posts, nil
+}
+
+func (fr *ForumRepository) GetThreadInfo(ctx context.Context, slugOrID string) (*models.Thread, error) {
+	fr.mu.RLock()
+	defer fr.mu.RUnlock()
+
+	thread, ok := fr.thread(slugOrID)
+	if !ok {
+		return nil, models.NotFound(models.EntityThread, slugOrID)
+	}
+	return fr.threadActivity().thread(thread), nil
+}
+
+func (fr *ForumRepository) UpdateThreadInfo(ctx context.Context, thread *models.Thread) error {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+
+	slugOrID := thread.Slug
+	if slugOrID == "" {
+		slugOrID = strconv.Itoa(thread.ID)
+	}
+	existing, ok := fr.thread(slugOrID)
+	if !ok {
+		return models.NotFound(models.EntityThread, slugOrID)
+	}
+	if thread.Title != "" {
+		existing.Title = thread.Title
+	}
+	if thread.Message != "" {
+		existing.Message = thread.Message
+	}
+	*thread = *existing
+	return nil
+}
+
+func (fr *ForumRepository) SetThreadState(ctx context.Context, slugOrID string, state *models.ThreadState) (*models.Thread, error) {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+
+	thread, ok := fr.thread(slugOrID)
+	if !ok {
+		return nil, models.NotFound(models.EntityThread, slugOrID)
+	}
+	if state.Locked != nil {
+		thread.Locked = *state.Locked
+	}
+	if state.Pinned != nil {
+		thread.Pinned = *state.Pinned
+	}
+	if state.Archived != nil {
+		thread.Archived = *state.Archived
+	}
+	return copyThread(thread), nil
+}
+
+func (fr *ForumRepository) DeleteThread(ctx context.Context, slugOrID string) error {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+
+	thread, ok := fr.thread(slugOrID)
+	if !ok {
+		return models.NotFound(models.EntityThread, slugOrID)
+	}
+	fr.deleted[thread.ID] = true
+	forum := fr.forums[key(thread.Forum)]
+	forum.Threads--
+
+	authors := []string{thread.Author}
+	for _, p := range fr.posts {
+		if p.Thread == thread.ID && !p.deleted {
+			p.deleted = true
+			forum.Posts--
+			authors = append(authors, p.Author)
+		}
+	}
+	for _, author := range authors {
+		fr.dropForumUser(thread.Forum, author)
+	}
+	return nil
+}
+
+// shiftForum moves counters and forum users from one forum to another after
+// threads and posts changed forum. The caller must hold fr.mu.
+func (fr *ForumRepository) shiftForum(from, to string, threads, posts int, authors []string) {
+	if key(from) == key(to) {
+		return
+	}
+	fr.forums[key(from)].Threads -= threads
+	fr.forums[key(from)].Posts -= posts
+	fr.forums[key(to)].Threads += threads
+	fr.forums[key(to)].Posts += posts
+	for _, author := range authors {
+		fr.addForumUser(to, author)
+	}
+	for _, author := range authors {
+		fr.dropForumUser(from, author)
+	}
+}
+
+func (fr *ForumRepository) MoveThread(ctx context.Context, slugOrID string, forum string) (*models.Thread, error) {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+
+	thread, ok := fr.thread(slugOrID)
+	if !ok {
+		return nil, models.NotFound(models.EntityThread, slugOrID)
+	}
+	target, ok := fr.forums[key(forum)]
+	if !ok {
+		return nil, models.NotFound(models.EntityForum, forum)
+	}
+
+	from := thread.Forum
+	thread.Forum = target.Slug
+	authors := []string{thread.Author}
+	var live int
+	for _, p := range fr.posts {
+		if p.Thread == thread.ID {
+			p.Forum = target.Slug
+			if !p.deleted {
+				live++
+				authors = append(authors, p.Author)
+			}
+		}
+	}
+	fr.shiftForum(from, target.Slug, 1, live, authors)
+	return copyThread(thread), nil
+}
+
+func (fr *ForumRepository) MergeThreads(ctx context.Context, slugOrID string, into string) (*models.Thread, error) {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+
+	source, ok := fr.thread(slugOrID)
+	if !ok {
+		return nil, models.NotFound(models.EntityThread, slugOrID)
+	}
+	target, ok := fr.thread(into)
+	if !ok {
+		return nil, models.NotFound(models.EntityThread, into)
+	}
+	if source.ID == target.ID {
+		return nil, models.Invalid("into", "must be another thread")
+	}
+
+	var authors []string
+	for _, p := range fr.posts {
+		if p.Thread == source.ID {
+			p.Thread = target.ID
+			p.Forum = target.Forum
+			if !p.deleted {
+				authors = append(authors, p.Author)
+			}
+		}
+	}
+	fr.deleted[source.ID] = true
+	fr.forums[key(source.Forum)].Threads--
+	fr.shiftForum(source.Forum, target.Forum, 0, len(authors), authors)
+	for _, author := range append(authors, source.Author) {
+		fr.dropForumUser(source.Forum, author)
+	}
+	return copyThread(target), nil
+}
+
+func (fr *ForumRepository) SplitThread(ctx context.Context, split *models.ThreadSplit) (*models.Thread, error) {
+	if split.Title == "" {
+		return nil, models.Invalid("title", "is required")
+	}
+
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+
+	root, ok := fr.post(int64(split.Post))
+	if !ok || root.deleted {
+		return nil, models.NotFound(models.EntityPost, strconv.Itoa(split.Post))
+	}
+	source, ok := fr.thread(strconv.Itoa(root.Thread))
+	if !ok {
+		return nil, models.NotFound(models.EntityThread, strconv.Itoa(root.Thread))
+	}
+	forum := fr.forums[key(source.Forum)]
+	if split.Forum != "" {
+		if forum, ok = fr.forums[key(split.Forum)]; !ok {
+			return nil, models.NotFound(models.EntityForum, split.Forum)
+		}
+	}
+
+	slug := split.Slug
+	if slug == "" {
+		slug = split.Title + root.Author
+	}
+	if _, ok := fr.slugs[key(slug)]; ok {
+		return nil, &models.ConflictError{Entity: models.EntityThread, Reason: fmt.Sprintf("Thread %s already exists", slug)}
+	}
+	message := split.Message
+	if message == "" {
+		message = root.Message
+	}
+	thread := &models.Thread{ID: len(fr.threads) + 1, Title: split.Title, Author: root.Author, Forum: forum.Slug,
+		Message: message, Slug: slug, Created: root.Created}
+	fr.threads = append(fr.threads, thread)
+	fr.slugs[key(slug)] = thread.ID
+	forum.Threads++
+	fr.addForumUser(forum.Slug, thread.Author)
+
+	var authors []string
+	for _, p := range fr.posts {
+		if p.Thread != source.ID {
+			continue
+		}
+		for i, id := range p.route {
+			if id == int64(split.Post) {
+				p.route = append([]int64{}, p.route[i:]...)
+				p.Thread = thread.ID
+				p.Forum = forum.Slug
+				if !p.deleted {
+					authors = append(authors, p.Author)
+				}
+				break
+			}
+		}
+	}
+	root.Parent = 0
+	fr.shiftForum(source.Forum, forum.Slug, 0, len(authors), authors)
+	return copyThread(thread), nil
+}
+
+func (fr *ForumRepository) InsertOrUpdateVote(ctx context.Context, slugOrID string, vote *models.Vote) (*models.Thread, error) {
+	if vote.Voice != 1 && vote.Voice != -1 {
+		return nil, models.Invalid("voice", "must be 1 or -1")
+	}
+
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+
+	thread, ok := fr.thread(slugOrID)
+	if !ok {
+		return nil, models.NotFound(models.EntityThread, slugOrID)
+	}
+	if _, ok := fr.users[key(vote.Nickname)]; !ok {
+		return nil, models.NotFound(models.EntityUser, vote.Nickname)
+	}
+	if err := thread.CheckVotable(); err != nil {
+		return nil, err
+	}
+	if err := fr.checkBan(thread.Forum, vote.Nickname); err != nil {
+		return nil, err
+	}
+
+	votes, ok := fr.votes[thread.ID]
+	if !ok {
+		votes = make(map[string]int)
+		fr.votes[thread.ID] = votes
+	}
+	delta := vote.Voice - votes[key(vote.Nickname)]
+	thread.Votes += delta
+	votes[key(vote.Nickname)] = vote.Voice
+	fr.addReputation(thread.Author, vote.Nickname, delta)
+	return copyThread(thread), nil
+}
+
+// addReputation credits author with delta for a vote of voter, unless they
+// voted for themselves. The caller must hold fr.mu for writing.
+func (fr *ForumRepository) addReputation(author, voter string, delta int) {
+	if key(author) != key(voter) {
+		fr.users[key(author)].Reputation += delta
+	}
+}
+
+func (fr *ForumRepository) InsertOrUpdatePostVote(ctx context.Context, vote *models.Vote) (*models.Post, error) {
+	if vote.Voice != 1 && vote.Voice != -1 {
+		return nil, models.Invalid("voice", "must be 1 or -1")
+	}
+
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+
+	p, err := fr.votablePost(vote.Post)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := fr.users[key(vote.Nickname)]; !ok {
+		return nil, models.NotFound(models.EntityUser, vote.Nickname)
+	}
+	if err := fr.checkBan(p.Forum, vote.Nickname); err != nil {
+		return nil, err
+	}
+
+	votes, ok := fr.postVotes[p.ID]
+	if !ok {
+		votes = make(map[string]int)
+		fr.postVotes[p.ID] = votes
+	}
+	delta := vote.Voice - votes[key(vote.Nickname)]
+	p.Score += delta
+	votes[key(vote.Nickname)] = vote.Voice
+	fr.addReputation(p.Author, vote.Nickname, delta)
+	return p.reactionView(), nil
+}
+
+// votablePost looks up a live post in a thread that takes votes and
+// reactions. The caller must hold fr.mu.
+func (fr *ForumRepository) votablePost(id int) (*post, error) {
+	p, ok := fr.post(int64(id))
+	if !ok || p.deleted || fr.deleted[p.Thread] {
+		return nil, models.NotFound(models.EntityPost, strconv.Itoa(id))
+	}
+	if err := fr.threads[p.Thread-1].CheckVotable(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (fr *ForumRepository) AddReaction(ctx context.Context, reaction *models.Reaction) (*models.Post, error) {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+
+	p, err := fr.votablePost(reaction.Post)
+	if err != nil {
+		return nil, err
+	}
+	user, ok := fr.users[key(reaction.Nickname)]
+	if !ok {
+		return nil, models.NotFound(models.EntityUser, reaction.Nickname)
+	}
+	if err := fr.checkBan(p.Forum, reaction.Nickname); err != nil {
+		return nil, err
+	}
+	for _, r := range p.reactions {
+		if r.Key == reaction.Key && key(r.Nickname) == key(user.Nickname) {
+			return p.reactionView(), nil
+		}
+	}
+	fr.reactionID++
+	p.reactions = append(p.reactions, &models.Reaction{
+		ID:       fr.reactionID,
+		Post:     p.ID,
+		Key:      reaction.Key,
+		Nickname: user.Nickname,
+		Created:  time.Now(),
+	})
+	return p.reactionView(), nil
+}
+
+func (fr *ForumRepository) RemoveReaction(ctx context.Context, reaction *models.Reaction) (*models.Post, error) {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+
+	p, err := fr.votablePost(reaction.Post)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := fr.users[key(reaction.Nickname)]; !ok {
+		return nil, models.NotFound(models.EntityUser, reaction.Nickname)
+	}
+	for i, r := range p.reactions {
+		if r.Key == reaction.Key && key(r.Nickname) == key(reaction.Nickname) {
+			p.reactions = append(p.reactions[:i:i], p.reactions[i+1:]...)
+			break
+		}
+	}
+	return p.reactionView(), nil
+}
+
+func (fr *ForumRepository) GetReactions(ctx context.Context, id int, reactionKey string, params *models.Params) ([]*models.Reaction, error) {
+	fr.mu.RLock()
+	defer fr.mu.RUnlock()
+
+	p, ok := fr.post(int64(id))
+	if !ok || fr.deleted[p.Thread] {
+		return nil, models.NotFound(models.EntityPost, strconv.Itoa(id))
+	}
+	var since int64
+	if params.Since != "" {
+		var err error
+		since, err = strconv.ParseInt(params.Since, 10, 64)
+		if err != nil {
+			return nil, models.Invalid("since", "must be a reaction id")
+		}
+	}
+	limit := params.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+
+	result := []*models.Reaction{}
+	for i := range p.reactions {
+		r := p.reactions[i]
+		if params.Desc {
+			r = p.reactions[len(p.reactions)-1-i]
+		}
+		if reactionKey != "" && r.Key != reactionKey {
+			continue
+		}
+		if params.Since != "" && (params.Desc && r.ID >= since || !params.Desc && r.ID <= since) {
+			continue
+		}
+		if len(result) == limit {
+			break
+		}
+		reaction := *r
+		result = append(result, &reaction)
+	}
+	return result, nil
+}
+
+func (fr *ForumRepository) GetThreadPosts(ctx context.Context, slugOrID string, params *models.Params) ([]*models.Post, error) {
+	fr.mu.RLock()
+	defer fr.mu.RUnlock()
+
+	thread, ok := fr.thread(slugOrID)
+	if !ok {
+		return nil, models.NotFound(models.EntityThread, slugOrID)
+	}
+
+	var posts []*post
+	for _, p := range fr.posts {
+		if p.Thread == thread.ID {
+			posts = append(posts, p)
+		}
+	}
+
+	var sinceID int64
+	var since *post
+	if params.Since != "" {
+		var err error
+		sinceID, err = strconv.ParseInt(params.Since, 10, 64)
+		if err != nil {
+			return nil, models.Invalid("since", "must be a post id")
+		}
+		since, _ = fr.post(sinceID)
+	}
+
+	var result []*post
+	switch params.Sort {
+	case "tree":
+		sort.Slice(posts, func(i, j int) bool {
+			return compareRoutes(posts[i].route, posts[j].route) < 0
+		})
+		if params.Desc {
+			reversePosts(posts)
+		}
+		for _, p := range posts {
+			if params.Since != "" {
+				if since == nil {
+					break
+				}
+				cmp := compareRoutes(p.route, since.route)
+				if params.Desc && cmp >= 0 || !params.Desc && cmp <= 0 {
+					continue
+				}
+			}
+			result = append(result, p)
+		}
+		result = limitPosts(result, params.Limit)
+	case "parent_tree":
+		var roots []int64
+		for _, p := range posts {
+			if p.Parent != 0 {
+				continue
+			}
+			if params.Since != "" {
+				if since == nil {
+					break
+				}
+				if params.Desc && p.route[0] >= since.route[0] || !params.Desc && p.route[0] <= since.route[0] {
+					continue
+				}
+			}
+			roots = append(roots, int64(p.ID))
+		}
+		if params.Desc {
+			sort.Slice(roots, func(i, j int) bool { return roots[i] > roots[j] })
+		}
+		if len(roots) > params.Limit {
+			roots = roots[:params.Limit]
+		}
+		sort.Slice(posts, func(i, j int) bool {
+			return compareRoutes(posts[i].route, posts[j].route) < 0
+		})
+		for _, root := range roots {
+			for _, p := range posts {
+				if p.route[0] == root {
+					result = append(result, p)
+				}
+			}
+		}
+	case "", "flat":
+		if params.Desc {
+			reversePosts(posts)
+		}
+		for _, p := range posts {
+			if params.Since != "" {
+				id := int(sinceID)
+				if params.Desc && p.ID >= id || !params.Desc && p.ID <= id {
+					continue
+				}
+			}
+			result = append(result, p)
+		}
+		result = limitPosts(result, params.Limit)
+	default:
+		return nil, models.Invalid("sort", "must be flat, tree or parent_tree")
+	}
+
+	var out []*models.Post
+	for _, p := range result {
+		out = append(out, p.reactionView())
+	}
+	return out, nil
+}
+
+// compareRoutes orders materialized paths the way Postgres compares BIGINT[].
+func compareRoutes(a, b []int64) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return len(a) - len(b)
+}
+
+func reversePosts(posts []*post) {
+	for i, j := 0, len(posts)-1; i < j; i, j = i+1, j-1 {
+		posts[i], posts[j] = posts[j], posts[i]
+	}
+}
+
+func limitPosts(posts []*post, limit int) []*post {
+	if len(posts) > limit {
+		return posts[:limit]
+	}
+	return posts
+}
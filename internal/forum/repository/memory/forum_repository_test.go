@@ -0,0 +1,201 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/dantedoyl/Tech_DB_Forum/internal/models"
+)
+
+// newModerationFixture returns a repository with two threads:
+//
+//	hello in forum a, by ann:  1 ann, 2 ben -> 1, 3 cid -> 2, 4 ann
+//	other in forum otherForum, by dan: 5 dan, 6 ben -> 5, 7 eve -> 5 (deleted)
+func newModerationFixture(t *testing.T, otherForum string) *ForumRepository {
+	t.Helper()
+	ctx := context.Background()
+	repo := NewForumRepository()
+	for _, nickname := range []string{"ann", "ben", "cid", "dan", "eve"} {
+		if err := repo.CreateUser(ctx, &models.User{Nickname: nickname, Email: nickname + "@example.com"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, slug := range []string{"a", "b"} {
+		if err := repo.CreateForum(ctx, &models.Forum{Slug: slug, Title: slug, User: "ann"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, thread := range []*models.Thread{
+		{Title: "hello", Author: "ann", Forum: "a", Slug: "hello"},
+		{Title: "other", Author: "dan", Forum: otherForum, Slug: "other"},
+	} {
+		if err := repo.CreateThread(ctx, thread); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, batch := range []struct {
+		thread string
+		posts  []*models.Post
+	}{
+		{"hello", []*models.Post{{Author: "ann"}, {Author: "ben", Parent: 1}, {Author: "cid", Parent: 2}, {Author: "ann"}}},
+		{"other", []*models.Post{{Author: "dan"}, {Author: "ben", Parent: 5}, {Author: "eve", Parent: 5}}},
+	} {
+		if _, err := repo.CreatePosts(ctx, batch.posts, batch.thread); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := repo.DeletePost(ctx, 7); err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+// tree lists the posts of a thread in tree order as "id<-parent route".
+func tree(t *testing.T, repo *ForumRepository, thread string) []string {
+	t.Helper()
+	posts, err := repo.GetThreadPosts(context.Background(), thread, &models.Params{Limit: 100, Sort: "tree"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := []string{}
+	for _, p := range posts {
+		lines = append(lines, fmt.Sprintf("%d<-%d %v", p.ID, p.Parent, repo.posts[p.ID-1].route))
+	}
+	return lines
+}
+
+// forumState is what a forum counts and lists after a moderator operation.
+type forumState struct {
+	threads, posts int
+	users          []string
+}
+
+func checkForums(t *testing.T, repo *ForumRepository, want map[string]forumState) {
+	t.Helper()
+	ctx := context.Background()
+	for slug, want := range want {
+		forum, err := repo.GetForumInfo(ctx, slug)
+		if err != nil {
+			t.Fatal(err)
+		}
+		users, err := repo.GetForumUsers(ctx, slug, &models.Params{Limit: 100})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := forumState{threads: forum.Threads, posts: forum.Posts, users: []string{}}
+		for _, user := range users {
+			got.users = append(got.users, user.Nickname)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("forum %s = %+v, want %+v", slug, got, want)
+		}
+	}
+}
+
+func checkTree(t *testing.T, repo *ForumRepository, thread string, want []string) {
+	t.Helper()
+	if got := tree(t, repo, thread); !reflect.DeepEqual(got, want) {
+		t.Errorf("thread %s tree = %q, want %q", thread, got, want)
+	}
+}
+
+func TestMoveThread(t *testing.T) {
+	repo := newModerationFixture(t, "a")
+	checkForums(t, repo, map[string]forumState{
+		"a": {2, 6, []string{"ann", "ben", "cid", "dan"}},
+		"b": {0, 0, []string{}},
+	})
+
+	moved, err := repo.MoveThread(context.Background(), "other", "B")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved.Forum != "b" {
+		t.Errorf("moved to %q, want b", moved.Forum)
+	}
+	checkTree(t, repo, "other", []string{"5<-0 [5]", "6<-5 [5 6]", "7<-5 [5 7]"})
+	for _, p := range repo.posts[4:] {
+		if p.Forum != "b" {
+			t.Errorf("post %d in forum %q, want b", p.ID, p.Forum)
+		}
+	}
+	// ben still has a post in a; dan had only the moved thread.
+	checkForums(t, repo, map[string]forumState{
+		"a": {1, 4, []string{"ann", "ben", "cid"}},
+		"b": {1, 2, []string{"ben", "dan"}},
+	})
+}
+
+func TestMergeThreads(t *testing.T) {
+	repo := newModerationFixture(t, "b")
+	ctx := context.Background()
+
+	merged, err := repo.MergeThreads(ctx, "other", "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merged.Slug != "hello" {
+		t.Errorf("merged into %q, want hello", merged.Slug)
+	}
+	// The merged posts keep their subtrees as new roots of the target.
+	checkTree(t, repo, "hello", []string{
+		"1<-0 [1]", "2<-1 [1 2]", "3<-2 [1 2 3]", "4<-0 [4]", "5<-0 [5]", "6<-5 [5 6]", "7<-5 [5 7]",
+	})
+	if _, err := repo.GetThreadInfo(ctx, "other"); err == nil {
+		t.Error("merged thread is still listed")
+	}
+	checkForums(t, repo, map[string]forumState{
+		"a": {1, 6, []string{"ann", "ben", "cid", "dan"}},
+		"b": {0, 0, []string{}},
+	})
+}
+
+func TestMergeThreadsInOneForum(t *testing.T) {
+	repo := newModerationFixture(t, "a")
+
+	if _, err := repo.MergeThreads(context.Background(), "other", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	// dan no longer authors the thread but still has a post in the forum.
+	checkForums(t, repo, map[string]forumState{
+		"a": {1, 6, []string{"ann", "ben", "cid", "dan"}},
+		"b": {0, 0, []string{}},
+	})
+}
+
+func TestSplitThread(t *testing.T) {
+	tests := []struct {
+		name   string
+		forum  string
+		forums map[string]forumState
+	}{
+		{"same forum", "", map[string]forumState{
+			"a": {3, 6, []string{"ann", "ben", "cid", "dan"}},
+			"b": {0, 0, []string{}},
+		}},
+		{"other forum", "b", map[string]forumState{
+			"a": {2, 4, []string{"ann", "ben", "dan"}},
+			"b": {1, 2, []string{"ben", "cid"}},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newModerationFixture(t, "a")
+
+			split, err := repo.SplitThread(context.Background(),
+				&models.ThreadSplit{Post: 2, Title: "split", Slug: "split", Forum: tt.forum})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if split.Author != "ben" {
+				t.Errorf("split thread author = %q, want ben", split.Author)
+			}
+			// The split post becomes a root and its replies follow it.
+			checkTree(t, repo, "split", []string{"2<-0 [2]", "3<-2 [2 3]"})
+			checkTree(t, repo, "hello", []string{"1<-0 [1]", "4<-0 [4]"})
+			checkForums(t, repo, tt.forums)
+		})
+	}
+}
@@ -1,70 +1,168 @@
 package postgres
 
 import (
+	"context"
 	"fmt"
 	"github.com/dantedoyl/Tech_DB_Forum/internal/models"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/pgtx"
 	"github.com/jackc/pgx"
-	"net/http"
+	"github.com/jackc/pgx/pgtype"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 )
 
+const (
+	codeUniqueViolation     = "23505"
+	codeForeignKeyViolation = "23503"
+	codeRaiseException      = "P0001"
+)
+
+const (
+	defaultMentionsLimit  = 100
+	defaultReactionsLimit = 100
+)
+
 type ForumRepository struct {
 	dbConn *pgx.ConnPool
 }
 
-func NewForumRepository( conn *pgx.ConnPool) *ForumRepository{
+func NewForumRepository(conn *pgx.ConnPool) *ForumRepository {
 	return &ForumRepository{dbConn: conn}
 }
 
-func (fr ForumRepository) CreateForum(forum *models.Forum) *models.Error{
+// conn is the transaction ctx carries, or the pool.
+func (fr ForumRepository) conn(ctx context.Context) pgtx.Queryer {
+	return pgtx.Conn(ctx, fr.dbConn)
+}
+
+func pgErrorCode(err error) string {
+	if pgErr, ok := err.(pgx.PgError); ok {
+		return pgErr.Code
+	}
+	return ""
+}
+
+// notFoundOr maps a missing row to NotFound and anything else to Internal.
+func notFoundOr(err error, entity models.Entity, key string) error {
+	if err == pgx.ErrNoRows {
+		return models.NotFound(entity, key)
+	}
+	return models.Internal(err)
+}
+
+// slugOrIDCondition turns a thread slug or id path segment into a WHERE
+// condition on the thread table and its argument.
+func slugOrIDCondition(slugOrID string) (string, interface{}) {
+	id, err := strconv.Atoi(slugOrID)
+	if err != nil {
+		return `slug=$1`, slugOrID
+	}
+	return `id=$1`, id
+}
+
+// activeBans returns the bans in force on nicknames in forum, site-wide bans
+// included, keyed by lower-cased nickname. The longest ban of a user wins.
+func activeBans(ctx context.Context, q pgtx.Queryer, forum string, nicknames []string) (map[string]*models.Ban, error) {
+	array := &pgtype.TextArray{}
+	if err := array.Set(nicknames); err != nil {
+		return nil, err
+	}
+	rows, err := q.QueryEx(ctx, `SELECT id, nickname, COALESCE(forum, ''), reason, expires_at FROM bans
+		WHERE nickname = ANY($1::text[]::citext[]) AND (forum IS NULL OR forum=$2)
+			AND lifted_at IS NULL AND (expires_at IS NULL OR expires_at > now())
+		ORDER BY expires_at DESC NULLS FIRST`, nil, array, forum)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bans := make(map[string]*models.Ban)
+	for rows.Next() {
+		ban := &models.Ban{}
+		var expires pgtype.Timestamptz
+		if err := rows.Scan(&ban.ID, &ban.Nickname, &ban.Forum, &ban.Reason, &expires); err != nil {
+			return nil, err
+		}
+		if expires.Status == pgtype.Present {
+			ban.Expires = &expires.Time
+		}
+		if _, ok := bans[strings.ToLower(ban.Nickname)]; !ok {
+			bans[strings.ToLower(ban.Nickname)] = ban
+		}
+	}
+	return bans, rows.Err()
+}
+
+// checkBan rejects a write of nickname in forum while a ban is in force.
+func checkBan(ctx context.Context, q pgtx.Queryer, forum string, nickname string) error {
+	bans, err := activeBans(ctx, q, forum, []string{nickname})
+	if err != nil {
+		return models.Internal(err)
+	}
+	if ban, ok := bans[strings.ToLower(nickname)]; ok {
+		return ban.Forbidden()
+	}
+	return nil
+}
+
+func (fr ForumRepository) CreateForum(ctx context.Context, forum *models.Forum) error {
 	var user string
-	err := fr.dbConn.QueryRow(`SELECT nickname FROM users WHERE nickname=$1;`, forum.User).Scan(&user)
+	err := fr.conn(ctx).QueryRowEx(ctx, `SELECT nickname FROM users WHERE nickname=$1;`, nil, forum.User).Scan(&user)
 	if err != nil {
-		return &models.Error{Code:    http.StatusNotFound, Message: "Can't find user with nickname"}
+		return notFoundOr(err, models.EntityUser, forum.User)
 	}
 	forum.User = user
-	err = fr.dbConn.QueryRow(`INSERT INTO forum(slug, author, title) VALUES ($1, $2, $3) RETURNING slug`,
-		forum.Slug, forum.User, forum.Title).Scan(&forum.Slug)
-	if err != nil {
-		if err.(pgx.PgError).Code == "23505"{
-			row := fr.dbConn.QueryRow(`SELECT slug, author, title, posts, threads FROM forum
-				WHERE slug=$1`, forum.Slug)
-			err = row.Scan(&forum.Slug, &forum.User, &forum.Title, &forum.Posts, &forum.Threads)
-			return &models.Error{Code: http.StatusConflict}
-		} else {
-			return &models.Error{Code: http.StatusInternalServerError, Message: err.Error()}
+	err = pgtx.Savepoint(ctx, fr.dbConn, func(q pgtx.Queryer) error {
+		return q.QueryRowEx(ctx, `INSERT INTO forum(slug, author, title) VALUES ($1, $2, $3) RETURNING slug`, nil,
+			forum.Slug, forum.User, forum.Title).Scan(&forum.Slug)
+	})
+	if err != nil {
+		if pgErrorCode(err) == codeUniqueViolation {
+			existing := &models.Forum{}
+			row := fr.conn(ctx).QueryRowEx(ctx, `SELECT slug, author, title, posts, threads FROM forum
+				WHERE slug=$1`, nil, forum.Slug)
+			err = row.Scan(&existing.Slug, &existing.User, &existing.Title, &existing.Posts, &existing.Threads)
+			if err != nil {
+				return models.Internal(err)
+			}
+			return models.Conflict(models.EntityForum, existing)
 		}
+		return models.Internal(err)
 	}
 
 	return nil
 }
 
-func (fr ForumRepository)GetForumInfo(slug string) (*models.Forum, *models.Error){
+func (fr ForumRepository) GetForumInfo(ctx context.Context, slug string) (*models.Forum, error) {
 	forum := &models.Forum{}
-	row := fr.dbConn.QueryRow(`SELECT slug, author, title, posts, threads FROM forum
-				WHERE slug=$1`, slug)
+	row := fr.conn(ctx).QueryRowEx(ctx, `SELECT slug, author, title, posts, threads FROM forum
+				WHERE slug=$1`, nil, slug)
 	err := row.Scan(&forum.Slug, &forum.User, &forum.Title, &forum.Posts, &forum.Threads)
 	if err != nil {
-		return nil, &models.Error{Code: http.StatusNotFound, Message: "Can't find forum" }
+		return nil, notFoundOr(err, models.EntityForum, slug)
 	}
 	return forum, nil
 }
 
-func (fr ForumRepository)CreateThread(thread *models.Thread) *models.Error{
+func (fr ForumRepository) CreateThread(ctx context.Context, thread *models.Thread) error {
 	var forumSlug string
-	err := fr.dbConn.QueryRow(`SELECT slug FROM forum
-				WHERE slug=$1`, thread.Forum).Scan(&forumSlug)
+	err := fr.conn(ctx).QueryRowEx(ctx, `SELECT slug FROM forum
+				WHERE slug=$1`, nil, thread.Forum).Scan(&forumSlug)
 	if err != nil {
-		return &models.Error{Code: http.StatusNotFound, Message: "Can't find forum" }
+		return notFoundOr(err, models.EntityForum, thread.Forum)
 	}
 
 	var userName string
-	row := fr.dbConn.QueryRow(`SELECT nickname FROM users WHERE nickname=$1;`, thread.Author)
+	row := fr.conn(ctx).QueryRowEx(ctx, `SELECT nickname FROM users WHERE nickname=$1;`, nil, thread.Author)
 	err = row.Scan(&userName)
 	if err != nil {
-		return &models.Error{Code: http.StatusNotFound, Message: "Can't find user" }
+		return notFoundOr(err, models.EntityUser, thread.Author)
+	}
+	err = checkBan(ctx, fr.conn(ctx), forumSlug, userName)
+	if err != nil {
+		return err
 	}
 	var slug string
 	if thread.Slug == "" {
@@ -72,34 +170,39 @@ func (fr ForumRepository)CreateThread(thread *models.Thread) *models.Error{
 	} else {
 		slug = thread.Slug
 	}
-	err = fr.dbConn.QueryRow(	`INSERT INTO thread(title, author, created, forum, message, slug, votes)
-							VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id, created`, thread.Title, thread.Author, thread.Created,
-		thread.Forum,
-		thread.Message, slug, thread.Votes).Scan(&thread.ID, &thread.Created)
-	if err != nil {
-		if err.(pgx.PgError).Code == "23505"{
-			row = fr.dbConn.QueryRow(`SELECT id, title, author, forum, message, votes, slug, created FROM thread
-							WHERE slug=$1;`, slug)
-			err = row.Scan(&thread.ID, &thread.Title, &thread.Author, &thread.Forum, &thread.Message, &thread.Votes,
-				&thread.Slug, &thread.Created)
-			return &models.Error{Code: http.StatusConflict}
-		} else {
-			return &models.Error{Code: http.StatusInternalServerError, Message: err.Error()}
+	err = pgtx.Savepoint(ctx, fr.dbConn, func(q pgtx.Queryer) error {
+		return q.QueryRowEx(ctx, `INSERT INTO thread(title, author, created, forum, message, slug, votes)
+							VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id, created`, nil, thread.Title, thread.Author, thread.Created,
+			thread.Forum,
+			thread.Message, slug, thread.Votes).Scan(&thread.ID, &thread.Created)
+	})
+	if err != nil {
+		if pgErrorCode(err) == codeUniqueViolation {
+			existing := &models.Thread{}
+			row = fr.conn(ctx).QueryRowEx(ctx, `SELECT id, title, author, forum, message, votes, slug, created,
+							locked, pinned, archived FROM thread WHERE slug=$1;`, nil, slug)
+			err = row.Scan(&existing.ID, &existing.Title, &existing.Author, &existing.Forum, &existing.Message, &existing.Votes,
+				&existing.Slug, &existing.Created, &existing.Locked, &existing.Pinned, &existing.Archived)
+			if err != nil {
+				return models.Internal(err)
+			}
+			return models.Conflict(models.EntityThread, existing)
 		}
+		return models.Internal(err)
 	}
 	thread.Forum = forumSlug
 	return nil
 }
 
-func (fr ForumRepository)GetForumUsers(slug string, params *models.Params) ([]*models.User, *models.Error){
+func (fr ForumRepository) GetForumUsers(ctx context.Context, slug string, params *models.Params) ([]*models.User, error) {
 	var forumSlug string
-	err := fr.dbConn.QueryRow(`SELECT slug FROM forum
-				WHERE slug=$1`, slug).Scan(&forumSlug)
+	err := fr.conn(ctx).QueryRowEx(ctx, `SELECT slug FROM forum
+				WHERE slug=$1`, nil, slug).Scan(&forumSlug)
 	if err != nil {
-		return nil, &models.Error{Code: http.StatusNotFound, Message: "Can't find forum" }
+		return nil, notFoundOr(err, models.EntityForum, slug)
 	}
 
-	query := `SELECT about, email, fullname, nickname 
+	query := `SELECT about, email, fullname, nickname
 				FROM forum_users WHERE slug=$1`
 	if params.Desc {
 		if params.Since != "" {
@@ -113,9 +216,9 @@ func (fr ForumRepository)GetForumUsers(slug string, params *models.Params) ([]*m
 		query += ` ORDER BY nickname LIMIT NULLIF($2, 0)`
 	}
 	var users []*models.User
-	rows, err := fr.dbConn.Query(query, slug, params.Limit)
+	rows, err := fr.conn(ctx).QueryEx(ctx, query, nil, slug, params.Limit)
 	if err != nil {
-		return nil, &models.Error{Code: http.StatusInternalServerError, Message: err.Error()}
+		return nil, models.Internal(err)
 	}
 
 	defer rows.Close()
@@ -124,376 +227,1332 @@ func (fr ForumRepository)GetForumUsers(slug string, params *models.Params) ([]*m
 		user := &models.User{}
 		err = rows.Scan(&user.About, &user.Email, &user.FullName, &user.Nickname)
 		if err != nil {
-			return nil, &models.Error{Code: http.StatusInternalServerError, Message: err.Error()}
+			return nil, models.Internal(err)
 		}
 		users = append(users, user)
 	}
+	if rows.Err() != nil {
+		return nil, models.Internal(rows.Err())
+	}
 	return users, nil
 }
 
-func (fr ForumRepository)GetForumThreads(slug string, params *models.Params) ([]*models.Thread, *models.Error){
+// GetForumThreads lists the threads of a forum by creation time, pinned
+// threads first.
+func (fr ForumRepository) GetForumThreads(ctx context.Context, slug string, params *models.Params) ([]*models.Thread, error) {
 	var forumSlug string
-	row := fr.dbConn.QueryRow(`SELECT slug FROM forum
-				WHERE slug=$1`, slug)
+	row := fr.conn(ctx).QueryRowEx(ctx, `SELECT slug FROM forum
+				WHERE slug=$1`, nil, slug)
 	err := row.Scan(&forumSlug)
 	if err != nil {
-		return nil, &models.Error{Code: http.StatusNotFound, Message: "Can't find forum" }
+		return nil, notFoundOr(err, models.EntityForum, slug)
+	}
+
+	switch params.Sort {
+	case "", "created":
+	case models.ThreadSortHot, models.ThreadSortTop, models.ThreadSortActive:
+		return fr.rankForumThreads(ctx, slug, params)
+	default:
+		return nil, models.Invalid("sort", "must be created, hot, top or active")
 	}
 
+	// Pinned threads lead the first page and don't count towards the limit.
+	// since and limit page the others, so every thread is reached whatever
+	// is pinned.
+	order := ` ORDER BY created ASC`
+	if params.Desc {
+		order = ` ORDER BY created DESC`
+	}
+	condition := ` WHERE forum=$1 AND deleted_at IS NULL`
 	var threads []*models.Thread
-	query := `SELECT id, author, created, forum, message, slug, title, votes FROM thread
-		WHERE forum=$1`
+	if params.Since == "" {
+		threads, err = fr.queryThreads(ctx, threadColumns+condition+` AND pinned`+order, slug)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	query := threadColumns + condition + ` AND NOT pinned`
 	var param []interface{}
 	if params.Since != "" {
-		if params.Desc {
-			query += ` AND created <= $2 ORDER BY created DESC LIMIT $3;`
-		} else {
-			query += ` AND created >= $2 ORDER BY created ASC LIMIT $3;`
+		since, err := time.Parse(time.RFC3339Nano, params.Since)
+		if err != nil {
+			return nil, models.Invalid("since", "must be an RFC 3339 timestamp")
 		}
-		param = append(param, slug, params.Since, params.Limit)
-	} else {
 		if params.Desc {
-			query += ` ORDER BY created DESC LIMIT $2;`
+			query += ` AND created <= $2`
 		} else {
-			query += ` ORDER BY created ASC LIMIT $2;`
+			query += ` AND created >= $2`
 		}
+		query += order + ` LIMIT $3;`
+		param = append(param, slug, since, params.Limit)
+	} else {
+		query += order + ` LIMIT $2;`
 		param = append(param, slug, params.Limit)
 	}
 
-	rows, err := fr.dbConn.Query(query, param...)
+	rest, err := fr.queryThreads(ctx, query, param...)
+	if err != nil {
+		return nil, err
+	}
+	return append(threads, rest...), nil
+}
+
+const threadFields = `id, author, created, forum, message, slug, title, votes, locked, pinned, archived,
+	posts, last_post_at`
+
+const threadColumns = `SELECT ` + threadFields + ` FROM thread`
+
+// threadRanks are the expressions the ranking sorts of forum threads order by,
+// highest first. migration 0017_thread_ranking indexes each of them after
+// pinned.
+var threadRanks = map[string]string{
+	models.ThreadSortHot:    `hot`,
+	models.ThreadSortTop:    `votes`,
+	models.ThreadSortActive: `COALESCE(last_post_at, created)`,
+}
+
+// rankForumThreads lists the threads of a forum by a ranking sort, pinned
+// threads first within the page. Ties go to the newer thread. since is the
+// cursor of the last thread seen and holds its place as it was listed, so
+// votes and posts that move it meanwhile don't shift the next page.
+func (fr ForumRepository) rankForumThreads(ctx context.Context, slug string, params *models.Params) ([]*models.Thread, error) {
+	rank := threadRanks[params.Sort]
+	args := []interface{}{slug}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	query := `SELECT ` + threadFields + `, hot FROM thread WHERE forum=$1 AND deleted_at IS NULL`
+	if params.Sort == models.ThreadSortTop {
+		start, err := models.WindowStart(params.Window, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		if !start.IsZero() {
+			query += ` AND created >= ` + arg(start)
+		}
+	}
+	if params.Since != "" {
+		since, err := models.ParseThreadCursor(params.Sort, params.Since)
+		if err != nil {
+			return nil, err
+		}
+		query += fmt.Sprintf(` AND (pinned, %s, id) < (%s, %s, %s)`,
+			rank, arg(since.Pinned), arg(since.Rank), arg(since.ID))
+	}
+	query += fmt.Sprintf(` ORDER BY pinned DESC, %s DESC, id DESC LIMIT %s`, rank, arg(params.Limit))
+
+	rows, err := fr.conn(ctx).QueryEx(ctx, query, nil, args...)
 	if err != nil {
-		return nil, &models.Error{Code: http.StatusInternalServerError, Message: err.Error()}
+		return nil, models.Internal(err)
 	}
 	defer rows.Close()
 
+	threads := []*models.Thread{}
+	for rows.Next() {
+		thread := &models.Thread{}
+		var hot float64
+		if err := scanThread(rows, thread, &hot); err != nil {
+			return nil, models.Internal(err)
+		}
+		cursor := &models.ThreadCursor{Pinned: thread.Pinned, ID: thread.ID}
+		switch params.Sort {
+		case models.ThreadSortHot:
+			cursor.Rank = hot
+		case models.ThreadSortTop:
+			cursor.Rank = thread.Votes
+		case models.ThreadSortActive:
+			cursor.Rank = thread.Created
+			if thread.LastPostAt != nil {
+				cursor.Rank = *thread.LastPostAt
+			}
+		}
+		thread.Cursor = cursor.String()
+		threads = append(threads, thread)
+	}
+	if rows.Err() != nil {
+		return nil, models.Internal(rows.Err())
+	}
+	return threads, nil
+}
+
+// scanThread scans a row selecting threadFields, then the columns of extra.
+func scanThread(rows *pgx.Rows, thread *models.Thread, extra ...interface{}) error {
+	var lastPost pgtype.Timestamptz
+	dest := append([]interface{}{&thread.ID, &thread.Author, &thread.Created, &thread.Forum, &thread.Message,
+		&thread.Slug, &thread.Title, &thread.Votes, &thread.Locked, &thread.Pinned, &thread.Archived,
+		&thread.Posts, &lastPost}, extra...)
+	if err := rows.Scan(dest...); err != nil {
+		return err
+	}
+	if lastPost.Status == pgtype.Present {
+		thread.LastPostAt = &lastPost.Time
+	}
+	return nil
+}
+
+func (fr ForumRepository) queryThreads(ctx context.Context, query string, args ...interface{}) ([]*models.Thread, error) {
+	rows, err := fr.conn(ctx).QueryEx(ctx, query, nil, args...)
+	if err != nil {
+		return nil, models.Internal(err)
+	}
+	defer rows.Close()
 
+	var threads []*models.Thread
 	for rows.Next() {
-		 thread := &models.Thread{}
-		err = rows.Scan(&thread.ID, &thread.Author, &thread.Created, &thread.Forum, &thread.Message,
-			&thread.Slug, &thread.Title, &thread.Votes)
-		if err != nil {
-			return nil, &models.Error{Code: http.StatusInternalServerError, Message: err.Error()}
+		thread := &models.Thread{}
+		if err = scanThread(rows, thread); err != nil {
+			return nil, models.Internal(err)
 		}
 		threads = append(threads, thread)
 	}
+	if rows.Err() != nil {
+		return nil, models.Internal(rows.Err())
+	}
 	return threads, nil
 }
 
-func (fr ForumRepository)UpdatePostInfo(info *models.PostUpdate) (*models.Post, *models.Error){
-	var postID int
-	row := fr.dbConn.QueryRow(`SELECT id FROM post WHERE id=$1;`, info.ID)
-	err := row.Scan(&postID)
+// UpdatePostInfo changes the message of a post. The replaced text is kept in
+// post_revisions; an empty or unchanged message is not an edit.
+func (fr ForumRepository) UpdatePostInfo(ctx context.Context, info *models.PostUpdate) (*models.Post, error) {
+	tx, err := pgtx.Begin(ctx, fr.dbConn)
+	if err != nil {
+		return nil, models.Internal(err)
+	}
+	defer tx.Rollback()
+
+	post, err := updatePostMessage(ctx, tx, info.ID, info.Message, info.Editor)
+	if err != nil {
+		return nil, err
+	}
+	if err = tx.CommitEx(ctx); err != nil {
+		return nil, models.Internal(err)
+	}
+	return post, nil
+}
+
+func updatePostMessage(ctx context.Context, tx *pgtx.Tx, id int, message, editor string) (*models.Post, error) {
+	var current string
+	err := tx.QueryRowEx(ctx, `SELECT message FROM post WHERE id=$1 AND deleted_at IS NULL FOR UPDATE`, nil, id).Scan(&current)
+	if err != nil {
+		return nil, notFoundOr(err, models.EntityPost, strconv.Itoa(id))
+	}
+
+	if message != "" && message != current {
+		_, err = tx.ExecEx(ctx, `INSERT INTO post_revisions(post_id, revision, message, editor)
+			SELECT $1, COALESCE(MAX(revision), 0) + 1, $2, NULLIF($3, '') FROM post_revisions WHERE post_id=$1`, nil,
+			id, current, editor)
+		if err != nil {
+			if pgErrorCode(err) == codeForeignKeyViolation {
+				return nil, models.NotFound(models.EntityUser, editor)
+			}
+			return nil, models.Internal(err)
+		}
+		_, err = tx.ExecEx(ctx, `UPDATE post SET message=$2, isEdited=true WHERE id=$1`, nil, id, message)
+		if err != nil {
+			return nil, models.Internal(err)
+		}
+		if err = syncMentions(ctx, tx, id, message); err != nil {
+			return nil, err
+		}
+	}
+
+	post := &models.Post{}
+	err = tx.QueryRowEx(ctx, `SELECT id, author, created, forum, isEdited, message, parent, thread, score FROM post WHERE id=$1`,
+		nil, id).Scan(&post.ID, &post.Author, &post.Created, &post.Forum, &post.IsEdited, &post.Message, &post.Parent,
+		&post.Thread, &post.Score)
+	if err != nil {
+		return nil, models.Internal(err)
+	}
+	return post, nil
+}
+
+func (fr ForumRepository) GetPostHistory(ctx context.Context, id int, params *models.Params) ([]*models.PostRevision, error) {
+	var exists bool
+	err := fr.conn(ctx).QueryRowEx(ctx, `SELECT true FROM post WHERE id=$1 AND deleted_at IS NULL`, nil, id).Scan(&exists)
+	if err != nil {
+		return nil, notFoundOr(err, models.EntityPost, strconv.Itoa(id))
+	}
+
+	query := `SELECT post_id, revision, message, COALESCE(editor, ''), created FROM post_revisions WHERE post_id=$1`
+	args := []interface{}{id, params.Limit}
+	if params.Since != "" {
+		since, err := strconv.Atoi(params.Since)
+		if err != nil {
+			return nil, models.Invalid("since", "must be a revision number")
+		}
+		if params.Desc {
+			query += ` AND revision < $3`
+		} else {
+			query += ` AND revision > $3`
+		}
+		args = append(args, since)
+	}
+	if params.Desc {
+		query += ` ORDER BY revision DESC LIMIT NULLIF($2, 0)`
+	} else {
+		query += ` ORDER BY revision LIMIT NULLIF($2, 0)`
+	}
+
+	rows, err := fr.conn(ctx).QueryEx(ctx, query, nil, args...)
+	if err != nil {
+		return nil, models.Internal(err)
+	}
+	defer rows.Close()
+
+	var revisions []*models.PostRevision
+	for rows.Next() {
+		revision := &models.PostRevision{}
+		err = rows.Scan(&revision.PostID, &revision.Revision, &revision.Message, &revision.Editor, &revision.Edited)
+		if err != nil {
+			return nil, models.Internal(err)
+		}
+		revisions = append(revisions, revision)
+	}
+	if rows.Err() != nil {
+		return nil, models.Internal(rows.Err())
+	}
+	return revisions, nil
+}
+
+// GetPostRevision returns one revision of a post; revision 0 is the current
+// text.
+func (fr ForumRepository) GetPostRevision(ctx context.Context, id int, revision int) (*models.PostRevision, error) {
+	result := &models.PostRevision{PostID: id}
+	if revision == 0 {
+		err := fr.conn(ctx).QueryRowEx(ctx, `SELECT message FROM post WHERE id=$1 AND deleted_at IS NULL`, nil,
+			id).Scan(&result.Message)
+		if err != nil {
+			return nil, notFoundOr(err, models.EntityPost, strconv.Itoa(id))
+		}
+		return result, nil
+	}
+
+	err := fr.conn(ctx).QueryRowEx(ctx, `SELECT r.revision, r.message, COALESCE(r.editor, ''), r.created
+		FROM post_revisions AS r JOIN post AS p ON p.id=r.post_id
+		WHERE r.post_id=$1 AND r.revision=$2 AND p.deleted_at IS NULL`, nil,
+		id, revision).Scan(&result.Revision, &result.Message, &result.Editor, &result.Edited)
 	if err != nil {
-		return nil, &models.Error{Code: http.StatusNotFound, Message: "Can't find forum"}
+		return nil, notFoundOr(err, models.EntityRevision, fmt.Sprintf("%d of post %d", revision, id))
 	}
+	return result, nil
+}
 
-	post := &models.Post{ID: info.ID}
+// RevertPost restores the text of a revision. The revert is an edit of its
+// own and is recorded like any other.
+func (fr ForumRepository) RevertPost(ctx context.Context, revert *models.PostRevert) (*models.Post, error) {
+	tx, err := pgtx.Begin(ctx, fr.dbConn)
+	if err != nil {
+		return nil, models.Internal(err)
+	}
+	defer tx.Rollback()
 
-	row = fr.dbConn.QueryRow(`UPDATE post SET message=COALESCE(NULLIF($1, ''), message),
-                             isEdited = CASE WHEN $1 = '' OR message = $1 THEN isEdited ELSE true END
-                             WHERE id=$2 RETURNING *`, info.Message, post.ID)
-	err = row.Scan(&post.ID, &post.Author, &post.Created, &post.Forum,  &post.IsEdited,
-		&post.Message, &post.Parent, &post.Thread, &post.Route)
+	var message string
+	err = tx.QueryRowEx(ctx, `SELECT message FROM post_revisions WHERE post_id=$1 AND revision=$2`, nil,
+		revert.ID, revert.Revision).Scan(&message)
 	if err != nil {
-		return post, &models.Error{Code: http.StatusInternalServerError, Message: err.Error()}
+		return nil, notFoundOr(err, models.EntityRevision, fmt.Sprintf("%d of post %d", revert.Revision, revert.ID))
 	}
 
+	post, err := updatePostMessage(ctx, tx, revert.ID, message, revert.Editor)
+	if err != nil {
+		return nil, err
+	}
+	if err = tx.CommitEx(ctx); err != nil {
+		return nil, models.Internal(err)
+	}
 	return post, nil
 }
 
-func (fr ForumRepository)PostInfo(id int, related models.Related) (*models.PostInfo, *models.Error){
+// DeletePost soft-deletes a post. It keeps its route, so replies stay in
+// place under a tombstone.
+func (fr ForumRepository) DeletePost(ctx context.Context, id int) error {
+	tx, err := pgtx.Begin(ctx, fr.dbConn)
+	if err != nil {
+		return models.Internal(err)
+	}
+	defer tx.Rollback()
+
+	var forum, author string
+	var thread int
+	err = tx.QueryRowEx(ctx, `UPDATE post SET deleted_at=now() WHERE id=$1 AND deleted_at IS NULL
+		RETURNING forum, author, thread`, nil, id).Scan(&forum, &author, &thread)
+	if err != nil {
+		return notFoundOr(err, models.EntityPost, strconv.Itoa(id))
+	}
+	if err = refreshThreadStats(ctx, tx, thread); err != nil {
+		return err
+	}
+	_, err = tx.ExecEx(ctx, `UPDATE forum SET posts=posts-1 WHERE slug=$1`, nil, forum)
+	if err != nil {
+		return models.Internal(err)
+	}
+	err = pruneForumUsers(ctx, tx, forum, []string{author})
+	if err != nil {
+		return err
+	}
+
+	if err = tx.CommitEx(ctx); err != nil {
+		return models.Internal(err)
+	}
+	return nil
+}
+
+func (fr ForumRepository) PostInfo(ctx context.Context, id int, related models.Related) (*models.PostInfo, error) {
 	postAll := &models.PostInfo{}
 
 	post := &models.Post{}
-	query := `SELECT p.id, p.author, p.created, p.forum, p.isEdited, p.message, p.parent, p.thread`
+	query := `SELECT p.id, p.author, p.created, p.forum, p.isEdited, p.message, p.parent, p.thread, p.score,
+		p.deleted_at IS NOT NULL`
 	if related.IsForum {
 		query += `, f.slug, f.author, f.title, f.posts, f.threads`
 	}
-	if related.IsUser{
-		query +=`, u.nickname, u.fullname, u.about, u.email`
+	if related.IsUser {
+		query += `, u.nickname, u.fullname, u.about, u.email, u.reputation`
 	}
-	if related.IsThread{
-		query +=`, t.id, t.title, t.author, t.forum, t.message, t.votes, t.slug, t.created`
+	if related.IsThread {
+		query += `, t.id, t.title, t.author, t.forum, t.message, t.votes, t.slug, t.created, t.locked, t.pinned,
+			t.archived`
 	}
 
 	query += ` FROM post AS p`
 	if related.IsForum {
-		query +=` JOIN forum AS f ON f.slug=p.forum`
+		query += ` JOIN forum AS f ON f.slug=p.forum`
 	}
-	if related.IsUser{
-		query +=` JOIN users AS u ON u.nickname=p.author`
+	if related.IsUser {
+		query += ` JOIN users AS u ON u.nickname=p.author`
 	}
-	if related.IsThread{
-		query +=` JOIN thread AS t ON t.id=p.thread`
+	if related.IsThread {
+		query += ` JOIN thread AS t ON t.id=p.thread`
 	}
-	query += ` WHERE p.id=$1`
-	row := fr.dbConn.QueryRow(query, id)
+	query += ` WHERE p.id=$1 AND NOT EXISTS (SELECT 1 FROM thread WHERE id=p.thread AND deleted_at IS NOT NULL)`
+	row := fr.conn(ctx).QueryRowEx(ctx, query, nil, id)
 
 	var params []interface{}
-	params = append(params, &post.ID, &post.Author, &post.Created, &post.Forum,  &post.IsEdited,
-		&post.Message, &post.Parent, &post.Thread)
+	var deleted bool
+	params = append(params, &post.ID, &post.Author, &post.Created, &post.Forum, &post.IsEdited,
+		&post.Message, &post.Parent, &post.Thread, &post.Score, &deleted)
 	thread := &models.Thread{}
 	if related.IsForum {
 		forum := &models.Forum{}
 		params = append(params, &forum.Slug, &forum.User, &forum.Title, &forum.Posts, &forum.Threads)
 		postAll.Forum = forum
 	}
-	if related.IsUser{
+	if related.IsUser {
 		user := &models.User{}
-		params = append(params, &user.Nickname, &user.FullName, &user.About, &user.Email)
+		params = append(params, &user.Nickname, &user.FullName, &user.About, &user.Email, &user.Reputation)
 		postAll.Author = user
 	}
-	if related.IsThread{
+	if related.IsThread {
 		params = append(params, &thread.ID, &thread.Title, &thread.Author, &thread.Forum, &thread.Message, &thread.Votes,
-			&thread.Slug, &thread.Created)
+			&thread.Slug, &thread.Created, &thread.Locked, &thread.Pinned, &thread.Archived)
 	}
 
 	postAll.Post = post
 	err := row.Scan(params...)
-	if(thread.Title != "") {
-		if (thread.Slug == thread.Title+thread.Author) {
+	if err != nil {
+		return nil, notFoundOr(err, models.EntityPost, strconv.Itoa(id))
+	}
+	if deleted {
+		post.Tombstone()
+	}
+	if err = loadReactions(ctx, fr.conn(ctx), []*models.Post{post}); err != nil {
+		return nil, err
+	}
+	if thread.Title != "" {
+		if thread.Slug == thread.Title+thread.Author {
 			postAll.Thread = models.DeleteSlug(thread)
 		} else {
 			postAll.Thread = thread
 		}
 	}
-	if err != nil {
-		return nil, &models.Error{Code: http.StatusNotFound, Message: "Can't find forum"}
-	}
 
 	return postAll, nil
 }
 
-func (fr ForumRepository)StatusDB() *models.Status{
+func (fr ForumRepository) StatusDB(ctx context.Context) (*models.Status, error) {
 	status := &models.Status{}
-	err := fr.dbConn.QueryRow(`SELECT COUNT(*) FROM users;`).Scan(&status.User)
-	if err != nil {
-		status.User = 0
-	}
-	err = fr.dbConn.QueryRow(`SELECT COUNT(*) FROM forum;`).Scan(&status.Forum)
-	if err != nil {
-		status.Forum = 0
-	}
-	err = fr.dbConn.QueryRow(`SELECT COUNT(*) FROM thread;`).Scan(&status.Thread)
-	if err != nil {
-		status.Thread = 0
-	}
-	err = fr.dbConn.QueryRow(`SELECT COUNT(*) FROM post;`).Scan(&status.Post)
+	err := fr.conn(ctx).QueryRowEx(ctx, `SELECT
+			(SELECT COUNT(*) FROM users),
+			(SELECT COUNT(*) FROM forum),
+			(SELECT COUNT(*) FROM thread WHERE deleted_at IS NULL),
+			(SELECT COUNT(*) FROM post WHERE deleted_at IS NULL);`, nil).Scan(&status.User, &status.Forum, &status.Thread, &status.Post)
 	if err != nil {
-		status.Post = 0
+		return nil, models.Internal(err)
 	}
-	return status
+	return status, nil
 }
 
-func (fr *ForumRepository) ClearDB() *models.Error {
-	_, err := fr.dbConn.Exec(`TRUNCATE users, forum, thread, post, votes CASCADE;`)
+func (fr *ForumRepository) ClearDB(ctx context.Context) error {
+	_, err := fr.conn(ctx).ExecEx(ctx, `TRUNCATE users, forum, thread, post, votes, webhooks CASCADE;`, nil)
 	if err != nil {
-		return nil
+		return models.Internal(err)
 	}
 	return nil
 }
-func (fr *ForumRepository)CreateUser(user *models.User)([]*models.User, *models.Error){
-	var users []*models.User
 
-	_, err := fr.dbConn.Exec(	`INSERT INTO users(nickname, fullname, about, email) VALUES ($1, $2, $3, $4);`,
-		user.Nickname, user.FullName, user.About, user.Email)
+func (fr *ForumRepository) CreateUser(ctx context.Context, user *models.User) error {
+	err := pgtx.Savepoint(ctx, fr.dbConn, func(q pgtx.Queryer) error {
+		_, err := q.ExecEx(ctx, `INSERT INTO users(nickname, fullname, about, email, password_hash)
+			VALUES ($1, $2, $3, $4, NULLIF($5, ''));`, nil,
+			user.Nickname, user.FullName, user.About, user.Email, user.PasswordHash)
+		return err
+	})
 	if err != nil {
-		if err.(pgx.PgError).Code == "23505"{
-			rows, err := fr.dbConn.Query(`SELECT nickname, fullName, about, email FROM users WHERE nickname=$1 or email=$2;`,
-				user.Nickname, user.Email)
-			defer rows.Close()
+		if pgErrorCode(err) == codeUniqueViolation {
+			rows, err := fr.conn(ctx).QueryEx(ctx, `SELECT nickname, fullName, about, email, reputation FROM users
+				WHERE nickname=$1 or email=$2;`, nil, user.Nickname, user.Email)
 			if err != nil {
-				return nil, &models.Error{Code: http.StatusInternalServerError, Message: err.Error()}
+				return models.Internal(err)
 			}
+			defer rows.Close()
+			var users []*models.User
 			for rows.Next() {
 				user := &models.User{}
-				err := rows.Scan(&user.Nickname, &user.FullName, &user.About, &user.Email)
+				err := rows.Scan(&user.Nickname, &user.FullName, &user.About, &user.Email, &user.Reputation)
 				if err != nil {
-					return users, &models.Error{Code: http.StatusInternalServerError, Message: err.Error()}
+					return models.Internal(err)
 				}
 				users = append(users, user)
 			}
-			return users, &models.Error{Code: http.StatusConflict}
-		} else {
-			return nil, &models.Error{Code: http.StatusInternalServerError, Message: err.Error()}
+			if rows.Err() != nil {
+				return models.Internal(rows.Err())
+			}
+			return models.Conflict(models.EntityUser, users)
 		}
+		return models.Internal(err)
 	}
 
-	users = append(users, user)
-	return users, nil
+	return nil
 }
 
-func (fr ForumRepository)GetUserProfile(nickname string) (*models.User, *models.Error){
+func (fr ForumRepository) GetUserProfile(ctx context.Context, nickname string) (*models.User, error) {
 	user := &models.User{}
-	row := fr.dbConn.QueryRow(`SELECT nickname, fullname, about, email FROM users WHERE nickname=$1;`, nickname)
-	err := row.Scan(&user.Nickname, &user.FullName, &user.About, &user.Email)
+	row := fr.conn(ctx).QueryRowEx(ctx, `SELECT nickname, fullname, about, email, reputation FROM users WHERE nickname=$1;`, nil,
+		nickname)
+	err := row.Scan(&user.Nickname, &user.FullName, &user.About, &user.Email, &user.Reputation)
 	if err != nil {
-		return nil,  &models.Error{Code: http.StatusNotFound, Message: "Can't find forum"}
+		return nil, notFoundOr(err, models.EntityUser, nickname)
 	}
 	return user, nil
 }
 
-func (fr ForumRepository)UpdateUserProfile(user *models.User) *models.Error{
-	err := fr.dbConn.QueryRow(
-		`UPDATE users SET 
-				email=COALESCE(NULLIF($1, ''), email), 
+func (fr ForumRepository) UpdateUserProfile(ctx context.Context, user *models.User) error {
+	nickname := user.Nickname
+	err := fr.conn(ctx).QueryRowEx(ctx,
+		`UPDATE users SET
+				email=COALESCE(NULLIF($1, ''), email),
 				about=COALESCE(NULLIF($2, ''), about),
-				fullname=COALESCE(NULLIF($3, ''), fullname) 
-				WHERE nickname=$4 RETURNING *`,
-		user.Email, user.About, user.FullName, user.Nickname).Scan(&user.Nickname, &user.FullName, &user.About, &user.Email)
+				fullname=COALESCE(NULLIF($3, ''), fullname)
+				WHERE nickname=$4 RETURNING nickname, fullname, about, email, reputation`, nil,
+		user.Email, user.About, user.FullName, user.Nickname).Scan(&user.Nickname, &user.FullName, &user.About, &user.Email,
+		&user.Reputation)
 	if err != nil {
-		if pgErr, ok := err.(pgx.PgError); ok && pgErr.Code == "23505" {
-			return &models.Error{Code: http.StatusConflict, Message: "Can't find forum"}
+		if pgErrorCode(err) == codeUniqueViolation {
+			return &models.ConflictError{Entity: models.EntityUser,
+				Reason: fmt.Sprintf("Email %s is already used by another user", user.Email)}
 		}
-		return &models.Error{Code: http.StatusNotFound, Message: "Can't find forum"}
+		return notFoundOr(err, models.EntityUser, nickname)
 	}
 
 	return nil
 }
 
-func (fr ForumRepository)CreatePosts(posts []*models.Post, slugOrID string) ([]*models.Post, *models.Error) {
-	var threadID int
-	var threadForum string
-	var param interface{}
-	query := `SELECT id, forum FROM thread WHERE `
-	id, err := strconv.Atoi(slugOrID)
+// CreatePosts inserts the batch in one transaction. Authors and parents are
+// checked before the insert so that a failure names the offending post, and
+// either every post is created or none is.
+func (fr ForumRepository) CreatePosts(ctx context.Context, posts []*models.Post, slugOrID string) ([]*models.Post, error) {
+	tx, err := pgtx.Begin(ctx, fr.dbConn)
 	if err != nil {
-		query += `slug=$1`
-		param = slugOrID
-	} else {
-		query += `id=$1`
-		param = id
+		return nil, models.Internal(err)
+	}
+	defer tx.Rollback()
+
+	thread := &models.Thread{}
+	condition, param := slugOrIDCondition(slugOrID)
+	row := tx.QueryRowEx(ctx, `SELECT id, forum, locked, archived FROM thread WHERE `+condition+` AND deleted_at IS NULL`,
+		nil, param)
+	err = row.Scan(&thread.ID, &thread.Forum, &thread.Locked, &thread.Archived)
+	if err != nil {
+		return nil, notFoundOr(err, models.EntityThread, slugOrID)
 	}
-	row := fr.dbConn.QueryRow(query, param)
-	err = row.Scan(&threadID,  &threadForum)
+	if err = thread.CheckOpen(); err != nil {
+		return nil, err
+	}
+	threadID, threadForum := thread.ID, thread.Forum
+	if len(posts) == 0 {
+		return posts, nil
+	}
+
+	ids, err := reservePostIDs(ctx, tx, len(posts))
+	if err != nil {
+		return nil, models.Internal(err)
+	}
+	err = validatePosts(ctx, tx, posts, ids, threadID, threadForum)
 	if err != nil {
-		return nil, &models.Error{Code: http.StatusNotFound, Message: "Can't find forum"}
+		return nil, err
 	}
 
-	insertQuery := `INSERT INTO post(author, created, forum, message, parent, thread) VALUES `
+	insertQuery := `INSERT INTO post(id, author, created, forum, message, parent, thread) VALUES `
 	createTime := time.Now()
 	var params []interface{}
-	//var parent
 
 	for i, post := range posts {
-		if post.Parent == 0 {
-
-		}
-		insertQuery += fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, $%d),", i * 6 + 1, i * 6 + 2, i * 6 + 3, i * 6 + 4, i * 6 + 5, i * 6 + 6)
-		params = append(params, post.Author, createTime, threadForum, post.Message, post.Parent, threadID)
+		insertQuery += fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, $%d, $%d),", i*7+1, i*7+2, i*7+3, i*7+4, i*7+5, i*7+6, i*7+7)
+		params = append(params, ids[i], post.Author, createTime, threadForum, post.Message, post.Parent, threadID)
 	}
 
 	insertQuery = strings.TrimSuffix(insertQuery, ",")
 	insertQuery += ` RETURNING id, forum, isEdited, thread, created;`
 
-	rows, err := fr.dbConn.Query(insertQuery, params...)
+	rows, err := tx.QueryEx(ctx, insertQuery, nil, params...)
 	if err != nil {
-		if err.(pgx.PgError).Code == "23503" {
-			return nil, &models.Error{Code: http.StatusNotFound, Message: "Can't find forum"}
-		}
-		return nil, &models.Error{Code: http.StatusConflict, Message: "Parent"}
+		return nil, createPostsError(err)
 	}
 
-	defer rows.Close()
-
-	for i, _ := range posts {
+	for i := range posts {
 		if rows.Next() {
-			err := rows.Scan(&(posts[i]).ID,&(posts[i]).Forum, &(posts[i]).IsEdited, &(posts[i]).Thread, &(posts[i]).Created)
+			err := rows.Scan(&(posts[i]).ID, &(posts[i]).Forum, &(posts[i]).IsEdited, &(posts[i]).Thread, &(posts[i]).Created)
 			if err != nil {
-				return nil, &models.Error{Code: http.StatusConflict, Message: "Can't find forum"}
+				rows.Close()
+				return nil, models.Internal(err)
 			}
 		}
 	}
-
+	rows.Close()
 	if rows.Err() != nil {
-		if rows.Err().(pgx.PgError).Code == "23503" {
-			return nil, &models.Error{Code: http.StatusNotFound, Message: "Can't find forum"}
+		return nil, createPostsError(rows.Err())
+	}
+
+	var mentionPosts []int64
+	var mentioned []string
+	for _, post := range posts {
+		for _, nickname := range models.ParseMentions(post.Message) {
+			mentionPosts = append(mentionPosts, int64(post.ID))
+			mentioned = append(mentioned, nickname)
 		}
-		return nil, &models.Error{Code: http.StatusConflict, Message: "Parent"}
+	}
+	if err = addMentions(ctx, tx, mentionPosts, mentioned); err != nil {
+		return nil, err
+	}
+	_, err = tx.ExecEx(ctx, `UPDATE thread SET posts = posts + $2,
+		last_post_at = GREATEST(last_post_at, $3) WHERE id = $1`, nil, threadID, len(posts), createTime)
+	if err != nil {
+		return nil, models.Internal(err)
 	}
 
+	err = tx.CommitEx(ctx)
+	if err != nil {
+		return nil, models.Internal(err)
+	}
 	return posts, nil
 }
 
-func (fr ForumRepository)GetThreadInfo(slugOrID string) (*models.Thread, *models.Error){
-	thread := &models.Thread{}
-	var param interface{}
-	query := `SELECT id, title, author, forum, message, votes, slug, created FROM thread WHERE `
-	id, err := strconv.Atoi(slugOrID)
-	if err != nil {
-		query += `slug=$1`
-		param = slugOrID
-	} else {
-		query += `id=$1`
-		param = id
+// addMentions records that post posts[i] mentions nicknames[i] and notifies
+// each user the first time a post mentions them, unless they wrote it.
+// Nicknames that name nobody are skipped.
+func addMentions(ctx context.Context, tx *pgtx.Tx, posts []int64, nicknames []string) error {
+	if len(posts) == 0 {
+		return nil
 	}
-	row := fr.dbConn.QueryRow(query, param)
-	err = row.Scan(
-		&thread.ID,
-		&thread.Title,
-		&thread.Author,
-		&thread.Forum,
-		&thread.Message,
-		&thread.Votes,
-		&thread.Slug,
-		&thread.Created)
-	if err != nil {
-		return nil, &models.Error{Code: http.StatusNotFound, Message: "Can't find forum"}
+	ids, names := pgtype.Int8Array{}, pgtype.TextArray{}
+	if err := ids.Set(posts); err != nil {
+		return models.Internal(err)
+	}
+	if err := names.Set(nicknames); err != nil {
+		return models.Internal(err)
 	}
 
-	return thread,nil
+	_, err := tx.ExecEx(ctx, `WITH added AS (
+			INSERT INTO post_mentions(post, nickname)
+			SELECT m.post, u.nickname
+			FROM unnest($1::bigint[], $2::text[]) AS m(post, nickname), users AS u
+			WHERE u.nickname = m.nickname::citext
+			ON CONFLICT DO NOTHING
+			RETURNING post, nickname)
+		INSERT INTO notifications(nickname, kind, thread, post, actor)
+		SELECT a.nickname, 'mention', p.thread, a.post, p.author
+		FROM added AS a
+		JOIN post AS p ON p.id = a.post
+		WHERE a.nickname <> p.author AND NOT EXISTS (
+			SELECT 1 FROM notifications AS n
+			WHERE n.kind = 'mention' AND n.post = a.post AND n.nickname = a.nickname)`, nil, &ids, &names)
+	if err != nil {
+		return models.Internal(err)
+	}
+	return nil
 }
 
-func (fr ForumRepository)UpdateThreadInfo(thread *models.Thread) *models.Error{
-	query := `UPDATE thread SET 
-				title=COALESCE(NULLIF($1, ''), title), 
-				message=COALESCE(NULLIF($2, ''), message) 
-				WHERE `
-	params := []interface{}{thread.Title, thread.Message}
-
-	if thread.Slug != "" {
-		query += `slug=$3 `
-		params = append(params, thread.Slug)
-	} else {
-		query += `id=$3 `
-		params = append(params, thread.ID)
+// syncMentions makes the mentions of post id those of its new message.
+func syncMentions(ctx context.Context, tx *pgtx.Tx, id int, message string) error {
+	nicknames := models.ParseMentions(message)
+	names := pgtype.TextArray{}
+	if err := names.Set(nicknames); err != nil {
+		return models.Internal(err)
 	}
-
-	query += `RETURNING *`
-	err := fr.dbConn.QueryRow(query, params...).Scan(&thread.ID, &thread.Title, &thread.Author, &thread.Created, &thread.Forum, &thread.Message, &thread.Slug, &thread.Votes)
+	_, err := tx.ExecEx(ctx, `DELETE FROM post_mentions WHERE post=$1 AND nickname <> ALL($2::text[]::citext[])`,
+		nil, id, &names)
 	if err != nil {
-		return &models.Error{Code: http.StatusNotFound, Message: "Can't find forum"}
+		return models.Internal(err)
 	}
 
-	return nil
+	posts := make([]int64, len(nicknames))
+	for i := range posts {
+		posts[i] = int64(id)
+	}
+	return addMentions(ctx, tx, posts, nicknames)
 }
 
-func (fr *ForumRepository)	InsertOrUpdateVote(slugOrID string, vote *models.Vote) (*models.Thread, *models.Error){
+// reservePostIDs takes n ids from the post sequence so that posts of a batch
+// can name earlier posts of the same batch as their parent.
+func reservePostIDs(ctx context.Context, tx *pgtx.Tx, n int) ([]int64, error) {
+	rows, err := tx.QueryEx(ctx, `SELECT nextval(pg_get_serial_sequence('post', 'id')) FROM generate_series(1, $1)`, nil, n)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+// validatePosts checks that every author exists and is not banned from forum
+// and that every parent is a post of the thread, either stored or earlier in
+// the batch. The error names the index of the first invalid post.
+func validatePosts(ctx context.Context, tx *pgtx.Tx, posts []*models.Post, ids []int64, threadID int, forum string) error {
+	batch := make(map[int64]int, len(ids))
+	for i, id := range ids {
+		batch[id] = i
+	}
+
+	var nicknames []string
+	var parentIDs []int64
+	for _, post := range posts {
+		nicknames = append(nicknames, post.Author)
+		if _, ok := batch[post.Parent]; post.Parent != 0 && !ok {
+			parentIDs = append(parentIDs, post.Parent)
+		}
+	}
+
+	authors := &pgtype.TextArray{}
+	if err := authors.Set(nicknames); err != nil {
+		return models.Internal(err)
+	}
+	rows, err := tx.QueryEx(ctx, `SELECT nickname FROM users WHERE nickname = ANY($1::text[]::citext[])`, nil, authors)
+	if err != nil {
+		return models.Internal(err)
+	}
+	known := make(map[string]bool)
+	for rows.Next() {
+		var nickname string
+		if err := rows.Scan(&nickname); err != nil {
+			rows.Close()
+			return models.Internal(err)
+		}
+		known[strings.ToLower(nickname)] = true
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		return models.Internal(rows.Err())
+	}
+
+	bans, err := activeBans(ctx, tx, forum, nicknames)
+	if err != nil {
+		return models.Internal(err)
+	}
+
+	parentThreads := make(map[int64]int)
+	if len(parentIDs) > 0 {
+		parents := &pgtype.Int8Array{}
+		if err := parents.Set(parentIDs); err != nil {
+			return models.Internal(err)
+		}
+		rows, err = tx.QueryEx(ctx, `SELECT id, thread FROM post WHERE id = ANY($1::bigint[])`, nil, parents)
+		if err != nil {
+			return models.Internal(err)
+		}
+		for rows.Next() {
+			var id int64
+			var thread int
+			if err := rows.Scan(&id, &thread); err != nil {
+				rows.Close()
+				return models.Internal(err)
+			}
+			parentThreads[id] = thread
+		}
+		rows.Close()
+		if rows.Err() != nil {
+			return models.Internal(rows.Err())
+		}
+	}
+
+	for i, post := range posts {
+		if !known[strings.ToLower(post.Author)] {
+			return &models.ItemError{Index: i, Err: models.NotFound(models.EntityUser, post.Author)}
+		}
+		if ban, ok := bans[strings.ToLower(post.Author)]; ok {
+			return &models.ItemError{Index: i, Err: ban.Forbidden()}
+		}
+		if post.Parent == 0 {
+			continue
+		}
+		if j, ok := batch[post.Parent]; ok {
+			if j < i {
+				continue
+			}
+		} else if thread, ok := parentThreads[post.Parent]; ok && thread == threadID {
+			continue
+		}
+		return &models.ItemError{Index: i, Err: &models.ConflictError{Entity: models.EntityPost,
+			Reason: fmt.Sprintf("Parent post %d is not in this thread", post.Parent)}}
+	}
+	return nil
+}
+
+func createPostsError(err error) error {
+	switch pgErrorCode(err) {
+	case codeForeignKeyViolation:
+		return models.NotFound(models.EntityUser, "")
+	case codeRaiseException:
+		return &models.ConflictError{Entity: models.EntityPost, Reason: "Parent post was created in another thread"}
+	}
+	return models.Internal(err)
+}
+
+// GetUserMentions pages by post id through the live posts that mention a
+// user.
+func (fr ForumRepository) GetUserMentions(ctx context.Context, nickname string, params *models.Params) ([]*models.Post, error) {
+	err := fr.conn(ctx).QueryRowEx(ctx, `SELECT nickname FROM users WHERE nickname=$1`, nil, nickname).Scan(&nickname)
+	if err != nil {
+		return nil, notFoundOr(err, models.EntityUser, nickname)
+	}
+
+	args := []interface{}{nickname}
+	query := `SELECT p.id, p.author, p.created, p.forum, p.isEdited, p.message, p.parent, p.thread, p.score
+		FROM post_mentions AS m
+		JOIN post AS p ON p.id = m.post
+		JOIN thread AS t ON t.id = p.thread
+		WHERE m.nickname = $1 AND p.deleted_at IS NULL AND t.deleted_at IS NULL`
+	if params.Since != "" {
+		since, err := strconv.ParseInt(params.Since, 10, 64)
+		if err != nil {
+			return nil, models.Invalid("since", "must be a post id")
+		}
+		args = append(args, since)
+		if params.Desc {
+			query += ` AND m.post < $2`
+		} else {
+			query += ` AND m.post > $2`
+		}
+	}
+	if params.Desc {
+		query += ` ORDER BY m.post DESC`
+	} else {
+		query += ` ORDER BY m.post`
+	}
+	limit := params.Limit
+	if limit <= 0 {
+		limit = defaultMentionsLimit
+	}
+	args = append(args, limit)
+	query += fmt.Sprintf(` LIMIT $%d`, len(args))
+
+	rows, err := fr.conn(ctx).QueryEx(ctx, query, nil, args...)
+	if err != nil {
+		return nil, models.Internal(err)
+	}
+	defer rows.Close()
+
+	posts := []*models.Post{}
+	for rows.Next() {
+		post := &models.Post{}
+		err = rows.Scan(&post.ID, &post.Author, &post.Created, &post.Forum, &post.IsEdited, &post.Message,
+			&post.Parent, &post.Thread, &post.Score)
+		if err != nil {
+			return nil, models.Internal(err)
+		}
+		posts = append(posts, post)
+	}
+	if rows.Err() != nil {
+		return nil, models.Internal(rows.Err())
+	}
+	return posts, nil
+}
+
+func (fr ForumRepository) GetThreadInfo(ctx context.Context, slugOrID string) (*models.Thread, error) {
 	thread := &models.Thread{}
-	var param interface{}
-	query := `SELECT id FROM thread WHERE `
-	id, err := strconv.Atoi(slugOrID)
+	condition, param := slugOrIDCondition(slugOrID)
+	row := fr.conn(ctx).QueryRowEx(ctx, `SELECT id, title, author, forum, message, votes, slug, created,
+		locked, pinned, archived, posts, last_post_at FROM thread WHERE `+condition+` AND deleted_at IS NULL`, nil, param)
+	var lastPost pgtype.Timestamptz
+	err := row.Scan(
+		&thread.ID,
+		&thread.Title,
+		&thread.Author,
+		&thread.Forum,
+		&thread.Message,
+		&thread.Votes,
+		&thread.Slug,
+		&thread.Created,
+		&thread.Locked,
+		&thread.Pinned,
+		&thread.Archived,
+		&thread.Posts,
+		&lastPost)
 	if err != nil {
-		query += `slug=$1`
-		param = slugOrID
+		return nil, notFoundOr(err, models.EntityThread, slugOrID)
+	}
+	if lastPost.Status == pgtype.Present {
+		thread.LastPostAt = &lastPost.Time
+	}
+
+	return thread, nil
+}
+
+func (fr ForumRepository) UpdateThreadInfo(ctx context.Context, thread *models.Thread) error {
+	query := `UPDATE thread SET
+				title=COALESCE(NULLIF($1, ''), title),
+				message=COALESCE(NULLIF($2, ''), message)
+				WHERE `
+	params := []interface{}{thread.Title, thread.Message}
+
+	key := thread.Slug
+	if thread.Slug != "" {
+		query += `slug=$3 `
+		params = append(params, thread.Slug)
 	} else {
-		query += `id=$1`
-		param = id
+		query += `id=$3 `
+		params = append(params, thread.ID)
+		key = strconv.Itoa(thread.ID)
+	}
+
+	query += `AND deleted_at IS NULL RETURNING id, title, author, created, forum, message, slug, votes,
+		locked, pinned, archived`
+	err := fr.conn(ctx).QueryRowEx(ctx, query, nil, params...).Scan(&thread.ID, &thread.Title, &thread.Author, &thread.Created, &thread.Forum, &thread.Message, &thread.Slug, &thread.Votes,
+		&thread.Locked, &thread.Pinned, &thread.Archived)
+	if err != nil {
+		return notFoundOr(err, models.EntityThread, key)
+	}
+
+	return nil
+}
+
+func (fr ForumRepository) SetThreadState(ctx context.Context, slugOrID string, state *models.ThreadState) (*models.Thread, error) {
+	thread := &models.Thread{}
+	condition, param := slugOrIDCondition(slugOrID)
+	err := fr.conn(ctx).QueryRowEx(ctx, `UPDATE thread SET
+			locked=COALESCE($2::boolean, locked),
+			pinned=COALESCE($3::boolean, pinned),
+			archived=COALESCE($4::boolean, archived)
+		WHERE `+condition+` AND deleted_at IS NULL
+		RETURNING id, title, author, forum, message, votes, slug, created, locked, pinned, archived`, nil,
+		param, state.Locked, state.Pinned, state.Archived).Scan(&thread.ID, &thread.Title, &thread.Author,
+		&thread.Forum, &thread.Message, &thread.Votes, &thread.Slug, &thread.Created, &thread.Locked, &thread.Pinned,
+		&thread.Archived)
+	if err != nil {
+		return nil, notFoundOr(err, models.EntityThread, slugOrID)
+	}
+	return thread, nil
+}
+
+// DeleteThread soft-deletes a thread together with its posts.
+func (fr ForumRepository) DeleteThread(ctx context.Context, slugOrID string) error {
+	tx, err := pgtx.Begin(ctx, fr.dbConn)
+	if err != nil {
+		return models.Internal(err)
+	}
+	defer tx.Rollback()
+
+	var threadID int
+	var forum, author string
+	condition, param := slugOrIDCondition(slugOrID)
+	err = tx.QueryRowEx(ctx, `UPDATE thread SET deleted_at=now() WHERE `+condition+` AND deleted_at IS NULL
+		RETURNING id, forum, author`, nil, param).Scan(&threadID, &forum, &author)
+	if err != nil {
+		return notFoundOr(err, models.EntityThread, slugOrID)
+	}
+
+	authors := []string{author}
+	rows, err := tx.QueryEx(ctx, `UPDATE post SET deleted_at=now() WHERE thread=$1 AND deleted_at IS NULL
+		RETURNING author`, nil, threadID)
+	if err != nil {
+		return models.Internal(err)
+	}
+	var posts int
+	for rows.Next() {
+		var postAuthor string
+		if err := rows.Scan(&postAuthor); err != nil {
+			rows.Close()
+			return models.Internal(err)
+		}
+		authors = append(authors, postAuthor)
+		posts++
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		return models.Internal(rows.Err())
+	}
+
+	_, err = tx.ExecEx(ctx, `UPDATE forum SET threads=threads-1, posts=posts-$2 WHERE slug=$1`, nil, forum, posts)
+	if err != nil {
+		return models.Internal(err)
+	}
+	err = pruneForumUsers(ctx, tx, forum, authors)
+	if err != nil {
+		return err
+	}
+
+	if err = tx.CommitEx(ctx); err != nil {
+		return models.Internal(err)
+	}
+	return nil
+}
+
+// pruneForumUsers drops the given users from forum_users of a forum once they
+// have no live thread or post left in it.
+func pruneForumUsers(ctx context.Context, tx *pgtx.Tx, forum string, nicknames []string) error {
+	users := &pgtype.TextArray{}
+	if err := users.Set(nicknames); err != nil {
+		return models.Internal(err)
+	}
+	_, err := tx.ExecEx(ctx, `DELETE FROM forum_users AS fu
+		WHERE fu.slug=$1 AND fu.nickname = ANY($2::text[]::citext[])
+		AND NOT EXISTS (SELECT 1 FROM post WHERE forum=fu.slug AND author=fu.nickname AND deleted_at IS NULL)
+		AND NOT EXISTS (SELECT 1 FROM thread WHERE forum=fu.slug AND author=fu.nickname AND deleted_at IS NULL)`, nil,
+		forum, users)
+	if err != nil {
+		return models.Internal(err)
+	}
+	return nil
+}
+
+// refreshThreadStats recounts the live posts of threads and the time of the
+// newest one after posts were deleted or moved.
+func refreshThreadStats(ctx context.Context, tx *pgtx.Tx, threads ...int) error {
+	ids := make([]int64, len(threads))
+	for i, id := range threads {
+		ids[i] = int64(id)
+	}
+	array := pgtype.Int8Array{}
+	if err := array.Set(ids); err != nil {
+		return models.Internal(err)
+	}
+	_, err := tx.ExecEx(ctx, `UPDATE thread AS t SET posts = s.posts, last_post_at = s.last
+		FROM (SELECT t.id, COUNT(p.id) AS posts, MAX(p.created) AS last
+			FROM thread AS t
+			LEFT JOIN post AS p ON p.thread = t.id AND p.deleted_at IS NULL
+			WHERE t.id = ANY($1::bigint[])
+			GROUP BY t.id) AS s
+		WHERE t.id = s.id`, nil, &array)
+	if err != nil {
+		return models.Internal(err)
+	}
+	return nil
+}
+
+// lockThread locks a live thread for the rest of the transaction.
+func lockThread(ctx context.Context, tx *pgtx.Tx, slugOrID string) (*models.Thread, error) {
+	thread := &models.Thread{}
+	condition, param := slugOrIDCondition(slugOrID)
+	err := tx.QueryRowEx(ctx, `SELECT id, forum, author FROM thread WHERE `+condition+` AND deleted_at IS NULL
+		FOR UPDATE`, nil, param).Scan(&thread.ID, &thread.Forum, &thread.Author)
+	if err != nil {
+		return nil, notFoundOr(err, models.EntityThread, slugOrID)
+	}
+	return thread, nil
+}
+
+func forumSlug(ctx context.Context, tx *pgtx.Tx, slug string) (string, error) {
+	err := tx.QueryRowEx(ctx, `SELECT slug FROM forum WHERE slug=$1`, nil, slug).Scan(&slug)
+	if err != nil {
+		return "", notFoundOr(err, models.EntityForum, slug)
+	}
+	return slug, nil
+}
+
+// movePosts moves the posts selected by condition to another thread and
+// forum. It returns the authors of the live posts moved; tombstones move too,
+// so the trees below them stay whole.
+func movePosts(ctx context.Context, tx *pgtx.Tx, set, condition string, args ...interface{}) ([]string, error) {
+	rows, err := tx.QueryEx(ctx, `UPDATE post SET `+set+` WHERE `+condition+`
+		RETURNING author, deleted_at IS NULL`, nil, args...)
+	if err != nil {
+		return nil, models.Internal(err)
+	}
+	defer rows.Close()
+
+	var authors []string
+	for rows.Next() {
+		var author string
+		var live bool
+		if err := rows.Scan(&author, &live); err != nil {
+			return nil, models.Internal(err)
+		}
+		if live {
+			authors = append(authors, author)
+		}
+	}
+	if rows.Err() != nil {
+		return nil, models.Internal(rows.Err())
+	}
+	return authors, nil
+}
+
+// shiftCounters moves threads and posts from the counters of one forum to
+// another and the authors from its forum_users.
+func shiftCounters(ctx context.Context, tx *pgtx.Tx, from, to string, threads, posts int, authors []string) error {
+	if from == to {
+		return nil
+	}
+	_, err := tx.ExecEx(ctx, `UPDATE forum SET threads=threads-$2, posts=posts-$3 WHERE slug=$1`, nil, from, threads, posts)
+	if err != nil {
+		return models.Internal(err)
+	}
+	_, err = tx.ExecEx(ctx, `UPDATE forum SET threads=threads+$2, posts=posts+$3 WHERE slug=$1`, nil, to, threads, posts)
+	if err != nil {
+		return models.Internal(err)
+	}
+
+	users := &pgtype.TextArray{}
+	if err := users.Set(authors); err != nil {
+		return models.Internal(err)
+	}
+	_, err = tx.ExecEx(ctx, `INSERT INTO forum_users(nickname, fullname, about, email, slug)
+		SELECT nickname, fullname, about, email, $1 FROM users WHERE nickname = ANY($2::text[]::citext[])
+		ON CONFLICT DO NOTHING`, nil, to, users)
+	if err != nil {
+		return models.Internal(err)
+	}
+	return pruneForumUsers(ctx, tx, from, authors)
+}
+
+// MoveThread moves a thread with all its posts to another forum.
+func (fr ForumRepository) MoveThread(ctx context.Context, slugOrID string, forum string) (*models.Thread, error) {
+	tx, err := pgtx.Begin(ctx, fr.dbConn)
+	if err != nil {
+		return nil, models.Internal(err)
+	}
+	defer tx.Rollback()
+
+	thread, err := lockThread(ctx, tx, slugOrID)
+	if err != nil {
+		return nil, err
+	}
+	forum, err = forumSlug(ctx, tx, forum)
+	if err != nil {
+		return nil, err
+	}
+
+	if forum != thread.Forum {
+		_, err = tx.ExecEx(ctx, `UPDATE thread SET forum=$2 WHERE id=$1`, nil, thread.ID, forum)
+		if err != nil {
+			return nil, models.Internal(err)
+		}
+		authors, err := movePosts(ctx, tx, `forum=$2`, `thread=$1`, thread.ID, forum)
+		if err != nil {
+			return nil, err
+		}
+		err = shiftCounters(ctx, tx, thread.Forum, forum, 1, len(authors), append(authors, thread.Author))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err = tx.CommitEx(ctx); err != nil {
+		return nil, models.Internal(err)
+	}
+	return fr.GetThreadInfo(ctx, strconv.Itoa(thread.ID))
+}
+
+// MergeThreads moves every post of a thread into another one and deletes the
+// emptied thread. Routes are made of post ids, which are unique across
+// threads, so the moved trees stay valid as they are; their roots become roots
+// of the target thread. Votes stay with the deleted thread.
+func (fr ForumRepository) MergeThreads(ctx context.Context, slugOrID string, into string) (*models.Thread, error) {
+	source, err := fr.GetThreadInfo(ctx, slugOrID)
+	if err != nil {
+		return nil, err
+	}
+	target, err := fr.GetThreadInfo(ctx, into)
+	if err != nil {
+		return nil, err
+	}
+	if source.ID == target.ID {
+		return nil, models.Invalid("into", "must be another thread")
+	}
+
+	tx, err := pgtx.Begin(ctx, fr.dbConn)
+	if err != nil {
+		return nil, models.Internal(err)
 	}
-	row := fr.dbConn.QueryRow(query, param)
-	err = row.Scan(
-		&thread.ID)
+	defer tx.Rollback()
+
+	// Lock in id order so that concurrent merges cannot deadlock.
+	first, second := source.ID, target.ID
+	if first > second {
+		first, second = second, first
+	}
+	locked := make(map[int]*models.Thread)
+	for _, id := range []int{first, second} {
+		thread, err := lockThread(ctx, tx, strconv.Itoa(id))
+		if err != nil {
+			return nil, err
+		}
+		locked[id] = thread
+	}
+	source, target = locked[source.ID], locked[target.ID]
+
+	authors, err := movePosts(ctx, tx, `thread=$2, forum=$3`, `thread=$1`, source.ID, target.ID, target.Forum)
+	if err != nil {
+		return nil, err
+	}
+	if err = refreshThreadStats(ctx, tx, source.ID, target.ID); err != nil {
+		return nil, err
+	}
+	_, err = tx.ExecEx(ctx, `UPDATE thread SET deleted_at=now() WHERE id=$1`, nil, source.ID)
+	if err != nil {
+		return nil, models.Internal(err)
+	}
+	_, err = tx.ExecEx(ctx, `UPDATE forum SET threads=threads-1 WHERE slug=$1`, nil, source.Forum)
+	if err != nil {
+		return nil, models.Internal(err)
+	}
+	err = shiftCounters(ctx, tx, source.Forum, target.Forum, 0, len(authors), authors)
+	if err != nil {
+		return nil, err
+	}
+	err = pruneForumUsers(ctx, tx, source.Forum, append(authors, source.Author))
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.CommitEx(ctx); err != nil {
+		return nil, models.Internal(err)
+	}
+	return fr.GetThreadInfo(ctx, strconv.Itoa(target.ID))
+}
+
+// SplitThread moves a post and the posts below it into a new thread, written
+// by the author of that post. The post becomes a root and the routes of the
+// subtree lose the part above it.
+func (fr ForumRepository) SplitThread(ctx context.Context, split *models.ThreadSplit) (*models.Thread, error) {
+	if split.Title == "" {
+		return nil, models.Invalid("title", "is required")
+	}
+
+	tx, err := pgtx.Begin(ctx, fr.dbConn)
 	if err != nil {
-		return nil, &models.Error{Code: http.StatusNotFound, Message: "Can't find forum"}
+		return nil, models.Internal(err)
 	}
+	defer tx.Rollback()
 
-	_, err = fr.dbConn.Exec(`INSERT INTO votes(author, voice, thread_id) VALUES ($1, $2, $3) ON CONFLICT (author, thread_id) DO UPDATE SET voice = $2;`, vote.Nickname,
+	root := &models.Post{}
+	err = tx.QueryRowEx(ctx, `SELECT thread, author, message, created FROM post WHERE id=$1 AND deleted_at IS NULL`, nil,
+		split.Post).Scan(&root.Thread, &root.Author, &root.Message, &root.Created)
+	if err != nil {
+		return nil, notFoundOr(err, models.EntityPost, strconv.Itoa(split.Post))
+	}
+	source, err := lockThread(ctx, tx, strconv.Itoa(root.Thread))
+	if err != nil {
+		return nil, err
+	}
+	forum := source.Forum
+	if split.Forum != "" {
+		forum, err = forumSlug(ctx, tx, split.Forum)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	message := split.Message
+	if message == "" {
+		message = root.Message
+	}
+	slug := split.Slug
+	if slug == "" {
+		slug = split.Title + root.Author
+	}
+	var threadID int
+	err = tx.QueryRowEx(ctx, `INSERT INTO thread(title, author, created, forum, message, slug)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`, nil, split.Title, root.Author, root.Created, forum, message,
+		slug).Scan(&threadID)
+	if err != nil {
+		if pgErrorCode(err) == codeUniqueViolation {
+			return nil, &models.ConflictError{Entity: models.EntityThread,
+				Reason: fmt.Sprintf("Thread %s already exists", slug)}
+		}
+		return nil, models.Internal(err)
+	}
+
+	authors, err := movePosts(ctx, tx,
+		`thread=$3, forum=$4, route=route[array_position(route, $2::bigint):],
+			parent=CASE WHEN id=$2 THEN 0 ELSE parent END`,
+		`thread=$1 AND route @> ARRAY[$2::bigint]`, source.ID, split.Post, threadID, forum)
+	if err != nil {
+		return nil, err
+	}
+	if err = refreshThreadStats(ctx, tx, source.ID, threadID); err != nil {
+		return nil, err
+	}
+	if forum != source.Forum {
+		err = shiftCounters(ctx, tx, source.Forum, forum, 0, len(authors), authors)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err = tx.CommitEx(ctx); err != nil {
+		return nil, models.Internal(err)
+	}
+	return fr.GetThreadInfo(ctx, strconv.Itoa(threadID))
+}
+
+func (fr *ForumRepository) InsertOrUpdateVote(ctx context.Context, slugOrID string, vote *models.Vote) (*models.Thread, error) {
+	if vote.Voice != 1 && vote.Voice != -1 {
+		return nil, models.Invalid("voice", "must be 1 or -1")
+	}
+
+	thread := &models.Thread{}
+	condition, param := slugOrIDCondition(slugOrID)
+	row := fr.conn(ctx).QueryRowEx(ctx, `SELECT id, forum, archived FROM thread WHERE `+condition+` AND deleted_at IS NULL`,
+		nil, param)
+	err := row.Scan(
+		&thread.ID, &thread.Forum, &thread.Archived)
+	if err != nil {
+		return nil, notFoundOr(err, models.EntityThread, slugOrID)
+	}
+	if err = thread.CheckVotable(); err != nil {
+		return nil, err
+	}
+	err = checkBan(ctx, fr.conn(ctx), thread.Forum, vote.Nickname)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = fr.conn(ctx).ExecEx(ctx, `INSERT INTO votes(author, voice, thread_id) VALUES ($1, $2, $3) ON CONFLICT (author, thread_id) DO UPDATE SET voice = $2;`, nil, vote.Nickname,
 		vote.Voice, thread.ID)
 	if err != nil {
-		return nil, &models.Error{Code: http.StatusNotFound, Message: "no user"}
+		if pgErrorCode(err) == codeForeignKeyViolation {
+			return nil, models.NotFound(models.EntityUser, vote.Nickname)
+		}
+		return nil, models.Internal(err)
 	}
 
-	err = fr.dbConn.QueryRow(`SELECT id, title, author, forum, message, votes, slug, created FROM thread WHERE id=$1`, thread.ID).Scan(
+	err = fr.conn(ctx).QueryRowEx(ctx, `SELECT id, title, author, forum, message, votes, slug, created, locked, pinned, archived
+		FROM thread WHERE id=$1`, nil, thread.ID).Scan(
 		&thread.ID,
 		&thread.Title,
 		&thread.Author,
@@ -501,35 +1560,243 @@ func (fr *ForumRepository)	InsertOrUpdateVote(slugOrID string, vote *models.Vote
 		&thread.Message,
 		&thread.Votes,
 		&thread.Slug,
-		&thread.Created)
+		&thread.Created,
+		&thread.Locked,
+		&thread.Pinned,
+		&thread.Archived)
 	if err != nil {
-		return nil, &models.Error{Code: http.StatusNotFound, Message: "Can't find forum"}
+		return nil, models.Internal(err)
 	}
 
 	return thread, nil
 }
 
-func (fr ForumRepository)GetThreadPosts(slugOrID string, params *models.Params) ([]*models.Post, *models.Error){
-	var threadID int
-	var param interface{}
-	query := `SELECT id FROM thread WHERE `
-	id, err := strconv.Atoi(slugOrID)
+// votablePost returns a live post in a thread that takes votes and reactions.
+func (fr ForumRepository) votablePost(ctx context.Context, id int) (*models.Post, error) {
+	post := &models.Post{}
+	thread := &models.Thread{}
+	err := fr.conn(ctx).QueryRowEx(ctx, `SELECT p.id, p.author, p.created, p.forum, p.isEdited, p.message, p.parent,
+			p.thread, p.score, t.archived
+		FROM post AS p
+		JOIN thread AS t ON t.id = p.thread
+		WHERE p.id = $1 AND p.deleted_at IS NULL AND t.deleted_at IS NULL`, nil, id).Scan(
+		&post.ID, &post.Author, &post.Created, &post.Forum, &post.IsEdited, &post.Message, &post.Parent,
+		&post.Thread, &post.Score, &thread.Archived)
 	if err != nil {
-		query += `slug=$1`
-		param = slugOrID
+		return nil, notFoundOr(err, models.EntityPost, strconv.Itoa(id))
+	}
+	thread.ID = post.Thread
+	if err = thread.CheckVotable(); err != nil {
+		return nil, err
+	}
+	return post, nil
+}
+
+// InsertOrUpdatePostVote sets the voice of a user on a post, replacing the
+// one they gave before, and returns the post with its new score.
+func (fr *ForumRepository) InsertOrUpdatePostVote(ctx context.Context, vote *models.Vote) (*models.Post, error) {
+	if vote.Voice != 1 && vote.Voice != -1 {
+		return nil, models.Invalid("voice", "must be 1 or -1")
+	}
+
+	post, err := fr.votablePost(ctx, vote.Post)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkBan(ctx, fr.conn(ctx), post.Forum, vote.Nickname); err != nil {
+		return nil, err
+	}
+
+	_, err = fr.conn(ctx).ExecEx(ctx, `INSERT INTO post_votes(author, voice, post) VALUES ($1, $2, $3)
+		ON CONFLICT (author, post) DO UPDATE SET voice = $2`, nil, vote.Nickname, vote.Voice, post.ID)
+	if err != nil {
+		if pgErrorCode(err) == codeForeignKeyViolation {
+			return nil, models.NotFound(models.EntityUser, vote.Nickname)
+		}
+		return nil, models.Internal(err)
+	}
+
+	err = fr.conn(ctx).QueryRowEx(ctx, `SELECT score FROM post WHERE id=$1`, nil, post.ID).Scan(&post.Score)
+	if err != nil {
+		return nil, models.Internal(err)
+	}
+	if err = loadReactions(ctx, fr.conn(ctx), []*models.Post{post}); err != nil {
+		return nil, err
+	}
+	return post, nil
+}
+
+// AddReaction records a reaction; reacting twice with the same key is not an
+// error. It returns the post with its new counts.
+func (fr ForumRepository) AddReaction(ctx context.Context, reaction *models.Reaction) (*models.Post, error) {
+	post, err := fr.votablePost(ctx, reaction.Post)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkBan(ctx, fr.conn(ctx), post.Forum, reaction.Nickname); err != nil {
+		return nil, err
+	}
+
+	_, err = fr.conn(ctx).ExecEx(ctx, `INSERT INTO post_reactions(post, nickname, reaction) VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING`, nil, post.ID, reaction.Nickname, reaction.Key)
+	if err != nil {
+		if pgErrorCode(err) == codeForeignKeyViolation {
+			return nil, models.NotFound(models.EntityUser, reaction.Nickname)
+		}
+		return nil, models.Internal(err)
+	}
+	if err = loadReactions(ctx, fr.conn(ctx), []*models.Post{post}); err != nil {
+		return nil, err
+	}
+	return post, nil
+}
+
+// RemoveReaction takes a reaction back; removing one that was never left is
+// not an error. It returns the post with its new counts.
+func (fr ForumRepository) RemoveReaction(ctx context.Context, reaction *models.Reaction) (*models.Post, error) {
+	post, err := fr.votablePost(ctx, reaction.Post)
+	if err != nil {
+		return nil, err
+	}
+	err = fr.conn(ctx).QueryRowEx(ctx, `SELECT nickname FROM users WHERE nickname=$1`, nil,
+		reaction.Nickname).Scan(&reaction.Nickname)
+	if err != nil {
+		return nil, notFoundOr(err, models.EntityUser, reaction.Nickname)
+	}
+
+	_, err = fr.conn(ctx).ExecEx(ctx, `DELETE FROM post_reactions WHERE post=$1 AND nickname=$2 AND reaction=$3`, nil,
+		post.ID, reaction.Nickname, reaction.Key)
+	if err != nil {
+		return nil, models.Internal(err)
+	}
+	if err = loadReactions(ctx, fr.conn(ctx), []*models.Post{post}); err != nil {
+		return nil, err
+	}
+	return post, nil
+}
+
+// GetReactions pages by reaction id through the reactions to a post, those
+// with key only unless key is empty.
+func (fr ForumRepository) GetReactions(ctx context.Context, id int, key string, params *models.Params) ([]*models.Reaction, error) {
+	err := fr.conn(ctx).QueryRowEx(ctx, `SELECT id FROM post AS p WHERE id=$1
+		AND NOT EXISTS (SELECT 1 FROM thread WHERE id=p.thread AND deleted_at IS NOT NULL)`, nil, id).Scan(&id)
+	if err != nil {
+		return nil, notFoundOr(err, models.EntityPost, strconv.Itoa(id))
+	}
+
+	args := []interface{}{id}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	query := `SELECT id, post, reaction, nickname, created FROM post_reactions WHERE post = $1`
+	if key != "" {
+		query += ` AND reaction = ` + arg(key)
+	}
+	if params.Since != "" {
+		since, err := strconv.ParseInt(params.Since, 10, 64)
+		if err != nil {
+			return nil, models.Invalid("since", "must be a reaction id")
+		}
+		if params.Desc {
+			query += ` AND id < ` + arg(since)
+		} else {
+			query += ` AND id > ` + arg(since)
+		}
+	}
+	if params.Desc {
+		query += ` ORDER BY id DESC`
 	} else {
-		query += `id=$1`
-		param = id
+		query += ` ORDER BY id`
 	}
-	err = fr.dbConn.QueryRow(query, param).Scan(&threadID)
+	limit := params.Limit
+	if limit <= 0 {
+		limit = defaultReactionsLimit
+	}
+	query += ` LIMIT ` + arg(limit)
+
+	rows, err := fr.conn(ctx).QueryEx(ctx, query, nil, args...)
+	if err != nil {
+		return nil, models.Internal(err)
+	}
+	defer rows.Close()
+
+	reactions := []*models.Reaction{}
+	for rows.Next() {
+		reaction := &models.Reaction{}
+		err = rows.Scan(&reaction.ID, &reaction.Post, &reaction.Key, &reaction.Nickname, &reaction.Created)
+		if err != nil {
+			return nil, models.Internal(err)
+		}
+		reactions = append(reactions, reaction)
+	}
+	if rows.Err() != nil {
+		return nil, models.Internal(rows.Err())
+	}
+	return reactions, nil
+}
+
+// loadReactions fills the reaction counts of posts with one query.
+func loadReactions(ctx context.Context, q pgtx.Queryer, posts []*models.Post) error {
+	if len(posts) == 0 {
+		return nil
+	}
+	byID := make(map[int]*models.Post, len(posts))
+	ids := make([]int64, 0, len(posts))
+	for _, post := range posts {
+		byID[post.ID] = post
+		ids = append(ids, int64(post.ID))
+	}
+	array := pgtype.Int8Array{}
+	if err := array.Set(ids); err != nil {
+		return models.Internal(err)
+	}
+
+	rows, err := q.QueryEx(ctx, `SELECT post, reaction, count(*) FROM post_reactions
+		WHERE post = ANY($1::bigint[]) GROUP BY post, reaction`, nil, &array)
 	if err != nil {
-		return nil, &models.Error{Code: http.StatusNotFound, Message: "Can't find forum"}
+		return models.Internal(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, count int
+		var key string
+		if err = rows.Scan(&id, &key, &count); err != nil {
+			return models.Internal(err)
+		}
+		post := byID[id]
+		if post.Reactions == nil {
+			post.Reactions = make(map[string]int)
+		}
+		post.Reactions[key] = count
+	}
+	if rows.Err() != nil {
+		return models.Internal(rows.Err())
+	}
+	return nil
+}
+
+func (fr ForumRepository) GetThreadPosts(ctx context.Context, slugOrID string, params *models.Params) ([]*models.Post, error) {
+	var threadID int
+	condition, param := slugOrIDCondition(slugOrID)
+	err := fr.conn(ctx).QueryRowEx(ctx, `SELECT id FROM thread WHERE `+condition+` AND deleted_at IS NULL`, nil, param).Scan(&threadID)
+	if err != nil {
+		return nil, notFoundOr(err, models.EntityThread, slugOrID)
+	}
+
+	var since int64
+	if params.Since != "" {
+		since, err = strconv.ParseInt(params.Since, 10, 64)
+		if err != nil {
+			return nil, models.Invalid("since", "must be a post id")
+		}
 	}
 
 	var selectPar []interface{}
 	var posts []*models.Post
 
-	query = `SELECT id, author, created, forum, isEdited, message, parent, thread FROM post`
+	query := `SELECT id, author, created, forum, isEdited, message, parent, thread, score, deleted_at IS NOT NULL FROM post`
 
 	switch params.Sort {
 	case "tree":
@@ -549,7 +1816,7 @@ func (fr ForumRepository)GetThreadPosts(slugOrID string, params *models.Params)
 				query += ` WHERE thread=$1 AND ROUTE > (SELECT route FROM post WHERE id = $2)
 		ORDER BY route ASC, id  ASC LIMIT $3;`
 			}
-			selectPar = append(selectPar, threadID, params.Since, params.Limit)
+			selectPar = append(selectPar, threadID, since, params.Limit)
 		}
 	case "parent_tree":
 		if params.Since == "" {
@@ -557,10 +1824,10 @@ func (fr ForumRepository)GetThreadPosts(slugOrID string, params *models.Params)
 				query += ` WHERE route[1] IN (SELECT id FROM post WHERE thread = $1 AND parent = 0 ORDER BY id DESC LIMIT $2)
 			ORDER BY route[1] DESC, route, id;`
 			} else {
-				query +=` WHERE route[1] IN (SELECT id FROM post WHERE thread = $1 AND parent = 0 ORDER BY id LIMIT $2)
+				query += ` WHERE route[1] IN (SELECT id FROM post WHERE thread = $1 AND parent = 0 ORDER BY id LIMIT $2)
 			ORDER BY route, id;`
 			}
-			selectPar =append(selectPar, threadID, params.Limit)
+			selectPar = append(selectPar, threadID, params.Limit)
 		} else {
 			if params.Desc {
 				query += ` WHERE route[1] IN (SELECT id FROM post WHERE thread = $1 AND parent = 0 AND ROUTE[1] <
@@ -569,39 +1836,53 @@ func (fr ForumRepository)GetThreadPosts(slugOrID string, params *models.Params)
 				query += ` WHERE route[1] IN (SELECT id FROM post WHERE thread = $1 AND parent = 0 AND ROUTE[1] >
 				(SELECT route[1] FROM post WHERE id = $2) ORDER BY id ASC LIMIT $3) ORDER BY route, id;`
 			}
-			selectPar =append(selectPar, threadID, params.Since, params.Limit)
+			selectPar = append(selectPar, threadID, since, params.Limit)
 		}
-	default:
+	case "", "flat":
 		if params.Since == "" {
 			if params.Desc {
-				query +=` WHERE thread=$1 ORDER BY id DESC LIMIT $2;`
+				query += ` WHERE thread=$1 ORDER BY id DESC LIMIT $2;`
 			} else {
-				query +=` WHERE thread=$1 ORDER BY id LIMIT $2;`
+				query += ` WHERE thread=$1 ORDER BY id LIMIT $2;`
 			}
-			selectPar =append(selectPar, threadID, params.Limit)
+			selectPar = append(selectPar, threadID, params.Limit)
 		} else {
 			if params.Desc {
 				query += ` WHERE thread=$1 AND id < $2 ORDER BY id DESC LIMIT $3;`
 			} else {
-				query +=` WHERE thread=$1 AND id > $2 ORDER BY id LIMIT $3;`
+				query += ` WHERE thread=$1 AND id > $2 ORDER BY id LIMIT $3;`
 			}
-			selectPar =append(selectPar, threadID, params.Since, params.Limit)
+			selectPar = append(selectPar, threadID, since, params.Limit)
 		}
+	default:
+		return nil, models.Invalid("sort", "must be flat, tree or parent_tree")
 	}
-	rows, err := fr.dbConn.Query(query, selectPar...)
+	rows, err := fr.conn(ctx).QueryEx(ctx, query, nil, selectPar...)
 	if err != nil {
-		return nil, &models.Error{Code: http.StatusInternalServerError, Message: err.Error()}
+		return nil, models.Internal(err)
 	}
 	defer rows.Close()
 
 	for rows.Next() {
 		post := &models.Post{}
-		err = rows.Scan(&post.ID, &post.Author, &post.Created, &post.Forum, &post.IsEdited, &post.Message, &post.Parent, &post.Thread)
+		var deleted bool
+		err = rows.Scan(&post.ID, &post.Author, &post.Created, &post.Forum, &post.IsEdited, &post.Message, &post.Parent, &post.Thread,
+			&post.Score, &deleted)
 		if err != nil {
-			return nil, &models.Error{Code: http.StatusInternalServerError, Message: err.Error()}
+			return nil, models.Internal(err)
+		}
+		if deleted {
+			post.Tombstone()
 		}
 
 		posts = append(posts, post)
 	}
+	if rows.Err() != nil {
+		return nil, models.Internal(rows.Err())
+	}
+	rows.Close()
+	if err = loadReactions(ctx, fr.conn(ctx), posts); err != nil {
+		return nil, err
+	}
 	return posts, nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/dantedoyl/Tech_DB_Forum/internal/auth"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/models"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/response"
+	"github.com/gorilla/mux"
+)
+
+// Authenticate resolves the session token of the Authorization header and
+// stores the caller in the request context. Requests without a token pass
+// through anonymous; an unknown or expired token is rejected.
+func Authenticate(authRepo auth.AuthRepository) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := auth.BearerToken(r)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			session, err := authRepo.GetSession(r.Context(), auth.HashToken(token))
+			if err != nil {
+				var notFound *models.NotFoundError
+				if errors.As(err, &notFound) {
+					err = models.Unauthorized("invalid or expired token")
+				}
+				w.Header().Set("Content-Type", "application/json")
+				response.WriteError(w, err)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(auth.WithNickname(r.Context(), session.Nickname)))
+		})
+	}
+}
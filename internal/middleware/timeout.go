@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Timeout bounds the context of every request by the timeout configured for
+// the name of the matched mux route, falling back to defaultTimeout. A zero
+// duration leaves the request without a deadline.
+func Timeout(defaultTimeout time.Duration, routeTimeouts map[string]time.Duration) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			timeout := defaultTimeout
+			if route := mux.CurrentRoute(r); route != nil {
+				if t, ok := routeTimeouts[route.GetName()]; ok {
+					timeout = t
+				}
+			}
+			if timeout <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
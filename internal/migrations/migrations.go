@@ -0,0 +1,241 @@
+// Package migrations keeps the database schema in step with the binary.
+//
+// Migrations are compiled in from sql/NNNN_name.up.sql and the matching
+// NNNN_name.down.sql. Applied versions are recorded in schema_migrations and
+// every run holds a Postgres advisory lock, so several instances starting at
+// once apply each migration exactly one time.
+package migrations
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx"
+)
+
+//go:embed sql/*.sql
+var files embed.FS
+
+// lockID is the pg_advisory_lock key shared by all migration runners.
+const lockID = 7_340_001
+
+// simpleProtocol lets a single Exec run a file with many statements.
+var simpleProtocol = &pgx.QueryExOptions{SimpleProtocol: true}
+
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type Status struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// All returns the compiled-in migrations ordered by version.
+func All() ([]*Migration, error) {
+	entries, err := files.ReadDir("sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		parts := fileName.FindStringSubmatch(entry.Name())
+		if parts == nil {
+			return nil, fmt.Errorf("migrations: unexpected file %s", entry.Name())
+		}
+		version, _ := strconv.Atoi(parts[1])
+		body, err := files.ReadFile(path.Join("sql", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: parts[2]}
+			byVersion[version] = m
+		} else if m.Name != parts[2] {
+			return nil, fmt.Errorf("migrations: version %d has two names: %s and %s", version, m.Name, parts[2])
+		}
+		if parts[3] == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	var all []*Migration
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migrations: %04d_%s needs both up and down files", m.Version, m.Name)
+		}
+		all = append(all, m)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Version < all[j].Version })
+	return all, nil
+}
+
+type Migrator struct {
+	dbConn *pgx.ConnPool
+}
+
+func NewMigrator(conn *pgx.ConnPool) *Migrator {
+	return &Migrator{dbConn: conn}
+}
+
+// Up applies every pending migration and returns the ones it applied.
+func (m *Migrator) Up(ctx context.Context) ([]*Migration, error) {
+	var applied []*Migration
+	err := m.locked(ctx, func(conn *pgx.Conn) error {
+		statuses, err := status(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			if s.AppliedAt != nil {
+				continue
+			}
+			migration := s.Migration
+			err = inTx(ctx, conn, func(tx *pgx.Tx) error {
+				if _, err := tx.ExecEx(ctx, migration.Up, simpleProtocol); err != nil {
+					return err
+				}
+				_, err := tx.ExecEx(ctx, `INSERT INTO schema_migrations(version, name) VALUES ($1, $2)`, nil,
+					migration.Version, migration.Name)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("migrations: %04d_%s up: %v", migration.Version, migration.Name, err)
+			}
+			applied = append(applied, &migration)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down reverts the most recently applied migration. It returns nil when
+// nothing is applied.
+func (m *Migrator) Down(ctx context.Context) (*Migration, error) {
+	var reverted *Migration
+	err := m.locked(ctx, func(conn *pgx.Conn) error {
+		statuses, err := status(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for i := len(statuses) - 1; i >= 0; i-- {
+			if statuses[i].AppliedAt == nil {
+				continue
+			}
+			migration := statuses[i].Migration
+			err = inTx(ctx, conn, func(tx *pgx.Tx) error {
+				if _, err := tx.ExecEx(ctx, migration.Down, simpleProtocol); err != nil {
+					return err
+				}
+				_, err := tx.ExecEx(ctx, `DELETE FROM schema_migrations WHERE version=$1`, nil, migration.Version)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("migrations: %04d_%s down: %v", migration.Version, migration.Name, err)
+			}
+			reverted = &migration
+			return nil
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// Status lists every known migration with the time it was applied, if any.
+func (m *Migrator) Status(ctx context.Context) ([]*Status, error) {
+	var statuses []*Status
+	err := m.locked(ctx, func(conn *pgx.Conn) error {
+		var err error
+		statuses, err = status(ctx, conn)
+		return err
+	})
+	return statuses, err
+}
+
+// locked runs fn on a single connection holding the migration advisory lock.
+func (m *Migrator) locked(ctx context.Context, fn func(conn *pgx.Conn) error) error {
+	conn, err := m.dbConn.AcquireEx(ctx)
+	if err != nil {
+		return err
+	}
+	defer m.dbConn.Release(conn)
+
+	if _, err = conn.ExecEx(ctx, `SELECT pg_advisory_lock($1)`, nil, lockID); err != nil {
+		return err
+	}
+	defer conn.ExecEx(context.Background(), `SELECT pg_advisory_unlock($1)`, nil, lockID)
+
+	_, err = conn.ExecEx(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations
+	(
+		version     INT         PRIMARY KEY,
+		name        text        NOT NULL,
+		applied_at  timestamp with time zone    DEFAULT now()
+	)`, nil)
+	if err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+func status(ctx context.Context, conn *pgx.Conn) ([]*Status, error) {
+	all, err := All()
+	if err != nil {
+		return nil, err
+	}
+
+	appliedAt := make(map[int]time.Time)
+	rows, err := conn.QueryEx(ctx, `SELECT version, applied_at FROM schema_migrations`, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		appliedAt[version] = at
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	var statuses []*Status
+	for _, m := range all {
+		s := &Status{Migration: *m}
+		if at, ok := appliedAt[m.Version]; ok {
+			s.AppliedAt = &at
+		}
+		statuses = append(statuses, s)
+	}
+	return statuses, nil
+}
+
+func inTx(ctx context.Context, conn *pgx.Conn, fn func(tx *pgx.Tx) error) error {
+	tx, err := conn.BeginEx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.CommitEx(ctx)
+}
@@ -0,0 +1,12 @@
+DROP TABLE IF EXISTS forum_users;
+DROP TABLE IF EXISTS votes;
+DROP TABLE IF EXISTS post;
+DROP TABLE IF EXISTS thread;
+DROP TABLE IF EXISTS forum;
+DROP TABLE IF EXISTS users;
+
+DROP FUNCTION IF EXISTS count_threads();
+DROP FUNCTION IF EXISTS insert_new_forum_user();
+DROP FUNCTION IF EXISTS add_vote();
+DROP FUNCTION IF EXISTS update_vote();
+DROP FUNCTION IF EXISTS post_update();
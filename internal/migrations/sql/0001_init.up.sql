@@ -1,6 +1,6 @@
 CREATE EXTENSION IF NOT EXISTS citext;
 
-CREATE UNLOGGED TABLE users
+CREATE UNLOGGED TABLE IF NOT EXISTS users
 (
     nickname    citext     PRIMARY KEY,
     fullname    text       NOT NULL,
@@ -8,7 +8,7 @@ CREATE UNLOGGED TABLE users
     email       citext     UNIQUE
 );
 
-CREATE UNLOGGED TABLE forum
+CREATE UNLOGGED TABLE IF NOT EXISTS forum
 (
     slug        citext      PRIMARY KEY,
     author      citext,
@@ -17,7 +17,7 @@ CREATE UNLOGGED TABLE forum
     threads     INT         DEFAULT 0
 );
 
-CREATE UNLOGGED TABLE thread
+CREATE UNLOGGED TABLE IF NOT EXISTS thread
 (
     id          SERIAL      PRIMARY KEY,
     title       text        not null,
@@ -29,7 +29,7 @@ CREATE UNLOGGED TABLE thread
     votes       INT         default 0
 );
 
-CREATE UNLOGGED TABLE post
+CREATE UNLOGGED TABLE IF NOT EXISTS post
 (
     id          BIGSERIAL   PRIMARY KEY,
     author      citext,
@@ -45,7 +45,7 @@ CREATE UNLOGGED TABLE post
     FOREIGN KEY (author) REFERENCES users  (nickname)
 );
 
-CREATE UNLOGGED TABLE votes
+CREATE UNLOGGED TABLE IF NOT EXISTS votes
 (
     id          BIGSERIAL   PRIMARY KEY,
     author      citext      REFERENCES users (nickname),
@@ -56,7 +56,7 @@ CREATE UNLOGGED TABLE votes
     FOREIGN KEY (thread_id) REFERENCES thread (id)
 );
 
-CREATE UNLOGGED TABLE forum_users
+CREATE UNLOGGED TABLE IF NOT EXISTS forum_users
 (
     nickname    citext      NOT NULL,
     fullname    TEXT        NOT NULL,
@@ -77,6 +77,7 @@ BEGIN
 END
 $count_threads$ LANGUAGE plpgsql;
 
+DROP TRIGGER IF EXISTS count_threads ON thread;
 CREATE TRIGGER count_threads
     BEFORE INSERT
     ON thread
@@ -97,12 +98,14 @@ RETURN NEW;
 END
 $insert_new_forum_user$ LANGUAGE plpgsql;
 
+DROP TRIGGER IF EXISTS insert_new_forum_user ON post;
 CREATE TRIGGER insert_new_forum_user
     AFTER INSERT
     ON post
     FOR EACH ROW
     EXECUTE PROCEDURE insert_new_forum_user();
 
+DROP TRIGGER IF EXISTS insert_new_forum_user ON thread;
 CREATE TRIGGER insert_new_forum_user
     AFTER INSERT
     ON thread
@@ -117,6 +120,7 @@ BEGIN
 END
 $add_vote$ LANGUAGE plpgsql;
 
+DROP TRIGGER IF EXISTS add_vote ON votes;
 CREATE TRIGGER add_vote
     AFTER INSERT
     ON votes
@@ -133,6 +137,7 @@ BEGIN
 END
 $update_vote$ LANGUAGE plpgsql;
 
+DROP TRIGGER IF EXISTS update_vote ON votes;
 CREATE TRIGGER update_vote
     AFTER UPDATE
     ON votes
@@ -162,6 +167,7 @@ BEGIN
 END
 $post_update$ LANGUAGE plpgsql;
 
+DROP TRIGGER IF EXISTS post_update ON post;
 CREATE TRIGGER post_update
     BEFORE INSERT
     ON post
@@ -184,4 +190,4 @@ create index if not exists post_path1 on post ((route[1]));
 
 CREATE INDEX if not exists post_thr_id ON post (thread);
 CREATE INDEX if not exists post_forum_id ON post (forum);
-CREATE INDEX if not exists post_author_id ON post (author);
\ No newline at end of file
+CREATE INDEX if not exists post_author_id ON post (author);
@@ -0,0 +1,10 @@
+DROP INDEX IF EXISTS thread_search_idx;
+DROP INDEX IF EXISTS post_search_idx;
+
+DROP TRIGGER IF EXISTS thread_search ON thread;
+DROP FUNCTION IF EXISTS thread_search();
+DROP TRIGGER IF EXISTS post_search ON post;
+DROP FUNCTION IF EXISTS post_search();
+
+ALTER TABLE thread DROP COLUMN IF EXISTS search;
+ALTER TABLE post DROP COLUMN IF EXISTS search;
@@ -0,0 +1,41 @@
+ALTER TABLE post ADD COLUMN IF NOT EXISTS search tsvector;
+ALTER TABLE thread ADD COLUMN IF NOT EXISTS search tsvector;
+
+CREATE OR REPLACE FUNCTION post_search() RETURNS TRIGGER AS
+$post_search$
+BEGIN
+    NEW.search := to_tsvector('simple', NEW.message);
+    RETURN NEW;
+END
+$post_search$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS post_search ON post;
+CREATE TRIGGER post_search
+    BEFORE INSERT OR UPDATE OF message
+    ON post
+    FOR EACH ROW
+    EXECUTE PROCEDURE post_search();
+
+CREATE OR REPLACE FUNCTION thread_search() RETURNS TRIGGER AS
+$thread_search$
+BEGIN
+    NEW.search := setweight(to_tsvector('simple', NEW.title), 'A') ||
+                  setweight(to_tsvector('simple', NEW.message), 'B');
+    RETURN NEW;
+END
+$thread_search$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS thread_search ON thread;
+CREATE TRIGGER thread_search
+    BEFORE INSERT OR UPDATE OF title, message
+    ON thread
+    FOR EACH ROW
+    EXECUTE PROCEDURE thread_search();
+
+UPDATE post SET search = to_tsvector('simple', message) WHERE search IS NULL;
+UPDATE thread SET search = setweight(to_tsvector('simple', title), 'A') ||
+                           setweight(to_tsvector('simple', message), 'B')
+    WHERE search IS NULL;
+
+CREATE INDEX IF NOT EXISTS post_search_idx ON post USING gin (search);
+CREATE INDEX IF NOT EXISTS thread_search_idx ON thread USING gin (search);
@@ -0,0 +1,2 @@
+ALTER TABLE thread DROP COLUMN IF EXISTS deleted_at;
+ALTER TABLE post DROP COLUMN IF EXISTS deleted_at;
@@ -0,0 +1,2 @@
+ALTER TABLE post ADD COLUMN IF NOT EXISTS deleted_at timestamp with time zone;
+ALTER TABLE thread ADD COLUMN IF NOT EXISTS deleted_at timestamp with time zone;
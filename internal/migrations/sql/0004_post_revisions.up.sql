@@ -0,0 +1,12 @@
+CREATE UNLOGGED TABLE IF NOT EXISTS post_revisions
+(
+    post_id     BIGINT      NOT NULL,
+    revision    INT         NOT NULL,
+    message     text        NOT NULL,
+    editor      citext,
+    created     timestamp with time zone    DEFAULT now(),
+
+    PRIMARY KEY (post_id, revision),
+    FOREIGN KEY (post_id) REFERENCES post (id),
+    FOREIGN KEY (editor) REFERENCES users (nickname)
+);
@@ -0,0 +1,13 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS password_hash text;
+
+CREATE UNLOGGED TABLE IF NOT EXISTS sessions
+(
+    token_hash  bytea       PRIMARY KEY,
+    nickname    citext      NOT NULL,
+    created     timestamp with time zone    DEFAULT now(),
+    expires_at  timestamp with time zone    NOT NULL,
+
+    FOREIGN KEY (nickname) REFERENCES users (nickname)
+);
+
+CREATE INDEX IF NOT EXISTS sessions_expires_at ON sessions (expires_at);
@@ -0,0 +1,17 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role text NOT NULL DEFAULT 'member'
+    CHECK (role IN ('admin', 'member', 'banned'));
+
+CREATE UNLOGGED TABLE IF NOT EXISTS forum_moderators
+(
+    forum       citext      NOT NULL,
+    nickname    citext      NOT NULL,
+    granted_by  citext,
+    created     timestamp with time zone    DEFAULT now(),
+
+    PRIMARY KEY (forum, nickname),
+    FOREIGN KEY (forum) REFERENCES forum (slug),
+    FOREIGN KEY (nickname) REFERENCES users (nickname),
+    FOREIGN KEY (granted_by) REFERENCES users (nickname) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS forum_moderators_nickname ON forum_moderators (nickname);
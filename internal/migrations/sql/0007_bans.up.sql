@@ -0,0 +1,20 @@
+CREATE UNLOGGED TABLE IF NOT EXISTS bans
+(
+    id          SERIAL      PRIMARY KEY,
+    nickname    citext      NOT NULL,
+    forum       citext,
+    reason      text        NOT NULL DEFAULT '',
+    issued_by   citext,
+    created     timestamp with time zone    DEFAULT now(),
+    expires_at  timestamp with time zone,
+    lifted_at   timestamp with time zone,
+    lifted_by   citext,
+
+    FOREIGN KEY (nickname) REFERENCES users (nickname),
+    FOREIGN KEY (forum) REFERENCES forum (slug),
+    FOREIGN KEY (issued_by) REFERENCES users (nickname) ON DELETE SET NULL,
+    FOREIGN KEY (lifted_by) REFERENCES users (nickname) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS bans_nickname_active ON bans (nickname) WHERE lifted_at IS NULL;
+CREATE INDEX IF NOT EXISTS bans_forum ON bans (forum, id);
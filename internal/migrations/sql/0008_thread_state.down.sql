@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS thread_forum_pinned;
+ALTER TABLE thread DROP COLUMN IF EXISTS archived;
+ALTER TABLE thread DROP COLUMN IF EXISTS pinned;
+ALTER TABLE thread DROP COLUMN IF EXISTS locked;
@@ -0,0 +1,7 @@
+ALTER TABLE thread ADD COLUMN IF NOT EXISTS locked boolean NOT NULL DEFAULT false;
+ALTER TABLE thread ADD COLUMN IF NOT EXISTS pinned boolean NOT NULL DEFAULT false;
+ALTER TABLE thread ADD COLUMN IF NOT EXISTS archived boolean NOT NULL DEFAULT false;
+
+-- Forum thread lists read the pinned threads of a forum and page the others
+-- by creation time.
+CREATE INDEX IF NOT EXISTS thread_forum_pinned ON thread (forum, pinned, created);
@@ -0,0 +1,26 @@
+CREATE UNLOGGED TABLE IF NOT EXISTS reports
+(
+    id          SERIAL      PRIMARY KEY,
+    post        BIGINT,
+    thread      INT,
+    reporter    citext      NOT NULL,
+    reason      text        NOT NULL,
+    comment     text        NOT NULL DEFAULT '',
+    status      text        NOT NULL DEFAULT 'open',
+    created     timestamp with time zone    DEFAULT now(),
+    handled_by  citext,
+    handled_at  timestamp with time zone,
+
+    CHECK ((post IS NULL) <> (thread IS NULL)),
+    CHECK (reason IN ('spam', 'abuse', 'off_topic', 'illegal', 'other')),
+    CHECK (status IN ('open', 'resolved', 'dismissed')),
+    FOREIGN KEY (post) REFERENCES post (id),
+    FOREIGN KEY (thread) REFERENCES thread (id),
+    FOREIGN KEY (reporter) REFERENCES users (nickname),
+    FOREIGN KEY (handled_by) REFERENCES users (nickname) ON DELETE SET NULL
+);
+
+-- A user has at most one open report about a post or thread.
+CREATE UNIQUE INDEX IF NOT EXISTS reports_open_once ON reports (reporter, COALESCE(post, 0), COALESCE(thread, 0))
+    WHERE status = 'open';
+CREATE INDEX IF NOT EXISTS reports_open ON reports (id) WHERE status = 'open';
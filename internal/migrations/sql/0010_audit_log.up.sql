@@ -0,0 +1,32 @@
+-- Logged, unlike the data tables, and without foreign keys so that entries
+-- outlive what they describe, /service/clear included.
+CREATE TABLE IF NOT EXISTS audit_log
+(
+    id          BIGSERIAL   PRIMARY KEY,
+    actor       citext,
+    action      text        NOT NULL,
+    entity      text        NOT NULL,
+    target      text        NOT NULL,
+    forum       citext,
+    before      jsonb,
+    after       jsonb,
+    created     timestamp with time zone    NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_actor ON audit_log (actor, id);
+CREATE INDEX IF NOT EXISTS audit_log_forum ON audit_log (forum, id);
+CREATE INDEX IF NOT EXISTS audit_log_created ON audit_log (created);
+
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS TRIGGER AS
+$audit_log_append_only$
+BEGIN
+    RAISE EXCEPTION 'audit_log is append-only';
+END
+$audit_log_append_only$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_log_append_only ON audit_log;
+CREATE TRIGGER audit_log_append_only
+    BEFORE UPDATE OR DELETE
+    ON audit_log
+    FOR EACH ROW
+    EXECUTE PROCEDURE audit_log_append_only();
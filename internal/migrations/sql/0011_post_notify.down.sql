@@ -0,0 +1,4 @@
+DROP TRIGGER IF EXISTS post_notify_update ON post;
+DROP FUNCTION IF EXISTS post_notify_update();
+DROP TRIGGER IF EXISTS post_notify_insert ON post;
+DROP FUNCTION IF EXISTS post_notify_insert();
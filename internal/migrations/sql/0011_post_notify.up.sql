@@ -0,0 +1,36 @@
+-- Announce post writes on the post_events channel for GET /thread/{slug_or_id}/stream.
+-- Inserts notify once per thread and statement with the highest new id, so a
+-- batch of posts costs one notification; edits notify once per post.
+CREATE OR REPLACE FUNCTION post_notify_insert() RETURNS TRIGGER AS
+$post_notify_insert$
+BEGIN
+    PERFORM pg_notify('post_events', json_build_object('event', 'post', 'thread', thread, 'post', max(id))::text)
+    FROM inserted
+    GROUP BY thread;
+    RETURN NULL;
+END
+$post_notify_insert$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS post_notify_insert ON post;
+CREATE TRIGGER post_notify_insert
+    AFTER INSERT
+    ON post
+    REFERENCING NEW TABLE AS inserted
+    FOR EACH STATEMENT
+    EXECUTE PROCEDURE post_notify_insert();
+
+CREATE OR REPLACE FUNCTION post_notify_update() RETURNS TRIGGER AS
+$post_notify_update$
+BEGIN
+    PERFORM pg_notify('post_events', json_build_object('event', 'edit', 'thread', new.thread, 'post', new.id)::text);
+    RETURN NULL;
+END
+$post_notify_update$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS post_notify_update ON post;
+CREATE TRIGGER post_notify_update
+    AFTER UPDATE OF message, deleted_at
+    ON post
+    FOR EACH ROW
+    WHEN (old.message IS DISTINCT FROM new.message OR old.deleted_at IS DISTINCT FROM new.deleted_at)
+    EXECUTE PROCEDURE post_notify_update();
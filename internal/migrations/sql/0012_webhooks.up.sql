@@ -0,0 +1,36 @@
+-- Logged, unlike the data tables, so that queued deliveries survive a crash;
+-- hence no foreign key to forum. /service/clear truncates both tables.
+CREATE TABLE IF NOT EXISTS webhooks
+(
+    id          SERIAL      PRIMARY KEY,
+    forum       citext      NOT NULL,
+    url         text        NOT NULL,
+    secret      text        NOT NULL,
+    events      text[]      NOT NULL,
+    created_by  citext,
+    created     timestamp with time zone    NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS webhooks_forum ON webhooks (forum);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries
+(
+    id              BIGSERIAL   PRIMARY KEY,
+    webhook         INT         NOT NULL,
+    event           text        NOT NULL,
+    payload         jsonb       NOT NULL,
+    status          text        NOT NULL DEFAULT 'pending',
+    attempts        INT         NOT NULL DEFAULT 0,
+    next_attempt_at timestamp with time zone    NOT NULL DEFAULT now(),
+    last_attempt_at timestamp with time zone,
+    response_status INT,
+    error           text        NOT NULL DEFAULT '',
+    created         timestamp with time zone    NOT NULL DEFAULT now(),
+    delivered_at    timestamp with time zone,
+
+    CHECK (status IN ('pending', 'delivered', 'failed')),
+    FOREIGN KEY (webhook) REFERENCES webhooks (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_log ON webhook_deliveries (webhook, id);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_due ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
@@ -0,0 +1,7 @@
+DROP TRIGGER IF EXISTS notify_thread_subscribers ON thread;
+DROP FUNCTION IF EXISTS notify_thread_subscribers();
+DROP TRIGGER IF EXISTS notify_post_subscribers ON post;
+DROP FUNCTION IF EXISTS notify_post_subscribers();
+DROP TABLE IF EXISTS notifications;
+DROP TABLE IF EXISTS forum_subscriptions;
+DROP TABLE IF EXISTS thread_subscriptions;
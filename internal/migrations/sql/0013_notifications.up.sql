@@ -0,0 +1,86 @@
+CREATE UNLOGGED TABLE IF NOT EXISTS thread_subscriptions
+(
+    nickname    citext      NOT NULL,
+    thread      INT         NOT NULL,
+    created     timestamp with time zone    NOT NULL DEFAULT now(),
+
+    PRIMARY KEY (thread, nickname),
+    FOREIGN KEY (nickname) REFERENCES users (nickname) ON DELETE CASCADE,
+    FOREIGN KEY (thread) REFERENCES thread (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS thread_subscriptions_user ON thread_subscriptions (nickname);
+
+CREATE UNLOGGED TABLE IF NOT EXISTS forum_subscriptions
+(
+    nickname    citext      NOT NULL,
+    forum       citext      NOT NULL,
+    created     timestamp with time zone    NOT NULL DEFAULT now(),
+
+    PRIMARY KEY (forum, nickname),
+    FOREIGN KEY (nickname) REFERENCES users (nickname) ON DELETE CASCADE,
+    FOREIGN KEY (forum) REFERENCES forum (slug) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS forum_subscriptions_user ON forum_subscriptions (nickname);
+
+CREATE UNLOGGED TABLE IF NOT EXISTS notifications
+(
+    id          BIGSERIAL   PRIMARY KEY,
+    nickname    citext      NOT NULL,
+    kind        text        NOT NULL,
+    thread      INT         NOT NULL,
+    post        BIGINT,
+    actor       citext      NOT NULL,
+    created     timestamp with time zone    NOT NULL DEFAULT now(),
+    read_at     timestamp with time zone,
+
+    FOREIGN KEY (nickname) REFERENCES users (nickname) ON DELETE CASCADE,
+    FOREIGN KEY (thread) REFERENCES thread (id) ON DELETE CASCADE,
+    FOREIGN KEY (post) REFERENCES post (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS notifications_inbox ON notifications (nickname, id);
+CREATE INDEX IF NOT EXISTS notifications_unread ON notifications (nickname) WHERE read_at IS NULL;
+
+-- Notify the subscribers of a thread of its new posts and the subscribers of
+-- a forum of its new threads, but not the authors of their own.
+CREATE OR REPLACE FUNCTION notify_post_subscribers() RETURNS TRIGGER AS
+$notify_post_subscribers$
+BEGIN
+    INSERT INTO notifications(nickname, kind, thread, post, actor)
+    SELECT s.nickname, 'post', i.thread, i.id, i.author
+    FROM inserted AS i
+    JOIN thread_subscriptions AS s ON s.thread = i.thread
+    WHERE s.nickname <> i.author;
+    RETURN NULL;
+END
+$notify_post_subscribers$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS notify_post_subscribers ON post;
+CREATE TRIGGER notify_post_subscribers
+    AFTER INSERT
+    ON post
+    REFERENCING NEW TABLE AS inserted
+    FOR EACH STATEMENT
+    EXECUTE PROCEDURE notify_post_subscribers();
+
+CREATE OR REPLACE FUNCTION notify_thread_subscribers() RETURNS TRIGGER AS
+$notify_thread_subscribers$
+BEGIN
+    INSERT INTO notifications(nickname, kind, thread, actor)
+    SELECT s.nickname, 'thread', i.id, i.author
+    FROM inserted AS i
+    JOIN forum_subscriptions AS s ON s.forum = i.forum
+    WHERE s.nickname <> i.author;
+    RETURN NULL;
+END
+$notify_thread_subscribers$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS notify_thread_subscribers ON thread;
+CREATE TRIGGER notify_thread_subscribers
+    AFTER INSERT
+    ON thread
+    REFERENCING NEW TABLE AS inserted
+    FOR EACH STATEMENT
+    EXECUTE PROCEDURE notify_thread_subscribers();
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS notifications_mentions;
+DROP TABLE IF EXISTS post_mentions;
@@ -0,0 +1,15 @@
+-- The users the current message of a post mentions. Notifications of kind
+-- 'mention' record who has been told, so that a mention removed and added
+-- again is not announced twice.
+CREATE UNLOGGED TABLE IF NOT EXISTS post_mentions
+(
+    post        BIGINT      NOT NULL,
+    nickname    citext      NOT NULL,
+
+    PRIMARY KEY (post, nickname),
+    FOREIGN KEY (post) REFERENCES post (id) ON DELETE CASCADE,
+    FOREIGN KEY (nickname) REFERENCES users (nickname) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS post_mentions_user ON post_mentions (nickname, post);
+CREATE INDEX IF NOT EXISTS notifications_mentions ON notifications (post, nickname) WHERE kind = 'mention';
@@ -0,0 +1,16 @@
+-- One row per user, post and reaction key. The keys on offer are
+-- configuration, so the table takes any key the server accepted.
+CREATE UNLOGGED TABLE IF NOT EXISTS post_reactions
+(
+    id          BIGSERIAL   PRIMARY KEY,
+    post        BIGINT      NOT NULL,
+    nickname    citext      NOT NULL,
+    reaction    TEXT        NOT NULL,
+    created     TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    UNIQUE (post, reaction, nickname),
+    FOREIGN KEY (post) REFERENCES post (id) ON DELETE CASCADE,
+    FOREIGN KEY (nickname) REFERENCES users (nickname) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS post_reactions_post ON post_reactions (post, id);
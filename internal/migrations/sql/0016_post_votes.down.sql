@@ -0,0 +1,7 @@
+DROP TRIGGER IF EXISTS post_vote ON post_votes;
+DROP FUNCTION IF EXISTS post_vote();
+DROP TRIGGER IF EXISTS thread_vote_reputation ON votes;
+DROP FUNCTION IF EXISTS thread_vote_reputation();
+ALTER TABLE users DROP COLUMN IF EXISTS reputation;
+ALTER TABLE post DROP COLUMN IF EXISTS score;
+DROP TABLE IF EXISTS post_votes;
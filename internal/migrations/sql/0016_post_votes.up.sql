@@ -0,0 +1,74 @@
+-- Votes on posts, with the same one-voice-per-user upsert as thread votes.
+CREATE UNLOGGED TABLE IF NOT EXISTS post_votes
+(
+    id          BIGSERIAL   PRIMARY KEY,
+    author      citext      NOT NULL REFERENCES users (nickname),
+    voice       INT         NOT NULL,
+    post        BIGINT      NOT NULL REFERENCES post (id) ON DELETE CASCADE,
+
+    UNIQUE (author, post)
+);
+
+ALTER TABLE post ADD COLUMN IF NOT EXISTS score INT NOT NULL DEFAULT 0;
+
+-- reputation is the sum of the votes a user's threads and posts received from
+-- other users. The triggers below keep it current.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS reputation INT NOT NULL DEFAULT 0;
+
+UPDATE users AS u SET reputation = r.total
+FROM (SELECT t.author, SUM(v.voice) AS total
+      FROM votes AS v
+      JOIN thread AS t ON t.id = v.thread_id
+      WHERE v.author <> t.author
+      GROUP BY t.author) AS r
+WHERE u.nickname = r.author;
+
+CREATE OR REPLACE FUNCTION thread_vote_reputation() RETURNS TRIGGER AS
+$thread_vote_reputation$
+DECLARE
+    delta INT := NEW.voice;
+BEGIN
+    IF TG_OP = 'UPDATE' THEN
+        delta := NEW.voice - OLD.voice;
+    END IF;
+    IF delta <> 0 THEN
+        UPDATE users SET reputation = reputation + delta
+        FROM thread
+        WHERE thread.id = NEW.thread_id AND users.nickname = thread.author AND thread.author <> NEW.author;
+    END IF;
+    RETURN NEW;
+END
+$thread_vote_reputation$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS thread_vote_reputation ON votes;
+CREATE TRIGGER thread_vote_reputation
+    AFTER INSERT OR UPDATE OF voice
+    ON votes
+    FOR EACH ROW
+    EXECUTE PROCEDURE thread_vote_reputation();
+
+CREATE OR REPLACE FUNCTION post_vote() RETURNS TRIGGER AS
+$post_vote$
+DECLARE
+    delta       INT := NEW.voice;
+    post_author citext;
+BEGIN
+    IF TG_OP = 'UPDATE' THEN
+        delta := NEW.voice - OLD.voice;
+    END IF;
+    IF delta <> 0 THEN
+        UPDATE post SET score = score + delta WHERE id = NEW.post RETURNING author INTO post_author;
+        IF post_author <> NEW.author THEN
+            UPDATE users SET reputation = reputation + delta WHERE nickname = post_author;
+        END IF;
+    END IF;
+    RETURN NEW;
+END
+$post_vote$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS post_vote ON post_votes;
+CREATE TRIGGER post_vote
+    AFTER INSERT OR UPDATE OF voice
+    ON post_votes
+    FOR EACH ROW
+    EXECUTE PROCEDURE post_vote();
@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS thread_forum_active;
+DROP INDEX IF EXISTS thread_forum_top;
+DROP INDEX IF EXISTS thread_forum_hot;
+DROP TRIGGER IF EXISTS thread_rank ON thread;
+DROP FUNCTION IF EXISTS thread_rank();
+DROP FUNCTION IF EXISTS thread_hot(INT, TIMESTAMPTZ);
+ALTER TABLE thread DROP COLUMN IF EXISTS hot;
+ALTER TABLE thread DROP COLUMN IF EXISTS last_post_at;
+ALTER TABLE thread DROP COLUMN IF EXISTS posts;
@@ -0,0 +1,46 @@
+-- Columns the forum thread listing ranks by. posts and last_post_at count the
+-- live posts of a thread and the time of the newest one; the repository keeps
+-- them current as posts are created, deleted and moved. hot follows votes and
+-- created through the trigger below.
+ALTER TABLE thread ADD COLUMN IF NOT EXISTS posts INT NOT NULL DEFAULT 0;
+ALTER TABLE thread ADD COLUMN IF NOT EXISTS last_post_at TIMESTAMPTZ;
+ALTER TABLE thread ADD COLUMN IF NOT EXISTS hot DOUBLE PRECISION NOT NULL DEFAULT 0;
+
+-- thread_hot ranks by votes, decayed by age: a thread needs ten times the
+-- votes to rank with one created 12.5 hours later. The score of a thread only
+-- changes with its votes, so it can be stored and indexed.
+CREATE OR REPLACE FUNCTION thread_hot(votes INT, created TIMESTAMPTZ) RETURNS DOUBLE PRECISION AS
+$thread_hot$
+SELECT sign(votes::float8) * log(greatest(abs(votes), 1)::float8) + extract(epoch FROM created)::float8 / 45000
+$thread_hot$ LANGUAGE sql IMMUTABLE;
+
+CREATE OR REPLACE FUNCTION thread_rank() RETURNS TRIGGER AS
+$thread_rank$
+BEGIN
+    NEW.hot := thread_hot(NEW.votes, NEW.created);
+    RETURN NEW;
+END
+$thread_rank$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS thread_rank ON thread;
+CREATE TRIGGER thread_rank
+    BEFORE INSERT OR UPDATE OF votes, created
+    ON thread
+    FOR EACH ROW
+    EXECUTE PROCEDURE thread_rank();
+
+UPDATE thread SET hot = thread_hot(votes, created);
+
+UPDATE thread AS t SET posts = s.posts, last_post_at = s.last
+FROM (SELECT thread, COUNT(*) AS posts, MAX(created) AS last
+      FROM post
+      WHERE deleted_at IS NULL
+      GROUP BY thread) AS s
+WHERE t.id = s.thread;
+
+-- The ranking sorts put pinned threads first within each page and page by
+-- (pinned, rank, id).
+CREATE INDEX IF NOT EXISTS thread_forum_hot ON thread (forum, pinned, hot, id) WHERE deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS thread_forum_top ON thread (forum, pinned, votes, id) WHERE deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS thread_forum_active ON thread (forum, pinned, (COALESCE(last_post_at, created)), id)
+    WHERE deleted_at IS NULL;
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// AuditEntry records one write: who made it, what it was and the state of
+// the target before and after. Actor is empty for anonymous writes that named
+// nobody.
+type AuditEntry struct {
+	ID      int64           `json:"id"`
+	Actor   string          `json:"actor,omitempty"`
+	Action  string          `json:"action"`
+	Entity  Entity          `json:"entity"`
+	Target  string          `json:"target"`
+	Forum   string          `json:"forum,omitempty"`
+	Before  json.RawMessage `json:"before,omitempty"`
+	After   json.RawMessage `json:"after,omitempty"`
+	Created time.Time       `json:"created"`
+}
+
+// AuditQuery is the query string of GET /api/audit. From and To are RFC 3339
+// bounds on Created, To exclusive; Since is the id of the last entry seen.
+type AuditQuery struct {
+	Actor  string `schema:"actor"`
+	Forum  string `schema:"forum"`
+	Action string `schema:"action"`
+	From   string `schema:"from"`
+	To     string `schema:"to"`
+	Limit  int    `schema:"limit"`
+	Since  int64  `schema:"since"`
+	Desc   bool   `schema:"desc"`
+}
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+type Credentials struct {
+	Nickname string `json:"nickname"`
+	Password string `json:"password"`
+}
+
+// Session is a login. Token is only known when the session is created; the
+// database keeps a hash of it.
+type Session struct {
+	Token    string    `json:"token,omitempty"`
+	Nickname string    `json:"nickname"`
+	Created  time.Time `json:"created"`
+	Expires  time.Time `json:"expires"`
+}
@@ -0,0 +1,47 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// Ban keeps a user from creating threads, posting and voting, everywhere or,
+// when Forum is set, in one forum. A ban without Expires is permanent. Bans
+// are never deleted: lifting one records who did it and when.
+type Ban struct {
+	ID       int        `json:"id"`
+	Nickname string     `json:"nickname"`
+	Forum    string     `json:"forum,omitempty"`
+	Reason   string     `json:"reason"`
+	IssuedBy string     `json:"issuedBy,omitempty"`
+	Created  time.Time  `json:"created"`
+	Expires  *time.Time `json:"expires,omitempty"`
+	Lifted   *time.Time `json:"lifted,omitempty"`
+	LiftedBy string     `json:"liftedBy,omitempty"`
+}
+
+// Forbidden describes the ban to the user it keeps out.
+func (b *Ban) Forbidden() *ForbiddenError {
+	reason := fmt.Sprintf("user %s is banned", b.Nickname)
+	if b.Forum != "" {
+		reason += " from forum " + b.Forum
+	}
+	if b.Expires != nil {
+		reason += " until " + b.Expires.UTC().Format(time.RFC3339)
+	}
+	if b.Reason != "" {
+		reason += ": " + b.Reason
+	}
+	return Forbidden(reason)
+}
+
+// BanQuery is the query string of GET /api/bans. Since is the id of the last
+// ban of the previous page.
+type BanQuery struct {
+	Nickname string `schema:"nickname"`
+	Forum    string `schema:"forum"`
+	Active   bool   `schema:"active"`
+	Limit    int    `schema:"limit"`
+	Since    int    `schema:"since"`
+	Desc     bool   `schema:"desc"`
+}
@@ -0,0 +1,135 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Entity names the kind of object an error is about.
+type Entity string
+
+const (
+	EntityUser      Entity = "user"
+	EntityForum     Entity = "forum"
+	EntityThread    Entity = "thread"
+	EntityPost      Entity = "post"
+	EntityRevision  Entity = "revision"
+	EntitySession   Entity = "session"
+	EntityModerator Entity = "moderator"
+	EntityBan       Entity = "ban"
+	EntityReport    Entity = "report"
+	EntityService   Entity = "service"
+	EntityWebhook   Entity = "webhook"
+)
+
+// NotFoundError reports that the entity identified by Key does not exist.
+type NotFoundError struct {
+	Entity Entity
+	Key    string
+}
+
+func NotFound(entity Entity, key string) *NotFoundError {
+	return &NotFoundError{Entity: entity, Key: key}
+}
+
+func (e *NotFoundError) Error() string {
+	if e.Key == "" {
+		return fmt.Sprintf("Can't find %s", e.Entity)
+	}
+	return fmt.Sprintf("Can't find %s %s", e.Entity, e.Key)
+}
+
+// ConflictError reports that a write clashes with stored data. Existing holds
+// the stored entity (or entities) when the conflict is a duplicate create.
+type ConflictError struct {
+	Entity   Entity
+	Existing interface{}
+	Reason   string
+}
+
+func Conflict(entity Entity, existing interface{}) *ConflictError {
+	return &ConflictError{Entity: entity, Existing: existing}
+}
+
+func (e *ConflictError) Error() string {
+	if e.Reason != "" {
+		return e.Reason
+	}
+	return fmt.Sprintf("%s already exists", e.Entity)
+}
+
+// ValidationError lists the request fields that were rejected and why.
+type ValidationError struct {
+	Fields map[string]string
+}
+
+func Invalid(field, reason string) *ValidationError {
+	return &ValidationError{Fields: map[string]string{field: reason}}
+}
+
+func (e *ValidationError) Error() string {
+	var parts []string
+	for field, reason := range e.Fields {
+		parts = append(parts, field+": "+reason)
+	}
+	sort.Strings(parts)
+	return "invalid request: " + strings.Join(parts, ", ")
+}
+
+// InternalError wraps a failure the client cannot fix.
+type InternalError struct {
+	Err error
+}
+
+func Internal(err error) *InternalError {
+	return &InternalError{Err: err}
+}
+
+func (e *InternalError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *InternalError) Unwrap() error {
+	return e.Err
+}
+
+// ItemError points at the element of a batch request that caused Err.
+type ItemError struct {
+	Index int
+	Err   error
+}
+
+func (e *ItemError) Error() string {
+	return fmt.Sprintf("item %d: %v", e.Index, e.Err)
+}
+
+func (e *ItemError) Unwrap() error {
+	return e.Err
+}
+
+// UnauthorizedError reports a request without valid credentials.
+type UnauthorizedError struct {
+	Reason string
+}
+
+func Unauthorized(reason string) *UnauthorizedError {
+	return &UnauthorizedError{Reason: reason}
+}
+
+func (e *UnauthorizedError) Error() string {
+	return e.Reason
+}
+
+// ForbiddenError reports an authenticated caller acting beyond its rights.
+type ForbiddenError struct {
+	Reason string
+}
+
+func Forbidden(reason string) *ForbiddenError {
+	return &ForbiddenError{Reason: reason}
+}
+
+func (e *ForbiddenError) Error() string {
+	return e.Reason
+}
@@ -0,0 +1,33 @@
+package models
+
+// Event describes a write to the subscribers of the live feed. Data is the
+// thread or post as the API returns it.
+type Event struct {
+	Type   string      `json:"type"`
+	Forum  string      `json:"forum"`
+	Thread int         `json:"thread"`
+	Data   interface{} `json:"data"`
+}
+
+const (
+	EventThreadCreated = "thread.created"
+	EventThreadUpdated = "thread.updated"
+	EventVoteChanged   = "vote.changed"
+	EventPostCreated   = "post.created"
+)
+
+// FeedRequest is a message from a feed client: Action is subscribe or
+// unsubscribe, and exactly one of Forum and Thread (a slug or id) is set.
+type FeedRequest struct {
+	Action string `json:"action"`
+	Forum  string `json:"forum,omitempty"`
+	Thread string `json:"thread,omitempty"`
+}
+
+// FeedReply answers a FeedRequest with the canonical forum slug or thread id.
+type FeedReply struct {
+	Type   string `json:"type"`
+	Forum  string `json:"forum,omitempty"`
+	Thread int    `json:"thread,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
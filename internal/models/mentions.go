@@ -0,0 +1,37 @@
+package models
+
+import (
+	"regexp"
+	"strings"
+)
+
+// MaxMentions bounds the mentions taken from one message.
+const MaxMentions = 50
+
+// mention matches @nickname where the @ does not follow a word character, so
+// that email addresses are not mentions.
+var mention = regexp.MustCompile(`(?:^|[^\w@])@([A-Za-z0-9_.]+)`)
+
+// ParseMentions returns the nicknames mentioned in message, in order of first
+// appearance and without case-insensitive duplicates. Whether they name
+// users is for the caller to check.
+func ParseMentions(message string) []string {
+	if !strings.Contains(message, "@") {
+		return nil
+	}
+	var nicknames []string
+	seen := map[string]bool{}
+	for _, m := range mention.FindAllStringSubmatch(message, -1) {
+		// A mention that ends a sentence is followed by a dot.
+		nickname := strings.TrimRight(m[1], ".")
+		if nickname == "" || seen[strings.ToLower(nickname)] {
+			continue
+		}
+		seen[strings.ToLower(nickname)] = true
+		nicknames = append(nicknames, nickname)
+		if len(nicknames) == MaxMentions {
+			break
+		}
+	}
+	return nicknames
+}
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParseMentions(t *testing.T) {
+	var many []string
+	for i := 0; i < MaxMentions+5; i++ {
+		many = append(many, "@u"+strconv.Itoa(i))
+	}
+	tests := []struct {
+		name    string
+		message string
+		want    []string
+	}{
+		{"none", "hello there", nil},
+		{"bare at", "meet @ noon", nil},
+		{"one", "@jo hi", []string{"jo"}},
+		{"order of appearance", "thanks @al and @jo", []string{"al", "jo"}},
+		{"comma", "@al, @jo: look", []string{"al", "jo"}},
+		{"end of sentence", "ask @jo.", []string{"jo"}},
+		{"brackets", "(cc @jo)", []string{"jo"}},
+		{"dots inside", "@jo.smith wrote", []string{"jo.smith"}},
+		{"underscores and digits", "@jo_2 @_x", []string{"jo_2", "_x"}},
+		{"duplicate", "@jo @jo", []string{"jo"}},
+		{"duplicate in another case", "@Jo and @jO", []string{"Jo"}},
+		{"email", "mail jo@example.com", nil},
+		{"email and mention", "jo@example.com or @al", []string{"al"}},
+		{"double at", "@@jo", nil},
+		{"newline", "line\n@jo", []string{"jo"}},
+		{"limit", strings.Join(many, " "), func() []string {
+			var want []string
+			for _, m := range many[:MaxMentions] {
+				want = append(want, m[1:])
+			}
+			return want
+		}()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseMentions(tt.message); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseMentions(%q) = %q, want %q", tt.message, got, tt.want)
+			}
+		})
+	}
+}
@@ -1,8 +1,11 @@
 package models
 
 import (
-	"encoding/json"
+	"fmt"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/diff"
 	"github.com/jackc/pgx/pgtype"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -18,6 +21,12 @@ type User struct {
 	FullName string `json:"fullname"`
 	About    string `json:"about"`
 	Email    string `json:"email"`
+	// PasswordHash is the bcrypt hash of the password, empty for accounts
+	// created without one.
+	PasswordHash string `json:"-"`
+	// Reputation sums the votes other users gave the user's threads and
+	// posts.
+	Reputation int `json:"reputation,omitempty"`
 }
 
 type Forum struct {
@@ -38,6 +47,44 @@ type Thread struct {
 	Votes   int       `json:"votes"`
 	Slug    string    `json:"slug"`
 	Created time.Time `json:"created"`
+	// A locked thread takes no new posts; an archived one takes neither posts
+	// nor votes. Pinned threads are listed first in their forum.
+	Locked   bool `json:"locked,omitempty"`
+	Pinned   bool `json:"pinned,omitempty"`
+	Archived bool `json:"archived,omitempty"`
+	// Posts counts the live posts of the thread and LastPostAt is the time of
+	// the newest. Only thread details and forum thread lists fill them.
+	Posts      int        `json:"posts,omitempty"`
+	LastPostAt *time.Time `json:"lastPostAt,omitempty"`
+	// Cursor is the since of the page after the thread. Only the ranking
+	// sorts of forum thread lists fill it.
+	Cursor string `json:"cursor,omitempty"`
+}
+
+// CheckOpen returns a conflict when the thread takes no new posts.
+func (t *Thread) CheckOpen() error {
+	switch {
+	case t.Archived:
+		return &ConflictError{Entity: EntityThread, Reason: fmt.Sprintf("Thread %d is archived", t.ID)}
+	case t.Locked:
+		return &ConflictError{Entity: EntityThread, Reason: fmt.Sprintf("Thread %d is locked", t.ID)}
+	}
+	return nil
+}
+
+// CheckVotable returns a conflict when the thread takes no votes.
+func (t *Thread) CheckVotable() error {
+	if t.Archived {
+		return &ConflictError{Entity: EntityThread, Reason: fmt.Sprintf("Thread %d is archived", t.ID)}
+	}
+	return nil
+}
+
+// ThreadState changes the flags of a thread. Nil fields are left as they are.
+type ThreadState struct {
+	Locked   *bool `json:"locked"`
+	Pinned   *bool `json:"pinned"`
+	Archived *bool `json:"archived"`
 }
 
 type ThreadWithoutSlug struct {
@@ -49,73 +96,225 @@ type ThreadWithoutSlug struct {
 	Votes   int       `json:"votes"`
 	Slug    string    `json:"-"`
 	Created time.Time `json:"created"`
+
+	Locked   bool `json:"locked,omitempty"`
+	Pinned   bool `json:"pinned,omitempty"`
+	Archived bool `json:"archived,omitempty"`
+
+	Posts      int        `json:"posts,omitempty"`
+	LastPostAt *time.Time `json:"lastPostAt,omitempty"`
+	Cursor     string     `json:"cursor,omitempty"`
 }
 
-func DeleteSlug(thread *Thread) *ThreadWithoutSlug{
+func DeleteSlug(thread *Thread) *ThreadWithoutSlug {
 	return &ThreadWithoutSlug{
-		ID:       thread.ID,
-		Title:    thread.Title,
-		Author:   thread.Author,
-		Forum:    thread.Forum,
-		Message:  thread.Message,
-		Votes:    thread.Votes,
-		Slug:     thread.Slug,
-		Created:  thread.Created,
+		ID:      thread.ID,
+		Title:   thread.Title,
+		Author:  thread.Author,
+		Forum:   thread.Forum,
+		Message: thread.Message,
+		Votes:   thread.Votes,
+		Slug:    thread.Slug,
+		Created: thread.Created,
+
+		Locked:   thread.Locked,
+		Pinned:   thread.Pinned,
+		Archived: thread.Archived,
+
+		Posts:      thread.Posts,
+		LastPostAt: thread.LastPostAt,
+		Cursor:     thread.Cursor,
 	}
 }
 
 type Post struct {
-	ID       	int          `json:"id"`
-	Author   	string       `json:"author"`
-	Created  	time.Time    `json:"created"`
-	Forum    	string       `json:"forum"`
-	IsEdited 	bool         `json:"isEdited"`
-	Message  	string       `json:"message"`
-	Parent   	int64    	 `json:"parent"`
-	Thread   	int          `json:"thread,"`
+	ID       int              `json:"id"`
+	Author   string           `json:"author"`
+	Created  time.Time        `json:"created"`
+	Forum    string           `json:"forum"`
+	IsEdited bool             `json:"isEdited"`
+	Message  string           `json:"message"`
+	Parent   int64            `json:"parent"`
+	Thread   int              `json:"thread,"`
 	Route    pgtype.Int8Array `json:"-"`
+	// IsDeleted marks a tombstone: the post was soft-deleted and its message
+	// replaced with DeletedMessage, but it keeps its place in the tree.
+	IsDeleted bool `json:"isDeleted,omitempty"`
+	// Score sums the votes on the post.
+	Score int `json:"score,omitempty"`
+	// Reactions counts the reactions to the post by key. Only the reads of
+	// thread posts and post details fill it.
+	Reactions map[string]int `json:"reactions,omitempty"`
+}
+
+// DeletedMessage is shown instead of the text of a soft-deleted post.
+const DeletedMessage = "[deleted]"
+
+// Tombstone hides the content of a soft-deleted post.
+func (p *Post) Tombstone() {
+	p.IsDeleted = true
+	p.Message = DeletedMessage
 }
 
 type PostUpdate struct {
-	ID       	int       	`json:"-"`
-	Message 	string 		`json:"message"`
+	ID      int    `json:"-"`
+	Message string `json:"message"`
+	Editor  string `json:"editor,omitempty"`
+}
+
+// PostRevision is the text a post had before its Revision-th edit.
+type PostRevision struct {
+	PostID   int       `json:"post"`
+	Revision int       `json:"revision"`
+	Message  string    `json:"message"`
+	Editor   string    `json:"editor,omitempty"`
+	Edited   time.Time `json:"edited"`
+}
+
+// PostDiff turns revision From of a post into revision To; revision 0 is the
+// current text.
+type PostDiff struct {
+	PostID int       `json:"post"`
+	From   int       `json:"from"`
+	To     int       `json:"to"`
+	Ops    []diff.Op `json:"ops"`
+}
+
+type PostRevert struct {
+	ID       int    `json:"-"`
+	Revision int    `json:"revision"`
+	Editor   string `json:"editor,omitempty"`
+}
+
+// ThreadSplit moves the subtree of posts rooted at Post into a new thread.
+// Message defaults to the text of the root post and Forum to the forum of the
+// thread being split.
+type ThreadSplit struct {
+	Post    int    `json:"-"`
+	Title   string `json:"title"`
+	Slug    string `json:"slug"`
+	Message string `json:"message"`
+	Forum   string `json:"forum"`
 }
 
 type PostInfo struct {
-	Author 		*User       `json:"author"`
-	Forum  		*Forum      `json:"forum"`
-	Post   		*Post       `json:"post"`
-	Thread 		interface{} `json:"thread"`
+	Author *User       `json:"author"`
+	Forum  *Forum      `json:"forum"`
+	Post   *Post       `json:"post"`
+	Thread interface{} `json:"thread"`
 }
 
+// Vote is a voice of 1 or -1 on a thread or, with Post set, on a post.
 type Vote struct {
-	Nickname 	string 		`json:"nickname"`
-	Voice    	int    		`json:"voice"`
-	Thread   	int    		`json:"-"`
+	Nickname string `json:"nickname"`
+	Voice    int    `json:"voice"`
+	Thread   int    `json:"-"`
+	Post     int    `json:"-"`
+}
+
+type Params struct {
+	Limit int    `json:"limit"`
+	Since string `json:"since"`
+	Desc  bool   `json:"desc"`
+	Sort  string `json:"sort"`
+	// Window limits sort=top of forum threads to those created in the last
+	// day, week, month or year.
+	Window string `json:"window"`
+}
+
+// Thread sorts of GetForumThreads besides the default by creation time. They
+// list the highest ranked threads first and page by ThreadCursor.
+const (
+	ThreadSortHot    = "hot"
+	ThreadSortTop    = "top"
+	ThreadSortActive = "active"
+)
+
+// ThreadCursor is the place of a thread in a ranking sort: pinned threads
+// first, then by Rank and by ID, highest first. Rank is the value the sort
+// ranks by: the hot score as a float64, the votes as an int or the time of
+// the last activity as a time.Time. The page after a thread lists the
+// threads placed after its cursor.
+type ThreadCursor struct {
+	Pinned bool
+	Rank   interface{}
+	ID     int
 }
 
-type Error struct {
-	Code 		int 		`json:"-"`
-	Message 	string 		`json:"message"`
+const pinnedCursor = "pinned_"
+
+// String formats the cursor as <rank>_<id>, prefixed with pinned_ for a
+// pinned thread.
+func (c *ThreadCursor) String() string {
+	var rank string
+	switch r := c.Rank.(type) {
+	case float64:
+		rank = strconv.FormatFloat(r, 'f', -1, 64)
+	case int:
+		rank = strconv.Itoa(r)
+	case time.Time:
+		rank = r.UTC().Format(time.RFC3339Nano)
+	}
+	cursor := rank + "_" + strconv.Itoa(c.ID)
+	if c.Pinned {
+		cursor = pinnedCursor + cursor
+	}
+	return cursor
 }
 
-func ErrorToJSON(error string) []byte{
-	bytes, err := json.Marshal(Error{Message: error})
+// ParseThreadCursor reads the cursor of a thread in sort.
+func ParseThreadCursor(sort, cursor string) (*ThreadCursor, error) {
+	invalid := Invalid("since", "must be the cursor of a thread in sort "+sort)
+	c := &ThreadCursor{}
+	if strings.HasPrefix(cursor, pinnedCursor) {
+		c.Pinned = true
+		cursor = cursor[len(pinnedCursor):]
+	}
+	sep := strings.LastIndexByte(cursor, '_')
+	if sep < 0 {
+		return nil, invalid
+	}
+	rank := cursor[:sep]
+	var err error
+	if c.ID, err = strconv.Atoi(cursor[sep+1:]); err != nil {
+		return nil, invalid
+	}
+	switch sort {
+	case ThreadSortHot:
+		c.Rank, err = strconv.ParseFloat(rank, 64)
+	case ThreadSortTop:
+		c.Rank, err = strconv.Atoi(rank)
+	case ThreadSortActive:
+		c.Rank, err = time.Parse(time.RFC3339Nano, rank)
+	default:
+		return nil, invalid
+	}
 	if err != nil {
-		return []byte("")
+		return nil, invalid
 	}
-	return bytes
+	return c, nil
 }
 
-type Params struct {
-	Limit 		int    		`json:"limit"`
-	Since 		string 		`json:"since"`
-	Desc  		bool   		`json:"desc"`
-	Sort 		string 		`json:"sort"`
+// WindowStart returns the earliest creation time of the threads that
+// sort=top ranks for window, the zero time when it ranks all of them.
+func WindowStart(window string, now time.Time) (time.Time, error) {
+	switch window {
+	case "", "all":
+		return time.Time{}, nil
+	case "day":
+		return now.AddDate(0, 0, -1), nil
+	case "week":
+		return now.AddDate(0, 0, -7), nil
+	case "month":
+		return now.AddDate(0, -1, 0), nil
+	case "year":
+		return now.AddDate(-1, 0, 0), nil
+	}
+	return time.Time{}, Invalid("window", "must be day, week, month, year or all")
 }
 
 type Related struct {
-	IsUser 		bool
-	IsForum 	bool
-	IsThread 	bool
-}
\ No newline at end of file
+	IsUser   bool
+	IsForum  bool
+	IsThread bool
+}
@@ -0,0 +1,53 @@
+package models
+
+import "time"
+
+// Subscription follows a thread (Thread set) or a forum (Forum set).
+type Subscription struct {
+	Nickname string    `json:"nickname"`
+	Forum    string    `json:"forum,omitempty"`
+	Thread   int       `json:"thread,omitempty"`
+	Created  time.Time `json:"created"`
+}
+
+// Notification tells a user about a post in a thread they follow, a thread in
+// a forum they follow or a post that mentions them. Thread and Forum are where
+// the content is now.
+type Notification struct {
+	ID      int64     `json:"id"`
+	Kind    string    `json:"kind"`
+	Forum   string    `json:"forum"`
+	Thread  int       `json:"thread"`
+	Post    int64     `json:"post,omitempty"`
+	Actor   string    `json:"actor"`
+	Created time.Time `json:"created"`
+	Read    bool      `json:"read"`
+}
+
+const (
+	NotificationPost    = "post"
+	NotificationThread  = "thread"
+	NotificationMention = "mention"
+)
+
+// Inbox is a page of notifications with the number of unread ones in total.
+type Inbox struct {
+	Unread        int             `json:"unread"`
+	Notifications []*Notification `json:"notifications"`
+}
+
+// NotificationQuery is the query string of the inbox. Since is the id of the
+// last notification seen; Unread keeps only unread notifications.
+type NotificationQuery struct {
+	Limit  int   `schema:"limit"`
+	Since  int64 `schema:"since"`
+	Desc   bool  `schema:"desc"`
+	Unread bool  `schema:"unread"`
+}
+
+// NotificationRead marks the notifications in IDs, or with All every
+// notification, as read.
+type NotificationRead struct {
+	IDs []int64 `json:"ids"`
+	All bool    `json:"all"`
+}
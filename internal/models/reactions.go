@@ -0,0 +1,27 @@
+package models
+
+import (
+	"regexp"
+	"time"
+)
+
+// Reaction is one user's reaction to a post. A user reacts at most once with
+// each key.
+type Reaction struct {
+	ID       int64     `json:"id"`
+	Post     int       `json:"post"`
+	Key      string    `json:"reaction"`
+	Nickname string    `json:"nickname"`
+	Created  time.Time `json:"created"`
+}
+
+// DefaultReactions are the reaction keys offered when none are configured.
+var DefaultReactions = []string{"like", "love", "laugh", "wow", "sad", "angry"}
+
+var reactionKey = regexp.MustCompile(`^[a-z0-9_+-]{1,32}$`)
+
+// ValidReactionKey reports whether key is usable as a reaction key: 1 to 32
+// lower-case letters, digits, '_', '+' or '-'.
+func ValidReactionKey(key string) bool {
+	return reactionKey.MatchString(key)
+}
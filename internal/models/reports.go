@@ -0,0 +1,47 @@
+package models
+
+import "time"
+
+// Report flags a post (Post set) or a whole thread for the moderators of its
+// forum. Thread and Forum are where the content is now, which changes when
+// threads are moved, merged or split.
+type Report struct {
+	ID        int        `json:"id"`
+	Post      int64      `json:"post,omitempty"`
+	Thread    int        `json:"thread"`
+	Forum     string     `json:"forum"`
+	Reporter  string     `json:"reporter"`
+	Reason    string     `json:"reason"`
+	Comment   string     `json:"comment,omitempty"`
+	Status    string     `json:"status"`
+	Created   time.Time  `json:"created"`
+	HandledBy string     `json:"handledBy,omitempty"`
+	Handled   *time.Time `json:"handled,omitempty"`
+}
+
+const (
+	ReportOpen      = "open"
+	ReportResolved  = "resolved"
+	ReportDismissed = "dismissed"
+)
+
+// ReportReasons are the categories a report is filed under.
+var ReportReasons = []string{"spam", "abuse", "off_topic", "illegal", "other"}
+
+func ValidReportReason(reason string) bool {
+	for _, r := range ReportReasons {
+		if r == reason {
+			return true
+		}
+	}
+	return false
+}
+
+// ReportQuery is the query string of the moderation queue. Status defaults
+// to open; Since is the id of the last report of the previous page.
+type ReportQuery struct {
+	Status string `schema:"status"`
+	Limit  int    `schema:"limit"`
+	Since  int    `schema:"since"`
+	Desc   bool   `schema:"desc"`
+}
@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// Role is the site-wide role of a user. Moderators are not a site role: a
+// member moderates the forums listed in UserRole.Moderates.
+type Role string
+
+const (
+	RoleAdmin  Role = "admin"
+	RoleMember Role = "member"
+	RoleBanned Role = "banned"
+)
+
+func (r Role) Valid() bool {
+	return r == RoleAdmin || r == RoleMember || r == RoleBanned
+}
+
+type UserRole struct {
+	Nickname  string   `json:"nickname"`
+	Role      Role     `json:"role"`
+	Moderates []string `json:"moderates"`
+}
+
+type Moderator struct {
+	Forum     string    `json:"forum"`
+	Nickname  string    `json:"nickname"`
+	GrantedBy string    `json:"grantedBy,omitempty"`
+	Created   time.Time `json:"created"`
+}
@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+// SearchQuery is the query string of GET /api/search. Limit, Since and Desc
+// follow Params: Since is the id of the last result of the previous page.
+type SearchQuery struct {
+	Query  string `schema:"q"`
+	Type   string `schema:"type"`
+	Forum  string `schema:"forum"`
+	Thread string `schema:"thread"`
+	Author string `schema:"author"`
+	From   string `schema:"from"`
+	To     string `schema:"to"`
+	Limit  int    `schema:"limit"`
+	Since  string `schema:"since"`
+	Desc   bool   `schema:"desc"`
+	Sort   string `schema:"sort"`
+}
+
+type SearchResult struct {
+	Type    string    `json:"type"`
+	ID      int       `json:"id"`
+	Thread  int       `json:"thread"`
+	Forum   string    `json:"forum"`
+	Author  string    `json:"author"`
+	Title   string    `json:"title,omitempty"`
+	Snippet string    `json:"snippet"`
+	Rank    float32   `json:"rank"`
+	Created time.Time `json:"created"`
+}
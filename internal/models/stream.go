@@ -0,0 +1,15 @@
+package models
+
+// PostNotice tells the streams of a thread that its posts changed. For
+// PostCreated, Post is the highest id a write added to Thread; for PostEdited
+// it is the post whose message was edited, reverted or deleted.
+type PostNotice struct {
+	Event  string `json:"event"`
+	Thread int    `json:"thread"`
+	Post   int    `json:"post"`
+}
+
+const (
+	PostCreated = "post"
+	PostEdited  = "edit"
+)
@@ -0,0 +1,69 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Webhook posts the events of a forum to URL. Secret keys the signature of
+// every delivery; it is shown only in the response that creates the webhook.
+type Webhook struct {
+	ID        int       `json:"id"`
+	Forum     string    `json:"forum"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	Events    []string  `json:"events"`
+	CreatedBy string    `json:"createdBy,omitempty"`
+	Created   time.Time `json:"created"`
+}
+
+// WebhookEvents are the event types a webhook may subscribe to.
+var WebhookEvents = []string{EventThreadCreated, EventThreadUpdated, EventVoteChanged, EventPostCreated}
+
+func ValidWebhookEvent(event string) bool {
+	for _, e := range WebhookEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDelivery is one event queued for one webhook, with the outcome of
+// its latest attempt.
+type WebhookDelivery struct {
+	ID             int64           `json:"id"`
+	Webhook        int             `json:"webhook"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttempt    *time.Time      `json:"nextAttempt,omitempty"`
+	LastAttempt    *time.Time      `json:"lastAttempt,omitempty"`
+	ResponseStatus int             `json:"responseStatus,omitempty"`
+	Error          string          `json:"error,omitempty"`
+	Created        time.Time       `json:"created"`
+	Delivered      *time.Time      `json:"delivered,omitempty"`
+}
+
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// WebhookTask is a delivery claimed by a worker, with where to send it.
+type WebhookTask struct {
+	*WebhookDelivery
+	URL    string
+	Secret string
+}
+
+// DeliveryQuery is the query string of the delivery log. Since is the id of
+// the last delivery seen.
+type DeliveryQuery struct {
+	Status string `schema:"status"`
+	Limit  int    `schema:"limit"`
+	Since  int64  `schema:"since"`
+	Desc   bool   `schema:"desc"`
+}
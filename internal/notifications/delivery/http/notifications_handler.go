@@ -0,0 +1,209 @@
+package delivery
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/dantedoyl/Tech_DB_Forum/internal/auth"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/models"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/notifications"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/response"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/roles"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/schema"
+)
+
+type NotificationsHandler struct {
+	NotificationRepo notifications.NotificationRepository
+	AuthRepo         auth.AuthRepository
+	Authorizer       *roles.Authorizer
+	RequireAuth      bool
+}
+
+func NewNotificationsHandler(r *mux.Router, notificationRepo notifications.NotificationRepository,
+	authRepo auth.AuthRepository, authorizer *roles.Authorizer, requireAuth bool) *NotificationsHandler {
+	nh := &NotificationsHandler{NotificationRepo: notificationRepo, AuthRepo: authRepo, Authorizer: authorizer,
+		RequireAuth: requireAuth}
+	r.HandleFunc("/thread/{slug_or_id}/subscription", nh.SubscribeThread).Methods(http.MethodPut).Name("thread.subscribe")
+	r.HandleFunc("/thread/{slug_or_id}/subscription", nh.UnsubscribeThread).Methods(http.MethodDelete).Name("thread.unsubscribe")
+	r.HandleFunc("/forum/{slug}/subscription", nh.SubscribeForum).Methods(http.MethodPut).Name("forum.subscribe")
+	r.HandleFunc("/forum/{slug}/subscription", nh.UnsubscribeForum).Methods(http.MethodDelete).Name("forum.unsubscribe")
+	r.HandleFunc("/user/{nickname}/subscriptions", nh.Subscriptions).Methods(http.MethodGet).Name("user.subscriptions")
+	r.HandleFunc("/user/{nickname}/notifications", nh.Inbox).Methods(http.MethodGet).Name("user.notifications")
+	r.HandleFunc("/user/{nickname}/notifications/read", nh.MarkRead).Methods(http.MethodPost).Name("user.notifications.read")
+	return nh
+}
+
+// subscriber is the authenticated caller or, when auth is not required, the
+// user named by the nickname parameter if that user has no password.
+// Banned users are rejected either way.
+func (nh *NotificationsHandler) subscriber(r *http.Request) (string, error) {
+	if nickname, ok := auth.Nickname(r.Context()); ok {
+		return nickname, nh.Authorizer.Active(r.Context(), nickname)
+	}
+	if nh.RequireAuth {
+		return "", models.Unauthorized("authentication required")
+	}
+	nickname := r.URL.Query().Get("nickname")
+	if nickname == "" {
+		return "", models.Invalid("nickname", "is required")
+	}
+	if err := auth.CheckClaim(r.Context(), nh.AuthRepo, nickname); err != nil {
+		return "", err
+	}
+	return nickname, nh.Authorizer.Active(r.Context(), nickname)
+}
+
+// self checks that the caller may read the account of nickname.
+func (nh *NotificationsHandler) self(r *http.Request, nickname string) error {
+	caller, ok := auth.Nickname(r.Context())
+	if !ok {
+		if nh.RequireAuth {
+			return models.Unauthorized("authentication required")
+		}
+		return auth.CheckClaim(r.Context(), nh.AuthRepo, nickname)
+	}
+	if !strings.EqualFold(caller, nickname) {
+		return models.Forbidden("only the account owner may do this")
+	}
+	return nil
+}
+
+func (nh *NotificationsHandler) SubscribeThread(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	nickname, err := nh.subscriber(r)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	sub, err := nh.NotificationRepo.SubscribeThread(r.Context(), nickname, mux.Vars(r)["slug_or_id"])
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	response.WriteJSON(w, http.StatusOK, sub)
+}
+
+func (nh *NotificationsHandler) UnsubscribeThread(w http.ResponseWriter, r *http.Request) {
+	nickname, err := nh.subscriber(r)
+	if err == nil {
+		err = nh.NotificationRepo.UnsubscribeThread(r.Context(), nickname, mux.Vars(r)["slug_or_id"])
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		response.WriteError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (nh *NotificationsHandler) SubscribeForum(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	nickname, err := nh.subscriber(r)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	sub, err := nh.NotificationRepo.SubscribeForum(r.Context(), nickname, mux.Vars(r)["slug"])
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	response.WriteJSON(w, http.StatusOK, sub)
+}
+
+func (nh *NotificationsHandler) UnsubscribeForum(w http.ResponseWriter, r *http.Request) {
+	nickname, err := nh.subscriber(r)
+	if err == nil {
+		err = nh.NotificationRepo.UnsubscribeForum(r.Context(), nickname, mux.Vars(r)["slug"])
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		response.WriteError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (nh *NotificationsHandler) Subscriptions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	nickname := mux.Vars(r)["nickname"]
+
+	if err := nh.self(r, nickname); err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	subs, err := nh.NotificationRepo.GetSubscriptions(r.Context(), nickname)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	response.WriteJSON(w, http.StatusOK, subs)
+}
+
+// Inbox returns a page of the user's notifications with their unread count.
+func (nh *NotificationsHandler) Inbox(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	nickname := mux.Vars(r)["nickname"]
+
+	if err := nh.self(r, nickname); err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	query := &models.NotificationQuery{}
+	decoder := schema.NewDecoder()
+	decoder.IgnoreUnknownKeys(true)
+	if err := decoder.Decode(query, r.URL.Query()); err != nil {
+		response.WriteError(w, models.Invalid("query", err.Error()))
+		return
+	}
+
+	inbox, err := nh.NotificationRepo.GetInbox(r.Context(), nickname, query)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	response.WriteJSON(w, http.StatusOK, inbox)
+}
+
+// MarkRead marks notifications as read and answers with the unread count.
+func (nh *NotificationsHandler) MarkRead(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	nickname := mux.Vars(r)["nickname"]
+
+	if err := nh.self(r, nickname); err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	read := &models.NotificationRead{}
+	if err := json.NewDecoder(r.Body).Decode(read); err != nil {
+		response.WriteError(w, response.InvalidBody(err))
+		return
+	}
+	if !read.All && len(read.IDs) == 0 {
+		response.WriteError(w, models.Invalid("ids", "are required unless all is set"))
+		return
+	}
+
+	unread, err := nh.NotificationRepo.MarkRead(r.Context(), nickname, read)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	response.WriteJSON(w, http.StatusOK, map[string]int{"unread": unread})
+}
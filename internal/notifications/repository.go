@@ -0,0 +1,20 @@
+package notifications
+
+import (
+	"context"
+
+	"github.com/dantedoyl/Tech_DB_Forum/internal/models"
+)
+
+// NotificationRepository stores what users follow and their inboxes. The
+// database fills the inboxes as posts and threads are created.
+type NotificationRepository interface {
+	SubscribeThread(ctx context.Context, nickname string, slugOrID string) (*models.Subscription, error)
+	UnsubscribeThread(ctx context.Context, nickname string, slugOrID string) error
+	SubscribeForum(ctx context.Context, nickname string, slug string) (*models.Subscription, error)
+	UnsubscribeForum(ctx context.Context, nickname string, slug string) error
+	GetSubscriptions(ctx context.Context, nickname string) ([]*models.Subscription, error)
+	GetInbox(ctx context.Context, nickname string, query *models.NotificationQuery) (*models.Inbox, error)
+	// MarkRead returns the number of notifications still unread.
+	MarkRead(ctx context.Context, nickname string, read *models.NotificationRead) (int, error)
+}
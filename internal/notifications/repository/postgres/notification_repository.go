@@ -0,0 +1,273 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/dantedoyl/Tech_DB_Forum/internal/models"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/pgtx"
+	"github.com/jackc/pgx"
+	"github.com/jackc/pgx/pgtype"
+)
+
+const defaultLimit = 100
+
+type NotificationRepository struct {
+	dbConn *pgx.ConnPool
+}
+
+func NewNotificationRepository(conn *pgx.ConnPool) *NotificationRepository {
+	return &NotificationRepository{dbConn: conn}
+}
+
+// conn is the transaction ctx carries, or the pool.
+func (nr NotificationRepository) conn(ctx context.Context) pgtx.Queryer {
+	return pgtx.Conn(ctx, nr.dbConn)
+}
+
+// user returns the canonical spelling of nickname.
+func (nr NotificationRepository) user(ctx context.Context, nickname string) (string, error) {
+	err := nr.conn(ctx).QueryRowEx(ctx, `SELECT nickname FROM users WHERE nickname=$1`, nil, nickname).Scan(&nickname)
+	if err == pgx.ErrNoRows {
+		return "", models.NotFound(models.EntityUser, nickname)
+	}
+	if err != nil {
+		return "", models.Internal(err)
+	}
+	return nickname, nil
+}
+
+func (nr NotificationRepository) threadID(ctx context.Context, slugOrID string) (int, error) {
+	condition, param := `slug=$1`, interface{}(slugOrID)
+	if id, err := strconv.Atoi(slugOrID); err == nil {
+		condition, param = `id=$1`, id
+	}
+
+	var id int
+	err := nr.conn(ctx).QueryRowEx(ctx, `SELECT id FROM thread WHERE `+condition+` AND deleted_at IS NULL`, nil, param).Scan(&id)
+	if err == pgx.ErrNoRows {
+		return 0, models.NotFound(models.EntityThread, slugOrID)
+	}
+	if err != nil {
+		return 0, models.Internal(err)
+	}
+	return id, nil
+}
+
+func (nr NotificationRepository) forumSlug(ctx context.Context, slug string) (string, error) {
+	err := nr.conn(ctx).QueryRowEx(ctx, `SELECT slug FROM forum WHERE slug=$1`, nil, slug).Scan(&slug)
+	if err == pgx.ErrNoRows {
+		return "", models.NotFound(models.EntityForum, slug)
+	}
+	if err != nil {
+		return "", models.Internal(err)
+	}
+	return slug, nil
+}
+
+// SubscribeThread is idempotent; it returns the existing subscription if
+// there is one.
+func (nr NotificationRepository) SubscribeThread(ctx context.Context, nickname string, slugOrID string) (*models.Subscription, error) {
+	nickname, err := nr.user(ctx, nickname)
+	if err != nil {
+		return nil, err
+	}
+	threadID, err := nr.threadID(ctx, slugOrID)
+	if err != nil {
+		return nil, err
+	}
+
+	sub := &models.Subscription{Nickname: nickname, Thread: threadID}
+	err = nr.conn(ctx).QueryRowEx(ctx, `WITH inserted AS (
+			INSERT INTO thread_subscriptions(nickname, thread) VALUES ($1, $2)
+			ON CONFLICT DO NOTHING RETURNING created)
+		SELECT created FROM inserted
+		UNION ALL
+		SELECT created FROM thread_subscriptions WHERE nickname=$1 AND thread=$2`,
+		nil, nickname, threadID).Scan(&sub.Created)
+	if err != nil {
+		return nil, models.Internal(err)
+	}
+	return sub, nil
+}
+
+func (nr NotificationRepository) UnsubscribeThread(ctx context.Context, nickname string, slugOrID string) error {
+	threadID, err := nr.threadID(ctx, slugOrID)
+	if err != nil {
+		return err
+	}
+	_, err = nr.conn(ctx).ExecEx(ctx, `DELETE FROM thread_subscriptions WHERE nickname=$1 AND thread=$2`,
+		nil, nickname, threadID)
+	if err != nil {
+		return models.Internal(err)
+	}
+	return nil
+}
+
+// SubscribeForum is idempotent; it returns the existing subscription if
+// there is one.
+func (nr NotificationRepository) SubscribeForum(ctx context.Context, nickname string, slug string) (*models.Subscription, error) {
+	nickname, err := nr.user(ctx, nickname)
+	if err != nil {
+		return nil, err
+	}
+	slug, err = nr.forumSlug(ctx, slug)
+	if err != nil {
+		return nil, err
+	}
+
+	sub := &models.Subscription{Nickname: nickname, Forum: slug}
+	err = nr.conn(ctx).QueryRowEx(ctx, `WITH inserted AS (
+			INSERT INTO forum_subscriptions(nickname, forum) VALUES ($1, $2)
+			ON CONFLICT DO NOTHING RETURNING created)
+		SELECT created FROM inserted
+		UNION ALL
+		SELECT created FROM forum_subscriptions WHERE nickname=$1 AND forum=$2`,
+		nil, nickname, slug).Scan(&sub.Created)
+	if err != nil {
+		return nil, models.Internal(err)
+	}
+	return sub, nil
+}
+
+func (nr NotificationRepository) UnsubscribeForum(ctx context.Context, nickname string, slug string) error {
+	slug, err := nr.forumSlug(ctx, slug)
+	if err != nil {
+		return err
+	}
+	_, err = nr.conn(ctx).ExecEx(ctx, `DELETE FROM forum_subscriptions WHERE nickname=$1 AND forum=$2`,
+		nil, nickname, slug)
+	if err != nil {
+		return models.Internal(err)
+	}
+	return nil
+}
+
+// GetSubscriptions lists the forums and threads a user follows, oldest first.
+func (nr NotificationRepository) GetSubscriptions(ctx context.Context, nickname string) ([]*models.Subscription, error) {
+	nickname, err := nr.user(ctx, nickname)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := nr.conn(ctx).QueryEx(ctx, `SELECT forum, 0, created FROM forum_subscriptions WHERE nickname=$1
+		UNION ALL
+		SELECT '', thread, created FROM thread_subscriptions WHERE nickname=$1
+		ORDER BY created`, nil, nickname)
+	if err != nil {
+		return nil, models.Internal(err)
+	}
+	defer rows.Close()
+
+	subs := []*models.Subscription{}
+	for rows.Next() {
+		sub := &models.Subscription{Nickname: nickname}
+		if err := rows.Scan(&sub.Forum, &sub.Thread, &sub.Created); err != nil {
+			return nil, models.Internal(err)
+		}
+		subs = append(subs, sub)
+	}
+	if rows.Err() != nil {
+		return nil, models.Internal(rows.Err())
+	}
+	return subs, nil
+}
+
+func (nr NotificationRepository) unread(ctx context.Context, nickname string) (int, error) {
+	var unread int
+	err := nr.conn(ctx).QueryRowEx(ctx, `SELECT count(*) FROM notifications WHERE nickname=$1 AND read_at IS NULL`,
+		nil, nickname).Scan(&unread)
+	if err != nil {
+		return 0, models.Internal(err)
+	}
+	return unread, nil
+}
+
+// GetInbox pages through the notifications of a user by id.
+func (nr NotificationRepository) GetInbox(ctx context.Context, nickname string, query *models.NotificationQuery) (*models.Inbox, error) {
+	nickname, err := nr.user(ctx, nickname)
+	if err != nil {
+		return nil, err
+	}
+
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	where := `n.nickname = ` + arg(nickname)
+	if query.Unread {
+		where += ` AND n.read_at IS NULL`
+	}
+	order := `n.id`
+	if query.Desc {
+		order = `n.id DESC`
+		if query.Since > 0 {
+			where += ` AND n.id < ` + arg(query.Since)
+		}
+	} else if query.Since > 0 {
+		where += ` AND n.id > ` + arg(query.Since)
+	}
+	limit := query.Limit
+	if limit <= 0 {
+		limit = defaultLimit
+	}
+
+	// Posts may have moved since, by a merge or split.
+	sql := fmt.Sprintf(`SELECT n.id, n.kind, t.forum, t.id, COALESCE(n.post, 0), n.actor, n.created,
+			n.read_at IS NOT NULL
+		FROM notifications AS n
+		LEFT JOIN post AS p ON p.id = n.post
+		JOIN thread AS t ON t.id = COALESCE(p.thread, n.thread)
+		WHERE %s ORDER BY %s LIMIT %s`, where, order, arg(limit))
+	rows, err := nr.conn(ctx).QueryEx(ctx, sql, nil, args...)
+	if err != nil {
+		return nil, models.Internal(err)
+	}
+	defer rows.Close()
+
+	inbox := &models.Inbox{Notifications: []*models.Notification{}}
+	for rows.Next() {
+		n := &models.Notification{}
+		err = rows.Scan(&n.ID, &n.Kind, &n.Forum, &n.Thread, &n.Post, &n.Actor, &n.Created, &n.Read)
+		if err != nil {
+			return nil, models.Internal(err)
+		}
+		inbox.Notifications = append(inbox.Notifications, n)
+	}
+	if rows.Err() != nil {
+		return nil, models.Internal(rows.Err())
+	}
+
+	inbox.Unread, err = nr.unread(ctx, nickname)
+	if err != nil {
+		return nil, err
+	}
+	return inbox, nil
+}
+
+// MarkRead ignores ids that are not the user's or already read.
+func (nr NotificationRepository) MarkRead(ctx context.Context, nickname string, read *models.NotificationRead) (int, error) {
+	nickname, err := nr.user(ctx, nickname)
+	if err != nil {
+		return 0, err
+	}
+
+	if read.All {
+		_, err = nr.conn(ctx).ExecEx(ctx, `UPDATE notifications SET read_at=now()
+			WHERE nickname=$1 AND read_at IS NULL`, nil, nickname)
+	} else {
+		ids := pgtype.Int8Array{}
+		if err := ids.Set(read.IDs); err != nil {
+			return 0, models.Internal(err)
+		}
+		_, err = nr.conn(ctx).ExecEx(ctx, `UPDATE notifications SET read_at=now()
+			WHERE nickname=$1 AND read_at IS NULL AND id = ANY($2::bigint[])`, nil, nickname, &ids)
+	}
+	if err != nil {
+		return 0, models.Internal(err)
+	}
+	return nr.unread(ctx, nickname)
+}
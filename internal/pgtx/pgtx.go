@@ -0,0 +1,133 @@
+// Package pgtx lets Postgres repositories share a transaction through a
+// context, so that a write and the rows recorded about it, such as its audit
+// entry or its webhook deliveries, commit or roll back together.
+//
+// Run begins the shared transaction. Repositories take their connection from
+// Conn and begin their own transactions with Begin, which becomes a savepoint
+// of the shared transaction when the context carries one.
+package pgtx
+
+import (
+	"context"
+
+	"github.com/jackc/pgx"
+)
+
+// Queryer is the query side shared by *pgx.ConnPool, *pgx.Tx and *Tx.
+type Queryer interface {
+	ExecEx(ctx context.Context, sql string, options *pgx.QueryExOptions, args ...interface{}) (pgx.CommandTag, error)
+	QueryEx(ctx context.Context, sql string, options *pgx.QueryExOptions, args ...interface{}) (*pgx.Rows, error)
+	QueryRowEx(ctx context.Context, sql string, options *pgx.QueryExOptions, args ...interface{}) *pgx.Row
+}
+
+type txKey struct{}
+
+func shared(ctx context.Context) (*pgx.Tx, bool) {
+	tx, ok := ctx.Value(txKey{}).(*pgx.Tx)
+	return tx, ok
+}
+
+// Run calls fn with a context carrying a transaction begun on pool and
+// commits it if fn succeeds. Within a context that already carries one, fn
+// joins it.
+func Run(ctx context.Context, pool *pgx.ConnPool, fn func(ctx context.Context) error) error {
+	if _, ok := shared(ctx); ok {
+		return fn(ctx)
+	}
+	tx, err := pool.BeginEx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(context.WithValue(ctx, txKey{}, tx)); err != nil {
+		return err
+	}
+	return tx.CommitEx(ctx)
+}
+
+// Conn returns the transaction ctx carries, or pool.
+func Conn(ctx context.Context, pool *pgx.ConnPool) Queryer {
+	if tx, ok := shared(ctx); ok {
+		return tx
+	}
+	return pool
+}
+
+// Savepoint calls fn with the connection of ctx. In a shared transaction fn
+// runs in a savepoint that is rolled back if fn fails, so that a statement
+// expected to fail, such as an insert that may conflict, leaves the
+// transaction usable for the queries that explain the failure.
+func Savepoint(ctx context.Context, pool *pgx.ConnPool, fn func(q Queryer) error) error {
+	if _, ok := shared(ctx); !ok {
+		return fn(pool)
+	}
+	tx, err := Begin(ctx, pool)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.CommitEx(ctx)
+}
+
+// Tx is the transaction of a single repository method: a transaction of its
+// own, or a savepoint in the shared transaction.
+type Tx struct {
+	Queryer
+	tx   *pgx.Tx
+	done bool
+}
+
+const savepoint = `pgtx`
+
+// Begin starts the transaction of a repository method.
+func Begin(ctx context.Context, pool *pgx.ConnPool) (*Tx, error) {
+	if outer, ok := shared(ctx); ok {
+		if _, err := outer.ExecEx(ctx, `SAVEPOINT `+savepoint, nil); err != nil {
+			return nil, err
+		}
+		return &Tx{Queryer: outer}, nil
+	}
+	tx, err := pool.BeginEx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{Queryer: tx, tx: tx}, nil
+}
+
+// CommitEx commits the transaction or releases the savepoint.
+func (t *Tx) CommitEx(ctx context.Context) error {
+	if t.tx != nil {
+		return t.tx.CommitEx(ctx)
+	}
+	if t.done {
+		return nil
+	}
+	t.done = true
+	_, err := t.ExecEx(ctx, `RELEASE SAVEPOINT `+savepoint, nil)
+	return err
+}
+
+// Rollback undoes the transaction or rolls back to the savepoint and releases
+// it. Like pgx.Tx.Rollback it does nothing after a commit, so it can be
+// deferred.
+func (t *Tx) Rollback() error {
+	if t.tx != nil {
+		return t.tx.Rollback()
+	}
+	if t.done {
+		return nil
+	}
+	t.done = true
+	// The request context may be done already; the savepoint must go anyway.
+	ctx := context.Background()
+	if _, err := t.ExecEx(ctx, `ROLLBACK TO SAVEPOINT `+savepoint, nil); err != nil {
+		return err
+	}
+	_, err := t.ExecEx(ctx, `RELEASE SAVEPOINT `+savepoint, nil)
+	return err
+}
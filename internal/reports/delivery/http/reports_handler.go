@@ -0,0 +1,226 @@
+package delivery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/dantedoyl/Tech_DB_Forum/internal/auth"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/forum"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/models"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/reports"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/response"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/roles"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/schema"
+)
+
+type ReportsHandler struct {
+	ReportRepo  reports.ReportRepository
+	ForumRepo   forum.ForumRepository
+	AuthRepo    auth.AuthRepository
+	Authorizer  *roles.Authorizer
+	RequireAuth bool
+}
+
+func NewReportsHandler(r *mux.Router, reportRepo reports.ReportRepository, forumRepo forum.ForumRepository,
+	authRepo auth.AuthRepository, authorizer *roles.Authorizer, requireAuth bool) *ReportsHandler {
+	rh := &ReportsHandler{ReportRepo: reportRepo, ForumRepo: forumRepo, AuthRepo: authRepo, Authorizer: authorizer,
+		RequireAuth: requireAuth}
+	r.HandleFunc("/post/{id}/report", rh.ReportPost).Methods(http.MethodPost).Name("post.report")
+	r.HandleFunc("/thread/{slug_or_id}/report", rh.ReportThread).Methods(http.MethodPost).Name("thread.report")
+	r.HandleFunc("/forum/{slug}/reports", rh.ForumReports).Methods(http.MethodGet).Name("forum.reports")
+	r.HandleFunc("/report/{id:[0-9]+}", rh.Report).Methods(http.MethodGet).Name("report.details")
+	r.HandleFunc("/report/{id:[0-9]+}/resolve", rh.ResolveReport).Methods(http.MethodPost).Name("report.resolve")
+	r.HandleFunc("/report/{id:[0-9]+}/dismiss", rh.DismissReport).Methods(http.MethodPost).Name("report.dismiss")
+	return rh
+}
+
+// reporter is the authenticated caller or, when auth is not required, the
+// reporter named in the body if that user has no password.
+// Banned users are rejected either way.
+func (rh *ReportsHandler) reporter(r *http.Request, claimed string) (string, error) {
+	if nickname, ok := auth.Nickname(r.Context()); ok {
+		return nickname, rh.Authorizer.Active(r.Context(), nickname)
+	}
+	if rh.RequireAuth {
+		return "", models.Unauthorized("authentication required")
+	}
+	if claimed == "" {
+		return "", models.Invalid("reporter", "is required")
+	}
+	if err := auth.CheckClaim(r.Context(), rh.AuthRepo, claimed); err != nil {
+		return "", err
+	}
+	return claimed, rh.Authorizer.Active(r.Context(), claimed)
+}
+
+// moderator returns the caller if it moderates forum.
+func (rh *ReportsHandler) moderator(r *http.Request, forum string) (string, error) {
+	caller, ok := auth.Nickname(r.Context())
+	if !ok {
+		return "", models.Unauthorized("authentication required")
+	}
+	return caller, rh.Authorizer.Moderator(r.Context(), caller, forum)
+}
+
+func (rh *ReportsHandler) decodeReport(r *http.Request) (*models.Report, error) {
+	report := &models.Report{}
+	if err := json.NewDecoder(r.Body).Decode(report); err != nil {
+		return nil, response.InvalidBody(err)
+	}
+	reporter, err := rh.reporter(r, report.Reporter)
+	if err != nil {
+		return nil, err
+	}
+	return &models.Report{Reporter: reporter, Reason: report.Reason, Comment: report.Comment}, nil
+}
+
+func (rh *ReportsHandler) ReportPost(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	id, _ := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+
+	report, err := rh.decodeReport(r)
+	if err == nil {
+		report.Post = id
+		err = rh.ReportRepo.ReportPost(r.Context(), report)
+	}
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	response.WriteJSON(w, http.StatusCreated, report)
+}
+
+func (rh *ReportsHandler) ReportThread(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	slugOrID := mux.Vars(r)["slug_or_id"]
+
+	report, err := rh.decodeReport(r)
+	if err == nil {
+		err = rh.ReportRepo.ReportThread(r.Context(), slugOrID, report)
+	}
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	response.WriteJSON(w, http.StatusCreated, report)
+}
+
+// ForumReports is the moderation queue of a forum.
+func (rh *ReportsHandler) ForumReports(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	slug := mux.Vars(r)["slug"]
+
+	query := &models.ReportQuery{}
+	decoder := schema.NewDecoder()
+	decoder.IgnoreUnknownKeys(true)
+	if err := decoder.Decode(query, r.URL.Query()); err != nil {
+		response.WriteError(w, models.Invalid("query", err.Error()))
+		return
+	}
+
+	if _, err := rh.moderator(r, slug); err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	list, err := rh.ReportRepo.GetForumReports(r.Context(), slug, query)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	response.WriteJSON(w, http.StatusOK, list)
+}
+
+func (rh *ReportsHandler) Report(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	report, err := rh.ReportRepo.GetReport(r.Context(), id)
+	if err == nil {
+		_, err = rh.moderator(r, report.Forum)
+	}
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	response.WriteJSON(w, http.StatusOK, report)
+}
+
+// ResolveReport accepts a report. With {"hide": true} the reported post or
+// thread is deleted as well.
+func (rh *ReportsHandler) ResolveReport(w http.ResponseWriter, r *http.Request) {
+	body := &struct {
+		Hide bool `json:"hide"`
+	}{}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(body); err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			response.WriteError(w, response.InvalidBody(err))
+			return
+		}
+	}
+	rh.closeReport(w, r, models.ReportResolved, body.Hide)
+}
+
+func (rh *ReportsHandler) DismissReport(w http.ResponseWriter, r *http.Request) {
+	rh.closeReport(w, r, models.ReportDismissed, false)
+}
+
+// hide deletes the post or thread of report. Content that is gone already
+// needs no hiding.
+func (rh *ReportsHandler) hide(ctx context.Context, report *models.Report) error {
+	var err error
+	if report.Post != 0 {
+		err = rh.ForumRepo.DeletePost(ctx, int(report.Post))
+	} else {
+		err = rh.ForumRepo.DeleteThread(ctx, strconv.Itoa(report.Thread))
+	}
+	var notFound *models.NotFoundError
+	if errors.As(err, &notFound) {
+		return nil
+	}
+	return err
+}
+
+func (rh *ReportsHandler) closeReport(w http.ResponseWriter, r *http.Request, status string, hide bool) {
+	w.Header().Set("Content-Type", "application/json")
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	report, err := rh.ReportRepo.GetReport(r.Context(), id)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+	caller, err := rh.moderator(r, report.Forum)
+	if err == nil && report.Status != models.ReportOpen {
+		err = &models.ConflictError{Entity: models.EntityReport,
+			Reason: fmt.Sprintf("Report %d is already %s", id, report.Status)}
+	}
+	if err == nil {
+		// Hiding the content and closing the report commit together.
+		err = rh.ReportRepo.Atomic(r.Context(), func(ctx context.Context) error {
+			if hide {
+				if err := rh.hide(ctx, report); err != nil {
+					return err
+				}
+			}
+			report, err = rh.ReportRepo.CloseReport(ctx, id, status, caller)
+			return err
+		})
+	}
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	response.WriteJSON(w, http.StatusOK, report)
+}
@@ -0,0 +1,20 @@
+package reports
+
+import (
+	"context"
+
+	"github.com/dantedoyl/Tech_DB_Forum/internal/models"
+)
+
+// ReportRepository stores the reports members file about posts and threads
+// and the moderators' decisions on them.
+type ReportRepository interface {
+	ReportPost(ctx context.Context, report *models.Report) error
+	ReportThread(ctx context.Context, slugOrID string, report *models.Report) error
+	GetReport(ctx context.Context, id int) (*models.Report, error)
+	GetForumReports(ctx context.Context, slug string, query *models.ReportQuery) ([]*models.Report, error)
+	CloseReport(ctx context.Context, id int, status string, handledBy string) (*models.Report, error)
+	// Atomic calls fn in one transaction that the repositories fn calls with
+	// its context join, this one included.
+	Atomic(ctx context.Context, fn func(ctx context.Context) error) error
+}
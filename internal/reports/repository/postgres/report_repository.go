@@ -0,0 +1,226 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/dantedoyl/Tech_DB_Forum/internal/models"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/pgtx"
+	"github.com/jackc/pgx"
+	"github.com/jackc/pgx/pgtype"
+)
+
+const (
+	codeUniqueViolation     = "23505"
+	codeForeignKeyViolation = "23503"
+)
+
+const defaultLimit = 100
+
+// reportSelect reads reports with the thread and forum their content is in
+// now, following posts that were moved to another thread.
+const reportSelect = `SELECT r.id, COALESCE(r.post, 0), t.id, t.forum, r.reporter, r.reason, r.comment, r.status,
+		r.created, COALESCE(r.handled_by, ''), r.handled_at
+	FROM reports AS r
+	LEFT JOIN post AS p ON p.id=r.post
+	JOIN thread AS t ON t.id=COALESCE(p.thread, r.thread)`
+
+type ReportRepository struct {
+	dbConn *pgx.ConnPool
+}
+
+func NewReportRepository(conn *pgx.ConnPool) *ReportRepository {
+	return &ReportRepository{dbConn: conn}
+}
+
+// conn is the transaction ctx carries, or the pool.
+func (rr ReportRepository) conn(ctx context.Context) pgtx.Queryer {
+	return pgtx.Conn(ctx, rr.dbConn)
+}
+
+func (rr ReportRepository) Atomic(ctx context.Context, fn func(ctx context.Context) error) error {
+	return pgtx.Run(ctx, rr.dbConn, fn)
+}
+
+func pgErrorCode(err error) string {
+	if pgErr, ok := err.(pgx.PgError); ok {
+		return pgErr.Code
+	}
+	return ""
+}
+
+func scanReport(row interface{ Scan(...interface{}) error }) (*models.Report, error) {
+	report := &models.Report{}
+	var handled pgtype.Timestamptz
+	err := row.Scan(&report.ID, &report.Post, &report.Thread, &report.Forum, &report.Reporter, &report.Reason,
+		&report.Comment, &report.Status, &report.Created, &report.HandledBy, &handled)
+	if err != nil {
+		return nil, err
+	}
+	if handled.Status == pgtype.Present {
+		report.Handled = &handled.Time
+	}
+	return report, nil
+}
+
+func (rr ReportRepository) ReportPost(ctx context.Context, report *models.Report) error {
+	var id int64
+	err := rr.conn(ctx).QueryRowEx(ctx, `SELECT p.id FROM post AS p JOIN thread AS t ON t.id=p.thread
+		WHERE p.id=$1 AND p.deleted_at IS NULL AND t.deleted_at IS NULL`, nil, report.Post).Scan(&id)
+	if err == pgx.ErrNoRows {
+		return models.NotFound(models.EntityPost, strconv.FormatInt(report.Post, 10))
+	}
+	if err != nil {
+		return models.Internal(err)
+	}
+	return rr.insert(ctx, report, report.Post, nil)
+}
+
+func (rr ReportRepository) ReportThread(ctx context.Context, slugOrID string, report *models.Report) error {
+	condition, param := `slug=$1`, interface{}(slugOrID)
+	if id, err := strconv.Atoi(slugOrID); err == nil {
+		condition, param = `id=$1`, id
+	}
+
+	var id int
+	err := rr.conn(ctx).QueryRowEx(ctx, `SELECT id FROM thread WHERE `+condition+` AND deleted_at IS NULL`, nil,
+		param).Scan(&id)
+	if err == pgx.ErrNoRows {
+		return models.NotFound(models.EntityThread, slugOrID)
+	}
+	if err != nil {
+		return models.Internal(err)
+	}
+	return rr.insert(ctx, report, nil, id)
+}
+
+// insert files a report about post or thread, one of which is nil. A second
+// open report of the same user about the same content is a conflict.
+func (rr ReportRepository) insert(ctx context.Context, report *models.Report, post, thread interface{}) error {
+	if !models.ValidReportReason(report.Reason) {
+		return models.Invalid("reason", "must be one of "+strings.Join(models.ReportReasons, ", "))
+	}
+
+	var id int
+	err := pgtx.Savepoint(ctx, rr.dbConn, func(q pgtx.Queryer) error {
+		return q.QueryRowEx(ctx, `INSERT INTO reports(post, thread, reporter, reason, comment)
+			VALUES ($1, $2, $3, $4, $5) RETURNING id`, nil, post, thread, report.Reporter, report.Reason,
+			report.Comment).Scan(&id)
+	})
+	switch pgErrorCode(err) {
+	case "":
+	case codeForeignKeyViolation:
+		return models.NotFound(models.EntityUser, report.Reporter)
+	case codeUniqueViolation:
+		existing, err := scanReport(rr.conn(ctx).QueryRowEx(ctx, reportSelect+`
+			WHERE r.status='open' AND r.reporter=$1 AND r.post IS NOT DISTINCT FROM $2 AND r.thread IS NOT DISTINCT FROM $3`,
+			nil, report.Reporter, post, thread))
+		if err != nil {
+			return models.Internal(err)
+		}
+		return models.Conflict(models.EntityReport, existing)
+	default:
+		return models.Internal(err)
+	}
+
+	created, err := rr.GetReport(ctx, id)
+	if err != nil {
+		return err
+	}
+	*report = *created
+	return nil
+}
+
+func (rr ReportRepository) GetReport(ctx context.Context, id int) (*models.Report, error) {
+	report, err := scanReport(rr.conn(ctx).QueryRowEx(ctx, reportSelect+` WHERE r.id=$1`, nil, id))
+	if err == pgx.ErrNoRows {
+		return nil, models.NotFound(models.EntityReport, strconv.Itoa(id))
+	}
+	if err != nil {
+		return nil, models.Internal(err)
+	}
+	return report, nil
+}
+
+// GetForumReports is the moderation queue of a forum, oldest report first.
+func (rr ReportRepository) GetForumReports(ctx context.Context, slug string, query *models.ReportQuery) ([]*models.Report, error) {
+	var forum string
+	err := rr.conn(ctx).QueryRowEx(ctx, `SELECT slug FROM forum WHERE slug=$1`, nil, slug).Scan(&forum)
+	if err == pgx.ErrNoRows {
+		return nil, models.NotFound(models.EntityForum, slug)
+	}
+	if err != nil {
+		return nil, models.Internal(err)
+	}
+
+	status := query.Status
+	if status == "" {
+		status = models.ReportOpen
+	}
+	if status != models.ReportOpen && status != models.ReportResolved && status != models.ReportDismissed {
+		return nil, models.Invalid("status", "must be open, resolved or dismissed")
+	}
+	limit := query.Limit
+	if limit <= 0 {
+		limit = defaultLimit
+	}
+
+	sql := reportSelect + ` WHERE t.forum=$1 AND r.status=$2`
+	order := ` ORDER BY r.id`
+	if query.Desc {
+		order = ` ORDER BY r.id DESC`
+	}
+	args := []interface{}{forum, status, limit}
+	if query.Since > 0 {
+		if query.Desc {
+			sql += ` AND r.id < $4`
+		} else {
+			sql += ` AND r.id > $4`
+		}
+		args = append(args, query.Since)
+	}
+
+	rows, err := rr.conn(ctx).QueryEx(ctx, sql+order+` LIMIT $3`, nil, args...)
+	if err != nil {
+		return nil, models.Internal(err)
+	}
+	defer rows.Close()
+
+	list := []*models.Report{}
+	for rows.Next() {
+		report, err := scanReport(rows)
+		if err != nil {
+			return nil, models.Internal(err)
+		}
+		list = append(list, report)
+	}
+	if rows.Err() != nil {
+		return nil, models.Internal(rows.Err())
+	}
+	return list, nil
+}
+
+// CloseReport resolves or dismisses an open report together with the other
+// open reports about the same content.
+func (rr ReportRepository) CloseReport(ctx context.Context, id int, status string, handledBy string) (*models.Report, error) {
+	tag, err := rr.conn(ctx).ExecEx(ctx, `WITH target AS (SELECT post, thread FROM reports WHERE id=$1 AND status='open')
+		UPDATE reports AS r SET status=$2, handled_by=NULLIF($3, ''), handled_at=now()
+		FROM target
+		WHERE r.status='open' AND r.post IS NOT DISTINCT FROM target.post
+			AND r.thread IS NOT DISTINCT FROM target.thread`, nil, id, status, handledBy)
+	if err != nil {
+		return nil, models.Internal(err)
+	}
+
+	report, err := rr.GetReport(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, &models.ConflictError{Entity: models.EntityReport,
+			Reason: fmt.Sprintf("Report %d is already %s", id, report.Status)}
+	}
+	return report, nil
+}
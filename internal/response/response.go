@@ -0,0 +1,90 @@
+// Package response writes the JSON bodies shared by all HTTP handlers.
+package response
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/dantedoyl/Tech_DB_Forum/internal/models"
+)
+
+// errorBody is the JSON body of every error response. Message is kept at the
+// top level so clients of the original API keep working.
+type errorBody struct {
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Entity  models.Entity     `json:"entity,omitempty"`
+	Fields  map[string]string `json:"fields,omitempty"`
+	// Index is the position of the offending element in a batch request.
+	Index *int `json:"index,omitempty"`
+}
+
+// WriteError maps a repository error to its HTTP status and body. A conflict
+// carrying the stored entity answers with that entity, as the API requires
+// for duplicate creates.
+func WriteError(w http.ResponseWriter, err error) {
+	var (
+		notFound     *models.NotFoundError
+		conflict     *models.ConflictError
+		validation   *models.ValidationError
+		unauthorized *models.UnauthorizedError
+		forbidden    *models.ForbiddenError
+		item         *models.ItemError
+	)
+	var status int
+	var body *errorBody
+	switch {
+	case errors.As(err, &notFound):
+		status = http.StatusNotFound
+		body = &errorBody{Code: "not_found", Message: notFound.Error(), Entity: notFound.Entity}
+	case errors.As(err, &conflict):
+		if conflict.Existing != nil {
+			WriteJSON(w, http.StatusConflict, conflict.Existing)
+			return
+		}
+		status = http.StatusConflict
+		body = &errorBody{Code: "conflict", Message: conflict.Error(), Entity: conflict.Entity}
+	case errors.As(err, &validation):
+		status = http.StatusBadRequest
+		body = &errorBody{Code: "invalid", Message: validation.Error(), Fields: validation.Fields}
+	case errors.As(err, &unauthorized):
+		status = http.StatusUnauthorized
+		body = &errorBody{Code: "unauthorized", Message: unauthorized.Error()}
+	case errors.As(err, &forbidden):
+		status = http.StatusForbidden
+		body = &errorBody{Code: "forbidden", Message: forbidden.Error()}
+	case errors.Is(err, context.DeadlineExceeded):
+		status = http.StatusGatewayTimeout
+		body = &errorBody{Code: "timeout", Message: "request timed out"}
+	default:
+		log.Printf("internal error: %v", err)
+		WriteJSON(w, http.StatusInternalServerError, &errorBody{Code: "internal", Message: "internal server error"})
+		return
+	}
+	if errors.As(err, &item) {
+		body.Index = &item.Index
+	}
+	WriteJSON(w, status, body)
+}
+
+// WriteJSON marshals v before writing the header so a marshalling failure
+// still produces a well-formed 500.
+func WriteJSON(w http.ResponseWriter, status int, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("marshal response: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"code":"internal","message":"internal server error"}`))
+		return
+	}
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// InvalidBody reports a request body that is not valid JSON.
+func InvalidBody(err error) error {
+	return models.Invalid("body", err.Error())
+}
@@ -0,0 +1,110 @@
+package response
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/dantedoyl/Tech_DB_Forum/internal/models"
+)
+
+func TestWriteError(t *testing.T) {
+	index := 2
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		body   interface{}
+	}{
+		{
+			name:   "not found",
+			err:    models.NotFound(models.EntityThread, "nope"),
+			status: http.StatusNotFound,
+			body:   &errorBody{Code: "not_found", Message: "Can't find thread nope", Entity: models.EntityThread},
+		},
+		{
+			name:   "wrapped not found",
+			err:    fmt.Errorf("lookup: %w", models.NotFound(models.EntityUser, "")),
+			status: http.StatusNotFound,
+			body:   &errorBody{Code: "not_found", Message: "Can't find user", Entity: models.EntityUser},
+		},
+		{
+			name:   "duplicate create",
+			err:    models.Conflict(models.EntityForum, &models.Forum{Slug: "news", User: "jo"}),
+			status: http.StatusConflict,
+			body:   &models.Forum{Slug: "news", User: "jo"},
+		},
+		{
+			name:   "conflict",
+			err:    &models.ConflictError{Entity: models.EntityThread, Reason: "thread is locked"},
+			status: http.StatusConflict,
+			body:   &errorBody{Code: "conflict", Message: "thread is locked", Entity: models.EntityThread},
+		},
+		{
+			name:   "invalid",
+			err:    models.Invalid("since", "must be a thread id"),
+			status: http.StatusBadRequest,
+			body: &errorBody{Code: "invalid", Message: "invalid request: since: must be a thread id",
+				Fields: map[string]string{"since": "must be a thread id"}},
+		},
+		{
+			name:   "invalid batch item",
+			err:    &models.ItemError{Index: 2, Err: models.Invalid("message", "required")},
+			status: http.StatusBadRequest,
+			body: &errorBody{Code: "invalid", Message: "invalid request: message: required",
+				Fields: map[string]string{"message": "required"}, Index: &index},
+		},
+		{
+			name:   "unauthorized",
+			err:    models.Unauthorized("log in"),
+			status: http.StatusUnauthorized,
+			body:   &errorBody{Code: "unauthorized", Message: "log in"},
+		},
+		{
+			name:   "forbidden",
+			err:    models.Forbidden("moderators only"),
+			status: http.StatusForbidden,
+			body:   &errorBody{Code: "forbidden", Message: "moderators only"},
+		},
+		{
+			name:   "timeout",
+			err:    models.Internal(fmt.Errorf("query: %w", context.DeadlineExceeded)),
+			status: http.StatusGatewayTimeout,
+			body:   &errorBody{Code: "timeout", Message: "request timed out"},
+		},
+		{
+			name:   "internal",
+			err:    models.Internal(errors.New("connection refused")),
+			status: http.StatusInternalServerError,
+			body:   &errorBody{Code: "internal", Message: "internal server error"},
+		},
+		{
+			name:   "unknown",
+			err:    errors.New("boom"),
+			status: http.StatusInternalServerError,
+			body:   &errorBody{Code: "internal", Message: "internal server error"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			WriteError(rec, tt.err)
+
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+			got := reflect.New(reflect.TypeOf(tt.body).Elem()).Interface()
+			if err := json.Unmarshal(rec.Body.Bytes(), got); err != nil {
+				t.Fatalf("body %s: %v", rec.Body, err)
+			}
+			if !reflect.DeepEqual(got, tt.body) {
+				t.Errorf("body = %s", rec.Body)
+			}
+		})
+	}
+}
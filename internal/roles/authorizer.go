@@ -0,0 +1,90 @@
+// Package roles holds site roles, forum moderators and the checks built on
+// them.
+package roles
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/dantedoyl/Tech_DB_Forum/internal/models"
+)
+
+// Authorizer decides what an authenticated user may do. Its methods return
+// nil when the action is allowed and a *models.ForbiddenError when it is not.
+type Authorizer struct {
+	Repo RoleRepository
+}
+
+func NewAuthorizer(repo RoleRepository) *Authorizer {
+	return &Authorizer{Repo: repo}
+}
+
+func (a *Authorizer) role(ctx context.Context, nickname string) (models.Role, error) {
+	userRole, err := a.Repo.GetUserRole(ctx, nickname)
+	if err != nil {
+		return "", err
+	}
+	return userRole.Role, nil
+}
+
+func (a *Authorizer) Admin(ctx context.Context, nickname string) error {
+	role, err := a.role(ctx, nickname)
+	if err != nil {
+		return err
+	}
+	if role != models.RoleAdmin {
+		return models.Forbidden("only admins may do this")
+	}
+	return nil
+}
+
+// Active rejects banned users.
+func (a *Authorizer) Active(ctx context.Context, nickname string) error {
+	role, err := a.role(ctx, nickname)
+	if err != nil {
+		return err
+	}
+	if role == models.RoleBanned {
+		return models.Forbidden(fmt.Sprintf("user %s is banned", nickname))
+	}
+	return nil
+}
+
+// Moderator allows admins and the moderators of forum.
+func (a *Authorizer) Moderator(ctx context.Context, nickname string, forum string) error {
+	role, err := a.role(ctx, nickname)
+	if err != nil {
+		return err
+	}
+	switch role {
+	case models.RoleAdmin:
+		return nil
+	case models.RoleBanned:
+		return models.Forbidden(fmt.Sprintf("user %s is banned", nickname))
+	}
+	ok, err := a.Repo.IsModerator(ctx, forum, nickname)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return models.Forbidden(fmt.Sprintf("only moderators of forum %s may do this", forum))
+	}
+	return nil
+}
+
+// Owner allows admins and the user who created forum.
+func (a *Authorizer) Owner(ctx context.Context, nickname string, forum string) error {
+	owner, err := a.Repo.ForumOwner(ctx, forum)
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(owner, nickname) {
+		return a.Active(ctx, nickname)
+	}
+	err = a.Admin(ctx, nickname)
+	if err != nil {
+		return models.Forbidden(fmt.Sprintf("only the owner of forum %s or an admin may do this", forum))
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package delivery
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/dantedoyl/Tech_DB_Forum/internal/auth"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/models"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/response"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/roles"
+	"github.com/gorilla/mux"
+)
+
+type RolesHandler struct {
+	RoleRepo   roles.RoleRepository
+	Authorizer *roles.Authorizer
+}
+
+func NewRolesHandler(r *mux.Router, roleRepo roles.RoleRepository, authorizer *roles.Authorizer) *RolesHandler {
+	rh := &RolesHandler{RoleRepo: roleRepo, Authorizer: authorizer}
+	r.HandleFunc("/forum/{slug}/moderators", rh.Moderators).Methods(http.MethodGet).Name("forum.moderators")
+	r.HandleFunc("/forum/{slug}/moderators/{nickname}", rh.GrantModerator).Methods(http.MethodPut).
+		Name("forum.moderators.grant")
+	r.HandleFunc("/forum/{slug}/moderators/{nickname}", rh.RevokeModerator).Methods(http.MethodDelete).
+		Name("forum.moderators.revoke")
+	r.HandleFunc("/user/{nickname}/role", rh.UserRole).Methods(http.MethodGet).Name("user.role")
+	r.HandleFunc("/user/{nickname}/role", rh.SetRole).Methods(http.MethodPut).Name("user.role.update")
+	return rh
+}
+
+func caller(r *http.Request) (string, error) {
+	nickname, ok := auth.Nickname(r.Context())
+	if !ok {
+		return "", models.Unauthorized("authentication required")
+	}
+	return nickname, nil
+}
+
+func (rh *RolesHandler) Moderators(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	slug := mux.Vars(r)["slug"]
+
+	moderators, err := rh.RoleRepo.GetModerators(r.Context(), slug)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	response.WriteJSON(w, http.StatusOK, moderators)
+}
+
+// GrantModerator lets the owner of a forum or an admin add a moderator.
+func (rh *RolesHandler) GrantModerator(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+
+	nickname, err := caller(r)
+	if err == nil {
+		err = rh.Authorizer.Owner(r.Context(), nickname, vars["slug"])
+	}
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	moderator := &models.Moderator{Forum: vars["slug"], Nickname: vars["nickname"], GrantedBy: nickname}
+	err = rh.RoleRepo.GrantModerator(r.Context(), moderator)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	response.WriteJSON(w, http.StatusOK, moderator)
+}
+
+func (rh *RolesHandler) RevokeModerator(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+
+	nickname, err := caller(r)
+	if err == nil {
+		err = rh.Authorizer.Owner(r.Context(), nickname, vars["slug"])
+	}
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	err = rh.RoleRepo.RevokeModerator(r.Context(), vars["slug"], vars["nickname"])
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (rh *RolesHandler) UserRole(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	nickname := mux.Vars(r)["nickname"]
+
+	userRole, err := rh.RoleRepo.GetUserRole(r.Context(), nickname)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	response.WriteJSON(w, http.StatusOK, userRole)
+}
+
+// SetRole changes the site role of a user. Only admins may call it.
+func (rh *RolesHandler) SetRole(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	nickname := mux.Vars(r)["nickname"]
+
+	admin, err := caller(r)
+	if err == nil {
+		err = rh.Authorizer.Admin(r.Context(), admin)
+	}
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	body := &struct {
+		Role models.Role `json:"role"`
+	}{}
+	err = json.NewDecoder(r.Body).Decode(body)
+	if err != nil {
+		response.WriteError(w, response.InvalidBody(err))
+		return
+	}
+
+	userRole, err := rh.RoleRepo.SetRole(r.Context(), nickname, body.Role)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	response.WriteJSON(w, http.StatusOK, userRole)
+}
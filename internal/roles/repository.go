@@ -0,0 +1,19 @@
+package roles
+
+import (
+	"context"
+
+	"github.com/dantedoyl/Tech_DB_Forum/internal/models"
+)
+
+// RoleRepository stores the site role of users and the moderators of forums.
+// The user who created a forum moderates it without being listed.
+type RoleRepository interface {
+	GetUserRole(ctx context.Context, nickname string) (*models.UserRole, error)
+	SetRole(ctx context.Context, nickname string, role models.Role) (*models.UserRole, error)
+	ForumOwner(ctx context.Context, slug string) (string, error)
+	IsModerator(ctx context.Context, slug string, nickname string) (bool, error)
+	GetModerators(ctx context.Context, slug string) ([]*models.Moderator, error)
+	GrantModerator(ctx context.Context, moderator *models.Moderator) error
+	RevokeModerator(ctx context.Context, slug string, nickname string) error
+}
@@ -0,0 +1,136 @@
+package postgres
+
+import (
+	"context"
+
+	"github.com/dantedoyl/Tech_DB_Forum/internal/models"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/pgtx"
+	"github.com/jackc/pgx"
+)
+
+const codeForeignKeyViolation = "23503"
+
+type RoleRepository struct {
+	dbConn *pgx.ConnPool
+}
+
+func NewRoleRepository(conn *pgx.ConnPool) *RoleRepository {
+	return &RoleRepository{dbConn: conn}
+}
+
+// conn is the transaction ctx carries, or the pool.
+func (rr RoleRepository) conn(ctx context.Context) pgtx.Queryer {
+	return pgtx.Conn(ctx, rr.dbConn)
+}
+
+func (rr RoleRepository) GetUserRole(ctx context.Context, nickname string) (*models.UserRole, error) {
+	userRole := &models.UserRole{}
+	var role string
+	err := rr.conn(ctx).QueryRowEx(ctx, `SELECT nickname, role,
+			ARRAY(SELECT forum::text FROM forum_moderators WHERE nickname=u.nickname ORDER BY forum)
+		FROM users AS u WHERE nickname=$1`, nil, nickname).Scan(&userRole.Nickname, &role, &userRole.Moderates)
+	if err == pgx.ErrNoRows {
+		return nil, models.NotFound(models.EntityUser, nickname)
+	}
+	if err != nil {
+		return nil, models.Internal(err)
+	}
+	userRole.Role = models.Role(role)
+	return userRole, nil
+}
+
+func (rr RoleRepository) SetRole(ctx context.Context, nickname string, role models.Role) (*models.UserRole, error) {
+	if !role.Valid() {
+		return nil, models.Invalid("role", "must be admin, member or banned")
+	}
+	tag, err := rr.conn(ctx).ExecEx(ctx, `UPDATE users SET role=$2 WHERE nickname=$1`, nil, nickname, string(role))
+	if err != nil {
+		return nil, models.Internal(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, models.NotFound(models.EntityUser, nickname)
+	}
+	return rr.GetUserRole(ctx, nickname)
+}
+
+func (rr RoleRepository) ForumOwner(ctx context.Context, slug string) (string, error) {
+	var owner string
+	err := rr.conn(ctx).QueryRowEx(ctx, `SELECT author FROM forum WHERE slug=$1`, nil, slug).Scan(&owner)
+	if err == pgx.ErrNoRows {
+		return "", models.NotFound(models.EntityForum, slug)
+	}
+	if err != nil {
+		return "", models.Internal(err)
+	}
+	return owner, nil
+}
+
+func (rr RoleRepository) IsModerator(ctx context.Context, slug string, nickname string) (bool, error) {
+	var ok bool
+	err := rr.conn(ctx).QueryRowEx(ctx, `SELECT
+			EXISTS(SELECT 1 FROM forum_moderators WHERE forum=$1 AND nickname=$2) OR
+			EXISTS(SELECT 1 FROM forum WHERE slug=$1 AND author=$2)`, nil, slug, nickname).Scan(&ok)
+	if err != nil {
+		return false, models.Internal(err)
+	}
+	return ok, nil
+}
+
+func (rr RoleRepository) GetModerators(ctx context.Context, slug string) ([]*models.Moderator, error) {
+	if _, err := rr.ForumOwner(ctx, slug); err != nil {
+		return nil, err
+	}
+
+	rows, err := rr.conn(ctx).QueryEx(ctx, `SELECT forum, nickname, COALESCE(granted_by, ''), created
+		FROM forum_moderators WHERE forum=$1 ORDER BY nickname`, nil, slug)
+	if err != nil {
+		return nil, models.Internal(err)
+	}
+	defer rows.Close()
+
+	moderators := []*models.Moderator{}
+	for rows.Next() {
+		moderator := &models.Moderator{}
+		err = rows.Scan(&moderator.Forum, &moderator.Nickname, &moderator.GrantedBy, &moderator.Created)
+		if err != nil {
+			return nil, models.Internal(err)
+		}
+		moderators = append(moderators, moderator)
+	}
+	if rows.Err() != nil {
+		return nil, models.Internal(rows.Err())
+	}
+	return moderators, nil
+}
+
+// GrantModerator adds a moderator; granting twice keeps the first grant.
+func (rr RoleRepository) GrantModerator(ctx context.Context, moderator *models.Moderator) error {
+	if _, err := rr.ForumOwner(ctx, moderator.Forum); err != nil {
+		return err
+	}
+
+	err := rr.conn(ctx).QueryRowEx(ctx, `INSERT INTO forum_moderators(forum, nickname, granted_by)
+		VALUES ((SELECT slug FROM forum WHERE slug=$1), $2, NULLIF($3, ''))
+		ON CONFLICT (forum, nickname) DO UPDATE SET granted_by=forum_moderators.granted_by
+		RETURNING forum, nickname, COALESCE(granted_by, ''), created`, nil,
+		moderator.Forum, moderator.Nickname, moderator.GrantedBy).
+		Scan(&moderator.Forum, &moderator.Nickname, &moderator.GrantedBy, &moderator.Created)
+	if err != nil {
+		if pgErr, ok := err.(pgx.PgError); ok && pgErr.Code == codeForeignKeyViolation {
+			return models.NotFound(models.EntityUser, moderator.Nickname)
+		}
+		return models.Internal(err)
+	}
+	return nil
+}
+
+func (rr RoleRepository) RevokeModerator(ctx context.Context, slug string, nickname string) error {
+	tag, err := rr.conn(ctx).ExecEx(ctx, `DELETE FROM forum_moderators WHERE forum=$1 AND nickname=$2`, nil, slug, nickname)
+	if err != nil {
+		return models.Internal(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return models.NotFound(models.EntityModerator, nickname)
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package delivery
+
+import (
+	"net/http"
+
+	"github.com/dantedoyl/Tech_DB_Forum/internal/models"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/response"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/search"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/schema"
+)
+
+type SearchHandler struct {
+	SearchRepo search.SearchRepository
+}
+
+func NewSearchHandler(r *mux.Router, searchRepo search.SearchRepository) *SearchHandler {
+	sh := &SearchHandler{SearchRepo: searchRepo}
+	r.HandleFunc("/search", sh.Search).Methods(http.MethodGet).Name("search")
+	return sh
+}
+
+func (sh *SearchHandler) Search(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	query := &models.SearchQuery{}
+	decoder := schema.NewDecoder()
+	decoder.IgnoreUnknownKeys(true)
+	if err := decoder.Decode(query, r.URL.Query()); err != nil {
+		response.WriteError(w, models.Invalid("query", err.Error()))
+		return
+	}
+
+	results, err := sh.SearchRepo.Search(r.Context(), query)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	if results == nil {
+		results = []*models.SearchResult{}
+	}
+	response.WriteJSON(w, http.StatusOK, results)
+}
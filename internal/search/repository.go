@@ -0,0 +1,12 @@
+package search
+
+import (
+	"context"
+
+	"github.com/dantedoyl/Tech_DB_Forum/internal/models"
+)
+
+// SearchRepository finds posts and threads by the words of their text.
+type SearchRepository interface {
+	Search(ctx context.Context, query *models.SearchQuery) ([]*models.SearchResult, error)
+}
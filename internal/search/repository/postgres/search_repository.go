@@ -0,0 +1,184 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dantedoyl/Tech_DB_Forum/internal/models"
+	"github.com/jackc/pgx"
+)
+
+// textConfig must match the configuration used by the search triggers of
+// migration 0002_search.
+const textConfig = "simple"
+
+const defaultLimit = 100
+
+const headlineOptions = "StartSel=<mark>, StopSel=</mark>, MaxFragments=2, MaxWords=30, MinWords=10"
+
+// source describes how one searchable table maps onto models.SearchResult.
+type source struct {
+	table  string
+	thread string
+	title  string
+}
+
+var sources = map[string]source{
+	"post":   {table: "post", thread: "thread", title: "''"},
+	"thread": {table: "thread", thread: "id", title: "title"},
+}
+
+type SearchRepository struct {
+	dbConn *pgx.ConnPool
+}
+
+func NewSearchRepository(conn *pgx.ConnPool) *SearchRepository {
+	return &SearchRepository{dbConn: conn}
+}
+
+// Search returns the posts or threads matching query.Query, a web search style
+// query ("quoted phrases", -excluded, or). Results are ordered by rank, best
+// first, or by creation time with sort=created.
+func (sr SearchRepository) Search(ctx context.Context, query *models.SearchQuery) ([]*models.SearchResult, error) {
+	if strings.TrimSpace(query.Query) == "" {
+		return nil, models.Invalid("q", "must not be empty")
+	}
+	kind := query.Type
+	if kind == "" {
+		kind = "post"
+	}
+	src, ok := sources[kind]
+	if !ok {
+		return nil, models.Invalid("type", "must be post or thread")
+	}
+	if query.Sort != "" && query.Sort != "rank" && query.Sort != "created" {
+		return nil, models.Invalid("sort", "must be rank or created")
+	}
+
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	with := fmt.Sprintf(`WITH q AS (SELECT websearch_to_tsquery('%s', %s) AS query)`, textConfig, arg(query.Query))
+	from := src.table + ` AS t, q`
+	where := `t.search @@ q.query AND t.deleted_at IS NULL`
+
+	if query.Forum != "" {
+		where += ` AND t.forum = ` + arg(query.Forum)
+	}
+	if query.Thread != "" {
+		threadID, err := sr.threadID(ctx, query.Thread)
+		if err != nil {
+			return nil, err
+		}
+		where += fmt.Sprintf(` AND t.%s = %s`, src.thread, arg(threadID))
+	}
+	if query.Author != "" {
+		where += ` AND t.author = ` + arg(query.Author)
+	}
+	if query.From != "" {
+		fromTime, err := time.Parse(time.RFC3339Nano, query.From)
+		if err != nil {
+			return nil, models.Invalid("from", "must be an RFC 3339 timestamp")
+		}
+		where += ` AND t.created >= ` + arg(fromTime)
+	}
+	if query.To != "" {
+		to, err := time.Parse(time.RFC3339Nano, query.To)
+		if err != nil {
+			return nil, models.Invalid("to", "must be an RFC 3339 timestamp")
+		}
+		where += ` AND t.created < ` + arg(to)
+	}
+
+	var orderBy string
+	switch {
+	case query.Sort == "created" && query.Desc:
+		orderBy = `created DESC, id DESC`
+	case query.Sort == "created":
+		orderBy = `created, id`
+	default:
+		orderBy = `rank DESC, id`
+	}
+
+	if query.Since != "" {
+		since, err := strconv.ParseInt(query.Since, 10, 64)
+		if err != nil {
+			return nil, models.Invalid("since", "must be the id of a result")
+		}
+		with += fmt.Sprintf(`, s AS (SELECT ts_rank(search, q.query) AS rank, created, id FROM %s, q WHERE id = %s)`,
+			src.table, arg(since))
+		from += `, s`
+		switch {
+		case query.Sort == "created" && query.Desc:
+			where += ` AND (t.created, t.id) < (s.created, s.id)`
+		case query.Sort == "created":
+			where += ` AND (t.created, t.id) > (s.created, s.id)`
+		default:
+			where += ` AND (ts_rank(t.search, q.query) < s.rank OR ts_rank(t.search, q.query) = s.rank AND t.id > s.id)`
+		}
+	}
+
+	limit := query.Limit
+	if limit <= 0 {
+		limit = defaultLimit
+	}
+
+	// Snippets are built only for the page being returned.
+	sql := fmt.Sprintf(`%s
+		SELECT r.id, r.thread, r.forum, r.author, r.title, r.created, r.rank,
+			ts_headline('%s', r.message, q.query, '%s')
+		FROM (
+			SELECT t.id, t.%s AS thread, t.forum, t.author, %s AS title, t.created, t.message,
+				ts_rank(t.search, q.query) AS rank
+			FROM %s
+			WHERE %s
+			ORDER BY %s
+			LIMIT %s
+		) AS r, q
+		ORDER BY %s`,
+		with, textConfig, headlineOptions, src.thread, src.title, from, where, orderBy, arg(limit), orderBy)
+
+	rows, err := sr.dbConn.QueryEx(ctx, sql, nil, args...)
+	if err != nil {
+		return nil, models.Internal(err)
+	}
+	defer rows.Close()
+
+	var results []*models.SearchResult
+	for rows.Next() {
+		result := &models.SearchResult{Type: kind}
+		err = rows.Scan(&result.ID, &result.Thread, &result.Forum, &result.Author, &result.Title, &result.Created,
+			&result.Rank, &result.Snippet)
+		if err != nil {
+			return nil, models.Internal(err)
+		}
+		results = append(results, result)
+	}
+	if rows.Err() != nil {
+		return nil, models.Internal(rows.Err())
+	}
+	return results, nil
+}
+
+func (sr SearchRepository) threadID(ctx context.Context, slugOrID string) (int, error) {
+	condition, param := `slug=$1`, interface{}(slugOrID)
+	if id, err := strconv.Atoi(slugOrID); err == nil {
+		condition, param = `id=$1`, id
+	}
+
+	var id int
+	err := sr.dbConn.QueryRowEx(ctx, `SELECT id FROM thread WHERE `+condition, nil, param).Scan(&id)
+	if err == pgx.ErrNoRows {
+		return 0, models.NotFound(models.EntityThread, slugOrID)
+	}
+	if err != nil {
+		return 0, models.Internal(err)
+	}
+	return id, nil
+}
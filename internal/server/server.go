@@ -0,0 +1,121 @@
+// Package server runs the HTTP server until the process is asked to stop and
+// then shuts it down without dropping in-flight requests.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+type State string
+
+const (
+	StateStarting State = "starting"
+	StateReady    State = "ready"
+	StateDraining State = "draining"
+	StateStopped  State = "stopped"
+)
+
+type Server struct {
+	httpServer      *http.Server
+	shutdownTimeout time.Duration
+	drainDelay      time.Duration
+
+	mu    sync.RWMutex
+	state State
+}
+
+// New wraps httpServer. On shutdown the server first reports itself as not
+// ready for drainDelay so load balancers stop routing to it, then waits up to
+// shutdownTimeout for in-flight requests before closing remaining connections.
+func New(httpServer *http.Server, shutdownTimeout, drainDelay time.Duration) *Server {
+	return &Server{
+		httpServer:      httpServer,
+		shutdownTimeout: shutdownTimeout,
+		drainDelay:      drainDelay,
+		state:           StateStarting,
+	}
+}
+
+func (s *Server) State() State {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.state
+}
+
+func (s *Server) setState(state State) {
+	s.mu.Lock()
+	prev := s.state
+	s.state = state
+	s.mu.Unlock()
+	log.Printf("server: %s -> %s", prev, state)
+}
+
+// OnShutdown registers f to run when shutdown begins, to end long-lived
+// requests such as streams that would otherwise hold it up.
+func (s *Server) OnShutdown(f func()) {
+	s.httpServer.RegisterOnShutdown(f)
+}
+
+// Ready answers 200 while the server accepts traffic and 503 otherwise.
+func (s *Server) Ready(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	state := s.State()
+	if state == StateReady {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	body, _ := json.Marshal(map[string]State{"status": state})
+	w.Write(body)
+}
+
+// Run serves until SIGINT or SIGTERM is received or the listener fails.
+func (s *Server) Run() error {
+	ln, err := net.Listen("tcp", s.httpServer.Addr)
+	if err != nil {
+		return err
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- s.httpServer.Serve(ln)
+	}()
+	log.Printf("server: listening on %s", ln.Addr())
+	s.setState(StateReady)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	select {
+	case err := <-serveErr:
+		s.setState(StateStopped)
+		return err
+	case sig := <-signals:
+		log.Printf("server: received %s", sig)
+	}
+
+	s.setState(StateDraining)
+	if s.drainDelay > 0 {
+		time.Sleep(s.drainDelay)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout)
+	defer cancel()
+	err = s.httpServer.Shutdown(ctx)
+	if err == context.DeadlineExceeded {
+		log.Printf("server: shutdown deadline of %s exceeded, closing remaining connections", s.shutdownTimeout)
+		err = s.httpServer.Close()
+	}
+	s.setState(StateStopped)
+	return err
+}
@@ -0,0 +1,217 @@
+package delivery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dantedoyl/Tech_DB_Forum/internal/forum"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/models"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/response"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/stream"
+	"github.com/gorilla/mux"
+)
+
+const (
+	heartbeat     = 15 * time.Second
+	retryMillis   = 3000
+	catchUpLimit  = 100
+	lifetimeSlack = time.Second
+)
+
+type StreamHandler struct {
+	Hub       *stream.Hub
+	ForumRepo forum.ForumRepository
+	// Lifetime ends each stream before the server's write timeout would cut
+	// it off mid-event; clients reconnect with Last-Event-ID. Zero means no
+	// limit.
+	Lifetime time.Duration
+}
+
+func NewStreamHandler(r *mux.Router, hub *stream.Hub, forumRepo forum.ForumRepository, writeTimeout time.Duration) *StreamHandler {
+	sh := &StreamHandler{Hub: hub, ForumRepo: forumRepo}
+	if writeTimeout > lifetimeSlack {
+		sh.Lifetime = writeTimeout - lifetimeSlack
+	}
+	r.HandleFunc("/thread/{slug_or_id}/stream", sh.Stream).Methods(http.MethodGet).Name("thread.stream")
+	return sh
+}
+
+// Stream sends the posts of a thread as server-sent events: "post" for new
+// posts, with the post id as the event id, and "edit" for posts whose message
+// changed or that were deleted. A client resumes after the post in its
+// Last-Event-ID header, or the since parameter on first connect; without
+// either the stream starts with the next post.
+func (sh *StreamHandler) Stream(w http.ResponseWriter, r *http.Request) {
+	slugOrID := mux.Vars(r)["slug_or_id"]
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		response.WriteError(w, models.Internal(fmt.Errorf("streaming is not supported by the response writer")))
+		return
+	}
+
+	resume := r.Header.Get("Last-Event-ID")
+	if resume == "" {
+		resume = r.URL.Query().Get("since")
+	}
+	var last int
+	if resume != "" {
+		var err error
+		last, err = strconv.Atoi(resume)
+		if err != nil {
+			response.WriteError(w, models.Invalid("since", "must be a post id"))
+			return
+		}
+	}
+
+	thread, err := sh.ForumRepo.GetThreadInfo(r.Context(), slugOrID)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	// Subscribe before reading so that no post falls between the read and
+	// the first notice.
+	sub := sh.Hub.Subscribe(thread.ID)
+	defer sub.Close()
+
+	s := &threadStream{w: w, flusher: flusher, repo: sh.ForumRepo, thread: strconv.Itoa(thread.ID), last: last}
+	if resume == "" {
+		if err := s.start(r.Context()); err != nil {
+			response.WriteError(w, err)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", retryMillis)
+	if err := s.catchUp(r.Context()); err != nil {
+		return
+	}
+
+	var expired <-chan time.Time
+	if sh.Lifetime > 0 {
+		timer := time.NewTimer(sh.Lifetime)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-expired:
+			return
+		case <-sub.Done():
+			return
+		case <-ticker.C:
+			if err := s.comment("heartbeat"); err != nil {
+				return
+			}
+		case <-sub.Resync():
+			err = s.catchUp(r.Context())
+		case notice := <-sub.Notices():
+			switch {
+			case notice.Event == models.PostCreated && notice.Post > s.last:
+				err = s.catchUp(r.Context())
+			case notice.Event == models.PostEdited && notice.Post <= s.last:
+				err = s.edit(r.Context(), notice.Post)
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// threadStream writes the events of one client. last is the id of the
+// newest post it has been sent.
+type threadStream struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	repo    forum.ForumRepository
+	thread  string
+	last    int
+}
+
+// start skips the posts written before the client connected.
+func (s *threadStream) start(ctx context.Context) error {
+	posts, err := s.repo.GetThreadPosts(ctx, s.thread, &models.Params{Limit: 1, Desc: true, Sort: "flat"})
+	if err != nil {
+		return err
+	}
+	if len(posts) > 0 {
+		s.last = posts[0].ID
+	}
+	return nil
+}
+
+// catchUp sends every post newer than the last one sent.
+func (s *threadStream) catchUp(ctx context.Context) error {
+	for {
+		posts, err := s.repo.GetThreadPosts(ctx, s.thread, &models.Params{
+			Limit: catchUpLimit,
+			Since: strconv.Itoa(s.last),
+			Sort:  "flat",
+		})
+		if err != nil {
+			return err
+		}
+		for _, post := range posts {
+			if err := s.event(models.PostCreated, strconv.Itoa(post.ID), post); err != nil {
+				return err
+			}
+			s.last = post.ID
+		}
+		if len(posts) < catchUpLimit {
+			return nil
+		}
+	}
+}
+
+// edit sends the current state of a post. It carries no event id, so that
+// a reconnecting client resumes after the newest post rather than this one.
+func (s *threadStream) edit(ctx context.Context, id int) error {
+	info, err := s.repo.PostInfo(ctx, id, models.Related{})
+	if _, ok := err.(*models.NotFoundError); ok {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return s.event(models.PostEdited, "", info.Post)
+}
+
+func (s *threadStream) event(event, id string, data interface{}) error {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if id != "" {
+		_, err = fmt.Fprintf(s.w, "event: %s\nid: %s\ndata: %s\n\n", event, id, body)
+	} else {
+		_, err = fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", event, body)
+	}
+	if err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+func (s *threadStream) comment(text string) error {
+	if _, err := fmt.Fprintf(s.w, ": %s\n\n", text); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
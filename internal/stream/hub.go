@@ -0,0 +1,162 @@
+// Package stream pushes the posts of a thread to clients as they are written.
+// A Hub listens for notices on one database connection and fans them out to
+// the subscriptions of each thread; subscribers read the posts themselves.
+package stream
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/dantedoyl/Tech_DB_Forum/internal/models"
+)
+
+const (
+	subscriptionBuffer = 64
+	maxBackoff         = 30 * time.Second
+)
+
+type Hub struct {
+	repo StreamRepository
+
+	mu     sync.Mutex
+	subs   map[int]map[*Subscription]struct{}
+	closed bool
+}
+
+func NewHub(repo StreamRepository) *Hub {
+	return &Hub{repo: repo, subs: map[int]map[*Subscription]struct{}{}}
+}
+
+// Subscription receives the notices of one thread. Notices may be lost while
+// the hub reconnects or when the subscriber falls behind; Resync then fires
+// and the subscriber should re-read what it may have missed.
+type Subscription struct {
+	hub     *Hub
+	thread  int
+	notices chan *models.PostNotice
+	resync  chan struct{}
+	done    chan struct{}
+	once    sync.Once
+}
+
+func (s *Subscription) Notices() <-chan *models.PostNotice { return s.notices }
+func (s *Subscription) Resync() <-chan struct{}            { return s.resync }
+
+// Done is closed when the hub shuts down.
+func (s *Subscription) Done() <-chan struct{} { return s.done }
+
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	if subs := s.hub.subs[s.thread]; subs != nil {
+		delete(subs, s)
+		if len(subs) == 0 {
+			delete(s.hub.subs, s.thread)
+		}
+	}
+	s.hub.mu.Unlock()
+	s.stop()
+}
+
+func (s *Subscription) stop() {
+	s.once.Do(func() { close(s.done) })
+}
+
+func (s *Subscription) signalResync() {
+	select {
+	case s.resync <- struct{}{}:
+	default:
+	}
+}
+
+func (h *Hub) Subscribe(thread int) *Subscription {
+	s := &Subscription{
+		hub:     h,
+		thread:  thread,
+		notices: make(chan *models.PostNotice, subscriptionBuffer),
+		resync:  make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		s.stop()
+		return s
+	}
+	if h.subs[thread] == nil {
+		h.subs[thread] = map[*Subscription]struct{}{}
+	}
+	h.subs[thread][s] = struct{}{}
+	return s
+}
+
+func (h *Hub) publish(notice *models.PostNotice) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range h.subs[notice.Thread] {
+		select {
+		case s.notices <- notice:
+		default:
+			s.signalResync()
+		}
+	}
+}
+
+func (h *Hub) resyncAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, subs := range h.subs {
+		for s := range subs {
+			s.signalResync()
+		}
+	}
+}
+
+// Close ends every subscription, current and future.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for _, subs := range h.subs {
+		for s := range subs {
+			s.stop()
+		}
+	}
+	h.subs = map[int]map[*Subscription]struct{}{}
+}
+
+// Run listens until ctx is done, reconnecting with exponential backoff. Every
+// subscription is resynced once a connection is established, since notices
+// sent while none was may have been lost.
+func (h *Hub) Run(ctx context.Context) {
+	backoff := time.Second
+	for ctx.Err() == nil {
+		listener, err := h.repo.Listen(ctx)
+		if err != nil {
+			log.Printf("stream: listen: %v, retrying in %s", err, backoff)
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+			}
+			if backoff *= 2; backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+			continue
+		}
+		backoff = time.Second
+		h.resyncAll()
+
+		for {
+			notice, err := listener.Next(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("stream: %v", err)
+				}
+				break
+			}
+			h.publish(notice)
+		}
+		listener.Close()
+	}
+}
@@ -0,0 +1,20 @@
+package stream
+
+import (
+	"context"
+
+	"github.com/dantedoyl/Tech_DB_Forum/internal/models"
+)
+
+// StreamRepository delivers the post notices of every server instance that
+// shares the database.
+type StreamRepository interface {
+	Listen(ctx context.Context) (PostListener, error)
+}
+
+// PostListener receives notices from the moment Listen returns it.
+type PostListener interface {
+	// Next blocks for the next notice. After an error the listener is unusable.
+	Next(ctx context.Context) (*models.PostNotice, error)
+	Close()
+}
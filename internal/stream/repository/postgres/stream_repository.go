@@ -0,0 +1,65 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"github.com/dantedoyl/Tech_DB_Forum/internal/models"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/stream"
+	"github.com/jackc/pgx"
+)
+
+// channel must match the triggers of migration 0011_post_notify.
+const channel = "post_events"
+
+type StreamRepository struct {
+	dbConn *pgx.ConnPool
+}
+
+func NewStreamRepository(conn *pgx.ConnPool) *StreamRepository {
+	return &StreamRepository{dbConn: conn}
+}
+
+// Listen holds a connection of the pool until the listener is closed.
+func (sr StreamRepository) Listen(ctx context.Context) (stream.PostListener, error) {
+	conn, err := sr.dbConn.AcquireEx(ctx)
+	if err != nil {
+		return nil, models.Internal(err)
+	}
+	if err := conn.Listen(channel); err != nil {
+		sr.dbConn.Release(conn)
+		return nil, models.Internal(err)
+	}
+	return &listener{pool: sr.dbConn, conn: conn}, nil
+}
+
+type listener struct {
+	pool *pgx.ConnPool
+	conn *pgx.Conn
+}
+
+func (l *listener) Next(ctx context.Context) (*models.PostNotice, error) {
+	for {
+		n, err := l.conn.WaitForNotification(ctx)
+		if err != nil {
+			return nil, err
+		}
+		notice := &models.PostNotice{}
+		if err := json.Unmarshal([]byte(n.Payload), notice); err != nil {
+			log.Printf("stream: bad notification %q: %v", n.Payload, err)
+			continue
+		}
+		return notice, nil
+	}
+}
+
+// Close returns the connection to the pool, which drops it if it broke.
+func (l *listener) Close() {
+	if l.conn.IsAlive() {
+		if err := l.conn.Unlisten(channel); err != nil {
+			l.conn.Close()
+		}
+	}
+	l.pool.Release(l.conn)
+}
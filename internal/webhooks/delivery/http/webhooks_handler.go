@@ -0,0 +1,187 @@
+package delivery
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/dantedoyl/Tech_DB_Forum/internal/auth"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/models"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/response"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/roles"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/webhooks"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/schema"
+)
+
+type WebhooksHandler struct {
+	WebhookRepo webhooks.WebhookRepository
+	Authorizer  *roles.Authorizer
+	// Guard checks the URLs of new webhooks.
+	Guard *webhooks.Guard
+}
+
+func NewWebhooksHandler(r *mux.Router, webhookRepo webhooks.WebhookRepository, authorizer *roles.Authorizer,
+	guard *webhooks.Guard) *WebhooksHandler {
+	wh := &WebhooksHandler{WebhookRepo: webhookRepo, Authorizer: authorizer, Guard: guard}
+	r.HandleFunc("/forum/{slug}/webhooks", wh.ForumWebhooks).Methods(http.MethodGet).Name("forum.webhooks")
+	r.HandleFunc("/forum/{slug}/webhooks", wh.CreateWebhook).Methods(http.MethodPost).Name("forum.webhooks.create")
+	r.HandleFunc("/webhook/{id:[0-9]+}", wh.Webhook).Methods(http.MethodGet).Name("webhook.details")
+	r.HandleFunc("/webhook/{id:[0-9]+}", wh.DeleteWebhook).Methods(http.MethodDelete).Name("webhook.delete")
+	r.HandleFunc("/webhook/{id:[0-9]+}/deliveries", wh.Deliveries).Methods(http.MethodGet).Name("webhook.deliveries")
+	return wh
+}
+
+// moderator returns the caller if it moderates forum.
+func (wh *WebhooksHandler) moderator(r *http.Request, forum string) (string, error) {
+	caller, ok := auth.Nickname(r.Context())
+	if !ok {
+		return "", models.Unauthorized("authentication required")
+	}
+	return caller, wh.Authorizer.Moderator(r.Context(), caller, forum)
+}
+
+// webhook loads the webhook of the request for a moderator of its forum.
+func (wh *WebhooksHandler) webhook(r *http.Request) (*models.Webhook, error) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	webhook, err := wh.WebhookRepo.GetWebhook(r.Context(), id)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := wh.moderator(r, webhook.Forum); err != nil {
+		return nil, err
+	}
+	return webhook, nil
+}
+
+func (wh *WebhooksHandler) ForumWebhooks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	slug := mux.Vars(r)["slug"]
+
+	if _, err := wh.moderator(r, slug); err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	list, err := wh.WebhookRepo.GetForumWebhooks(r.Context(), slug)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	response.WriteJSON(w, http.StatusOK, list)
+}
+
+// CreateWebhook registers a webhook for the forum. The URL may not resolve to
+// an address the guard blocks. Events defaults to every event type; a secret
+// is generated when none is given. The response is the only one that
+// includes the secret.
+func (wh *WebhooksHandler) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	slug := mux.Vars(r)["slug"]
+
+	caller, err := wh.moderator(r, slug)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	webhook := &models.Webhook{}
+	if err := json.NewDecoder(r.Body).Decode(webhook); err != nil {
+		response.WriteError(w, response.InvalidBody(err))
+		return
+	}
+	if err := wh.Guard.CheckURL(r.Context(), webhook.URL); err != nil {
+		response.WriteError(w, err)
+		return
+	}
+	if len(webhook.Events) == 0 {
+		webhook.Events = models.WebhookEvents
+	}
+	for _, event := range webhook.Events {
+		if !models.ValidWebhookEvent(event) {
+			response.WriteError(w, models.Invalid("events", "unknown event type "+strconv.Quote(event)))
+			return
+		}
+	}
+	if webhook.Secret == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			response.WriteError(w, models.Internal(err))
+			return
+		}
+		webhook.Secret = hex.EncodeToString(secret)
+	}
+	webhook.Forum = slug
+	webhook.CreatedBy = caller
+
+	if err := wh.WebhookRepo.CreateWebhook(r.Context(), webhook); err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	response.WriteJSON(w, http.StatusCreated, webhook)
+}
+
+func (wh *WebhooksHandler) Webhook(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	webhook, err := wh.webhook(r)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	response.WriteJSON(w, http.StatusOK, webhook)
+}
+
+// DeleteWebhook removes a webhook along with its queued deliveries and log.
+func (wh *WebhooksHandler) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	webhook, err := wh.webhook(r)
+	if err == nil {
+		err = wh.WebhookRepo.DeleteWebhook(r.Context(), webhook.ID)
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		response.WriteError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Deliveries is the delivery log of a webhook: every queued event with the
+// outcome of its latest attempt.
+func (wh *WebhooksHandler) Deliveries(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	webhook, err := wh.webhook(r)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	query := &models.DeliveryQuery{}
+	decoder := schema.NewDecoder()
+	decoder.IgnoreUnknownKeys(true)
+	if err := decoder.Decode(query, r.URL.Query()); err != nil {
+		response.WriteError(w, models.Invalid("query", err.Error()))
+		return
+	}
+	switch query.Status {
+	case "", models.DeliveryPending, models.DeliveryDelivered, models.DeliveryFailed:
+	default:
+		response.WriteError(w, models.Invalid("status", "must be pending, delivered or failed"))
+		return
+	}
+
+	deliveries, err := wh.WebhookRepo.GetDeliveries(r.Context(), webhook.ID, query)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	response.WriteJSON(w, http.StatusOK, deliveries)
+}
@@ -0,0 +1,204 @@
+// Package webhooks posts forum events to the URLs registered for them. A
+// Dispatcher is the outbox of the forum writes: it queues their events in
+// Postgres in the transaction of the write and delivers the queue with
+// retries, so no committed event is lost to a restart or a failing receiver.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/dantedoyl/Tech_DB_Forum/internal/models"
+)
+
+const (
+	SignatureHeader = "X-Forum-Signature-256"
+	EventHeader     = "X-Forum-Event"
+	DeliveryHeader  = "X-Forum-Delivery"
+
+	pollInterval = 5 * time.Second
+	claimLimit   = 20
+	sendTimeout  = 10 * time.Second
+	lease        = 2 * time.Minute
+	maxAttempts  = 8
+	firstBackoff = 10 * time.Second
+	maxBackoff   = time.Hour
+	maxErrorText = 500
+)
+
+// Sign returns the value of SignatureHeader for body: "sha256=" and the hex
+// HMAC-SHA256 of body keyed with secret.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Backoff is the wait after the given failed attempt: 10s, doubling up to an
+// hour.
+func Backoff(attempt int) time.Duration {
+	wait := firstBackoff
+	for i := 1; i < attempt && wait < maxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > maxBackoff {
+		wait = maxBackoff
+	}
+	return wait
+}
+
+// Dispatcher implements events.Outbox. It delivers through the client of a
+// Guard.
+type Dispatcher struct {
+	repo   WebhookRepository
+	client *http.Client
+	wake   chan struct{}
+	// queued is set when Enqueue queues a delivery and cleared by the
+	// Publish that follows the commit.
+	queued int32
+}
+
+func NewDispatcher(repo WebhookRepository, guard *Guard) *Dispatcher {
+	return &Dispatcher{
+		repo:   repo,
+		client: guard.Client(sendTimeout),
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+func (d *Dispatcher) Atomic(ctx context.Context, fn func(ctx context.Context) error) error {
+	return d.repo.Atomic(ctx, fn)
+}
+
+// Enqueue queues the deliveries of events in the transaction ctx carries.
+// An error fails the write.
+func (d *Dispatcher) Enqueue(ctx context.Context, events ...*models.Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	payloads := make([][]byte, len(events))
+	for i, e := range events {
+		payload, err := json.Marshal(e)
+		if err != nil {
+			return models.Internal(err)
+		}
+		payloads[i] = payload
+	}
+	queued, err := d.repo.Enqueue(ctx, events, payloads)
+	if err != nil {
+		return err
+	}
+	if queued > 0 {
+		atomic.StoreInt32(&d.queued, 1)
+	}
+	return nil
+}
+
+// Publish is the bus handler. Events reach the bus after their write has
+// committed, so it only wakes Run when deliveries were queued; Run polls for
+// the rest, such as deliveries queued by other processes.
+func (d *Dispatcher) Publish(*models.Event) {
+	if atomic.CompareAndSwapInt32(&d.queued, 1, 0) {
+		select {
+		case d.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Run delivers due deliveries until ctx is done.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		d.deliverDue(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+// deliverDue sends claimed deliveries until none is due.
+func (d *Dispatcher) deliverDue(ctx context.Context) {
+	for ctx.Err() == nil {
+		tasks, err := d.repo.ClaimDeliveries(ctx, claimLimit, lease)
+		if err != nil {
+			log.Printf("webhooks: claim: %v", err)
+			return
+		}
+		if len(tasks) == 0 {
+			return
+		}
+		for _, task := range tasks {
+			d.send(ctx, task)
+			if err := d.repo.RecordAttempt(ctx, task.WebhookDelivery); err != nil {
+				log.Printf("webhooks: delivery %d: %v", task.ID, err)
+			}
+		}
+	}
+}
+
+// send makes one attempt and sets the outcome on task. Any 2xx response
+// counts as delivered.
+func (d *Dispatcher) send(ctx context.Context, task *models.WebhookTask) {
+	task.ResponseStatus, task.Error = 0, ""
+	status, err := d.post(ctx, task)
+	task.ResponseStatus = status
+	switch {
+	case err == nil && status >= 200 && status < 300:
+		task.Status = models.DeliveryDelivered
+		task.NextAttempt = nil
+		return
+	case err != nil:
+		task.Error = err.Error()
+	default:
+		task.Error = "unexpected status " + strconv.Itoa(status)
+	}
+	if len(task.Error) > maxErrorText {
+		task.Error = task.Error[:maxErrorText]
+	}
+
+	if task.Attempts >= maxAttempts {
+		task.Status = models.DeliveryFailed
+		task.NextAttempt = nil
+		return
+	}
+	task.Status = models.DeliveryPending
+	next := time.Now().Add(Backoff(task.Attempts))
+	task.NextAttempt = &next
+}
+
+func (d *Dispatcher) post(ctx context.Context, task *models.WebhookTask) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, task.URL, bytes.NewReader(task.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Tech_DB_Forum-Webhooks")
+	req.Header.Set(EventHeader, task.Event)
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(task.ID, 10))
+	req.Header.Set(SignatureHeader, Sign(task.Secret, task.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("post: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64<<10))
+	return resp.StatusCode, nil
+}
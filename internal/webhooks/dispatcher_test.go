@@ -0,0 +1,319 @@
+package webhooks
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dantedoyl/Tech_DB_Forum/internal/models"
+)
+
+// fakeRepository is a WebhookRepository keeping one webhook and its queue in
+// memory. Only the queue methods are implemented.
+type fakeRepository struct {
+	WebhookRepository
+
+	mu      sync.Mutex
+	webhook *models.Webhook
+	tasks   []*models.WebhookTask
+	records int
+}
+
+func (fr *fakeRepository) Atomic(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
+
+func (fr *fakeRepository) Enqueue(ctx context.Context, events []*models.Event, payloads [][]byte) (int, error) {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	queued := 0
+	for i, e := range events {
+		if e.Forum != fr.webhook.Forum {
+			continue
+		}
+		fr.add(e.Type, payloads[i], 0)
+		queued++
+	}
+	return queued, nil
+}
+
+// add queues a due delivery; fr.mu is held or not needed yet.
+func (fr *fakeRepository) add(event string, payload []byte, attempts int) *models.WebhookTask {
+	task := &models.WebhookTask{
+		WebhookDelivery: &models.WebhookDelivery{
+			ID:       int64(len(fr.tasks) + 1),
+			Webhook:  fr.webhook.ID,
+			Event:    event,
+			Payload:  payload,
+			Status:   models.DeliveryPending,
+			Attempts: attempts,
+		},
+		URL:    fr.webhook.URL,
+		Secret: fr.webhook.Secret,
+	}
+	fr.tasks = append(fr.tasks, task)
+	return task
+}
+
+func (fr *fakeRepository) ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]*models.WebhookTask, error) {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	now := time.Now()
+	var claimed []*models.WebhookTask
+	for _, task := range fr.tasks {
+		if len(claimed) == limit {
+			break
+		}
+		if task.Status != models.DeliveryPending || task.NextAttempt != nil && task.NextAttempt.After(now) {
+			continue
+		}
+		task.Attempts++
+		next := now.Add(lease)
+		task.NextAttempt = &next
+		claimed = append(claimed, task)
+	}
+	return claimed, nil
+}
+
+func (fr *fakeRepository) RecordAttempt(ctx context.Context, delivery *models.WebhookDelivery) error {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	fr.records++
+	return nil
+}
+
+// loopback is a guard that lets the dispatcher reach httptest servers.
+func loopback(t *testing.T) *Guard {
+	t.Helper()
+	guard, err := NewGuard([]string{"127.0.0.0/8", "::1/128"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return guard
+}
+
+// receiver starts a server answering with status and returns the fake
+// repository of a webhook pointing at it.
+func receiver(t *testing.T, status int, handle func(r *http.Request, body []byte)) *fakeRepository {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		if handle != nil {
+			handle(r, body)
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	return &fakeRepository{webhook: &models.Webhook{ID: 1, Forum: "news", URL: srv.URL, Secret: "s3cret"}}
+}
+
+func TestDispatcherSignsDeliveries(t *testing.T) {
+	payload := []byte(`{"type":"post.created","forum":"news","thread":1,"data":{}}`)
+	type request struct {
+		header http.Header
+		body   []byte
+	}
+	requests := make(chan request, 1)
+	repo := receiver(t, http.StatusOK, func(r *http.Request, body []byte) {
+		requests <- request{r.Header, body}
+	})
+	task := repo.add(models.EventPostCreated, payload, 0)
+
+	NewDispatcher(repo, loopback(t)).deliverDue(context.Background())
+
+	var got request
+	select {
+	case got = <-requests:
+	default:
+		t.Fatal("receiver got no request")
+	}
+	gotBody := got.body
+	if string(gotBody) != string(payload) {
+		t.Errorf("body = %s, want %s", gotBody, payload)
+	}
+	if sig := got.header.Get(SignatureHeader); sig != Sign("s3cret", gotBody) {
+		t.Errorf("%s = %q, want %q", SignatureHeader, sig, Sign("s3cret", gotBody))
+	}
+	if sig := got.header.Get(SignatureHeader); sig == Sign("other", gotBody) {
+		t.Errorf("%s verifies with the wrong secret", SignatureHeader)
+	}
+	if event := got.header.Get(EventHeader); event != models.EventPostCreated {
+		t.Errorf("%s = %q, want %q", EventHeader, event, models.EventPostCreated)
+	}
+	if id := got.header.Get(DeliveryHeader); id != strconv.FormatInt(task.ID, 10) {
+		t.Errorf("%s = %q, want %d", DeliveryHeader, id, task.ID)
+	}
+}
+
+func TestDispatcherMarksDelivered(t *testing.T) {
+	repo := receiver(t, http.StatusNoContent, nil)
+	task := repo.add(models.EventThreadCreated, []byte(`{}`), 0)
+
+	NewDispatcher(repo, loopback(t)).deliverDue(context.Background())
+
+	if task.Status != models.DeliveryDelivered {
+		t.Errorf("status = %q, want %q", task.Status, models.DeliveryDelivered)
+	}
+	if task.Attempts != 1 || task.NextAttempt != nil || task.ResponseStatus != http.StatusNoContent {
+		t.Errorf("attempts = %d, next = %v, response = %d; want 1, nil, 204",
+			task.Attempts, task.NextAttempt, task.ResponseStatus)
+	}
+	if repo.records != 1 {
+		t.Errorf("recorded %d attempts, want 1", repo.records)
+	}
+}
+
+func TestDispatcherRetriesServerErrors(t *testing.T) {
+	var hits int32
+	repo := receiver(t, http.StatusBadGateway, func(*http.Request, []byte) { atomic.AddInt32(&hits, 1) })
+	task := repo.add(models.EventVoteChanged, []byte(`{}`), 0)
+	d := NewDispatcher(repo, loopback(t))
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		before := time.Now()
+		d.deliverDue(context.Background())
+		after := time.Now()
+
+		if n := atomic.LoadInt32(&hits); int(n) != attempt {
+			t.Fatalf("attempt %d: receiver hit %d times", attempt, n)
+		}
+		if task.Status != models.DeliveryPending || task.Attempts != attempt {
+			t.Fatalf("attempt %d: status = %q, attempts = %d", attempt, task.Status, task.Attempts)
+		}
+		if task.ResponseStatus != http.StatusBadGateway || task.Error != "unexpected status 502" {
+			t.Errorf("attempt %d: response = %d, error = %q", attempt, task.ResponseStatus, task.Error)
+		}
+		wait := Backoff(attempt)
+		if task.NextAttempt == nil || task.NextAttempt.Before(before.Add(wait)) || task.NextAttempt.After(after.Add(wait)) {
+			t.Fatalf("attempt %d: next attempt %v, want %v from now", attempt, task.NextAttempt, wait)
+		}
+
+		// The delivery is not due again until the backoff has passed.
+		d.deliverDue(context.Background())
+		if int(atomic.LoadInt32(&hits)) != attempt {
+			t.Fatalf("attempt %d: retried before the backoff", attempt)
+		}
+		due := time.Now().Add(-time.Second)
+		task.NextAttempt = &due
+	}
+}
+
+func TestDispatcherFailsAfterMaxAttempts(t *testing.T) {
+	repo := receiver(t, http.StatusInternalServerError, nil)
+	task := repo.add(models.EventPostCreated, []byte(`{}`), maxAttempts-1)
+
+	NewDispatcher(repo, loopback(t)).deliverDue(context.Background())
+
+	if task.Status != models.DeliveryFailed {
+		t.Errorf("status = %q, want %q", task.Status, models.DeliveryFailed)
+	}
+	if task.Attempts != maxAttempts || task.NextAttempt != nil {
+		t.Errorf("attempts = %d, next = %v; want %d, nil", task.Attempts, task.NextAttempt, maxAttempts)
+	}
+}
+
+func TestDispatcherDeliversEnqueuedEvents(t *testing.T) {
+	delivered := make(chan []byte, 1)
+	repo := receiver(t, http.StatusOK, func(r *http.Request, body []byte) { delivered <- body })
+	d := NewDispatcher(repo, loopback(t))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.Run(ctx)
+
+	event := &models.Event{Type: models.EventThreadCreated, Forum: "news", Thread: 7}
+	other := &models.Event{Type: models.EventThreadCreated, Forum: "other", Thread: 8}
+	err := d.Atomic(ctx, func(ctx context.Context) error {
+		return d.Enqueue(ctx, event, other)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Publish(event)
+
+	// Publish wakes Run, well before the next poll.
+	select {
+	case body := <-delivered:
+		want := `{"type":"thread.created","forum":"news","thread":7,"data":null}`
+		if string(body) != want {
+			t.Errorf("body = %s, want %s", body, want)
+		}
+	case <-time.After(pollInterval / 2):
+		t.Fatal("event not delivered")
+	}
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	if len(repo.tasks) != 1 {
+		t.Errorf("queued %d deliveries, want 1", len(repo.tasks))
+	}
+}
+
+func TestDispatcherRefusesBlockedAddresses(t *testing.T) {
+	var hits int32
+	repo := receiver(t, http.StatusOK, func(*http.Request, []byte) { atomic.AddInt32(&hits, 1) })
+	task := repo.add(models.EventPostCreated, []byte(`{}`), 0)
+	guard, err := NewGuard(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	NewDispatcher(repo, guard).deliverDue(context.Background())
+
+	if n := atomic.LoadInt32(&hits); n != 0 {
+		t.Errorf("receiver on loopback hit %d times", n)
+	}
+	if task.Status != models.DeliveryPending || !strings.Contains(task.Error, "resolves to the blocked address 127.0.0.1") {
+		t.Errorf("status = %q, error = %q; want pending on a blocked address", task.Status, task.Error)
+	}
+}
+
+func TestDispatcherDoesNotFollowRedirects(t *testing.T) {
+	var hits int32
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+	}))
+	t.Cleanup(target.Close)
+	redirect := httptest.NewServer(http.RedirectHandler(target.URL, http.StatusFound))
+	t.Cleanup(redirect.Close)
+	repo := &fakeRepository{webhook: &models.Webhook{ID: 1, Forum: "news", URL: redirect.URL, Secret: "s3cret"}}
+	task := repo.add(models.EventPostCreated, []byte(`{}`), 0)
+
+	NewDispatcher(repo, loopback(t)).deliverDue(context.Background())
+
+	if n := atomic.LoadInt32(&hits); n != 0 {
+		t.Errorf("redirect target hit %d times", n)
+	}
+	if task.Status != models.DeliveryPending || task.ResponseStatus != http.StatusFound {
+		t.Errorf("status = %q, response = %d; want pending after a 302", task.Status, task.ResponseStatus)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{0, 10 * time.Second},
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{3, 40 * time.Second},
+		{7, 640 * time.Second},
+		{9, 2560 * time.Second},
+		{10, time.Hour},
+		{maxAttempts * 10, time.Hour},
+	}
+	for _, tt := range tests {
+		if got := Backoff(tt.attempt); got != tt.want {
+			t.Errorf("Backoff(%d) = %v, want %v", tt.attempt, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package webhooks
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/dantedoyl/Tech_DB_Forum/internal/models"
+)
+
+// blockedNets are the addresses webhooks may not reach unless allowed:
+// loopback, private, shared, link-local (the cloud metadata endpoints
+// 169.254.169.254 and fd00:ec2::254 among them), unspecified and multicast.
+var blockedNets = mustParseCIDRs(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets, err := parseCIDRs(cidrs)
+	if err != nil {
+		panic(err)
+	}
+	return nets
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func contains(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Guard keeps webhooks off the internal network. It checks the addresses a
+// webhook URL resolves to when the webhook is registered, and again when the
+// dispatcher connects, so a name that later resolves elsewhere is caught too.
+type Guard struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// NewGuard returns a Guard for the CIDR blocks of the configuration. allow
+// opens blocks that are blocked by default, such as a receiver on the
+// private network; deny blocks more. allow wins where they overlap.
+func NewGuard(allow, deny []string) (*Guard, error) {
+	g := &Guard{}
+	var err error
+	if g.allow, err = parseCIDRs(allow); err != nil {
+		return nil, err
+	}
+	if g.deny, err = parseCIDRs(deny); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// Allowed reports whether webhooks may connect to ip.
+func (g *Guard) Allowed(ip net.IP) bool {
+	if contains(g.allow, ip) {
+		return true
+	}
+	return !contains(blockedNets, ip) && !contains(g.deny, ip)
+}
+
+// resolve returns the addresses of host, failing if any is blocked.
+func (g *Guard) resolve(ctx context.Context, host string) ([]net.IP, error) {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]net.IP, 0, len(addrs))
+	for _, addr := range addrs {
+		if !g.Allowed(addr.IP) {
+			return nil, fmt.Errorf("%s resolves to the blocked address %s", host, addr.IP)
+		}
+		ips = append(ips, addr.IP)
+	}
+	return ips, nil
+}
+
+// CheckURL validates the URL of a new webhook.
+func (g *Guard) CheckURL(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return models.Invalid("url", "must be an absolute http or https URL")
+	}
+	if _, err := g.resolve(ctx, u.Hostname()); err != nil {
+		if _, ok := err.(*net.DNSError); ok {
+			return models.Invalid("url", fmt.Sprintf("host %s does not resolve", u.Hostname()))
+		}
+		return models.Invalid("url", "must not point at a loopback, private or link-local address: "+err.Error())
+	}
+	return nil
+}
+
+// DialContext connects to addr like net.Dialer, refusing blocked addresses.
+// It dials the checked address rather than the name, so the name cannot
+// resolve differently in between.
+func (g *Guard) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := g.resolve(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("%s has no address", host)
+	}
+	dialer := &net.Dialer{Timeout: sendTimeout}
+	for _, ip := range ips {
+		var conn net.Conn
+		if conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port)); err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+// Client returns an HTTP client that dials through the guard, uses no proxy
+// and does not follow redirects: a redirect is returned as the response.
+func (g *Guard) Client(timeout time.Duration) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = g.DialContext
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
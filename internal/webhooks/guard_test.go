@@ -0,0 +1,83 @@
+package webhooks
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestGuardAllowed(t *testing.T) {
+	guard, err := NewGuard([]string{"10.1.0.0/16"}, []string{"203.0.113.0/24"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1::248", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"0.0.0.0", false},
+		{"::", false},
+		{"10.0.0.1", false},
+		{"172.16.5.4", false},
+		{"192.168.1.1", false},
+		{"100.64.0.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"fd00:ec2::254", false},
+		{"224.0.0.1", false},
+		// Allowed ranges open blocked addresses; denied ranges block more.
+		{"10.1.2.3", true},
+		{"203.0.113.9", false},
+	}
+	for _, tt := range tests {
+		if got := guard.Allowed(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("Allowed(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestNewGuardRejectsBadBlocks(t *testing.T) {
+	if _, err := NewGuard([]string{"10.1.0.0"}, nil); err == nil {
+		t.Error("allow: address without a prefix length accepted")
+	}
+	if _, err := NewGuard(nil, []string{"example.com/8"}); err == nil {
+		t.Error("deny: host name accepted")
+	}
+}
+
+func TestGuardCheckURL(t *testing.T) {
+	guard, err := NewGuard(nil, []string{"198.51.100.0/24"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://93.184.216.34/hook", ""},
+		{"http://93.184.216.34:8080/hook", ""},
+		{"ftp://93.184.216.34/hook", "must be an absolute http or https URL"},
+		{"/hook", "must be an absolute http or https URL"},
+		{"http://:80/hook", "must be an absolute http or https URL"},
+		{"http://127.0.0.1:5000/api/clear", "blocked address 127.0.0.1"},
+		{"http://[::1]/hook", "blocked address ::1"},
+		{"http://169.254.169.254/latest/meta-data/", "blocked address 169.254.169.254"},
+		{"https://10.0.0.8/hook", "blocked address 10.0.0.8"},
+		{"https://198.51.100.7/hook", "blocked address 198.51.100.7"},
+	}
+	for _, tt := range tests {
+		err := guard.CheckURL(context.Background(), tt.url)
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("CheckURL(%s) = %v, want nil", tt.url, err)
+		case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+			t.Errorf("CheckURL(%s) = %v, want an error with %q", tt.url, err, tt.want)
+		}
+	}
+}
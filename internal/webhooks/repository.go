@@ -0,0 +1,30 @@
+package webhooks
+
+import (
+	"context"
+	"time"
+
+	"github.com/dantedoyl/Tech_DB_Forum/internal/models"
+)
+
+// WebhookRepository stores webhooks and the queue of their deliveries.
+type WebhookRepository interface {
+	// Atomic calls fn in one transaction that Enqueue and the repositories fn
+	// calls with its context join.
+	Atomic(ctx context.Context, fn func(ctx context.Context) error) error
+	CreateWebhook(ctx context.Context, webhook *models.Webhook) error
+	GetWebhook(ctx context.Context, id int) (*models.Webhook, error)
+	GetForumWebhooks(ctx context.Context, slug string) ([]*models.Webhook, error)
+	DeleteWebhook(ctx context.Context, id int) error
+	GetDeliveries(ctx context.Context, webhook int, query *models.DeliveryQuery) ([]*models.WebhookDelivery, error)
+
+	// Enqueue queues payloads[i] for every webhook of the forum of events[i]
+	// that subscribes to its type and returns how many deliveries it queued.
+	Enqueue(ctx context.Context, events []*models.Event, payloads [][]byte) (int, error)
+	// ClaimDeliveries takes up to limit due deliveries, counting an attempt
+	// for each and hiding them from other workers for lease.
+	ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]*models.WebhookTask, error)
+	// RecordAttempt stores the status, response, error and next attempt of
+	// a claimed delivery.
+	RecordAttempt(ctx context.Context, delivery *models.WebhookDelivery) error
+}
@@ -0,0 +1,282 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/dantedoyl/Tech_DB_Forum/internal/models"
+	"github.com/dantedoyl/Tech_DB_Forum/internal/pgtx"
+	"github.com/jackc/pgx"
+	"github.com/jackc/pgx/pgtype"
+)
+
+const defaultLimit = 100
+
+const webhookColumns = `id, forum, url, events, COALESCE(created_by, ''), created`
+
+const deliveryColumns = `d.id, d.webhook, d.event, d.payload::text, d.status, d.attempts, d.next_attempt_at,
+	d.last_attempt_at, COALESCE(d.response_status, 0), d.error, d.created, d.delivered_at`
+
+type WebhookRepository struct {
+	dbConn *pgx.ConnPool
+}
+
+func NewWebhookRepository(conn *pgx.ConnPool) *WebhookRepository {
+	return &WebhookRepository{dbConn: conn}
+}
+
+// conn is the transaction ctx carries, or the pool.
+func (wr WebhookRepository) conn(ctx context.Context) pgtx.Queryer {
+	return pgtx.Conn(ctx, wr.dbConn)
+}
+
+func (wr WebhookRepository) Atomic(ctx context.Context, fn func(ctx context.Context) error) error {
+	return pgtx.Run(ctx, wr.dbConn, fn)
+}
+
+type scanner interface {
+	Scan(...interface{}) error
+}
+
+func scanWebhook(row scanner) (*models.Webhook, error) {
+	webhook := &models.Webhook{}
+	var events pgtype.TextArray
+	err := row.Scan(&webhook.ID, &webhook.Forum, &webhook.URL, &events, &webhook.CreatedBy, &webhook.Created)
+	if err != nil {
+		return nil, err
+	}
+	if err := events.AssignTo(&webhook.Events); err != nil {
+		return nil, err
+	}
+	return webhook, nil
+}
+
+func scanDelivery(row scanner, extra ...interface{}) (*models.WebhookDelivery, error) {
+	d := &models.WebhookDelivery{}
+	var payload string
+	var next, last, delivered pgtype.Timestamptz
+	dest := []interface{}{&d.ID, &d.Webhook, &d.Event, &payload, &d.Status, &d.Attempts, &next, &last,
+		&d.ResponseStatus, &d.Error, &d.Created, &delivered}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	d.Payload = []byte(payload)
+	if d.Status == models.DeliveryPending {
+		d.NextAttempt = &next.Time
+	}
+	if last.Status == pgtype.Present {
+		d.LastAttempt = &last.Time
+	}
+	if delivered.Status == pgtype.Present {
+		d.Delivered = &delivered.Time
+	}
+	return d, nil
+}
+
+// CreateWebhook stores webhook with the canonical slug of its forum.
+func (wr WebhookRepository) CreateWebhook(ctx context.Context, webhook *models.Webhook) error {
+	err := wr.conn(ctx).QueryRowEx(ctx, `SELECT slug FROM forum WHERE slug=$1`, nil, webhook.Forum).Scan(&webhook.Forum)
+	if err == pgx.ErrNoRows {
+		return models.NotFound(models.EntityForum, webhook.Forum)
+	}
+	if err != nil {
+		return models.Internal(err)
+	}
+
+	events := pgtype.TextArray{}
+	if err := events.Set(webhook.Events); err != nil {
+		return models.Internal(err)
+	}
+	row := wr.conn(ctx).QueryRowEx(ctx, `INSERT INTO webhooks(forum, url, secret, events, created_by)
+		VALUES ($1, $2, $3, $4::text[], NULLIF($5, '')) RETURNING `+webhookColumns,
+		nil, webhook.Forum, webhook.URL, webhook.Secret, &events, webhook.CreatedBy)
+	created, err := scanWebhook(row)
+	if err != nil {
+		return models.Internal(err)
+	}
+	created.Secret = webhook.Secret
+	*webhook = *created
+	return nil
+}
+
+func (wr WebhookRepository) GetWebhook(ctx context.Context, id int) (*models.Webhook, error) {
+	row := wr.conn(ctx).QueryRowEx(ctx, `SELECT `+webhookColumns+` FROM webhooks WHERE id=$1`, nil, id)
+	webhook, err := scanWebhook(row)
+	if err == pgx.ErrNoRows {
+		return nil, models.NotFound(models.EntityWebhook, strconv.Itoa(id))
+	}
+	if err != nil {
+		return nil, models.Internal(err)
+	}
+	return webhook, nil
+}
+
+func (wr WebhookRepository) GetForumWebhooks(ctx context.Context, slug string) ([]*models.Webhook, error) {
+	var exists bool
+	err := wr.conn(ctx).QueryRowEx(ctx, `SELECT EXISTS(SELECT 1 FROM forum WHERE slug=$1)`, nil, slug).Scan(&exists)
+	if err != nil {
+		return nil, models.Internal(err)
+	}
+	if !exists {
+		return nil, models.NotFound(models.EntityForum, slug)
+	}
+
+	rows, err := wr.conn(ctx).QueryEx(ctx, `SELECT `+webhookColumns+` FROM webhooks WHERE forum=$1 ORDER BY id`, nil, slug)
+	if err != nil {
+		return nil, models.Internal(err)
+	}
+	defer rows.Close()
+
+	webhooks := []*models.Webhook{}
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, models.Internal(err)
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	if rows.Err() != nil {
+		return nil, models.Internal(rows.Err())
+	}
+	return webhooks, nil
+}
+
+// DeleteWebhook removes a webhook with its delivery log and queue.
+func (wr WebhookRepository) DeleteWebhook(ctx context.Context, id int) error {
+	tag, err := wr.conn(ctx).ExecEx(ctx, `DELETE FROM webhooks WHERE id=$1`, nil, id)
+	if err != nil {
+		return models.Internal(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return models.NotFound(models.EntityWebhook, strconv.Itoa(id))
+	}
+	return nil
+}
+
+// GetDeliveries pages through the delivery log of a webhook by id.
+func (wr WebhookRepository) GetDeliveries(ctx context.Context, webhook int, query *models.DeliveryQuery) ([]*models.WebhookDelivery, error) {
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	where := `d.webhook = ` + arg(webhook)
+	if query.Status != "" {
+		where += ` AND d.status = ` + arg(query.Status)
+	}
+	order := `d.id`
+	if query.Desc {
+		order = `d.id DESC`
+		if query.Since > 0 {
+			where += ` AND d.id < ` + arg(query.Since)
+		}
+	} else if query.Since > 0 {
+		where += ` AND d.id > ` + arg(query.Since)
+	}
+	limit := query.Limit
+	if limit <= 0 {
+		limit = defaultLimit
+	}
+
+	sql := fmt.Sprintf(`SELECT %s FROM webhook_deliveries AS d WHERE %s ORDER BY %s LIMIT %s`,
+		deliveryColumns, where, order, arg(limit))
+	rows, err := wr.conn(ctx).QueryEx(ctx, sql, nil, args...)
+	if err != nil {
+		return nil, models.Internal(err)
+	}
+	defer rows.Close()
+
+	deliveries := []*models.WebhookDelivery{}
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, models.Internal(err)
+		}
+		deliveries = append(deliveries, d)
+	}
+	if rows.Err() != nil {
+		return nil, models.Internal(rows.Err())
+	}
+	return deliveries, nil
+}
+
+func (wr WebhookRepository) Enqueue(ctx context.Context, events []*models.Event, payloads [][]byte) (int, error) {
+	types := make([]string, len(events))
+	forums := make([]string, len(events))
+	bodies := make([]string, len(events))
+	for i, event := range events {
+		types[i], forums[i], bodies[i] = event.Type, event.Forum, string(payloads[i])
+	}
+	typeArray, forumArray, bodyArray := &pgtype.TextArray{}, &pgtype.TextArray{}, &pgtype.TextArray{}
+	if err := typeArray.Set(types); err != nil {
+		return 0, models.Internal(err)
+	}
+	if err := forumArray.Set(forums); err != nil {
+		return 0, models.Internal(err)
+	}
+	if err := bodyArray.Set(bodies); err != nil {
+		return 0, models.Internal(err)
+	}
+	tag, err := wr.conn(ctx).ExecEx(ctx, `INSERT INTO webhook_deliveries(webhook, event, payload)
+		SELECT w.id, e.type, e.payload::jsonb
+		FROM unnest($1::text[], $2::text[], $3::text[]) WITH ORDINALITY AS e(type, forum, payload, n)
+		JOIN webhooks AS w ON w.forum = e.forum::citext AND e.type = ANY(w.events)
+		ORDER BY e.n, w.id`,
+		nil, typeArray, forumArray, bodyArray)
+	if err != nil {
+		return 0, models.Internal(err)
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+// ClaimDeliveries lets several workers, in one process or many, share the
+// queue: a claimed delivery is locked until the lease moves its next attempt
+// past now, and a worker that dies leaves it due again when the lease ends.
+func (wr WebhookRepository) ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]*models.WebhookTask, error) {
+	rows, err := wr.conn(ctx).QueryEx(ctx, `UPDATE webhook_deliveries AS d
+		SET attempts = d.attempts + 1, last_attempt_at = now(), next_attempt_at = now() + $2 * interval '1 millisecond'
+		FROM webhooks AS w
+		WHERE w.id = d.webhook AND d.id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status = 'pending' AND next_attempt_at <= now()
+			ORDER BY next_attempt_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED)
+		RETURNING `+deliveryColumns+`, w.url, w.secret`, nil, limit, lease.Milliseconds())
+	if err != nil {
+		return nil, models.Internal(err)
+	}
+	defer rows.Close()
+
+	var tasks []*models.WebhookTask
+	for rows.Next() {
+		task := &models.WebhookTask{}
+		task.WebhookDelivery, err = scanDelivery(rows, &task.URL, &task.Secret)
+		if err != nil {
+			return nil, models.Internal(err)
+		}
+		tasks = append(tasks, task)
+	}
+	if rows.Err() != nil {
+		return nil, models.Internal(rows.Err())
+	}
+	return tasks, nil
+}
+
+func (wr WebhookRepository) RecordAttempt(ctx context.Context, d *models.WebhookDelivery) error {
+	next := time.Now()
+	if d.NextAttempt != nil {
+		next = *d.NextAttempt
+	}
+	_, err := wr.conn(ctx).ExecEx(ctx, `UPDATE webhook_deliveries
+		SET status=$2, response_status=NULLIF($3, 0), error=$4, next_attempt_at=$5,
+			delivered_at = CASE WHEN $2 = 'delivered' THEN now() END
+		WHERE id=$1`, nil, d.ID, d.Status, d.ResponseStatus, d.Error, next)
+	if err != nil {
+		return models.Internal(err)
+	}
+	return nil
+}